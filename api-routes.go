@@ -0,0 +1,556 @@
+package main
+
+import (
+	"adb-server/audit"
+	"adb-server/authentication"
+	"adb-server/handlers"
+	"adb-server/internal/adb"
+	"adb-server/jobs"
+	"adb-server/mcp"
+	"adb-server/models"
+	"adb-server/registry"
+	"adb-server/routes"
+	"adb-server/webhooks"
+	"net/http"
+	"strings"
+)
+
+// Bumped when the API changes in a way clients can see
+const apiVersion = "2.0.0"
+
+var (
+	deviceIDParam = routes.Param{Name: "device-id", Required: true, Description: "Device serial or a registry selector (name:, tag:, group:)"}
+	serialParam   = routes.Param{Name: "serial", In: routes.InPath, Description: "Device serial or a registry selector (name:, tag:, group:)"}
+	jobIDParam    = routes.Param{Name: "id", In: routes.InPath, Description: "Job ID"}
+)
+
+// Where the v1 device routes find what v2 has in the path
+var (
+	v1Device        = map[string]string{"serial": "device-id"}
+	v1DevicePackage = map[string]string{"serial": "device-id", "name": "package"}
+)
+
+func jsonBody(schema any) *routes.Body {
+	return &routes.Body{Schema: schema}
+}
+
+func ok(description string, schema any) routes.Response {
+	return routes.Response{Status: http.StatusOK, Description: description, Body: jsonBody(schema)}
+}
+
+func accepted(description string, schema any) routes.Response {
+	return routes.Response{Status: http.StatusAccepted, Description: description, Body: jsonBody(schema)}
+}
+
+var message = map[string]string{}
+
+var batchRoute = routes.Route{
+	Method: http.MethodPost,
+	Tag:    "batch",
+	Params: []routes.Param{
+		{Name: "stream", Type: routes.TypeBoolean, Description: "Answer with a line of NDJSON per device as they finish"},
+	},
+	Body:      &routes.Body{Schema: models.BatchRequest{}, Required: true},
+	Responses: []routes.Response{ok("Results per device", models.BatchResponse{})},
+	Scopes:    []string{authentication.ScopeDevicesWrite},
+}
+
+func batch(pattern string, summary string, handler http.HandlerFunc) routes.Route {
+	route := batchRoute
+	route.Pattern = pattern
+	route.Summary = summary
+	route.Handler = handler
+
+	return route
+}
+
+// apiRoutes is every route the server has, mounted by Register and described by /v1/openapi.json
+func apiRoutes(server *models.Server, readiness http.HandlerFunc, maxUploadBytes int64) *routes.Registry {
+	api := routes.NewRegistry()
+
+	api.Add(
+		routes.Route{
+			Method: http.MethodPost, Pattern: "/v1/pair", Public: true, Tag: "auth",
+			Summary:     "Pair with the server",
+			Description: "Sets the X-Auth-Token cookie. Only one client can be paired at a time.",
+			Params: []routes.Param{
+				{Name: "scopes", Description: "Comma separated scopes to limit the session to, all of them when left out"},
+			},
+			Responses: []routes.Response{
+				ok("Paired", models.PairingResponse{}),
+				{Status: http.StatusConflict, Description: "A client is already paired"},
+			},
+			Handler: handlers.PairWithServer,
+		},
+		routes.Route{
+			Method: http.MethodDelete, Pattern: "/v1/unpair", Tag: "auth",
+			Summary:   "Forget the current pairing",
+			Responses: []routes.Response{ok("Unpaired", models.PairingResponse{})},
+			Handler:   handlers.UnpairFromServer,
+		},
+		routes.Route{
+			Method: http.MethodGet, Pattern: "/v1/csrf-token", Tag: "auth",
+			Summary:   "Get the CSRF token for the session",
+			Responses: []routes.Response{ok("The token", models.CSRFTokenResponse{})},
+			Handler:   handlers.HandleCSRFToken,
+		},
+		routes.Route{
+			Method: http.MethodGet, Pattern: "/v1/openapi.json", Public: true, Tag: "server",
+			Summary:   "This document",
+			Responses: []routes.Response{ok("OpenAPI 3.1 document", map[string]any{})},
+			Handler:   api.ServeOpenAPI(apiVersion),
+		},
+		routes.Route{
+			Method: http.MethodGet, Pattern: "/v1/health", Tag: "server",
+			Summary:   "Check the server is up",
+			Responses: []routes.Response{ok("Up", models.HealthResponse{})},
+			Handler:   handlers.HandleServerHealth,
+		},
+		routes.Route{
+			Method: http.MethodGet, Pattern: "/v1/health/ready", Tag: "server",
+			Summary: "Check adb, the devices, the temp dir and the job queue",
+			Responses: []routes.Response{
+				ok("Ready or degraded", models.ReadinessResponse{}),
+				{Status: http.StatusServiceUnavailable, Description: "Unhealthy", Body: jsonBody(models.ReadinessResponse{})},
+			},
+			Handler: readiness,
+		},
+		routes.Route{
+			Method: http.MethodPost, Pattern: "/v1/admin/shutdown", Tag: "server",
+			Summary:   "Shut the server down gracefully",
+			Responses: []routes.Response{accepted("Shutting down", message)},
+			Scopes:    []string{authentication.ScopeAdmin},
+			Handler:   handlers.HandleShutdown(server.RequestShutdown),
+		},
+		routes.Route{
+			Method: http.MethodGet, Pattern: "/v1/adb/list-devices", Tag: "devices", Deprecated: true,
+			Summary:     "List devices adb knows about, with what the registry knows about them",
+			Description: "Use GET /v2/devices.",
+			Params: []routes.Param{
+				{Name: "tag", Description: "Only devices with this registry tag"},
+				{Name: "include-offline", Type: routes.TypeBoolean, Description: "Include registered devices that aren't connected"},
+			},
+			Responses: []routes.Response{ok("Devices", []models.DeviceListing{})},
+			Scopes:    []string{authentication.ScopeDevicesRead},
+			Handler:   handlers.HandleListDevices,
+		},
+		routes.Route{
+			Method: http.MethodGet, Pattern: "/v1/adb/list-packages", Tag: "devices", Deprecated: true,
+			Summary:     "List packages installed on a device",
+			Description: "Use GET /v2/devices/{serial}/packages.",
+			Params: []routes.Param{
+				deviceIDParam,
+				{Name: "include-system", Type: routes.TypeBoolean},
+				{Name: "uninstalled", Type: routes.TypeBoolean, Description: "Include packages uninstalled with their data kept"},
+			},
+			Responses: []routes.Response{ok("Packages", []adb.Package{})},
+			Scopes:    []string{authentication.ScopeDevicesRead},
+			Handler:   handlers.V1(handlers.HandleListPackages, v1Device),
+		},
+		routes.Route{
+			Method: http.MethodPost, Pattern: "/v1/adb/install-package", Tag: "devices", Deprecated: true,
+			Summary:     "Install an APK",
+			Description: "Use POST /v2/devices/{serial}/packages.",
+			Params: []routes.Param{
+				deviceIDParam,
+				{Name: "path", Description: "APK on the server's filesystem"},
+				{Name: "name", Description: "File name of an uploaded APK, for the audit log"},
+			},
+			Body:      &routes.Body{ContentType: "application/vnd.android.package-archive", Description: "The APK, when path isn't given"},
+			Responses: []routes.Response{ok("Installed", message)},
+			Scopes:    []string{authentication.ScopeDevicesWrite},
+			Handler:   handlers.V1(handlers.HandleInstallApp, v1Device),
+		},
+		routes.Route{
+			Method: http.MethodDelete, Pattern: "/v1/adb/uninstall-package", Tag: "devices", Deprecated: true,
+			Summary:     "Uninstall a package",
+			Description: "Use DELETE /v2/devices/{serial}/packages/{name}.",
+			Params: []routes.Param{
+				deviceIDParam,
+				{Name: "package", Required: true},
+				{Name: "keep-data", Type: routes.TypeBoolean},
+				{Name: "user", Type: routes.TypeInteger, Minimum: routes.Minimum(0), Description: "Android user to uninstall for"},
+			},
+			Responses: []routes.Response{ok("Uninstalled", message)},
+			Scopes:    []string{authentication.ScopeDevicesWrite},
+			Handler:   handlers.V1(handlers.HandleUninstallApp, v1DevicePackage),
+		},
+		routes.Route{
+			Method: http.MethodGet, Pattern: "/v1/adb/locks", Tag: "devices",
+			Summary: "Show who holds or waits for each device",
+			Params: []routes.Param{
+				{Name: "device-id", Description: "Only this device"},
+			},
+			Responses: []routes.Response{ok("Locks", []adb.DeviceLockStatus{})},
+			Scopes:    []string{authentication.ScopeDevicesRead},
+			Handler:   handlers.HandleDeviceLocks,
+		},
+		batch("/v1/batch/install", "Install an APK on many devices", handlers.HandleBatchInstall),
+		batch("/v1/batch/uninstall", "Uninstall a package from many devices", handlers.HandleBatchUninstall),
+		batch("/v1/batch/shell", "Run a shell command on many devices", handlers.HandleBatchShell),
+		batch("/v1/batch/reboot", "Reboot many devices", handlers.HandleBatchReboot),
+		routes.Route{
+			Method: http.MethodGet, Pattern: "/v1/events", Tag: "events",
+			Summary: "Stream device, job and operation events",
+			Params: []routes.Param{
+				{Name: "types", Description: "Comma separated event types, all when left out"},
+				{Name: "last-event-id", Type: routes.TypeInteger, Minimum: routes.Minimum(0), Description: "Replay events after this one"},
+				{Name: "Last-Event-ID", In: routes.InHeader, Type: routes.TypeInteger, Minimum: routes.Minimum(0), Description: "Sent by EventSource when it reconnects"},
+			},
+			Responses: []routes.Response{
+				{Status: http.StatusOK, Description: "Server-sent events", Body: &routes.Body{ContentType: "text/event-stream"}},
+			},
+			Scopes:  []string{authentication.ScopeDevicesRead},
+			Handler: handlers.HandleEvents,
+		},
+		routes.Route{
+			Method: http.MethodGet, Pattern: "/v1/audit", Tag: "audit",
+			Summary: "Query the audit log",
+			Params: []routes.Param{
+				{Name: "session"},
+				{Name: "device-id"},
+				{Name: "operation"},
+				{Name: "outcome", Enum: []string{audit.OutcomeSuccess, audit.OutcomeFailure}},
+				{Name: "since", Description: "RFC 3339 time"},
+				{Name: "until", Description: "RFC 3339 time"},
+				{Name: "limit", Type: routes.TypeInteger, Minimum: routes.Minimum(1), Maximum: routes.Maximum(handlers.MaxAuditQueryLimit), Description: "100 when left out"},
+			},
+			Responses: []routes.Response{ok("Entries, newest first", []audit.Entry{})},
+			Scopes:    []string{authentication.ScopeAuditRead},
+			Handler:   handlers.HandleAuditQuery,
+		},
+		routes.Route{
+			Method: http.MethodGet, Pattern: "/v1/jobs", Tag: "jobs",
+			Summary:   "List the session's jobs, every session's for admins",
+			Responses: []routes.Response{ok("Jobs", []jobs.Job{})},
+			Scopes:    []string{authentication.ScopeDevicesRead},
+			Handler:   handlers.HandleListJobs,
+		},
+		routes.Route{
+			Method: http.MethodPost, Pattern: "/v1/jobs/install", Tag: "jobs",
+			Summary: "Install an APK in the background",
+			Params: []routes.Param{
+				deviceIDParam,
+				{Name: "path", Description: "APK on the server's filesystem"},
+				{Name: "name", Description: "File name of an uploaded APK, for the audit log"},
+			},
+			Body:      &routes.Body{ContentType: "application/vnd.android.package-archive", Description: "The APK, when path isn't given"},
+			Responses: []routes.Response{accepted("Job started", jobs.Job{})},
+			Scopes:    []string{authentication.ScopeDevicesWrite},
+			Handler:   handlers.HandleInstallJob,
+		},
+		routes.Route{
+			Method: http.MethodPost, Pattern: "/v1/jobs/push", Tag: "jobs",
+			Summary: "Push a file to a device",
+			Params: []routes.Param{
+				deviceIDParam,
+				{Name: "path", Required: true, Description: "File on the server's filesystem"},
+				{Name: "remote-path", Required: true},
+			},
+			Responses: []routes.Response{accepted("Job started", jobs.Job{})},
+			Scopes:    []string{authentication.ScopeDevicesWrite},
+			Handler:   handlers.HandlePushJob,
+		},
+		routes.Route{
+			Method: http.MethodPost, Pattern: "/v1/jobs/pull", Tag: "jobs",
+			Summary:   "Pull a file from a device, fetched afterwards as the job's artifact",
+			Params:    []routes.Param{deviceIDParam, {Name: "remote-path", Required: true, Description: "A file, not a directory"}},
+			Responses: []routes.Response{accepted("Job started", jobs.Job{})},
+			Scopes:    []string{authentication.ScopeDevicesRead},
+			Handler:   handlers.HandlePullJob,
+		},
+		routes.Route{
+			Method: http.MethodPost, Pattern: "/v1/jobs/bugreport", Tag: "jobs",
+			Summary:   "Capture a bugreport, fetched afterwards as the job's artifact",
+			Params:    []routes.Param{deviceIDParam},
+			Responses: []routes.Response{accepted("Job started", jobs.Job{})},
+			Scopes:    []string{authentication.ScopeDevicesRead},
+			Handler:   handlers.HandleBugreportJob,
+		},
+		routes.Route{
+			Method: http.MethodGet, Pattern: "/v1/jobs/{id}", Tag: "jobs",
+			Summary:   "Get a job with its progress and logs",
+			Params:    []routes.Param{jobIDParam},
+			Responses: []routes.Response{ok("The job", jobs.Job{})},
+			Scopes:    []string{authentication.ScopeDevicesRead},
+			Handler:   handlers.HandleJob,
+		},
+		routes.Route{
+			Method: http.MethodDelete, Pattern: "/v1/jobs/{id}", Tag: "jobs",
+			Summary: "Cancel a job",
+			Params:  []routes.Param{jobIDParam},
+			Responses: []routes.Response{
+				ok("The job, cancelling", jobs.Job{}),
+				{Status: http.StatusConflict, Description: "The job already finished"},
+			},
+			Scopes:  []string{authentication.ScopeDevicesWrite},
+			Handler: handlers.HandleCancelJob,
+		},
+		routes.Route{
+			Method: http.MethodGet, Pattern: "/v1/jobs/{id}/artifact", Tag: "jobs",
+			Summary: "Download what a pull or bugreport job produced",
+			Params:  []routes.Param{jobIDParam},
+			Responses: []routes.Response{
+				{Status: http.StatusOK, Description: "The file", Body: &routes.Body{ContentType: "application/octet-stream"}},
+			},
+			Scopes:  []string{authentication.ScopeDevicesRead},
+			Handler: handlers.HandleJobArtifact,
+		},
+	)
+
+	api.Add(registryRoutes()...)
+	api.Add(webhookRoutes()...)
+	api.Add(v2Routes()...)
+
+	// Tool calls go back through the protected mux, as the caller, so they're checked like any request
+	mcpServer := mcp.NewServer(mcp.Options{
+		Name:            "adb-server",
+		Version:         apiVersion,
+		MaxMessageBytes: maxUploadBytes*4/3 + 1<<20,
+	}, api, server.ProtectedMux)
+
+	api.Add(routes.Route{
+		Method: http.MethodPost, Pattern: "/v1/mcp", Tag: "mcp",
+		Summary:     "Model Context Protocol endpoint",
+		Description: "Streamable HTTP transport, answered with JSON. The tools are the routes with an MCP tool name, listed for the scopes the session has. Clients without cookies can send the session token as a bearer token.",
+		Body:        &routes.Body{Required: true, Description: "A JSON-RPC 2.0 message"},
+		Responses: []routes.Response{
+			ok("The JSON-RPC response", map[string]any{}),
+			{Status: http.StatusAccepted, Description: "A notification, nothing to answer"},
+		},
+		Handler: mcpServer.ServeHTTP,
+	})
+
+	return api
+}
+
+func registryRoutes() []routes.Route {
+	deviceParam := routes.Param{Name: "device_id", In: routes.InPath, Description: "Device serial"}
+	groupParam := routes.Param{Name: "name", In: routes.InPath, Description: "Group name"}
+
+	return []routes.Route{
+		{
+			Method: http.MethodGet, Pattern: "/v1/registry/devices", Tag: "registry",
+			Summary:   "List registered devices",
+			Responses: []routes.Response{ok("Devices", []registry.DeviceInfo{})},
+			Scopes:    []string{authentication.ScopeDevicesRead},
+			Handler:   handlers.HandleRegistryDevices,
+		},
+		{
+			Method: http.MethodGet, Pattern: "/v1/registry/devices/{device_id}", Tag: "registry",
+			Summary:   "Get a registered device",
+			Params:    []routes.Param{deviceParam},
+			Responses: []routes.Response{ok("The device", registry.DeviceInfo{})},
+			Scopes:    []string{authentication.ScopeDevicesRead},
+			Handler:   handlers.HandleRegistryDevice,
+		},
+		{
+			Method: http.MethodPut, Pattern: "/v1/registry/devices/{device_id}", Tag: "registry",
+			Summary:   "Set a device's name, tags, owner and notes",
+			Params:    []routes.Param{deviceParam},
+			Body:      &routes.Body{Schema: models.DeviceMetadataRequest{}, Required: true},
+			Responses: []routes.Response{ok("The device", registry.DeviceInfo{})},
+			Scopes:    []string{authentication.ScopeRegistryWrite},
+			Handler:   handlers.HandlePutRegistryDevice,
+		},
+		{
+			Method: http.MethodDelete, Pattern: "/v1/registry/devices/{device_id}", Tag: "registry",
+			Summary:   "Remove a device from the registry",
+			Params:    []routes.Param{deviceParam},
+			Responses: []routes.Response{ok("Removed", message)},
+			Scopes:    []string{authentication.ScopeRegistryWrite},
+			Handler:   handlers.HandleDeleteRegistryDevice,
+		},
+		{
+			Method: http.MethodGet, Pattern: "/v1/registry/groups", Tag: "registry",
+			Summary:   "List device groups",
+			Responses: []routes.Response{ok("Groups", []registry.Group{})},
+			Scopes:    []string{authentication.ScopeDevicesRead},
+			Handler:   handlers.HandleRegistryGroups,
+		},
+		{
+			Method: http.MethodPut, Pattern: "/v1/registry/groups/{name}", Tag: "registry",
+			Summary:   "Create or replace a group",
+			Params:    []routes.Param{groupParam},
+			Body:      &routes.Body{Schema: models.GroupRequest{}, Required: true},
+			Responses: []routes.Response{ok("The group", registry.Group{})},
+			Scopes:    []string{authentication.ScopeRegistryWrite},
+			Handler:   handlers.HandlePutRegistryGroup,
+		},
+		{
+			Method: http.MethodDelete, Pattern: "/v1/registry/groups/{name}", Tag: "registry",
+			Summary:   "Delete a group",
+			Params:    []routes.Param{groupParam},
+			Responses: []routes.Response{ok("Deleted", message)},
+			Scopes:    []string{authentication.ScopeRegistryWrite},
+			Handler:   handlers.HandleDeleteRegistryGroup,
+		},
+	}
+}
+
+// Managing webhooks takes admin, they send events anywhere and their secrets sign them
+func webhookRoutes() []routes.Route {
+	idParam := routes.Param{Name: "id", In: routes.InPath, Description: "Webhook ID"}
+	notFound := routes.Response{Status: http.StatusNotFound, Description: "No such webhook"}
+
+	return []routes.Route{
+		{
+			Method: http.MethodGet, Pattern: "/v1/webhooks", Tag: "webhooks",
+			Summary:   "List webhooks, without their secrets",
+			Responses: []routes.Response{ok("Webhooks", []webhooks.Webhook{})},
+			Scopes:    []string{authentication.ScopeAdmin},
+			Handler:   handlers.HandleWebhooks,
+		},
+		{
+			Method: http.MethodPost, Pattern: "/v1/webhooks", Tag: "webhooks",
+			Summary: "Register a webhook",
+			Description: "Events are " + strings.Join(webhooks.EventTypes, ", ") + ", every one of them when none are given. " +
+				"Each is POSTed as JSON with " + webhooks.HeaderSignature + ": sha256=HMAC-SHA256(secret, " + webhooks.HeaderTimestamp + " + \".\" + body), " +
+				"and retried with backoff when the receiver can't be reached or answers 408, 429 or 5xx. " +
+				"The secret is generated when not given and only shown in this response.",
+			Body: &routes.Body{Schema: models.WebhookRequest{}, Required: true},
+			Responses: []routes.Response{
+				{Status: http.StatusCreated, Description: "The webhook, with its secret", Body: jsonBody(webhooks.Webhook{})},
+			},
+			Scopes:  []string{authentication.ScopeAdmin},
+			Handler: handlers.HandleCreateWebhook,
+		},
+		{
+			Method: http.MethodGet, Pattern: "/v1/webhooks/{id}", Tag: "webhooks",
+			Summary:   "Get a webhook",
+			Params:    []routes.Param{idParam},
+			Responses: []routes.Response{ok("The webhook", webhooks.Webhook{}), notFound},
+			Scopes:    []string{authentication.ScopeAdmin},
+			Handler:   handlers.HandleWebhook,
+		},
+		{
+			Method: http.MethodPut, Pattern: "/v1/webhooks/{id}", Tag: "webhooks",
+			Summary:     "Change a webhook's URL, events and description",
+			Description: "The secret is only replaced when one is given.",
+			Params:      []routes.Param{idParam},
+			Body:        &routes.Body{Schema: models.WebhookRequest{}, Required: true},
+			Responses:   []routes.Response{ok("The webhook", webhooks.Webhook{}), notFound},
+			Scopes:      []string{authentication.ScopeAdmin},
+			Handler:     handlers.HandleUpdateWebhook,
+		},
+		{
+			Method: http.MethodDelete, Pattern: "/v1/webhooks/{id}", Tag: "webhooks",
+			Summary:   "Delete a webhook",
+			Params:    []routes.Param{idParam},
+			Responses: []routes.Response{ok("Deleted", message), notFound},
+			Scopes:    []string{authentication.ScopeAdmin},
+			Handler:   handlers.HandleDeleteWebhook,
+		},
+		{
+			Method: http.MethodGet, Pattern: "/v1/webhooks/{id}/deliveries", Tag: "webhooks",
+			Summary:     "Show the webhook's last deliveries and their attempts",
+			Description: "Kept in memory, newest last.",
+			Params:      []routes.Param{idParam},
+			Responses:   []routes.Response{ok("Deliveries", []webhooks.Delivery{}), notFound},
+			Scopes:      []string{authentication.ScopeAdmin},
+			Handler:     handlers.HandleWebhookDeliveries,
+		},
+		{
+			Method: http.MethodPost, Pattern: "/v1/webhooks/{id}/test", Tag: "webhooks",
+			Summary:     "Send a webhook.test event now",
+			Description: "Tried once, without waiting behind the webhook's other deliveries.",
+			Params:      []routes.Param{idParam},
+			Responses:   []routes.Response{ok("How the delivery went", webhooks.Delivery{}), notFound},
+			Scopes:      []string{authentication.ScopeAdmin},
+			Handler:     handlers.HandleTestWebhook,
+		},
+	}
+}
+
+// v2 names devices and packages as resources and lets the method say what happens to them
+func v2Routes() []routes.Route {
+	return []routes.Route{
+		{
+			Method: http.MethodGet, Pattern: "/v2/devices", Tag: "devices",
+			Summary: "List devices adb knows about, with what the registry knows about them",
+			Params: []routes.Param{
+				{Name: "tag", Description: "Only devices with this registry tag"},
+				{Name: "include-offline", Type: routes.TypeBoolean, Description: "Include registered devices that aren't connected"},
+			},
+			Responses: []routes.Response{ok("Devices", []models.DeviceListing{})},
+			Scopes:    []string{authentication.ScopeDevicesRead},
+			Tool:      "list_devices",
+			Handler:   handlers.HandleListDevices,
+		},
+		{
+			Method: http.MethodGet, Pattern: "/v2/devices/{serial}", Tag: "devices",
+			Summary: "Get a device, connected or registered",
+			Params:  []routes.Param{serialParam},
+			Responses: []routes.Response{
+				ok("The device", models.DeviceListing{}),
+				{Status: http.StatusNotFound, Description: "Neither adb nor the registry knows the device"},
+			},
+			Scopes:  []string{authentication.ScopeDevicesRead},
+			Tool:    "get_device",
+			Handler: handlers.HandleGetDevice,
+		},
+		{
+			Method: http.MethodGet, Pattern: "/v2/devices/{serial}/packages", Tag: "devices",
+			Summary: "List packages installed on a device",
+			Params: []routes.Param{
+				serialParam,
+				{Name: "include-system", Type: routes.TypeBoolean},
+				{Name: "uninstalled", Type: routes.TypeBoolean, Description: "Include packages uninstalled with their data kept"},
+			},
+			Responses: []routes.Response{ok("Packages", []adb.Package{})},
+			Scopes:    []string{authentication.ScopeDevicesRead},
+			Tool:      "list_packages",
+			Handler:   handlers.HandleListPackages,
+		},
+		{
+			Method: http.MethodPost, Pattern: "/v2/devices/{serial}/packages", Tag: "devices",
+			Summary:     "Install an APK",
+			Description: "Either path names an APK on the server, or the APK is the request body.",
+			Params: []routes.Param{
+				serialParam,
+				{Name: "path", Description: "APK on the server's filesystem"},
+				{Name: "name", Description: "File name of an uploaded APK, for the audit log"},
+			},
+			Body:      &routes.Body{ContentType: "application/vnd.android.package-archive", Description: "The APK, when path isn't given"},
+			Responses: []routes.Response{ok("Installed", message)},
+			Scopes:    []string{authentication.ScopeDevicesWrite},
+			Tool:      "install_package",
+			Handler:   handlers.HandleInstallApp,
+		},
+		{
+			Method: http.MethodDelete, Pattern: "/v2/devices/{serial}/packages/{name}", Tag: "devices",
+			Summary: "Uninstall a package",
+			Params: []routes.Param{
+				serialParam,
+				{Name: "name", In: routes.InPath, Description: "Package name"},
+				{Name: "keep-data", Type: routes.TypeBoolean},
+				{Name: "user", Type: routes.TypeInteger, Minimum: routes.Minimum(0), Description: "Android user to uninstall for"},
+			},
+			Responses: []routes.Response{ok("Uninstalled", message)},
+			Scopes:    []string{authentication.ScopeDevicesWrite},
+			Tool:      "uninstall_package",
+			Handler:   handlers.HandleUninstallApp,
+		},
+		{
+			Method: http.MethodPost, Pattern: "/v2/devices/{serial}/shell", Tag: "devices",
+			Summary:   "Run a shell command on a device",
+			Params:    []routes.Param{serialParam},
+			Body:      &routes.Body{Schema: models.ShellRequest{}, Required: true},
+			Responses: []routes.Response{ok("What the command printed", models.ShellResult{})},
+			Scopes:    []string{authentication.ScopeDevicesWrite},
+			Tool:      "shell",
+			Handler:   handlers.HandleShell,
+		},
+		{
+			Method: http.MethodGet, Pattern: "/v2/devices/{serial}/screenshot", Tag: "devices",
+			Summary: "Take a screenshot",
+			Params:  []routes.Param{serialParam},
+			Responses: []routes.Response{
+				{Status: http.StatusOK, Description: "The screen", Body: &routes.Body{ContentType: "image/png"}},
+			},
+			Scopes:  []string{authentication.ScopeDevicesRead},
+			Tool:    "screenshot",
+			Handler: handlers.HandleScreenshot,
+		},
+	}
+}
@@ -0,0 +1,311 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Entry is one line of the audit log, one per device-mutating operation
+type Entry struct {
+	Time       time.Time         `json:"time"`
+	SessionID  string            `json:"session_id"`
+	RequestID  string            `json:"request_id,omitempty"`
+	Operation  string            `json:"operation"`
+	DeviceID   string            `json:"device_id,omitempty"`
+	Arguments  map[string]string `json:"arguments,omitempty"`
+	APKSHA256  string            `json:"apk_sha256,omitempty"`
+	DurationMS int64             `json:"duration_ms"`
+	Outcome    string            `json:"outcome"`
+	Error      string            `json:"error,omitempty"`
+}
+
+type Filter struct {
+	SessionID string
+	DeviceID  string
+	Operation string
+	Outcome   string
+	Since     time.Time
+	Until     time.Time
+	Limit     int
+}
+
+type Options struct {
+	Path     string
+	MaxBytes int64 // rotate once the current file would grow past this
+	MaxFiles int   // rotated files kept besides the current one
+}
+
+// Logger appends entries as JSON lines and rotates audit.log -> audit.log.1 -> audit.log.2 ...
+// Entries are never rewritten, only whole files fall off the end.
+type Logger struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	maxFiles int
+	file     *os.File
+	size     int64
+	onRecord []func(Entry)
+}
+
+func Open(options Options) (*Logger, error) {
+	if options.Path == "" {
+		return nil, errors.New("audit log path is required")
+	}
+
+	if options.MaxBytes <= 0 {
+		options.MaxBytes = 10 << 20
+	}
+
+	if options.MaxFiles <= 0 {
+		options.MaxFiles = 5
+	}
+
+	if err := os.MkdirAll(filepath.Dir(options.Path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create audit log dir: %w", err)
+	}
+
+	logger := &Logger{
+		path:     options.Path,
+		maxBytes: options.MaxBytes,
+		maxFiles: options.MaxFiles,
+	}
+
+	if err := logger.openCurrent(); err != nil {
+		return nil, err
+	}
+
+	return logger, nil
+}
+
+func (logger *Logger) Record(entry Entry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	line = append(line, '\n')
+
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+
+	if logger.size > 0 && logger.size+int64(len(line)) > logger.maxBytes {
+		if err := logger.rotate(); err != nil {
+			return fmt.Errorf("failed to rotate audit log: %w", err)
+		}
+	}
+
+	written, err := logger.file.Write(line)
+	logger.size += int64(written)
+
+	if err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+
+	for _, callback := range logger.onRecord {
+		callback(entry)
+	}
+
+	return nil
+}
+
+// RecordOutcome records an operation that started at started and just ended with
+// operationError. It's already done, so failing to write it is only logged.
+func (logger *Logger) RecordOutcome(entry Entry, started time.Time, operationError error) {
+	if logger == nil {
+		return
+	}
+
+	entry.Time = started.UTC()
+	entry.DurationMS = time.Since(started).Milliseconds()
+	entry.Outcome = OutcomeSuccess
+
+	if operationError != nil {
+		entry.Outcome = OutcomeFailure
+		entry.Error = operationError.Error()
+	}
+
+	if err := logger.Record(entry); err != nil {
+		log.Printf("Error writing audit entry for %s: %v", entry.Operation, err)
+	}
+}
+
+// OnRecord registers a callback run for every entry once it is written. Runs with the log
+// locked, so it must not record entries itself.
+func (logger *Logger) OnRecord(callback func(Entry)) {
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+
+	logger.onRecord = append(logger.onRecord, callback)
+}
+
+// Query returns matching entries newest first, reading rotated files too
+func (logger *Logger) Query(filter Filter) ([]Entry, error) {
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+
+	entries := []Entry{}
+
+	// Newest file first, and newest line first within each file
+	for index := 0; index <= logger.maxFiles; index++ {
+		fileEntries, err := readEntries(logger.rotatedPath(index))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		slices.Reverse(fileEntries)
+
+		for _, entry := range fileEntries {
+			if !filter.matches(entry) {
+				continue
+			}
+
+			entries = append(entries, entry)
+
+			if filter.Limit > 0 && len(entries) >= filter.Limit {
+				return entries, nil
+			}
+		}
+	}
+
+	return entries, nil
+}
+
+func (logger *Logger) Close() error {
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+
+	return logger.file.Close()
+}
+
+// HashFile is used to record exactly which APK was installed
+func HashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (filter Filter) matches(entry Entry) bool {
+	if filter.SessionID != "" && entry.SessionID != filter.SessionID {
+		return false
+	}
+	if filter.DeviceID != "" && entry.DeviceID != filter.DeviceID {
+		return false
+	}
+	if filter.Operation != "" && entry.Operation != filter.Operation {
+		return false
+	}
+	if filter.Outcome != "" && entry.Outcome != filter.Outcome {
+		return false
+	}
+	if !filter.Since.IsZero() && entry.Time.Before(filter.Since) {
+		return false
+	}
+	if !filter.Until.IsZero() && entry.Time.After(filter.Until) {
+		return false
+	}
+
+	return true
+}
+
+func (logger *Logger) openCurrent() error {
+	file, err := os.OpenFile(logger.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	logger.file = file
+	logger.size = info.Size()
+
+	return nil
+}
+
+func (logger *Logger) rotate() error {
+	if err := logger.file.Close(); err != nil {
+		return err
+	}
+
+	// Oldest one falls off, everything else shifts up by one
+	_ = os.Remove(logger.rotatedPath(logger.maxFiles))
+
+	for index := logger.maxFiles - 1; index >= 0; index-- {
+		err := os.Rename(logger.rotatedPath(index), logger.rotatedPath(index+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return logger.openCurrent()
+}
+
+func (logger *Logger) rotatedPath(index int) string {
+	if index == 0 {
+		return logger.path
+	}
+
+	return logger.path + "." + strconv.Itoa(index)
+}
+
+func readEntries(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []Entry
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+
+	for scanner.Scan() {
+		var entry Entry
+
+		// A torn last line after a crash shouldn't make the whole log unreadable
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
@@ -2,14 +2,26 @@ package authentication
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"log"
+	"time"
 )
 
-var pairingToken string
+// Session is a single paired client. We only ever keep the hash of the token around,
+// the raw token lives in the client's cookie and nowhere else.
+type Session struct {
+	ID                string    `json:"id"`
+	TokenHash         string    `json:"token_hash,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+	LastSeen          time.Time `json:"last_seen"`
+	ClientCertificate string    `json:"client_certificate,omitempty"` // subject CN for mTLS sessions
+	Scopes            []string  `json:"scopes,omitempty"`             // every scope when empty
+}
 
-func GenerateAuthToken(tokenLength int) (string, error) {
+// GenerateAuthToken creates a session limited to scopes, or with all of them when none are given
+func GenerateAuthToken(tokenLength int, scopes ...string) (string, error) {
 	tokenBytes := make([]byte, tokenLength)
 
 	_, err := rand.Read(tokenBytes)
@@ -21,19 +33,73 @@ func GenerateAuthToken(tokenLength int) (string, error) {
 
 	token := hex.EncodeToString(tokenBytes) // Converting raw bytes to a string
 
-	pairingToken = token
+	sessionID, err := newSessionID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+
+	session := Session{
+		ID:        sessionID,
+		TokenHash: hashToken(token),
+		CreatedAt: now,
+		LastSeen:  now,
+		Scopes:    scopes,
+	}
+
+	if err := store.add(session); err != nil {
+		return "", fmt.Errorf("failed to persist session: %w", err)
+	}
 
 	return token, nil
 }
 
 func VerifyAuthToken(token string) bool {
-	if pairingToken == "" || token == "" {
-		return false
+	_, ok := LookupSession(token)
+	return ok
+}
+
+// LookupSession returns the session a raw token belongs to
+func LookupSession(token string) (Session, bool) {
+	if token == "" {
+		return Session{}, false
 	}
-	return token == pairingToken
+
+	return store.touch(hashToken(token))
+}
+
+// RevokeSession forgets the session the token belongs to, so the client has to pair again
+func RevokeSession(token string) error {
+	return store.remove(hashToken(token))
+}
+
+// ResetSessions forgets every paired client
+func ResetSessions() error {
+	return store.reset()
 }
 
 // Insecure? probably, fast? definitely
 func IsPaired() bool {
-	return pairingToken != ""
+	return store.count() > 0
+}
+
+// SessionCount is how many clients are currently paired
+func SessionCount() int {
+	return store.count()
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newSessionID() (string, error) {
+	idBytes := make([]byte, 8)
+
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", fmt.Errorf("failed to generate session id: %w", err)
+	}
+
+	return hex.EncodeToString(idBytes), nil
 }
@@ -0,0 +1,429 @@
+package authentication
+
+import (
+	"adb-server/utilities"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bump this whenever the on-disk layout changes and add a migration below
+const credentialFileVersion = 1
+
+const credentialFileName = "credentials.json"
+const keyringFileName = "credentials.key"
+
+// How stale LastSeen may get before touching a session is written back to disk
+const lastSeenFlushInterval = time.Minute
+
+// credentialFile is the envelope stored on disk. Payload is used for plaintext files,
+// Ciphertext (AES-256-GCM over the same payload) when a key is configured.
+type credentialFile struct {
+	Version    int             `json:"version"`
+	Cipher     string          `json:"cipher,omitempty"`
+	Nonce      string          `json:"nonce,omitempty"`
+	Ciphertext string          `json:"ciphertext,omitempty"`
+	Payload    json.RawMessage `json:"payload,omitempty"`
+}
+
+type credentialPayload struct {
+	Sessions []Session `json:"sessions"`
+	Secret   string    `json:"secret,omitempty"` // base64, used to derive CSRF tokens
+}
+
+// Each migration takes the raw decoded file at version N and rewrites it in place to N+1
+var credentialMigrations = map[int]func(raw map[string]any) error{
+	0: migrateUnversionedCredentials,
+}
+
+type credentialStore struct {
+	mu       sync.Mutex
+	path     string // empty means memory only
+	key      []byte // nil means plaintext
+	secret   []byte
+	sessions map[string]Session
+}
+
+// Until LoadCredentials is called sessions only live in memory, same as before
+var store = &credentialStore{sessions: map[string]Session{}, secret: newSecret()}
+
+// DefaultCredentialsPath is where the store lives unless told otherwise
+func DefaultCredentialsPath(configDir string) string {
+	return filepath.Join(configDir, credentialFileName)
+}
+
+// LoadCredentials points the store at a file and loads any sessions from it.
+// A nil key keeps the file in plaintext (tokens are hashed either way).
+func LoadCredentials(path string, key []byte) error {
+	if key != nil && len(key) != 32 {
+		return errors.New("credentials key must be 32 bytes")
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.path = path
+	store.key = key
+	store.sessions = map[string]Session{}
+	store.secret = newSecret()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read credentials file: %w", err)
+	}
+
+	file, migrated, err := migrateCredentialFile(data)
+	if err != nil {
+		return err
+	}
+
+	payload, err := decodeCredentialPayload(file, key)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range payload.Sessions {
+		store.sessions[session.TokenHash] = session
+	}
+
+	secret, err := base64.StdEncoding.DecodeString(payload.Secret)
+	if err != nil || len(secret) == 0 {
+		// Older files don't have one yet, generating it invalidates nothing but CSRF tokens
+		secret = newSecret()
+		migrated = true
+	}
+
+	store.secret = secret
+
+	// Rewrite the file if it was migrated or its encryption doesn't match the key we were given
+	encrypted := file.Cipher != ""
+	if migrated || encrypted != (key != nil) {
+		return store.saveLocked()
+	}
+
+	return nil
+}
+
+// ParseCredentialsKey accepts a 32 byte key as hex or base64
+func ParseCredentialsKey(encoded string) ([]byte, error) {
+	encoded = strings.TrimSpace(encoded)
+
+	if key, err := hex.DecodeString(encoded); err == nil && len(key) == 32 {
+		return key, nil
+	}
+
+	if key, err := base64.StdEncoding.DecodeString(encoded); err == nil && len(key) == 32 {
+		return key, nil
+	}
+
+	return nil, errors.New("credentials key must be 32 bytes encoded as hex or base64")
+}
+
+// KeyringKey is our stand-in for an OS keyring: a random key kept next to the store
+// in its own owner-only file, created on first use.
+func KeyringKey(configDir string) ([]byte, error) {
+	keyPath := filepath.Join(configDir, keyringFileName)
+
+	data, err := os.ReadFile(keyPath)
+	if err == nil {
+		return ParseCredentialsKey(string(data))
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read keyring file: %w", err)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate credentials key: %w", err)
+	}
+
+	if err := utilities.WriteFileAtomic(keyPath, []byte(hex.EncodeToString(key))); err != nil {
+		return nil, fmt.Errorf("failed to write keyring file: %w", err)
+	}
+
+	return key, nil
+}
+
+func (credentials *credentialStore) add(session Session) error {
+	credentials.mu.Lock()
+	defer credentials.mu.Unlock()
+
+	credentials.sessions[session.TokenHash] = session
+
+	return credentials.saveLocked()
+}
+
+func (credentials *credentialStore) touch(tokenHash string) (Session, bool) {
+	credentials.mu.Lock()
+	defer credentials.mu.Unlock()
+
+	session, ok := credentials.sessions[tokenHash]
+	if !ok {
+		return Session{}, false
+	}
+
+	now := time.Now().UTC()
+	flush := now.Sub(session.LastSeen) > lastSeenFlushInterval
+
+	session.LastSeen = now
+	credentials.sessions[tokenHash] = session
+
+	if flush {
+		// Best effort, failing to record LastSeen shouldn't lock anyone out
+		_ = credentials.saveLocked()
+	}
+
+	return session, true
+}
+
+func (credentials *credentialStore) remove(tokenHash string) error {
+	credentials.mu.Lock()
+	defer credentials.mu.Unlock()
+
+	if _, ok := credentials.sessions[tokenHash]; !ok {
+		return nil
+	}
+
+	delete(credentials.sessions, tokenHash)
+
+	return credentials.saveLocked()
+}
+
+func (credentials *credentialStore) reset() error {
+	credentials.mu.Lock()
+	defer credentials.mu.Unlock()
+
+	credentials.sessions = map[string]Session{}
+
+	return credentials.saveLocked()
+}
+
+func (credentials *credentialStore) serverSecret() []byte {
+	credentials.mu.Lock()
+	defer credentials.mu.Unlock()
+
+	return credentials.secret
+}
+
+func (credentials *credentialStore) count() int {
+	credentials.mu.Lock()
+	defer credentials.mu.Unlock()
+
+	return len(credentials.sessions)
+}
+
+func (credentials *credentialStore) saveLocked() error {
+	if credentials.path == "" {
+		return nil
+	}
+
+	payload := credentialPayload{
+		Sessions: make([]Session, 0, len(credentials.sessions)),
+		Secret:   base64.StdEncoding.EncodeToString(credentials.secret),
+	}
+	for _, session := range credentials.sessions {
+		payload.Sessions = append(payload.Sessions, session)
+	}
+
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	file := credentialFile{Version: credentialFileVersion}
+
+	if credentials.key != nil {
+		nonce, ciphertext, err := seal(credentials.key, payloadBytes)
+		if err != nil {
+			return err
+		}
+
+		file.Cipher = "aes-256-gcm"
+		file.Nonce = base64.StdEncoding.EncodeToString(nonce)
+		file.Ciphertext = base64.StdEncoding.EncodeToString(ciphertext)
+	} else {
+		file.Payload = payloadBytes
+	}
+
+	fileBytes, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return utilities.WriteFileAtomic(credentials.path, fileBytes)
+}
+
+func migrateCredentialFile(data []byte) (credentialFile, bool, error) {
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return credentialFile{}, false, fmt.Errorf("credentials file is corrupt: %w", err)
+	}
+
+	version := 0
+	if versionNumber, ok := raw["version"].(float64); ok {
+		version = int(versionNumber)
+	}
+
+	if version > credentialFileVersion {
+		return credentialFile{}, false, fmt.Errorf("credentials file version %d is newer than this server supports (%d)", version, credentialFileVersion)
+	}
+
+	migrated := false
+
+	for version < credentialFileVersion {
+		migration, ok := credentialMigrations[version]
+		if !ok {
+			return credentialFile{}, false, fmt.Errorf("no migration for credentials file version %d", version)
+		}
+
+		if err := migration(raw); err != nil {
+			return credentialFile{}, false, fmt.Errorf("failed to migrate credentials file from version %d: %w", version, err)
+		}
+
+		version++
+		raw["version"] = version
+		migrated = true
+	}
+
+	// Round trip through JSON to land in the typed struct
+	normalized, err := json.Marshal(raw)
+	if err != nil {
+		return credentialFile{}, false, err
+	}
+
+	var file credentialFile
+	if err := json.Unmarshal(normalized, &file); err != nil {
+		return credentialFile{}, false, fmt.Errorf("credentials file is corrupt: %w", err)
+	}
+
+	return file, migrated, nil
+}
+
+// Unversioned files are the hand written / pre-release layout: {"tokens": ["<raw token>", ...]}.
+// Hash the tokens and wrap them in a v1 plaintext envelope.
+func migrateUnversionedCredentials(raw map[string]any) error {
+	tokens, _ := raw["tokens"].([]any)
+
+	now := time.Now().UTC()
+	payload := credentialPayload{Sessions: []Session{}}
+
+	for _, token := range tokens {
+		tokenString, ok := token.(string)
+		if !ok || tokenString == "" {
+			continue
+		}
+
+		sessionID, err := newSessionID()
+		if err != nil {
+			return err
+		}
+
+		payload.Sessions = append(payload.Sessions, Session{
+			ID:        sessionID,
+			TokenHash: hashToken(tokenString),
+			CreatedAt: now,
+			LastSeen:  now,
+		})
+	}
+
+	delete(raw, "tokens")
+	raw["payload"] = payload
+
+	return nil
+}
+
+func decodeCredentialPayload(file credentialFile, key []byte) (credentialPayload, error) {
+	var payload credentialPayload
+
+	payloadBytes := []byte(file.Payload)
+
+	if file.Cipher != "" {
+		if file.Cipher != "aes-256-gcm" {
+			return payload, fmt.Errorf("unsupported credentials cipher %q", file.Cipher)
+		}
+
+		if key == nil {
+			return payload, errors.New("credentials file is encrypted but no key was configured")
+		}
+
+		nonce, err := base64.StdEncoding.DecodeString(file.Nonce)
+		if err != nil {
+			return payload, fmt.Errorf("credentials file nonce is corrupt: %w", err)
+		}
+
+		ciphertext, err := base64.StdEncoding.DecodeString(file.Ciphertext)
+		if err != nil {
+			return payload, fmt.Errorf("credentials file ciphertext is corrupt: %w", err)
+		}
+
+		payloadBytes, err = open(key, nonce, ciphertext)
+		if err != nil {
+			return payload, errors.New("failed to decrypt credentials file, wrong key?")
+		}
+	}
+
+	if len(payloadBytes) == 0 {
+		return payload, nil
+	}
+
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return payload, fmt.Errorf("credentials payload is corrupt: %w", err)
+	}
+
+	return payload, nil
+}
+
+func newSecret() []byte {
+	secret := make([]byte, 32)
+
+	if _, err := rand.Read(secret); err != nil {
+		panic(fmt.Sprintf("failed to generate server secret: %v", err))
+	}
+
+	return secret
+}
+
+func seal(key []byte, plaintext []byte) (nonce []byte, ciphertext []byte, err error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+
+	return nonce, aead.Seal(nil, nonce, plaintext, nil), nil
+}
+
+func open(key []byte, nonce []byte, ciphertext []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,63 @@
+package authentication
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Scopes limit what a session may do. Sessions without any have all of them, which is what
+// pairing hands out unless the client asks for less, and what the unix socket gets.
+const (
+	ScopeDevicesRead   = "devices:read"   // list devices and packages, watch events, pull files and bugreports
+	ScopeDevicesWrite  = "devices:write"  // install, uninstall, push, shell, reboot, cancel jobs
+	ScopeRegistryWrite = "registry:write" // change device names, tags and groups
+	ScopeAuditRead     = "audit:read"
+	ScopeAdmin         = "admin" // shut the server down, manage webhooks
+)
+
+var AllScopes = []string{ScopeDevicesRead, ScopeDevicesWrite, ScopeRegistryWrite, ScopeAuditRead, ScopeAdmin}
+
+// Scopes that sessions authenticated by a client certificate get, all of them when empty
+var clientCertificateScopes []string
+
+func SetClientCertificateScopes(scopes []string) {
+	clientCertificateScopes = scopes
+}
+
+// HasScope says whether the session may do what scope covers
+func (session Session) HasScope(scope string) bool {
+	return len(session.Scopes) == 0 || slices.Contains(session.Scopes, scope)
+}
+
+// ParseScopes splits a comma separated list and rejects scopes we don't know
+func ParseScopes(list string) ([]string, error) {
+	var scopes []string
+
+	for _, scope := range strings.Split(list, ",") {
+		scope = strings.TrimSpace(scope)
+		if scope == "" {
+			continue
+		}
+
+		if err := ValidateScopes([]string{scope}); err != nil {
+			return nil, err
+		}
+
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return scopes, nil
+}
+
+func ValidateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		if !slices.Contains(AllScopes, scope) {
+			return fmt.Errorf("unknown scope %q, use %s", scope, strings.Join(AllScopes, ", "))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,25 @@
+package authentication
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+)
+
+// SessionForClientCertificate maps a verified mTLS client certificate to a session.
+// Nothing is stored, the certificate is the credential so the same cert always lands on the same session.
+func SessionForClientCertificate(certificate *x509.Certificate) Session {
+	sum := sha256.Sum256(certificate.Raw)
+
+	return Session{
+		ID:                "cert-" + hex.EncodeToString(sum[:8]),
+		CreatedAt:         certificate.NotBefore,
+		ClientCertificate: certificate.Subject.CommonName,
+		Scopes:            clientCertificateScopes,
+	}
+}
+
+// LocalSocketSession is the session every connection over the owner-only unix socket gets
+func LocalSocketSession() Session {
+	return Session{ID: "local-socket"}
+}
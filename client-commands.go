@@ -0,0 +1,490 @@
+package main
+
+import (
+	"adb-server/client"
+	"adb-server/discovery"
+	"adb-server/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// Subcommands that talk to a running server instead of being one. They find it through the
+// discovery file the server writes and reuse the session adb-server pair stored.
+var clientCommands = map[string]func(ctx context.Context, args []string) int{
+	"pair":      runPairCommand,
+	"unpair":    runUnpairCommand,
+	"devices":   runDevicesCommand,
+	"packages":  runPackagesCommand,
+	"install":   runInstallCommand,
+	"uninstall": runUninstallCommand,
+	"logs":      runLogsCommand,
+	"mcp":       runMCPCommand,
+}
+
+func runClientCommand(name string, args []string) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return clientCommands[name](ctx, args)
+}
+
+// adb-server pair [-server URL] [-scopes ...]
+func runPairCommand(ctx context.Context, args []string) int {
+	flags, common := newClientFlagSet("pair", "pair [-server URL] [-scopes devices:read,...] [-fingerprint SHA-256]")
+	scopes := flags.String("scopes", "", "comma separated scopes to limit the session to, all of them when empty")
+	flags.StringVar(&common.fingerprint, "fingerprint", "", "the TLS certificate fingerprint the server logged at startup, pinned for every later call")
+	if _, code, ok := parseClientFlags(flags, args); !ok {
+		return code
+	}
+
+	adbServer, address, err := connectClient(common)
+	if err != nil {
+		return clientError(err)
+	}
+
+	if strings.HasPrefix(address, "unix:") {
+		fmt.Printf("%s is a unix socket, it needs no pairing\n", address)
+		return models.ExitOK
+	}
+
+	var scopeList []string
+	if *scopes != "" {
+		scopeList = strings.Split(*scopes, ",")
+	}
+
+	result, err := adbServer.Pair(ctx, scopeList...)
+	if errors.Is(err, client.ErrAlreadyPaired) {
+		fmt.Fprintln(os.Stderr, "The server is already paired with another client. Unpair that one or restart the server with -reset-pairings.")
+		return models.ExitError
+	}
+	if err != nil {
+		return clientError(err)
+	}
+
+	if err := saveClientSession(adbServer, common.fingerprint); err != nil {
+		return clientError(err)
+	}
+
+	if common.json {
+		return printJSON(result)
+	}
+
+	fmt.Printf("Paired with %s\n", address)
+	if len(result.Scopes) > 0 {
+		fmt.Printf("Scopes: %s\n", strings.Join(result.Scopes, ", "))
+	}
+
+	return models.ExitOK
+}
+
+// adb-server unpair
+func runUnpairCommand(ctx context.Context, args []string) int {
+	flags, common := newClientFlagSet("unpair", "unpair [-server URL]")
+	if _, code, ok := parseClientFlags(flags, args); !ok {
+		return code
+	}
+
+	adbServer, _, err := connectClient(common)
+	if err != nil {
+		return clientError(err)
+	}
+
+	// Forget it locally even when the server already had, that's what the user asked for
+	if err := adbServer.Unpair(ctx); err != nil && !errors.Is(err, client.ErrUnauthorized) {
+		return clientError(err)
+	}
+
+	if err := removeClientSession(); err != nil {
+		return clientError(err)
+	}
+
+	fmt.Println("Unpaired")
+
+	return models.ExitOK
+}
+
+// adb-server devices [-tag lab] [-all] [-json]
+func runDevicesCommand(ctx context.Context, args []string) int {
+	flags, common := newClientFlagSet("devices", "devices [-tag TAG] [-all] [-json]")
+	tag := flags.String("tag", "", "only devices with this registry tag")
+	all := flags.Bool("all", false, "include registered devices adb can't see right now")
+
+	if _, code, ok := parseClientFlags(flags, args); !ok {
+		return code
+	}
+
+	return withClient(ctx, common, func(adbServer *client.Client) error {
+		devices, err := adbServer.Devices(ctx, client.DeviceFilter{Tag: *tag, IncludeOffline: *all})
+		if err != nil {
+			return err
+		}
+
+		if common.json {
+			return writeJSON(devices)
+		}
+
+		return writeTable([]string{"SERIAL", "STATE", "MODEL", "NAME", "TAGS"}, len(devices), func(row int) []string {
+			device := devices[row]
+			return []string{device.Serial, device.State, device.Model, device.Name, strings.Join(device.Tags, ",")}
+		})
+	})
+}
+
+// adb-server packages -device SERIAL [-system] [-uninstalled] [-json]
+func runPackagesCommand(ctx context.Context, args []string) int {
+	flags, common := newClientFlagSet("packages", "packages -device SERIAL [-system] [-uninstalled] [-json]")
+	device := flags.String("device", "", "serial or registry selector (tag:, group:, name:) of the device")
+	system := flags.Bool("system", false, "include system packages")
+	uninstalled := flags.Bool("uninstalled", false, "include packages uninstalled with their data kept")
+
+	if _, code, ok := parseClientFlags(flags, args); !ok {
+		return code
+	}
+
+	if *device == "" {
+		return usageError(flags, "-device is required")
+	}
+
+	return withClient(ctx, common, func(adbServer *client.Client) error {
+		packages, err := adbServer.Packages(ctx, *device, client.PackageOptions{IncludeSystem: *system, IncludeUninstalled: *uninstalled})
+		if err != nil {
+			return err
+		}
+
+		slices.SortFunc(packages, func(a, b client.Package) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+
+		if common.json {
+			return writeJSON(packages)
+		}
+
+		return writeTable([]string{"PACKAGE", "PATH"}, len(packages), func(row int) []string {
+			return []string{packages[row].Name, packages[row].ApkPath}
+		})
+	})
+}
+
+// adb-server install -device SERIAL [-async] app.apk...
+func runInstallCommand(ctx context.Context, args []string) int {
+	flags, common := newClientFlagSet("install", "install -device SERIAL [-async] app.apk...")
+	device := flags.String("device", "", "serial or registry selector (tag:, group:, name:) of the device")
+	async := flags.Bool("async", false, "start install jobs and print them instead of waiting")
+
+	apks, code, ok := parseClientFlags(flags, args)
+	if !ok {
+		return code
+	}
+
+	if *device == "" {
+		return usageError(flags, "-device is required")
+	}
+
+	if len(apks) == 0 {
+		return usageError(flags, "no APK given")
+	}
+
+	return withClient(ctx, common, func(adbServer *client.Client) error {
+		var jobs []client.Job
+
+		for _, apk := range apks {
+			if *async {
+				job, err := adbServer.InstallFileJob(ctx, *device, apk)
+				if err != nil {
+					return fmt.Errorf("%s: %w", apk, err)
+				}
+
+				jobs = append(jobs, job)
+
+				if !common.json {
+					fmt.Printf("Installing %s on %s as job %s\n", filepath.Base(apk), *device, job.ID)
+				}
+
+				continue
+			}
+
+			if err := adbServer.InstallFile(ctx, *device, apk); err != nil {
+				return fmt.Errorf("%s: %w", apk, err)
+			}
+
+			if !common.json {
+				fmt.Printf("Installed %s on %s\n", filepath.Base(apk), *device)
+			}
+		}
+
+		if common.json && *async {
+			return writeJSON(jobs)
+		}
+
+		if common.json {
+			return writeJSON(map[string]any{"installed": apks, "device_id": *device})
+		}
+
+		return nil
+	})
+}
+
+// adb-server uninstall -device SERIAL [-keep-data] [-user N] package...
+func runUninstallCommand(ctx context.Context, args []string) int {
+	flags, common := newClientFlagSet("uninstall", "uninstall -device SERIAL [-keep-data] [-user N] package...")
+	device := flags.String("device", "", "serial or registry selector (tag:, group:, name:) of the device")
+	keepData := flags.Bool("keep-data", false, "keep the app's data and cache")
+	user := flags.Int("user", -1, "only uninstall for this Android user, every user when -1")
+
+	packageNames, code, ok := parseClientFlags(flags, args)
+	if !ok {
+		return code
+	}
+
+	if *device == "" {
+		return usageError(flags, "-device is required")
+	}
+
+	if len(packageNames) == 0 {
+		return usageError(flags, "no package given")
+	}
+
+	options := client.UninstallOptions{KeepData: *keepData}
+	if *user >= 0 {
+		options.User = user
+	}
+
+	return withClient(ctx, common, func(adbServer *client.Client) error {
+		for _, packageName := range packageNames {
+			if err := adbServer.Uninstall(ctx, *device, packageName, options); err != nil {
+				return fmt.Errorf("%s: %w", packageName, err)
+			}
+
+			if !common.json {
+				fmt.Printf("Uninstalled %s from %s\n", packageName, *device)
+			}
+		}
+
+		if common.json {
+			return writeJSON(map[string]any{"uninstalled": packageNames, "device_id": *device})
+		}
+
+		return nil
+	})
+}
+
+// adb-server logs [-device SERIAL] [-operation install] [-failed] [-limit 20] [-follow] [-json]
+// The server's record of what was done to devices, and with -follow what happens from now on.
+func runLogsCommand(ctx context.Context, args []string) int {
+	flags, common := newClientFlagSet("logs", "logs [-device SERIAL] [-operation OP] [-failed] [-limit N] [-follow] [-json]")
+	device := flags.String("device", "", "only operations on this device")
+	operation := flags.String("operation", "", "only this operation (install, uninstall, push, ...)")
+	failed := flags.Bool("failed", false, "only operations that failed")
+	limit := flags.Int("limit", 20, "how many past operations to show")
+	follow := flags.Bool("follow", false, "keep printing operations, device and job events as they happen")
+
+	if _, code, ok := parseClientFlags(flags, args); !ok {
+		return code
+	}
+
+	filter := client.AuditFilter{DeviceID: *device, Operation: *operation, Limit: *limit}
+	if *failed {
+		filter.Outcome = "failure"
+	}
+
+	return withClient(ctx, common, func(adbServer *client.Client) error {
+		var subscription *client.Subscription
+
+		// Subscribe first so nothing falls between the history and the stream
+		if *follow {
+			var err error
+			if subscription, err = adbServer.Subscribe(ctx); err != nil {
+				return err
+			}
+			defer subscription.Close()
+		}
+
+		entries, err := adbServer.Audit(ctx, filter)
+		if err != nil {
+			return err
+		}
+
+		// The server answers newest first, logs read the other way
+		slices.Reverse(entries)
+
+		for _, entry := range entries {
+			printLogEntry(common.json, entry)
+		}
+
+		if subscription == nil {
+			return nil
+		}
+
+		for event := range subscription.Events {
+			printEvent(common.json, event, filter)
+		}
+
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		return subscription.Err()
+	})
+}
+
+func printLogEntry(asJSON bool, entry client.AuditEntry) {
+	if asJSON {
+		_ = writeJSONLine(entry)
+		return
+	}
+
+	line := fmt.Sprintf("%s  %-9s %-10s %-16s %s", entry.Time.Local().Format(time.DateTime), entry.Outcome, entry.Operation, entry.DeviceID, formatArguments(entry.Arguments))
+	if entry.Error != "" {
+		line += "  error: " + entry.Error
+	}
+
+	fmt.Println(strings.TrimRight(line, " "))
+}
+
+func printEvent(asJSON bool, event client.Event, filter client.AuditFilter) {
+	if event.Type == client.EventOperation {
+		entry, err := event.AuditEntry()
+		if err != nil || !matchesAuditFilter(entry, filter) {
+			return
+		}
+
+		printLogEntry(asJSON, entry)
+		return
+	}
+
+	// Device and job events only get in the way when looking at one kind of operation
+	if filter.Operation != "" || filter.Outcome != "" {
+		return
+	}
+
+	if asJSON {
+		_ = writeJSONLine(event)
+		return
+	}
+
+	timestamp := event.Time.Local().Format(time.DateTime)
+
+	switch event.Type {
+	case client.EventJobFinished:
+		job, err := event.Job()
+		if err != nil || (filter.DeviceID != "" && job.DeviceID != filter.DeviceID) {
+			return
+		}
+
+		fmt.Printf("%s  job %s %s on %s %s %s\n", timestamp, job.ID, job.Type, job.DeviceID, job.Status, job.Error)
+	default:
+		change, err := event.DeviceChange()
+		if err != nil || (filter.DeviceID != "" && change.Serial != filter.DeviceID) {
+			return
+		}
+
+		fmt.Printf("%s  %s %s (%s)\n", timestamp, event.Type, change.Serial, change.State)
+	}
+}
+
+// The history is filtered by the server, the live stream by us
+func matchesAuditFilter(entry client.AuditEntry, filter client.AuditFilter) bool {
+	return (filter.DeviceID == "" || entry.DeviceID == filter.DeviceID) &&
+		(filter.Operation == "" || entry.Operation == filter.Operation) &&
+		(filter.Outcome == "" || entry.Outcome == filter.Outcome)
+}
+
+func formatArguments(arguments map[string]string) string {
+	var parts []string
+	for name, value := range arguments {
+		parts = append(parts, name+"="+value)
+	}
+
+	slices.Sort(parts)
+
+	return strings.Join(parts, " ")
+}
+
+// withClient connects, runs the command and saves the session back, whatever the CSRF token turned into
+func withClient(ctx context.Context, common *clientFlags, command func(adbServer *client.Client) error) int {
+	adbServer, _, err := connectClient(common)
+	if err != nil {
+		return clientError(err)
+	}
+
+	err = command(adbServer)
+
+	if saveError := saveClientSession(adbServer, ""); saveError != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't store the session: %v\n", saveError)
+	}
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return models.ExitError
+		}
+
+		return clientError(err)
+	}
+
+	return models.ExitOK
+}
+
+// clientError prints err with a hint for the mistakes people actually make
+func clientError(err error) int {
+	fmt.Fprintln(os.Stderr, err)
+
+	switch {
+	case errors.Is(err, discovery.ErrNoServer):
+	case errors.Is(err, client.ErrUnauthorized):
+		fmt.Fprintln(os.Stderr, "Not paired with this server, run adb-server pair first.")
+	case errors.Is(err, syscall.ECONNREFUSED):
+		fmt.Fprintln(os.Stderr, "The server isn't running anymore, start it again or pass -server.")
+	}
+
+	return models.ExitError
+}
+
+func usageError(flags *flag.FlagSet, message string) int {
+	fmt.Fprintln(os.Stderr, message)
+	flags.Usage()
+
+	return models.ExitConfig
+}
+
+func printJSON(value any) int {
+	if err := writeJSON(value); err != nil {
+		return clientError(err)
+	}
+
+	return models.ExitOK
+}
+
+func writeJSON(value any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(value)
+}
+
+// One object per line, for output that keeps coming
+func writeJSONLine(value any) error {
+	return json.NewEncoder(os.Stdout).Encode(value)
+}
+
+func writeTable(header []string, rows int, row func(index int) []string) error {
+	table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(table, strings.Join(header, "\t"))
+
+	for index := range rows {
+		fmt.Fprintln(table, strings.Join(row(index), "\t"))
+	}
+
+	return table.Flush()
+}
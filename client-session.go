@@ -0,0 +1,187 @@
+package main
+
+import (
+	"adb-server/client"
+	"adb-server/discovery"
+	"adb-server/models"
+	"adb-server/utilities"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Where the command line client keeps its pairing. Sessions outlive server restarts, the
+// server's address doesn't, so this isn't tied to one.
+const clientSessionFile = "cli-session.json"
+
+type clientSession struct {
+	Token          string `json:"token"`
+	CSRFToken      string `json:"csrf_token,omitempty"`
+	TLSFingerprint string `json:"tls_fingerprint,omitempty"`
+}
+
+// clientFlags are the ones every client subcommand takes
+type clientFlags struct {
+	server      string
+	json        bool
+	fingerprint string // only pair has a flag for it, the session keeps it after that
+}
+
+func newClientFlagSet(name string, usage string) (*flag.FlagSet, *clientFlags) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	common := &clientFlags{}
+
+	flags.StringVar(&common.server, "server", os.Getenv("ADB_SERVER_URL"), "server URL or unix socket path, found through the discovery file when empty (env ADB_SERVER_URL)")
+	flags.BoolVar(&common.json, "json", false, "print JSON instead of a table")
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: adb-server %s\n", usage)
+		flags.PrintDefaults()
+	}
+
+	return flags, common
+}
+
+// parseClientFlags lets flags and arguments come in any order, install app.apk -device X reads as well as the other way round
+func parseClientFlags(flags *flag.FlagSet, args []string) ([]string, int, bool) {
+	var positional []string
+
+	for {
+		if err := flags.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, models.ExitOK, false
+			}
+			return nil, models.ExitConfig, false
+		}
+
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, models.ExitOK, true
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// connectClient builds a client for the server named by -server or the discovery file, with
+// the stored session when there is one
+func connectClient(common *clientFlags) (*client.Client, string, error) {
+	configDir, err := utilities.ConfigDir()
+	if err != nil {
+		return nil, "", err
+	}
+
+	session, err := readClientSession(configDir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	options := client.Options{
+		Token:          session.Token,
+		CSRFToken:      session.CSRFToken,
+		TLSFingerprint: session.TLSFingerprint,
+		UserAgent:      "adb-server-cli",
+	}
+
+	switch {
+	case strings.HasPrefix(common.server, "http://") || strings.HasPrefix(common.server, "https://"):
+		options.BaseURL = common.server
+	case common.server != "":
+		options.UnixSocket = strings.TrimPrefix(common.server, "unix:")
+	default:
+		info, err := discovery.Read(discovery.Path(configDir))
+		if err != nil {
+			return nil, "", err
+		}
+
+		options.BaseURL = info.URL
+		options.UnixSocket = info.UnixSocket
+
+		// The server wrote it into our own config dir, that's as trustworthy as the pin from pairing
+		if info.TLSFingerprint != "" {
+			options.TLSFingerprint = info.TLSFingerprint
+		}
+	}
+
+	if common.fingerprint != "" {
+		options.TLSFingerprint = common.fingerprint
+	}
+
+	adbServer, err := client.New(options)
+	if err != nil {
+		return nil, "", err
+	}
+
+	address := options.BaseURL
+	if options.UnixSocket != "" {
+		address = "unix:" + options.UnixSocket
+	}
+
+	return adbServer, address, nil
+}
+
+func readClientSession(configDir string) (clientSession, error) {
+	var session clientSession
+
+	data, err := os.ReadFile(filepath.Join(configDir, clientSessionFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return session, nil
+	}
+	if err != nil {
+		return session, err
+	}
+
+	if err := json.Unmarshal(data, &session); err != nil {
+		return session, fmt.Errorf("reading %s: %w", clientSessionFile, err)
+	}
+
+	return session, nil
+}
+
+// saveClientSession keeps whatever the client ended up with, the CSRF token may have been refreshed
+func saveClientSession(adbServer *client.Client, tlsFingerprint string) error {
+	configDir, err := utilities.ConfigDir()
+	if err != nil {
+		return err
+	}
+
+	token, csrfToken := adbServer.Session()
+	if token == "" {
+		return nil
+	}
+
+	previous, _ := readClientSession(configDir)
+	if tlsFingerprint == "" {
+		tlsFingerprint = previous.TLSFingerprint
+	}
+
+	session := clientSession{Token: token, CSRFToken: csrfToken, TLSFingerprint: tlsFingerprint}
+	if session == previous {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(session, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return utilities.WriteFileAtomic(filepath.Join(configDir, clientSessionFile), data)
+}
+
+func removeClientSession() error {
+	configDir, err := utilities.ConfigDir()
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(filepath.Join(configDir, clientSessionFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
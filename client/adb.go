@@ -0,0 +1,156 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const apkContentType = "application/vnd.android.package-archive"
+
+// Devices lists what adb sees, with the registry's names and tags when the server keeps one
+func (client *Client) Devices(ctx context.Context, filter DeviceFilter) ([]Device, error) {
+	query := url.Values{}
+
+	if filter.Tag != "" {
+		query.Set("tag", filter.Tag)
+	}
+
+	if filter.IncludeOffline {
+		query.Set("include-offline", "true")
+	}
+
+	var devices []Device
+	err := client.getJSON(ctx, "/v2/devices", query, &devices)
+	return devices, err
+}
+
+// Device gets one device, registered devices adb can't see come back disconnected.
+// ErrNotFound when neither adb nor the registry knows it.
+func (client *Client) Device(ctx context.Context, deviceID string) (Device, error) {
+	var device Device
+	err := client.getJSON(ctx, devicePath(deviceID), nil, &device)
+	return device, err
+}
+
+// Packages lists a device's packages. deviceID is a serial or a registry selector that
+// matches exactly one device.
+func (client *Client) Packages(ctx context.Context, deviceID string, options PackageOptions) ([]Package, error) {
+	query := url.Values{}
+
+	if options.IncludeSystem {
+		query.Set("include-system", "true")
+	}
+
+	if options.IncludeUninstalled {
+		query.Set("uninstalled", "true")
+	}
+
+	var packages []Package
+	err := client.getJSON(ctx, devicePath(deviceID, "packages"), query, &packages)
+	return packages, err
+}
+
+// Install streams apk to the server and waits for adb to install it. Installs aren't retried,
+// the body can't be sent twice; for long installs InstallJob returns right away instead.
+func (client *Client) Install(ctx context.Context, deviceID string, apk io.Reader, options InstallOptions) error {
+	return client.doJSON(ctx, installCall(devicePath(deviceID, "packages"), url.Values{}, apk, options), nil)
+}
+
+// InstallFile is Install for an APK on this machine
+func (client *Client) InstallFile(ctx context.Context, deviceID, apkPath string) error {
+	file, options, err := openAPK(apkPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return client.Install(ctx, deviceID, file, options)
+}
+
+// InstallServerPath installs an APK that is already on the server's machine
+func (client *Client) InstallServerPath(ctx context.Context, deviceID, serverPath string) error {
+	return client.doJSON(ctx, call{
+		method: http.MethodPost,
+		path:   devicePath(deviceID, "packages"),
+		query:  url.Values{"path": {serverPath}},
+	}, nil)
+}
+
+// InstallJob uploads apk and has the server install it as a job, see WaitJob
+func (client *Client) InstallJob(ctx context.Context, deviceID string, apk io.Reader, options InstallOptions) (Job, error) {
+	var job Job
+	err := client.doJSON(ctx, installCall("/v1/jobs/install", url.Values{"device-id": {deviceID}}, apk, options), &job)
+	return job, err
+}
+
+// InstallFileJob is InstallJob for an APK on this machine
+func (client *Client) InstallFileJob(ctx context.Context, deviceID, apkPath string) (Job, error) {
+	file, options, err := openAPK(apkPath)
+	if err != nil {
+		return Job{}, err
+	}
+	defer file.Close()
+
+	return client.InstallJob(ctx, deviceID, file, options)
+}
+
+func (client *Client) Uninstall(ctx context.Context, deviceID, packageName string, options UninstallOptions) error {
+	query := url.Values{}
+
+	if options.KeepData {
+		query.Set("keep-data", "true")
+	}
+
+	if options.User != nil {
+		query.Set("user", strconv.Itoa(*options.User))
+	}
+
+	return client.doJSON(ctx, call{method: http.MethodDelete, path: devicePath(deviceID, "packages", packageName), query: query}, nil)
+}
+
+func installCall(path string, query url.Values, apk io.Reader, options InstallOptions) call {
+	if options.Name != "" {
+		query.Set("name", options.Name)
+	}
+
+	return call{
+		method:      http.MethodPost,
+		path:        path,
+		query:       query,
+		body:        apk,
+		size:        options.Size,
+		contentType: apkContentType,
+	}
+}
+
+// devicePath is /v2/devices/{serial} followed by elements, each escaped so selectors like
+// "name:lab phone" stay one path segment
+func devicePath(deviceID string, elements ...string) string {
+	path := "/v2/devices/" + url.PathEscape(deviceID)
+
+	for _, element := range elements {
+		path += "/" + url.PathEscape(element)
+	}
+
+	return path
+}
+
+func openAPK(apkPath string) (*os.File, InstallOptions, error) {
+	file, err := os.Open(apkPath)
+	if err != nil {
+		return nil, InstallOptions{}, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, InstallOptions{}, err
+	}
+
+	return file, InstallOptions{Name: filepath.Base(apkPath), Size: info.Size()}, nil
+}
@@ -0,0 +1,40 @@
+package client
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Audit returns the operations the server recorded, newest first
+func (client *Client) Audit(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	query := url.Values{}
+
+	for name, value := range map[string]string{
+		"session":   filter.SessionID,
+		"device-id": filter.DeviceID,
+		"operation": filter.Operation,
+		"outcome":   filter.Outcome,
+	} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+
+	if !filter.Since.IsZero() {
+		query.Set("since", filter.Since.Format(time.RFC3339))
+	}
+
+	if !filter.Until.IsZero() {
+		query.Set("until", filter.Until.Format(time.RFC3339))
+	}
+
+	if filter.Limit > 0 {
+		query.Set("limit", strconv.Itoa(filter.Limit))
+	}
+
+	var entries []AuditEntry
+	err := client.getJSON(ctx, "/v1/audit", query, &entries)
+	return entries, err
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Pair claims the server for this client and keeps the session for the calls that follow.
+// Only one client can be paired, a second Pair fails with ErrAlreadyPaired. Passing scopes
+// limits what the session may do, it gets every scope otherwise.
+func (client *Client) Pair(ctx context.Context, scopes ...string) (PairResult, error) {
+	var query url.Values
+	if len(scopes) > 0 {
+		query = url.Values{"scopes": {strings.Join(scopes, ",")}}
+	}
+
+	res, err := client.do(ctx, call{method: http.MethodPost, path: "/v1/pair", query: query})
+	if err != nil {
+		return PairResult{}, err
+	}
+	defer res.Body.Close()
+
+	var result PairResult
+	if err := decode(res, &result); err != nil {
+		return PairResult{}, err
+	}
+
+	for _, cookie := range res.Cookies() {
+		if cookie.Name == authCookie {
+			client.setSession(cookie.Value, result.CSRFToken)
+		}
+	}
+
+	return result, nil
+}
+
+// Unpair ends the session so another client can pair
+func (client *Client) Unpair(ctx context.Context) error {
+	if err := client.doJSON(ctx, call{method: http.MethodDelete, path: "/v1/unpair"}, nil); err != nil {
+		return err
+	}
+
+	client.clearSession()
+
+	return nil
+}
+
+func (client *Client) Health(ctx context.Context) (Health, error) {
+	var health Health
+	err := client.getJSON(ctx, "/v1/health", nil, &health)
+	return health, err
+}
+
+// Ready runs the server's readiness checks. A server that isn't ready still returns the checks,
+// along with an *APIError carrying the 503.
+func (client *Client) Ready(ctx context.Context) (Readiness, error) {
+	request := call{method: http.MethodGet, path: "/v1/health/ready"}
+
+	// Not through do, a 503 here is an answer rather than something to retry
+	res, err := client.send(ctx, request)
+	if err != nil {
+		return Readiness{}, err
+	}
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusServiceUnavailable {
+		return Readiness{}, readAPIError(request, res)
+	}
+	defer res.Body.Close()
+
+	var readiness Readiness
+	if err := decode(res, &readiness); err != nil {
+		return Readiness{}, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return readiness, &APIError{Method: request.method, Path: request.path, StatusCode: res.StatusCode, Message: readiness.Status}
+	}
+
+	return readiness, nil
+}
@@ -0,0 +1,408 @@
+// Package client talks to a running adb-server over its HTTP API. It keeps the session cookie
+// and CSRF token from pairing, retries idempotent calls the server couldn't take right now, and
+// turns the server's error responses into errors that work with errors.Is.
+//
+//	adbServer, err := client.New(client.Options{BaseURL: "http://127.0.0.1:49231"})
+//	if _, err := adbServer.Pair(ctx); err != nil { ... }
+//	devices, err := adbServer.Devices(ctx, client.DeviceFilter{})
+//
+// The package only depends on the standard library so other tools can vendor it on its own.
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Header and cookie names the server uses
+const (
+	authCookie = "X-Auth-Token"
+	csrfHeader = "X-CSRF-Token"
+)
+
+type Options struct {
+	// BaseURL is where the server listens, e.g. http://127.0.0.1:49231. Ignored with UnixSocket.
+	BaseURL string
+
+	// UnixSocket connects to a server started with server.unix-socket, which needs no pairing
+	UnixSocket string
+
+	// Token and CSRFToken are a session from an earlier Pair, leave them empty to pair fresh
+	Token     string
+	CSRFToken string
+
+	// TLSFingerprint pins the server's certificate (SHA-256, colon separated hex as the server
+	// prints it) instead of checking it against the system roots, which a self-signed one fails
+	TLSFingerprint string
+
+	// HTTPClient replaces the default client, its Transport is used as is
+	HTTPClient *http.Client
+
+	// Retry controls how idempotent calls are retried, the zero value means DefaultRetry
+	Retry RetryPolicy
+
+	// UserAgent is sent with every request
+	UserAgent string
+}
+
+// RetryPolicy is exponential backoff with jitter. Only GETs are retried, and only on network
+// errors and 429, 502, 503 and 504, since anything else would just fail the same way again.
+type RetryPolicy struct {
+	MaxAttempts int // including the first, 1 turns retries off
+	MinDelay    time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultRetry = RetryPolicy{MaxAttempts: 4, MinDelay: 200 * time.Millisecond, MaxDelay: 5 * time.Second}
+
+// Client is safe for concurrent use
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	retry      RetryPolicy
+	userAgent  string
+
+	mu        sync.Mutex
+	token     string
+	csrfToken string
+}
+
+func New(options Options) (*Client, error) {
+	baseURL := options.BaseURL
+	if options.UnixSocket != "" {
+		// Any host does, the transport dials the socket regardless
+		baseURL = "http://adb-server"
+	}
+
+	if baseURL == "" {
+		return nil, errors.New("client: BaseURL or UnixSocket is required")
+	}
+
+	parsed, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("client: invalid BaseURL: %w", err)
+	}
+
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("client: BaseURL must be http or https, got %q", baseURL)
+	}
+
+	httpClient := options.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Transport: newTransport(options)}
+	}
+
+	retry := options.Retry
+	if retry.MaxAttempts == 0 {
+		retry = DefaultRetry
+	}
+
+	return &Client{
+		baseURL:    parsed,
+		httpClient: httpClient,
+		retry:      retry,
+		userAgent:  options.UserAgent,
+		token:      options.Token,
+		csrfToken:  options.CSRFToken,
+	}, nil
+}
+
+func newTransport(options Options) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if options.UnixSocket != "" {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", options.UnixSocket)
+		}
+	}
+
+	if options.TLSFingerprint != "" {
+		pinned := normalizeFingerprint(options.TLSFingerprint)
+
+		transport.TLSClientConfig = &tls.Config{
+			// The chain isn't checked, the pin is stronger than anything a CA would say about it
+			InsecureSkipVerify: true,
+			VerifyConnection: func(state tls.ConnectionState) error {
+				if len(state.PeerCertificates) == 0 {
+					return errors.New("server sent no certificate")
+				}
+
+				sum := sha256.Sum256(state.PeerCertificates[0].Raw)
+				if hex.EncodeToString(sum[:]) != pinned {
+					return ErrFingerprintMismatch
+				}
+
+				return nil
+			},
+		}
+	}
+
+	return transport
+}
+
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.NewReplacer(":", "", " ", "").Replace(fingerprint))
+}
+
+// Session is what it takes to come back later without pairing again
+func (client *Client) Session() (token, csrfToken string) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	return client.token, client.csrfToken
+}
+
+func (client *Client) setSession(token, csrfToken string) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if token != "" {
+		client.token = token
+	}
+
+	if csrfToken != "" {
+		client.csrfToken = csrfToken
+	}
+}
+
+func (client *Client) clearSession() {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	client.token = ""
+	client.csrfToken = ""
+}
+
+// call is one API request. body is either nil, an io.Reader sent as is (never retried, it
+// can't be rewound) or anything else, which is sent as JSON.
+type call struct {
+	method      string
+	path        string
+	query       url.Values
+	body        any
+	size        int64 // of a streamed body, sent chunked when 0
+	contentType string
+	header      http.Header
+}
+
+// getJSON and friends run a call and decode the JSON answer into out when it isn't nil
+func (client *Client) getJSON(ctx context.Context, path string, query url.Values, out any) error {
+	return client.doJSON(ctx, call{method: http.MethodGet, path: path, query: query}, out)
+}
+
+func (client *Client) doJSON(ctx context.Context, request call, out any) error {
+	res, err := client.do(ctx, request)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if out == nil {
+		_, _ = io.Copy(io.Discard, res.Body)
+		return nil
+	}
+
+	return decode(res, out)
+}
+
+func decode(res *http.Response, out any) error {
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding %s %s response: %w", res.Request.Method, res.Request.URL.Path, err)
+	}
+
+	return nil
+}
+
+// do sends the call, with retries for GETs and one CSRF token refresh for everything else.
+// A non-2xx answer comes back as *APIError with the body already read and closed.
+func (client *Client) do(ctx context.Context, request call) (*http.Response, error) {
+	refreshedCSRF := false
+
+	// Sessions carried over without their CSRF token get it now, a streamed body can't be sent twice
+	if token, csrfToken := client.Session(); token != "" && csrfToken == "" && request.method != http.MethodGet {
+		refreshedCSRF = client.refreshCSRFToken(ctx) == nil
+	}
+
+	for attempt := 1; ; attempt++ {
+		res, err := client.send(ctx, request)
+
+		if err == nil && res.StatusCode < 300 {
+			return res, nil
+		}
+
+		var apiError *APIError
+		if err == nil {
+			apiError = readAPIError(request, res)
+			err = apiError
+		}
+
+		// A stale CSRF token after a server restart, fetch the current one and try once more
+		if apiError != nil && apiError.StatusCode == http.StatusForbidden && !refreshedCSRF && request.method != http.MethodGet && isReplayable(request) {
+			refreshedCSRF = true
+
+			if client.refreshCSRFToken(ctx) == nil {
+				continue
+			}
+		}
+
+		if !client.shouldRetry(request, attempt, apiError, err) {
+			return nil, err
+		}
+
+		delay := client.backoff(attempt)
+		if apiError != nil && apiError.RetryAfter > 0 {
+			delay = min(apiError.RetryAfter, client.retry.MaxDelay)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (client *Client) send(ctx context.Context, request call) (*http.Response, error) {
+	target := client.baseURL.JoinPath(request.path)
+	target.RawQuery = request.query.Encode()
+
+	var body io.Reader
+	contentType := request.contentType
+
+	switch value := request.body.(type) {
+	case nil:
+	case io.Reader:
+		body = value
+	default:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+
+		body = strings.NewReader(string(encoded))
+		contentType = "application/json"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, request.method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range request.header {
+		req.Header[name] = values
+	}
+
+	if request.size > 0 {
+		req.ContentLength = request.size
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	if client.userAgent != "" {
+		req.Header.Set("User-Agent", client.userAgent)
+	}
+
+	token, csrfToken := client.Session()
+
+	if token != "" {
+		req.AddCookie(&http.Cookie{Name: authCookie, Value: token})
+	}
+
+	if csrfToken != "" && request.method != http.MethodGet && request.method != http.MethodHead {
+		req.Header.Set(csrfHeader, csrfToken)
+	}
+
+	res, err := client.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// The server hands out a fresh token on some answers, keep up with it
+	client.setSession("", res.Header.Get(csrfHeader))
+
+	return res, nil
+}
+
+func (client *Client) shouldRetry(request call, attempt int, apiError *APIError, err error) bool {
+	if attempt >= client.retry.MaxAttempts || request.method != http.MethodGet || !isReplayable(request) {
+		return false
+	}
+
+	if apiError == nil {
+		// Network trouble, but not our own deadline or cancellation
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, ErrFingerprintMismatch)
+	}
+
+	switch apiError.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+// Streamed bodies are gone after the first try
+func isReplayable(request call) bool {
+	_, streamed := request.body.(io.Reader)
+	return !streamed
+}
+
+func (client *Client) backoff(attempt int) time.Duration {
+	delay := client.retry.MinDelay << (attempt - 1)
+	if delay <= 0 || delay > client.retry.MaxDelay {
+		delay = client.retry.MaxDelay
+	}
+
+	// Full jitter so a crowd of clients doesn't come back in lockstep
+	return delay/2 + rand.N(delay/2+1)
+}
+
+func (client *Client) refreshCSRFToken(ctx context.Context) error {
+	res, err := client.send(ctx, call{method: http.MethodGet, path: "/v1/csrf-token"})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return readAPIError(call{method: http.MethodGet, path: "/v1/csrf-token"}, res)
+	}
+
+	var body struct {
+		CSRFToken string `json:"csrf_token"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return err
+	}
+
+	client.setSession("", body.CSRFToken)
+
+	return nil
+}
+
+func retryAfter(res *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(res.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+
+	return time.Duration(seconds) * time.Second
+}
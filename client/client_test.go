@@ -0,0 +1,234 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Fast enough that retrying tests don't wait around
+var testRetry = RetryPolicy{MaxAttempts: 3, MinDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+func newTestClient(t *testing.T, server *httptest.Server, options Options) *Client {
+	t.Helper()
+
+	options.BaseURL = server.URL
+	options.Retry = testRetry
+
+	adbServer, err := New(options)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	return adbServer
+}
+
+func TestRetriesGET(t *testing.T) {
+	var calls atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if calls.Add(1) == 1 {
+			http.Error(res, "adb server restarting", http.StatusServiceUnavailable)
+			return
+		}
+
+		_, _ = res.Write([]byte(`[{"device_id":"SER1","status":"device","authorized":true}]`))
+	}))
+	defer server.Close()
+
+	devices, err := newTestClient(t, server, Options{}).Devices(context.Background(), DeviceFilter{})
+	if err != nil {
+		t.Fatalf("Devices: %v", err)
+	}
+
+	if len(devices) != 1 || devices[0].Serial != "SER1" {
+		t.Errorf("got %+v", devices)
+	}
+
+	if calls.Load() != 2 {
+		t.Errorf("server got %d calls, want the 503 and its retry", calls.Load())
+	}
+}
+
+func TestGivesUpAfterMaxAttempts(t *testing.T) {
+	var calls atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		calls.Add(1)
+		http.Error(res, "adb server restarting", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	_, err := newTestClient(t, server, Options{}).Devices(context.Background(), DeviceFilter{})
+	if !errors.Is(err, ErrQueueFull) {
+		t.Errorf("got %v, want ErrQueueFull", err)
+	}
+
+	if calls.Load() != int32(testRetry.MaxAttempts) {
+		t.Errorf("server got %d calls, want %d", calls.Load(), testRetry.MaxAttempts)
+	}
+}
+
+func TestDoesNotRetry(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		call   func(adbServer *Client) error
+	}{
+		{
+			name:   "POST",
+			status: http.StatusServiceUnavailable,
+			call: func(adbServer *Client) error {
+				_, err := adbServer.Pair(context.Background())
+				return err
+			},
+		},
+		{
+			name:   "GET not found",
+			status: http.StatusNotFound,
+			call: func(adbServer *Client) error {
+				_, err := adbServer.Device(context.Background(), "SER1")
+				return err
+			},
+		},
+		{
+			name:   "GET server error",
+			status: http.StatusInternalServerError,
+			call: func(adbServer *Client) error {
+				_, err := adbServer.Device(context.Background(), "SER1")
+				return err
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls atomic.Int32
+
+			server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				calls.Add(1)
+				http.Error(res, http.StatusText(test.status), test.status)
+			}))
+			defer server.Close()
+
+			err := test.call(newTestClient(t, server, Options{}))
+
+			var apiError *APIError
+			if !errors.As(err, &apiError) || apiError.StatusCode != test.status {
+				t.Errorf("got %v, want an APIError with %d", err, test.status)
+			}
+
+			if calls.Load() != 1 {
+				t.Errorf("server got %d calls, want 1", calls.Load())
+			}
+		})
+	}
+}
+
+// csrfServer takes DELETEs carrying the current CSRF token and hands that token out on /v1/csrf-token
+type csrfServer struct {
+	current   string
+	refreshes atomic.Int32
+	deletes   atomic.Int32
+}
+
+func (server *csrfServer) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	if cookie, err := req.Cookie(authCookie); err != nil || cookie.Value != "session" {
+		http.Error(res, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if req.URL.Path == "/v1/csrf-token" {
+		server.refreshes.Add(1)
+		fmt.Fprintf(res, `{"csrf_token":%q}`, server.current)
+		return
+	}
+
+	if req.Header.Get(csrfHeader) != server.current {
+		http.Error(res, "missing or invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	server.deletes.Add(1)
+}
+
+func TestRefreshesStaleCSRFToken(t *testing.T) {
+	handler := &csrfServer{current: "after-restart"}
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	adbServer := newTestClient(t, server, Options{Token: "session", CSRFToken: "before-restart"})
+
+	if err := adbServer.Uninstall(context.Background(), "SER1", "com.example.app", UninstallOptions{}); err != nil {
+		t.Fatalf("Uninstall: %v", err)
+	}
+
+	if handler.refreshes.Load() != 1 || handler.deletes.Load() != 1 {
+		t.Errorf("got %d refreshes and %d deletes, want 1 of each", handler.refreshes.Load(), handler.deletes.Load())
+	}
+
+	if _, csrfToken := adbServer.Session(); csrfToken != "after-restart" {
+		t.Errorf("kept CSRF token %q", csrfToken)
+	}
+}
+
+func TestRefreshesCSRFTokenOnlyOnce(t *testing.T) {
+	// A token that's never right, refreshing can't help
+	handler := &csrfServer{current: ""}
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	adbServer := newTestClient(t, server, Options{Token: "session", CSRFToken: "stale"})
+
+	err := adbServer.Uninstall(context.Background(), "SER1", "com.example.app", UninstallOptions{})
+	if !errors.Is(err, ErrCSRFTokenStale) {
+		t.Errorf("got %v, want ErrCSRFTokenStale", err)
+	}
+
+	if handler.refreshes.Load() != 1 {
+		t.Errorf("refreshed %d times, want once", handler.refreshes.Load())
+	}
+}
+
+func TestTLSFingerprint(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		_, _ = res.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer server.Close()
+
+	// Colon separated upper case, the way the server prints it
+	sum := sha256.Sum256(server.Certificate().Raw)
+	pairs := make([]string, len(sum))
+	for i, b := range sum {
+		pairs[i] = fmt.Sprintf("%02X", b)
+	}
+
+	pinned := newTestClient(t, server, Options{TLSFingerprint: strings.Join(pairs, ":")})
+
+	if health, err := pinned.Health(context.Background()); err != nil || health.Status != "ok" {
+		t.Errorf("with the right fingerprint got %+v, %v", health, err)
+	}
+
+	sum[0]++
+	wrong := newTestClient(t, server, Options{TLSFingerprint: fmt.Sprintf("%x", sum)})
+
+	if _, err := wrong.Health(context.Background()); !errors.Is(err, ErrFingerprintMismatch) {
+		t.Errorf("with the wrong fingerprint got %v, want ErrFingerprintMismatch", err)
+	}
+
+	// Without a pin the self-signed certificate fails the system roots
+	unpinned := newTestClient(t, server, Options{})
+
+	if _, err := unpinned.Health(context.Background()); err == nil {
+		t.Error("trusted a self-signed certificate without a pin")
+	}
+}
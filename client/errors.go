@@ -0,0 +1,93 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The server answers errors with a status code and a plain text message, these let callers
+// tell the cases apart with errors.Is(err, client.ErrNotFound) and so on
+var (
+	ErrBadRequest     = errors.New("bad request")
+	ErrUnauthorized   = errors.New("not paired or the session was revoked")
+	ErrForbidden      = errors.New("forbidden")
+	ErrNotFound       = errors.New("not found")
+	ErrConflict       = errors.New("conflict")
+	ErrTooLarge       = errors.New("request too large")
+	ErrQueueFull      = errors.New("server is busy, try again later")
+	ErrServer         = errors.New("server error")
+	ErrAlreadyPaired  = errors.New("a client is already paired")
+	ErrJobFinished    = errors.New("job already finished")
+	ErrCSRFTokenStale = errors.New("missing or invalid CSRF token")
+
+	ErrFingerprintMismatch = errors.New("server certificate doesn't match the pinned fingerprint")
+)
+
+// Longest error body we keep, the server's messages are one line
+const maxErrorBody = 4096
+
+// APIError is a non-2xx answer from the server
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string        // the server's plain text message
+	RetryAfter time.Duration // from Retry-After, when the server sent one
+}
+
+func (apiError *APIError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s: %s", apiError.Method, apiError.Path, apiError.StatusCode, http.StatusText(apiError.StatusCode), apiError.Message)
+}
+
+func (apiError *APIError) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return apiError.StatusCode == http.StatusBadRequest
+	case ErrUnauthorized:
+		return apiError.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return apiError.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return apiError.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return apiError.StatusCode == http.StatusConflict
+	case ErrTooLarge:
+		return apiError.StatusCode == http.StatusRequestEntityTooLarge
+	case ErrQueueFull:
+		return apiError.StatusCode == http.StatusServiceUnavailable || apiError.StatusCode == http.StatusTooManyRequests
+	case ErrServer:
+		return apiError.StatusCode >= 500
+	// The specific ones go by the server's message, the status alone is shared with other cases
+	case ErrAlreadyPaired:
+		return apiError.StatusCode == http.StatusConflict && strings.Contains(apiError.Message, "already paired")
+	case ErrJobFinished:
+		return apiError.StatusCode == http.StatusConflict && strings.HasPrefix(apiError.Message, "job already")
+	case ErrCSRFTokenStale:
+		return apiError.StatusCode == http.StatusForbidden && strings.Contains(apiError.Message, "CSRF")
+	}
+
+	return false
+}
+
+func readAPIError(request call, res *http.Response) *APIError {
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBody))
+
+	message := strings.TrimSpace(string(body))
+	if message == "" {
+		message = http.StatusText(res.StatusCode)
+	}
+
+	return &APIError{
+		Method:     request.method,
+		Path:       request.path,
+		StatusCode: res.StatusCode,
+		Message:    message,
+		RetryAfter: retryAfter(res),
+	}
+}
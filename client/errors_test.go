@@ -0,0 +1,42 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestAPIErrorIs(t *testing.T) {
+	tests := []struct {
+		status  int
+		message string
+		target  error
+		want    bool
+	}{
+		{status: http.StatusConflict, message: "a client is already paired", target: ErrAlreadyPaired, want: true},
+		{status: http.StatusConflict, message: "a client is already paired", target: ErrConflict, want: true},
+		{status: http.StatusConflict, message: "job already finished", target: ErrAlreadyPaired},
+		{status: http.StatusConflict, message: "job already finished", target: ErrJobFinished, want: true},
+		{status: http.StatusBadRequest, message: "already paired", target: ErrAlreadyPaired},
+		{status: http.StatusForbidden, message: "missing or invalid CSRF token", target: ErrCSRFTokenStale, want: true},
+		{status: http.StatusForbidden, message: "missing scope devices:write", target: ErrCSRFTokenStale},
+		{status: http.StatusForbidden, message: "missing scope devices:write", target: ErrForbidden, want: true},
+		{status: http.StatusUnauthorized, message: "unauthorized", target: ErrUnauthorized, want: true},
+		{status: http.StatusNotFound, message: "device not found", target: ErrNotFound, want: true},
+		{status: http.StatusRequestEntityTooLarge, message: "too large", target: ErrTooLarge, want: true},
+		{status: http.StatusServiceUnavailable, message: "job queue is full", target: ErrQueueFull, want: true},
+		{status: http.StatusTooManyRequests, message: "slow down", target: ErrQueueFull, want: true},
+		{status: http.StatusServiceUnavailable, message: "job queue is full", target: ErrServer, want: true},
+		{status: http.StatusInternalServerError, message: "adb failed", target: ErrServer, want: true},
+		{status: http.StatusInternalServerError, message: "adb failed", target: ErrNotFound},
+		{status: http.StatusNotFound, message: "device not found", target: errors.New("not found")},
+	}
+
+	for _, test := range tests {
+		apiError := &APIError{Method: http.MethodGet, Path: "/v1/test", StatusCode: test.status, Message: test.message}
+
+		if got := errors.Is(apiError, test.target); got != test.want {
+			t.Errorf("%d %q is %v: got %v, want %v", test.status, test.message, test.target, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Subscription delivers server events on Events until its context is cancelled, Close is
+// called, or the server stops accepting the session. Dropped connections are picked up again
+// where they left off, as far as the server still remembers.
+type Subscription struct {
+	Events <-chan Event
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// Close stops the subscription and waits for it to wind down
+func (subscription *Subscription) Close() {
+	subscription.cancel()
+	<-subscription.done
+}
+
+// Err is why Events was closed, nil after Close or a cancelled context
+func (subscription *Subscription) Err() error {
+	subscription.mu.Lock()
+	defer subscription.mu.Unlock()
+
+	return subscription.err
+}
+
+// Subscribe streams events of the given types from /v1/events, every type when none are given.
+// It returns once the first connection is up, so an unpaired client finds out right away.
+// An HTTPClient passed in Options must not have a Timeout, it would cut the stream.
+func (client *Client) Subscribe(ctx context.Context, types ...string) (*Subscription, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	query := url.Values{}
+	if len(types) > 0 {
+		query.Set("types", strings.Join(types, ","))
+	}
+
+	request := call{method: http.MethodGet, path: "/v1/events", query: query}
+
+	res, err := client.do(ctx, request)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	events := make(chan Event, 16)
+	subscription := &Subscription{Events: events, cancel: cancel, done: make(chan struct{})}
+
+	go func() {
+		defer close(subscription.done)
+		defer close(events)
+
+		var lastID uint64
+
+		for attempt := 1; ; attempt++ {
+			// However the stream ended, unless we were told to stop it gets picked up again
+			received := readEventStream(ctx, res.Body, events, &lastID)
+			res.Body.Close()
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			// Only a connection that got nowhere counts towards backing off
+			if received {
+				attempt = 1
+			}
+
+			res, err = client.reconnect(ctx, request, lastID, attempt)
+			if err != nil {
+				if ctx.Err() == nil {
+					subscription.mu.Lock()
+					subscription.err = err
+					subscription.mu.Unlock()
+				}
+
+				return
+			}
+		}
+	}()
+
+	return subscription, nil
+}
+
+// reconnect waits out the backoff and opens the stream again from lastID. Being logged out or a
+// server that's gone for good ends the subscription, the retry policy decides how long to try.
+func (client *Client) reconnect(ctx context.Context, request call, lastID uint64, attempt int) (*http.Response, error) {
+	request.header = http.Header{}
+	if lastID > 0 {
+		request.header.Set("Last-Event-ID", strconv.FormatUint(lastID, 10))
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(client.backoff(attempt)):
+	}
+
+	return client.do(ctx, request)
+}
+
+// readEventStream parses text/event-stream until the body ends, and reports whether it got any event
+func readEventStream(ctx context.Context, body io.Reader, events chan<- Event, lastID *uint64) bool {
+	reader := bufio.NewReader(body)
+	received := false
+
+	var data strings.Builder
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return received
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case line == "":
+			// A blank line ends the event
+			if data.Len() == 0 {
+				continue
+			}
+
+			var event Event
+			decodeError := json.Unmarshal([]byte(data.String()), &event)
+			data.Reset()
+
+			if decodeError != nil {
+				continue
+			}
+
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return received
+			}
+
+			*lastID = event.ID
+			received = true
+		case strings.HasPrefix(line, ":"):
+			// Comments, the server's heartbeats
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSubscriptionResumes(t *testing.T) {
+	var (
+		mu           sync.Mutex
+		connections  int
+		lastEventIDs []string
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		connections++
+		connection := connections
+		lastEventIDs = append(lastEventIDs, req.Header.Get("Last-Event-ID"))
+		mu.Unlock()
+
+		if req.URL.Query().Get("types") != "device.connected" {
+			http.Error(res, "unexpected types", http.StatusBadRequest)
+			return
+		}
+
+		res.Header().Set("Content-Type", "text/event-stream")
+
+		// The first connection drops after two events, the second carries on from there
+		first, last := uint64(1), uint64(2)
+		if connection > 1 {
+			first, last = 3, 3
+		}
+
+		_, _ = fmt.Fprint(res, ": heartbeat\n\n")
+
+		for id := first; id <= last; id++ {
+			_, _ = fmt.Fprintf(res, "id: %d\nevent: device.connected\ndata: {\"id\":%d,\"type\":\"device.connected\",\"data\":{\"device_id\":\"SER%d\"}}\n\n", id, id, id)
+		}
+
+		res.(http.Flusher).Flush()
+
+		if connection > 1 {
+			<-req.Context().Done()
+		}
+	}))
+	defer server.Close()
+
+	adbServer := newTestClient(t, server, Options{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	subscription, err := adbServer.Subscribe(ctx, "device.connected")
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	for want := uint64(1); want <= 3; want++ {
+		select {
+		case event := <-subscription.Events:
+			if event.ID != want || event.Type != "device.connected" {
+				t.Errorf("got event %+v, want id %d", event, want)
+			}
+		case <-ctx.Done():
+			t.Fatalf("event %d never came", want)
+		}
+	}
+
+	subscription.Close()
+
+	if err := subscription.Err(); err != nil {
+		t.Errorf("Err after Close: %v", err)
+	}
+
+	if _, open := <-subscription.Events; open {
+		t.Error("Events still open after Close")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(lastEventIDs) != 2 || lastEventIDs[0] != "" || lastEventIDs[1] != "2" {
+		t.Errorf("connections sent Last-Event-ID %q, want none and then 2", lastEventIDs)
+	}
+}
+
+func TestSubscriptionEndsWhenUnpaired(t *testing.T) {
+	var (
+		mu          sync.Mutex
+		connections int
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		connections++
+		connection := connections
+		mu.Unlock()
+
+		// Revoked while the first stream was up
+		if connection > 1 {
+			http.Error(res, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		res.Header().Set("Content-Type", "text/event-stream")
+		res.(http.Flusher).Flush()
+	}))
+	defer server.Close()
+
+	subscription, err := newTestClient(t, server, Options{}).Subscribe(context.Background())
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	select {
+	case _, open := <-subscription.Events:
+		if open {
+			t.Fatal("got an event from an empty stream")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscription didn't end")
+	}
+
+	if err := subscription.Err(); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Err is %v, want ErrUnauthorized", err)
+	}
+
+	subscription.Close()
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Jobs lists every job the server still remembers, newest first
+func (client *Client) Jobs(ctx context.Context) ([]Job, error) {
+	var jobs []Job
+	err := client.getJSON(ctx, "/v1/jobs", nil, &jobs)
+	return jobs, err
+}
+
+func (client *Client) Job(ctx context.Context, id string) (Job, error) {
+	var job Job
+	err := client.getJSON(ctx, "/v1/jobs/"+id, nil, &job)
+	return job, err
+}
+
+// CancelJob stops a queued or running job, a job that already ended gives ErrJobFinished
+func (client *Client) CancelJob(ctx context.Context, id string) (Job, error) {
+	var job Job
+	err := client.doJSON(ctx, call{method: http.MethodDelete, path: "/v1/jobs/" + id}, &job)
+	return job, err
+}
+
+// WaitJob polls until the job ends and returns it however it ended, check Status. Subscribe
+// to EventJobFinished instead when waiting on many jobs.
+func (client *Client) WaitJob(ctx context.Context, id string, interval time.Duration) (Job, error) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		job, err := client.Job(ctx, id)
+		if err != nil || job.Finished() {
+			return job, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return job, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// JobArtifact opens the file a pull or bugreport job produced, the caller closes it
+func (client *Client) JobArtifact(ctx context.Context, id string) (io.ReadCloser, error) {
+	res, err := client.do(ctx, call{method: http.MethodGet, path: "/v1/jobs/" + id + "/artifact"})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Body, nil
+}
@@ -0,0 +1,24 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// MCP sends one Model Context Protocol message to the server's endpoint and returns the
+// server's answer, nil for notifications, which get none
+func (client *Client) MCP(ctx context.Context, message json.RawMessage) (json.RawMessage, error) {
+	res, err := client.do(ctx, call{method: http.MethodPost, path: "/v1/mcp", body: message})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusAccepted {
+		return nil, nil
+	}
+
+	return io.ReadAll(res.Body)
+}
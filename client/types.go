@@ -0,0 +1,170 @@
+package client
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// These mirror the server's JSON. They are copies rather than imports so the package stays
+// usable without the rest of the module.
+
+type PairResult struct {
+	Status    string   `json:"status"`
+	CSRFToken string   `json:"csrf_token,omitempty"`
+	Scopes    []string `json:"scopes,omitempty"` // every scope when empty
+}
+
+type Health struct {
+	Time   string `json:"time"`
+	Status string `json:"status"`
+}
+
+type Readiness struct {
+	Time   string                    `json:"time"`
+	Status string                    `json:"status"`
+	Checks map[string]ReadinessCheck `json:"checks"`
+}
+
+type ReadinessCheck struct {
+	Status  string         `json:"status"`
+	Message string         `json:"message,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// Device is what adb reports plus what the server's registry knows about it
+type Device struct {
+	Serial       string   `json:"device_id"`
+	State        string   `json:"status"` // device, unauthorized, offline, disconnected, ...
+	Model        string   `json:"model"`
+	Manufacturer string   `json:"manufacturer"`
+	IsAuthorized bool     `json:"authorized"`
+	Name         string   `json:"name,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	Owner        string   `json:"owner,omitempty"`
+	Notes        string   `json:"notes,omitempty"`
+	Groups       []string `json:"groups,omitempty"`
+}
+
+type DeviceFilter struct {
+	Tag            string // only devices carrying this registry tag
+	IncludeOffline bool   // registered devices adb can't see right now, as "disconnected"
+}
+
+type Package struct {
+	Name     string `json:"Name"`
+	ApkPath  string `json:"ApkPath"`
+	IsSystem bool   `json:"IsSystem"`
+}
+
+type PackageOptions struct {
+	IncludeSystem      bool
+	IncludeUninstalled bool
+}
+
+type InstallOptions struct {
+	// Name is the file name recorded in the audit log, "upload.apk" when empty
+	Name string
+	// Size of the APK in bytes, sent as Content-Length when known
+	Size int64
+}
+
+type UninstallOptions struct {
+	KeepData bool
+	User     *int // every user when nil
+}
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+type Job struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	DeviceID   string          `json:"device_id,omitempty"`
+	SessionID  string          `json:"session_id,omitempty"`
+	Status     string          `json:"status"`
+	Progress   int             `json:"progress"`
+	Logs       []string        `json:"logs"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	StartedAt  *time.Time      `json:"started_at,omitempty"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+}
+
+func (job Job) Finished() bool {
+	return job.FinishedAt != nil
+}
+
+// Event types the server publishes on /v1/events
+const (
+	EventDeviceConnected    = "device.connected"
+	EventDeviceDisconnected = "device.disconnected"
+	EventDeviceStateChanged = "device.state-changed"
+	EventJobFinished        = "job.finished"
+	EventOperation          = "operation"
+)
+
+// Event is one server event, Data is decoded with DeviceChange, Job or AuditEntry depending on Type
+type Event struct {
+	ID   uint64          `json:"id"`
+	Type string          `json:"type"`
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// DeviceChange is the data of the device events
+type DeviceChange struct {
+	Serial        string `json:"device_id"`
+	State         string `json:"status"`
+	Model         string `json:"model"`
+	Manufacturer  string `json:"manufacturer"`
+	IsAuthorized  bool   `json:"authorized"`
+	PreviousState string `json:"previous_status,omitempty"`
+}
+
+func (event Event) DeviceChange() (DeviceChange, error) {
+	var change DeviceChange
+	err := json.Unmarshal(event.Data, &change)
+	return change, err
+}
+
+func (event Event) Job() (Job, error) {
+	var job Job
+	err := json.Unmarshal(event.Data, &job)
+	return job, err
+}
+
+// AuditEntry is one operation from the server's audit log
+type AuditEntry struct {
+	Time       time.Time         `json:"time"`
+	SessionID  string            `json:"session_id"`
+	RequestID  string            `json:"request_id,omitempty"`
+	Operation  string            `json:"operation"`
+	DeviceID   string            `json:"device_id,omitempty"`
+	Arguments  map[string]string `json:"arguments,omitempty"`
+	APKSHA256  string            `json:"apk_sha256,omitempty"`
+	DurationMS int64             `json:"duration_ms"`
+	Outcome    string            `json:"outcome"` // success or failure
+	Error      string            `json:"error,omitempty"`
+}
+
+type AuditFilter struct {
+	SessionID string
+	DeviceID  string
+	Operation string
+	Outcome   string
+	Since     time.Time
+	Until     time.Time
+	Limit     int // the server's default of 100 when 0, at most 1000
+}
+
+func (event Event) AuditEntry() (AuditEntry, error) {
+	var entry AuditEntry
+	err := json.Unmarshal(event.Data, &entry)
+	return entry, err
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"adb-server/config"
+	"adb-server/models"
+	"adb-server/utilities"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// adb-server config print [-format toml|json] [server flags...]
+// Shows the effective configuration after the file, env vars and flags have been applied.
+func runConfigCommand(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "usage: adb-server config print [-format toml|json] [server flags...]")
+		return models.ExitConfig
+	}
+
+	args = args[1:]
+
+	format := "toml"
+	if len(args) >= 2 && (args[0] == "-format" || args[0] == "--format") {
+		format, args = args[1], args[2:]
+	}
+
+	configDir, err := utilities.ConfigDir()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return models.ExitError
+	}
+
+	cfg, err := config.Load(args, configDir)
+	if errors.Is(err, flag.ErrHelp) {
+		return models.ExitOK
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return models.ExitConfig
+	}
+
+	switch format {
+	case "toml":
+		if cfg.File != "" {
+			fmt.Printf("# loaded from %s\n", cfg.File)
+		}
+
+		err = cfg.PrintTOML(os.Stdout)
+	case "json":
+		err = cfg.PrintJSON(os.Stdout)
+	default:
+		err = fmt.Errorf("unknown format %q, use toml or json", format)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return models.ExitConfig
+	}
+
+	return models.ExitOK
+}
@@ -0,0 +1,310 @@
+package config
+
+import (
+	"adb-server/authentication"
+	"adb-server/transport"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Config is every knob the server has. Values are layered: defaults, then the config file,
+// then ADB_SERVER_* environment variables, then command-line flags.
+type Config struct {
+	ADB      ADBConfig
+	Server   ServerConfig
+	TLS      TLSConfig
+	Auth     AuthConfig
+	Jobs     JobsConfig
+	Limits   LimitsConfig
+	Metrics  MetricsConfig
+	Log      LogConfig
+	Tracing  TracingConfig
+	Webhooks WebhooksConfig
+
+	File          string // config file the values were read from, empty if there was none
+	ResetPairings bool   // one-off action, only settable as a flag
+
+	sources map[string]string // setting key -> where its value came from
+}
+
+type ADBConfig struct {
+	Path             string
+	ServerAddress    string
+	ReadTimeout      time.Duration
+	InstallTimeout   time.Duration
+	UninstallTimeout time.Duration
+	TransferTimeout  time.Duration
+	BugreportTimeout time.Duration
+	RecordTo         string
+	ReplayFrom       string
+	ReplayRealTime   bool
+}
+
+type ServerConfig struct {
+	BindAddress     string
+	PortMin         int
+	PortMax         int
+	UnixSocket      string
+	AllowedOrigins  []string
+	AllowedHosts    []string
+	ShutdownTimeout time.Duration
+	AuditLog        string
+	RegistryFile    string
+}
+
+type TLSConfig struct {
+	Enabled      bool
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	ClientAuth   string
+	ClientScopes []string // what certificate sessions may do, everything when empty
+}
+
+type AuthConfig struct {
+	CredentialsFile string
+	CredentialsKey  string
+	Keyring         bool
+}
+
+type JobsConfig struct {
+	Retention time.Duration
+	MaxActive int
+}
+
+type WebhooksConfig struct {
+	File         string
+	Timeout      time.Duration
+	MaxAttempts  int
+	AllowedHosts []string
+}
+
+type TracingConfig struct {
+	Endpoint    string
+	ServiceName string
+	Headers     []string // Name=value
+}
+
+type LogConfig struct {
+	Level  string
+	Format string
+}
+
+type MetricsConfig struct {
+	Enabled bool
+	Token   string
+}
+
+type LimitsConfig struct {
+	ReadHeaderTimeout time.Duration
+	MaxHeaderBytes    int
+	BatchConcurrency  int
+	MaxUploadBytes    int
+}
+
+func Defaults() *Config {
+	return &Config{
+		ADB: ADBConfig{
+			Path:             "adb",
+			ReadTimeout:      30 * time.Second,
+			InstallTimeout:   120 * time.Second,
+			UninstallTimeout: 30 * time.Second,
+			TransferTimeout:  10 * time.Minute,
+			BugreportTimeout: 10 * time.Minute,
+		},
+		Server: ServerConfig{
+			BindAddress:     "127.0.0.1",
+			PortMin:         35000,
+			PortMax:         49151,
+			ShutdownTimeout: 30 * time.Second,
+		},
+		TLS: TLSConfig{
+			ClientAuth: transport.ClientAuthNone,
+		},
+		Jobs: JobsConfig{
+			Retention: time.Hour,
+			MaxActive: 32,
+		},
+		Limits: LimitsConfig{
+			ReadHeaderTimeout: 10 * time.Second,
+			MaxHeaderBytes:    1 << 20,
+			BatchConcurrency:  8,
+			MaxUploadBytes:    1 << 30,
+		},
+		Metrics: MetricsConfig{
+			Enabled: true,
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Tracing: TracingConfig{
+			ServiceName: "adb-server",
+		},
+		Webhooks: WebhooksConfig{
+			Timeout:     10 * time.Second,
+			MaxAttempts: 5,
+		},
+		sources: map[string]string{},
+	}
+}
+
+// Source says where a setting's effective value came from: default, file, env or flag
+func (config *Config) Source(key string) string {
+	if source, ok := config.sources[key]; ok {
+		return source
+	}
+
+	return "default"
+}
+
+// Validate reports every problem at once instead of making the user fix them one restart at a time
+func (config *Config) Validate() error {
+	var problems []error
+
+	if config.ADB.Path == "" {
+		problems = append(problems, errors.New("adb.path must not be empty"))
+	}
+
+	if config.ADB.ServerAddress != "" {
+		if _, _, err := net.SplitHostPort(config.ADB.ServerAddress); err != nil {
+			problems = append(problems, fmt.Errorf("adb.server-address must be host:port: %w", err))
+		}
+	}
+
+	for key, timeout := range map[string]time.Duration{
+		"adb.read-timeout":           config.ADB.ReadTimeout,
+		"adb.install-timeout":        config.ADB.InstallTimeout,
+		"adb.uninstall-timeout":      config.ADB.UninstallTimeout,
+		"server.shutdown-timeout":    config.Server.ShutdownTimeout,
+		"limits.read-header-timeout": config.Limits.ReadHeaderTimeout,
+		"webhooks.timeout":           config.Webhooks.Timeout,
+	} {
+		if timeout <= 0 {
+			problems = append(problems, fmt.Errorf("%s must be positive", key))
+		}
+	}
+
+	if config.Server.PortMin < 1 || config.Server.PortMax > 65535 || config.Server.PortMin > config.Server.PortMax {
+		problems = append(problems, fmt.Errorf("port range %d-%d is invalid", config.Server.PortMin, config.Server.PortMax))
+	}
+
+	if config.Server.BindAddress == "" {
+		problems = append(problems, errors.New("server.bind-address must not be empty"))
+	}
+
+	// Without names to check the Host header against, a DNS rebinding page could talk to us
+	if ip := net.ParseIP(config.Server.BindAddress); ip != nil && ip.IsUnspecified() && config.Server.UnixSocket == "" && len(config.Server.AllowedHosts) == 0 {
+		problems = append(problems, fmt.Errorf("server.allowed-hosts must name the hosts clients reach us by when binding %s", config.Server.BindAddress))
+	}
+
+	// /metrics doesn't go through pairing, only the token keeps the rest of the network out
+	if config.Metrics.Enabled && config.Metrics.Token == "" && config.Server.UnixSocket == "" && !IsLoopback(config.Server.BindAddress) {
+		problems = append(problems, fmt.Errorf("metrics.token must be set to serve metrics on %s, or set metrics.enabled = false", config.Server.BindAddress))
+	}
+
+	if (config.TLS.CertFile == "") != (config.TLS.KeyFile == "") {
+		problems = append(problems, errors.New("tls.cert-file and tls.key-file must be set together"))
+	}
+
+	switch config.TLS.ClientAuth {
+	case transport.ClientAuthNone:
+	case transport.ClientAuthOptional, transport.ClientAuthRequire:
+		if !config.TLS.Enabled {
+			problems = append(problems, errors.New("tls.client-auth needs tls.enabled"))
+		}
+
+		if config.TLS.ClientCAFile == "" {
+			problems = append(problems, errors.New("tls.client-auth needs tls.client-ca-file"))
+		}
+	default:
+		problems = append(problems, fmt.Errorf("tls.client-auth must be none, optional or require, got %q", config.TLS.ClientAuth))
+	}
+
+	if err := authentication.ValidateScopes(config.TLS.ClientScopes); err != nil {
+		problems = append(problems, fmt.Errorf("tls.client-scopes: %w", err))
+	}
+
+	if config.TLS.Enabled && config.Server.UnixSocket != "" {
+		problems = append(problems, errors.New("tls.enabled and server.unix-socket can't be combined"))
+	}
+
+	if config.ADB.RecordTo != "" && config.ADB.ReplayFrom != "" {
+		problems = append(problems, errors.New("adb.record-to and adb.replay-from are mutually exclusive"))
+	}
+
+	if config.Auth.CredentialsKey != "" && config.Auth.Keyring {
+		problems = append(problems, errors.New("auth.credentials-key and auth.keyring are mutually exclusive"))
+	}
+
+	if config.Limits.MaxHeaderBytes < 4096 {
+		problems = append(problems, errors.New("limits.max-header-bytes must be at least 4096"))
+	}
+
+	if config.Jobs.MaxActive < 0 {
+		problems = append(problems, errors.New("jobs.max-active can't be negative"))
+	}
+
+	if config.Limits.BatchConcurrency < 1 {
+		problems = append(problems, errors.New("limits.batch-concurrency must be at least 1"))
+	}
+
+	if config.Limits.MaxUploadBytes < 1 {
+		problems = append(problems, errors.New("limits.max-upload-bytes must be at least 1"))
+	}
+
+	if config.Webhooks.MaxAttempts < 1 {
+		problems = append(problems, errors.New("webhooks.max-attempts must be at least 1"))
+	}
+
+	for _, host := range config.Webhooks.AllowedHosts {
+		if _, _, found := strings.Cut(host, "/"); found {
+			if _, err := netip.ParsePrefix(strings.TrimSpace(host)); err != nil {
+				problems = append(problems, fmt.Errorf("webhooks.allowed-hosts: %q is not a CIDR", host))
+			}
+		}
+	}
+
+	switch strings.ToLower(config.Log.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		problems = append(problems, fmt.Errorf("log.level must be debug, info, warn or error, got %q", config.Log.Level))
+	}
+
+	if config.Log.Format != "text" && config.Log.Format != "json" {
+		problems = append(problems, fmt.Errorf("log.format must be text or json, got %q", config.Log.Format))
+	}
+
+	if endpoint := config.Tracing.Endpoint; endpoint != "" && !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		problems = append(problems, fmt.Errorf("tracing.endpoint must be an http(s) URL, got %q", endpoint))
+	}
+
+	for _, header := range config.Tracing.Headers {
+		if name, _, found := strings.Cut(header, "="); !found || strings.TrimSpace(name) == "" {
+			problems = append(problems, errors.New("tracing.headers entries must look like Name=value"))
+			break
+		}
+	}
+
+	// The timeouts come from a map, sorted they read the same on every run
+	slices.SortFunc(problems, func(a, b error) int {
+		return strings.Compare(a.Error(), b.Error())
+	})
+
+	return errors.Join(problems...)
+}
+
+// IsLoopback says whether a bind address only takes connections from this machine
+func IsLoopback(bindAddress string) bool {
+	if bindAddress == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(bindAddress)
+	return ip != nil && ip.IsLoopback()
+}
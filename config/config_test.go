@@ -0,0 +1,75 @@
+package config
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidateReportsProblemsInOrder(t *testing.T) {
+	config := Defaults()
+	config.ADB.ReadTimeout = 0
+	config.ADB.InstallTimeout = 0
+	config.Server.ShutdownTimeout = 0
+	config.Webhooks.Timeout = 0
+	config.Log.Format = "xml"
+
+	first := config.Validate()
+	if first == nil {
+		t.Fatal("Validate accepted zero timeouts")
+	}
+
+	problems := strings.Split(first.Error(), "\n")
+	if len(problems) != 5 {
+		t.Fatalf("got %d problems, want 5: %q", len(problems), problems)
+	}
+
+	if !slices.IsSorted(problems) {
+		t.Errorf("problems aren't sorted: %q", problems)
+	}
+
+	// The timeouts are checked from a map, whose order changes from run to run
+	for range 20 {
+		if again := config.Validate(); again.Error() != first.Error() {
+			t.Fatalf("Validate changed its mind:\n%s\nthen\n%s", first, again)
+		}
+	}
+}
+
+func TestValidateWildcardBindNeedsAllowedHosts(t *testing.T) {
+	config := Defaults()
+	config.Server.BindAddress = "0.0.0.0"
+	config.Metrics.Token = "scrape"
+
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "server.allowed-hosts") {
+		t.Errorf("got %v, want an error about server.allowed-hosts", err)
+	}
+
+	config.Server.AllowedHosts = []string{"lab.local"}
+
+	if err := config.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+}
+
+func TestValidateMetricsOffLoopbackNeedToken(t *testing.T) {
+	config := Defaults()
+	config.Server.BindAddress = "192.0.2.10"
+
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "metrics.token") {
+		t.Errorf("got %v, want an error about metrics.token", err)
+	}
+
+	config.Metrics.Enabled = false
+
+	if err := config.Validate(); err != nil {
+		t.Errorf("Validate with metrics off: %v", err)
+	}
+
+	config.Metrics.Enabled = true
+	config.Metrics.Token = "scrape"
+
+	if err := config.Validate(); err != nil {
+		t.Errorf("Validate with a token: %v", err)
+	}
+}
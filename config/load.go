@@ -0,0 +1,146 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+const defaultFileName = "config.toml"
+
+// Load builds the effective configuration from defaults, the config file, the environment and
+// the given command-line arguments, in that order of precedence, and validates it.
+// The file is -config, else $ADB_SERVER_CONFIG, else config.toml in configDir if it exists.
+func Load(args []string, configDir string) (*Config, error) {
+	config := Defaults()
+
+	flagSet := flag.NewFlagSet("adb-server", flag.ContinueOnError)
+	flagSet.SetOutput(io.Discard)
+
+	configFile := flagSet.String("config", "", "config file (TOML)")
+	flagSet.BoolVar(&config.ResetPairings, "reset-pairings", false, "forget every paired client before starting")
+
+	// Flags win over everything, but the file and env have to be applied first, so just collect them for now
+	type flagValue struct {
+		entry setting
+		value string
+	}
+
+	var flagValues []flagValue
+
+	for _, entry := range settings {
+		collect := func(value string) error {
+			flagValues = append(flagValues, flagValue{entry: entry, value: value})
+			return nil
+		}
+
+		if entry.IsBool {
+			flagSet.BoolFunc(entry.flagName(), entry.Usage, collect)
+		} else {
+			flagSet.Func(entry.flagName(), entry.Usage, collect)
+		}
+	}
+
+	if err := flagSet.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			flagSet.SetOutput(os.Stderr)
+			flagSet.PrintDefaults()
+		}
+
+		return nil, err
+	}
+
+	if flagSet.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", flagSet.Arg(0))
+	}
+
+	path, explicit := *configFile, *configFile != ""
+	if !explicit {
+		path, explicit = os.Getenv(envPrefix+"CONFIG"), os.Getenv(envPrefix+"CONFIG") != ""
+	}
+	if !explicit && configDir != "" {
+		path = filepath.Join(configDir, defaultFileName)
+	}
+
+	if path != "" {
+		if err := config.applyFile(path, explicit); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := config.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	for _, flagValue := range flagValues {
+		if err := flagValue.entry.set(config, flagValue.value); err != nil {
+			return nil, err
+		}
+
+		config.sources[flagValue.entry.Key] = "flag"
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// A missing file is only an error when the user pointed us at it
+func (config *Config) applyFile(path string, mustExist bool) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !mustExist {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	values, err := parseTOML(string(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	// In order, so a file with several mistakes always reports the same one first
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		value := values[key]
+
+		entry, ok := lookupSetting(key)
+		if !ok {
+			return fmt.Errorf("%s: unknown setting %q", path, key)
+		}
+
+		if err := entry.set(config, value); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		config.sources[key] = "file"
+	}
+
+	config.File = path
+
+	return nil
+}
+
+func (config *Config) applyEnv() error {
+	for _, entry := range settings {
+		value, ok := os.LookupEnv(entry.envName())
+		if !ok {
+			continue
+		}
+
+		if err := entry.set(config, value); err != nil {
+			return fmt.Errorf("%s: %w", entry.envName(), err)
+		}
+
+		config.sources[entry.Key] = "env"
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const redacted = "<redacted>"
+
+// PrintTOML writes the effective configuration as a config file, noting where each value came from.
+// The output can be used as a starting config.toml.
+func (config *Config) PrintTOML(writer io.Writer) error {
+	section := ""
+
+	for _, entry := range settings {
+		entrySection, name, _ := strings.Cut(entry.Key, ".")
+
+		if entrySection != section {
+			if section != "" {
+				fmt.Fprintln(writer)
+			}
+
+			fmt.Fprintf(writer, "[%s]\n", entrySection)
+			section = entrySection
+		}
+
+		fmt.Fprintf(writer, "# %s (%s, %s)\n", entry.Usage, config.Source(entry.Key), entry.envName())
+		fmt.Fprintf(writer, "%s = %s\n", name, config.tomlValue(entry))
+	}
+
+	return nil
+}
+
+// PrintJSON writes the effective values and their sources as JSON, for scripts
+func (config *Config) PrintJSON(writer io.Writer) error {
+	type printedSetting struct {
+		Value  string `json:"value"`
+		Source string `json:"source"`
+	}
+
+	printed := map[string]printedSetting{}
+
+	for _, entry := range settings {
+		printed[entry.Key] = printedSetting{Value: config.displayValue(entry), Source: config.Source(entry.Key)}
+	}
+
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(printed)
+}
+
+func (config *Config) displayValue(entry setting) string {
+	value := entry.get(config)
+
+	if entry.Secret && value != "" {
+		return redacted
+	}
+
+	return value
+}
+
+func (config *Config) tomlValue(entry setting) string {
+	value := config.displayValue(entry)
+
+	if entry.IsList {
+		var items []string
+
+		if value != "" {
+			for _, item := range strings.Split(value, ",") {
+				items = append(items, strconv.Quote(item))
+			}
+		}
+
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+
+	if entry.IsBool {
+		return value
+	}
+
+	if _, err := strconv.Atoi(value); err == nil {
+		return value
+	}
+
+	return strconv.Quote(value)
+}
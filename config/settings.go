@@ -0,0 +1,197 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const envPrefix = "ADB_SERVER_"
+
+// setting ties one key to its field. The same table drives the config file, env vars, flags and `config print`.
+type setting struct {
+	Key    string // dotted, as used in the file: section.name
+	Flag   string // flag name, defaults to the key with dots turned into dashes
+	Env    string // env var, defaults to ADB_SERVER_ + key upper-cased
+	Usage  string
+	Secret bool // redacted by config print
+	IsBool bool // flag can be given without a value
+	IsList bool // printed as an array in the config file
+
+	get func(config *Config) string
+	set func(config *Config, value string) error
+}
+
+func (entry setting) flagName() string {
+	if entry.Flag != "" {
+		return entry.Flag
+	}
+
+	return strings.ReplaceAll(entry.Key, ".", "-")
+}
+
+func (entry setting) envName() string {
+	if entry.Env != "" {
+		return entry.Env
+	}
+
+	return envPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(entry.Key))
+}
+
+// Flags predating the config file keep their short names
+var settings = []setting{
+	stringSetting("adb.path", "", "path to the adb binary", func(config *Config) *string { return &config.ADB.Path }),
+	stringSetting("adb.server-address", "", "host:port of the adb server, adb's default when empty", func(config *Config) *string { return &config.ADB.ServerAddress }),
+	durationSetting("adb.read-timeout", "", "timeout for listing devices and packages", func(config *Config) *time.Duration { return &config.ADB.ReadTimeout }),
+	durationSetting("adb.install-timeout", "", "timeout for installing an APK", func(config *Config) *time.Duration { return &config.ADB.InstallTimeout }),
+	durationSetting("adb.uninstall-timeout", "", "timeout for uninstalling a package", func(config *Config) *time.Duration { return &config.ADB.UninstallTimeout }),
+	durationSetting("adb.transfer-timeout", "", "timeout for pushing or pulling a file", func(config *Config) *time.Duration { return &config.ADB.TransferTimeout }),
+	durationSetting("adb.bugreport-timeout", "", "timeout for capturing a bugreport", func(config *Config) *time.Duration { return &config.ADB.BugreportTimeout }),
+	stringSetting("adb.record-to", "", "record every adb invocation to this cassette file, to attach to bug reports", func(config *Config) *string { return &config.ADB.RecordTo }),
+	stringSetting("adb.replay-from", "", "answer from this cassette file instead of running adb", func(config *Config) *string { return &config.ADB.ReplayFrom }),
+	boolSetting("adb.replay-real-time", "", "replay cassettes at the speed they were recorded", func(config *Config) *bool { return &config.ADB.ReplayRealTime }),
+
+	stringSetting("server.bind-address", "bind", "address to listen on, anything but loopback should use TLS", func(config *Config) *string { return &config.Server.BindAddress }),
+	intSetting("server.port-min", "", "lowest port picked at random", func(config *Config) *int { return &config.Server.PortMin }),
+	intSetting("server.port-max", "", "highest port picked at random", func(config *Config) *int { return &config.Server.PortMax }),
+	stringSetting("server.unix-socket", "unix-socket", "serve on this unix socket (owner-only) instead of a TCP port", func(config *Config) *string { return &config.Server.UnixSocket }),
+	listSetting("server.allowed-origins", "allowed-origins", "web origins allowed to call the API (e.g. http://localhost:5173)", func(config *Config) *[]string { return &config.Server.AllowedOrigins }),
+	listSetting("server.allowed-hosts", "allowed-hosts", "extra Host names the server may be reached by (e.g. devicelab.local)", func(config *Config) *[]string { return &config.Server.AllowedHosts }),
+	durationSetting("server.shutdown-timeout", "shutdown-timeout", "how long in-flight operations get to finish on shutdown", func(config *Config) *time.Duration { return &config.Server.ShutdownTimeout }),
+	stringSetting("server.audit-log", "audit-log", "audit log file, defaults to audit/audit.log in the config dir", func(config *Config) *string { return &config.Server.AuditLog }),
+	stringSetting("server.registry-file", "", "device names, tags and groups, defaults to registry.json in the config dir", func(config *Config) *string { return &config.Server.RegistryFile }),
+
+	boolSetting("tls.enabled", "tls", "serve HTTPS, with a self-signed certificate unless a cert and key are given", func(config *Config) *bool { return &config.TLS.Enabled }),
+	stringSetting("tls.cert-file", "tls-cert", "PEM certificate to serve instead of the self-signed one", func(config *Config) *string { return &config.TLS.CertFile }),
+	stringSetting("tls.key-file", "tls-key", "PEM private key for the certificate", func(config *Config) *string { return &config.TLS.KeyFile }),
+	stringSetting("tls.client-ca-file", "tls-client-ca", "PEM bundle of CAs for client certificate (mTLS) auth", func(config *Config) *string { return &config.TLS.ClientCAFile }),
+	stringSetting("tls.client-auth", "tls-client-auth", "client certificate auth: none, optional or require", func(config *Config) *string { return &config.TLS.ClientAuth }),
+	listSetting("tls.client-scopes", "tls-client-scopes", "scopes client certificate sessions get (devices:read, devices:write, registry:write, audit:read, admin), all when empty", func(config *Config) *[]string { return &config.TLS.ClientScopes }),
+
+	stringSetting("auth.credentials-file", "", "where paired sessions are stored, defaults to credentials.json in the config dir", func(config *Config) *string { return &config.Auth.CredentialsFile }),
+	withEnv(secret(stringSetting("auth.credentials-key", "", "32 byte key (hex or base64) to encrypt the credentials file with", func(config *Config) *string { return &config.Auth.CredentialsKey })), envPrefix+"CREDENTIALS_KEY"),
+	withEnv(boolSetting("auth.keyring", "", "encrypt the credentials file with a generated key kept next to it", func(config *Config) *bool { return &config.Auth.Keyring }), envPrefix+"CREDENTIALS_KEYRING"),
+
+	durationSetting("jobs.retention", "", "how long finished jobs and their artifacts are kept", func(config *Config) *time.Duration { return &config.Jobs.Retention }),
+	intSetting("jobs.max-active", "", "most jobs queued or running at once, 0 for no limit", func(config *Config) *int { return &config.Jobs.MaxActive }),
+
+	durationSetting("limits.read-header-timeout", "", "how long a client gets to send request headers", func(config *Config) *time.Duration { return &config.Limits.ReadHeaderTimeout }),
+	intSetting("limits.max-header-bytes", "", "largest request header block accepted", func(config *Config) *int { return &config.Limits.MaxHeaderBytes }),
+	intSetting("limits.batch-concurrency", "", "most devices a batch operation works on at once", func(config *Config) *int { return &config.Limits.BatchConcurrency }),
+	intSetting("limits.max-upload-bytes", "", "largest APK a client may upload for install", func(config *Config) *int { return &config.Limits.MaxUploadBytes }),
+
+	stringSetting("log.level", "log-level", "debug, info, warn or error, debug logs every adb invocation", func(config *Config) *string { return &config.Log.Level }),
+	stringSetting("log.format", "log-format", "text or json", func(config *Config) *string { return &config.Log.Format }),
+
+	stringSetting("tracing.endpoint", "", "OTLP/HTTP collector to send traces to (e.g. http://127.0.0.1:4318), tracing is off when empty", func(config *Config) *string { return &config.Tracing.Endpoint }),
+	stringSetting("tracing.service-name", "", "service.name reported with every span", func(config *Config) *string { return &config.Tracing.ServiceName }),
+	secret(listSetting("tracing.headers", "", "extra headers for the collector as Name=value, e.g. an API key", func(config *Config) *[]string { return &config.Tracing.Headers })),
+
+	stringSetting("webhooks.file", "", "registered webhooks and their secrets, defaults to webhooks.json in the config dir", func(config *Config) *string { return &config.Webhooks.File }),
+	durationSetting("webhooks.timeout", "", "how long a webhook receiver gets to answer a delivery", func(config *Config) *time.Duration { return &config.Webhooks.Timeout }),
+	listSetting("webhooks.allowed-hosts", "", "loopback, link-local or metadata receivers webhooks may still deliver to, as names, IPs or CIDRs", func(config *Config) *[]string { return &config.Webhooks.AllowedHosts }),
+	intSetting("webhooks.max-attempts", "", "how many times a webhook delivery is tried before giving up", func(config *Config) *int { return &config.Webhooks.MaxAttempts }),
+
+	boolSetting("metrics.enabled", "", "serve Prometheus metrics on /metrics", func(config *Config) *bool { return &config.Metrics.Enabled }),
+	secret(stringSetting("metrics.token", "", "bearer token scrapers must send for /metrics, required unless bound to loopback", func(config *Config) *string { return &config.Metrics.Token })),
+}
+
+func lookupSetting(key string) (setting, bool) {
+	for _, entry := range settings {
+		if entry.Key == key {
+			return entry, true
+		}
+	}
+
+	return setting{}, false
+}
+
+func stringSetting(key, flag, usage string, field func(config *Config) *string) setting {
+	return setting{
+		Key: key, Flag: flag, Usage: usage,
+		get: func(config *Config) string { return *field(config) },
+		set: func(config *Config, value string) error {
+			*field(config) = value
+			return nil
+		},
+	}
+}
+
+func intSetting(key, flag, usage string, field func(config *Config) *int) setting {
+	return setting{
+		Key: key, Flag: flag, Usage: usage,
+		get: func(config *Config) string { return strconv.Itoa(*field(config)) },
+		set: func(config *Config, value string) error {
+			number, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s: %q is not a whole number", key, value)
+			}
+
+			*field(config) = number
+			return nil
+		},
+	}
+}
+
+func boolSetting(key, flag, usage string, field func(config *Config) *bool) setting {
+	return setting{
+		Key: key, Flag: flag, Usage: usage, IsBool: true,
+		get: func(config *Config) string { return strconv.FormatBool(*field(config)) },
+		set: func(config *Config, value string) error {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%s: %q is not true or false", key, value)
+			}
+
+			*field(config) = parsed
+			return nil
+		},
+	}
+}
+
+func durationSetting(key, flag, usage string, field func(config *Config) *time.Duration) setting {
+	return setting{
+		Key: key, Flag: flag, Usage: usage,
+		get: func(config *Config) string { return field(config).String() },
+		set: func(config *Config, value string) error {
+			duration, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("%s: %q is not a duration like 30s or 2m", key, value)
+			}
+
+			*field(config) = duration
+			return nil
+		},
+	}
+}
+
+// Lists are comma separated in env vars and flags, arrays in the config file
+func listSetting(key, flag, usage string, field func(config *Config) *[]string) setting {
+	return setting{
+		Key: key, Flag: flag, Usage: usage, IsList: true,
+		get: func(config *Config) string { return strings.Join(*field(config), ",") },
+		set: func(config *Config, value string) error {
+			var items []string
+
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+
+			*field(config) = items
+			return nil
+		},
+	}
+}
+
+func secret(entry setting) setting {
+	entry.Secret = true
+	return entry
+}
+
+func withEnv(entry setting, env string) setting {
+	entry.Env = env
+	return entry
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseTOML understands the slice of TOML our config needs: [section] headers, comments,
+// and key = value where value is a string, number, boolean or a one-line array of strings.
+// It returns dotted keys (section.key) with values in the same text form env vars and flags use.
+func parseTOML(text string) (map[string]string, error) {
+	values := map[string]string{}
+	section := ""
+
+	for lineNumber, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(stripComment(line))
+
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("line %d: invalid section header", lineNumber+1)
+			}
+
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		key, rawValue, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNumber+1)
+		}
+
+		key = strings.TrimSpace(key)
+		if section != "" {
+			key = section + "." + key
+		}
+
+		value, err := parseTOMLValue(strings.TrimSpace(rawValue))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber+1, err)
+		}
+
+		if _, duplicate := values[key]; duplicate {
+			return nil, fmt.Errorf("line %d: %s is set twice", lineNumber+1, key)
+		}
+
+		values[key] = value
+	}
+
+	return values, nil
+}
+
+func parseTOMLValue(raw string) (string, error) {
+	switch {
+	case raw == "":
+		return "", fmt.Errorf("missing value")
+
+	case strings.HasPrefix(raw, "["):
+		if !strings.HasSuffix(raw, "]") {
+			return "", fmt.Errorf("arrays must be on one line")
+		}
+
+		var items []string
+
+		for _, item := range splitArray(raw[1 : len(raw)-1]) {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue // trailing comma
+			}
+
+			value, err := parseTOMLString(item)
+			if err != nil {
+				return "", err
+			}
+
+			items = append(items, value)
+		}
+
+		return strings.Join(items, ","), nil
+
+	case strings.HasPrefix(raw, `"`), strings.HasPrefix(raw, "'"):
+		return parseTOMLString(raw)
+
+	default:
+		// Bare numbers and booleans are passed through for the setting to parse
+		return raw, nil
+	}
+}
+
+func parseTOMLString(raw string) (string, error) {
+	if len(raw) >= 2 && raw[0] == '\'' && raw[len(raw)-1] == '\'' {
+		return raw[1 : len(raw)-1], nil // literal string, no escapes
+	}
+
+	value, err := strconv.Unquote(raw)
+	if err != nil || !strings.HasPrefix(raw, `"`) {
+		return "", fmt.Errorf("invalid string %s", raw)
+	}
+
+	return value, nil
+}
+
+// Split on commas that aren't inside quotes
+func splitArray(raw string) []string {
+	var items []string
+	start := 0
+
+	scanUnquoted(raw, func(index int, char rune) bool {
+		if char == ',' {
+			items = append(items, raw[start:index])
+			start = index + 1
+		}
+
+		return true
+	})
+
+	return append(items, raw[start:])
+}
+
+// Drop everything after a # that isn't inside a string
+func stripComment(line string) string {
+	end := len(line)
+
+	scanUnquoted(line, func(index int, char rune) bool {
+		if char == '#' {
+			end = index
+			return false
+		}
+
+		return true
+	})
+
+	return line[:end]
+}
+
+// scanUnquoted calls visit with every character outside of strings until it returns false.
+// Backslashes escape in basic strings only, so "a\\" ends at its second quote and 'a\' at its first.
+func scanUnquoted(text string, visit func(index int, char rune) bool) {
+	var quote rune
+	escaped := false
+
+	for index, char := range text {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && char == '\\':
+			escaped = true
+		case quote != 0 && char == quote:
+			quote = 0
+		case quote != 0:
+		case char == '"' || char == '\'':
+			quote = char
+		case !visit(index, char):
+			return
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want map[string]string
+	}{
+		{
+			name: "top level keys",
+			text: "key = \"value\"\nother = 'literal'",
+			want: map[string]string{"key": "value", "other": "literal"},
+		},
+		{
+			name: "sections",
+			text: "[adb]\npath = \"/opt/adb\"\n\n[ server ]\nport-min = 40000",
+			want: map[string]string{"adb.path": "/opt/adb", "server.port-min": "40000"},
+		},
+		{
+			name: "numbers booleans and durations pass through",
+			text: "[x]\nn = 42\nb = true\nd = \"30s\"",
+			want: map[string]string{"x.n": "42", "x.b": "true", "x.d": "30s"},
+		},
+		{
+			name: "escapes in basic strings",
+			text: `a = "tab\there \"quoted\" back\\slash \u00e9"`,
+			want: map[string]string{"a": "tab\there \"quoted\" back\\slash é"},
+		},
+		{
+			name: "literal strings keep backslashes",
+			text: `path = 'C:\Users\adb'`,
+			want: map[string]string{"path": `C:\Users\adb`},
+		},
+		{
+			name: "comments",
+			text: "# whole line\n[a] # after a header\nkey = \"value # not a comment\" # a comment\nother = 'x#y'",
+			want: map[string]string{"a.key": "value # not a comment", "a.other": "x#y"},
+		},
+		{
+			name: "escaped backslash before the closing quote",
+			text: `key = "dir\\" # comment`,
+			want: map[string]string{"key": `dir\`},
+		},
+		{
+			name: "arrays",
+			text: `list = ["a", 'b', "c,d", ]` + "\nempty = []",
+			want: map[string]string{"list": "a,b,c,d", "empty": ""},
+		},
+		{
+			name: "arrays with escaped quotes",
+			text: `list = ["say \"hi\", twice", "x"]`,
+			want: map[string]string{"list": `say "hi", twice,x`},
+		},
+		{
+			name: "empty file",
+			text: "\n  \n# nothing\n",
+			want: map[string]string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseTOML(test.text)
+			if err != nil {
+				t.Fatalf("parseTOML: %v", err)
+			}
+
+			if !maps.Equal(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "unclosed header", text: "[adb\npath = \"x\"", want: "line 1: invalid section header"},
+		{name: "array of tables", text: "[[adb]]", want: "line 1: invalid section header"},
+		{name: "no equals sign", text: "[adb]\npath", want: "line 2: expected key = value"},
+		{name: "missing value", text: "path =", want: "line 1: missing value"},
+		{name: "unterminated string", text: `path = "abc`, want: "line 1: invalid string"},
+		{name: "bad escape", text: `path = "\q"`, want: "line 1: invalid string"},
+		{name: "multi-line array", text: "list = [\n\"a\"]", want: "line 1: arrays must be on one line"},
+		{name: "bare word in array", text: "list = [a]", want: "line 1: invalid string a"},
+		{name: "duplicate key", text: "[a]\nk = 1\n[a]\nk = 2", want: "line 4: a.k is set twice"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseTOML(test.text)
+			if err == nil {
+				t.Fatalf("parseTOML succeeded, want an error containing %q", test.want)
+			}
+
+			if !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got error %q, want it to contain %q", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package discovery
+
+import (
+	"adb-server/utilities"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const fileName = "server.json"
+
+var ErrNoServer = errors.New("no running adb-server found, start one or pass -server")
+
+// Info is what a running server leaves behind so the command line client can find it
+type Info struct {
+	PID            int       `json:"pid"`
+	URL            string    `json:"url,omitempty"`         // http(s)://host:port, empty on a unix socket
+	UnixSocket     string    `json:"unix_socket,omitempty"` // path of the socket, it needs no pairing
+	TLSFingerprint string    `json:"tls_fingerprint,omitempty"`
+	StartedAt      time.Time `json:"started_at"`
+}
+
+// Path is where the discovery file lives, next to the server's other state
+func Path(configDir string) string {
+	return filepath.Join(configDir, fileName)
+}
+
+func Write(path string, info Info) error {
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return utilities.WriteFileAtomic(path, data)
+}
+
+// Read returns ErrNoServer when there is no file, the server may still have died without
+// removing it, callers find that out when they connect
+func Read(path string) (Info, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Info{}, ErrNoServer
+	}
+	if err != nil {
+		return Info{}, err
+	}
+
+	var info Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		return Info{}, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	return info, nil
+}
+
+// Remove deletes the file if it still describes this process, a newer server may have taken over
+func Remove(path string) error {
+	info, err := Read(path)
+	if err != nil || info.PID != os.Getpid() {
+		return nil
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
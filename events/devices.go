@@ -0,0 +1,101 @@
+package events
+
+import (
+	"adb-server/internal/adb"
+	"context"
+	"log"
+	"time"
+)
+
+// DeviceChange is the data of the device events
+type DeviceChange struct {
+	adb.Device
+	PreviousState string `json:"previous_status,omitempty"`
+}
+
+// WatchDevices polls adb for devices coming, going and changing state and publishes the
+// differences. It only polls while someone is subscribed, adb devices isn't free, and starts
+// over from what it sees then. Returns when ctx is done.
+func WatchDevices(ctx context.Context, bus *Bus, adbClient adb.Client, interval time.Duration) {
+	wake := make(chan struct{}, 1)
+
+	bus.OnSubscribersChanged(func(subscribers int) {
+		if subscribers > 0 {
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		}
+	})
+
+	for {
+		for bus.Subscribers() == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-wake:
+			}
+		}
+
+		watchWhileSubscribed(ctx, bus, adbClient, interval)
+
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func watchWhileSubscribed(ctx context.Context, bus *Bus, adbClient adb.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var known map[string]adb.Device
+
+	for bus.Subscribers() > 0 {
+		devices, err := adbClient.Devices(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			log.Printf("Error polling devices for events: %v", err)
+		} else {
+			current := map[string]adb.Device{}
+			for _, device := range devices {
+				current[device.Serial] = device
+			}
+
+			// The first look is the baseline, everything on it was already there
+			if known != nil {
+				publishDeviceChanges(bus, known, current)
+			}
+
+			known = current
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func publishDeviceChanges(bus *Bus, known, current map[string]adb.Device) {
+	for serial, device := range current {
+		previous, found := known[serial]
+
+		switch {
+		case !found:
+			bus.Publish(TypeDeviceConnected, DeviceChange{Device: device})
+		case previous.State != device.State:
+			bus.Publish(TypeDeviceStateChanged, DeviceChange{Device: device, PreviousState: previous.State})
+		}
+	}
+
+	for serial, device := range known {
+		if _, found := current[serial]; !found {
+			bus.Publish(TypeDeviceDisconnected, DeviceChange{Device: device})
+		}
+	}
+}
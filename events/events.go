@@ -0,0 +1,180 @@
+package events
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	TypeDeviceConnected    = "device.connected"
+	TypeDeviceDisconnected = "device.disconnected"
+	TypeDeviceStateChanged = "device.state-changed"
+	TypeJobFinished        = "job.finished"
+	TypeOperation          = "operation" // an audited operation, the audit entry is the data
+)
+
+// Enough for a client that reconnects after a blip to catch up with Last-Event-ID
+const replayBuffer = 256
+
+// How many events a slow subscriber may fall behind before it gets cut off
+const subscriberBuffer = 64
+
+type Event struct {
+	ID   uint64    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data,omitempty"`
+}
+
+// Subscription receives events on C until it is closed, by Close or because the bus went away
+// or the subscriber fell too far behind
+type Subscription struct {
+	C <-chan Event
+
+	bus    *Bus
+	events chan Event
+	types  []string
+	closed bool
+}
+
+func (subscription *Subscription) Close() {
+	subscription.bus.mu.Lock()
+	defer subscription.bus.mu.Unlock()
+
+	subscription.bus.dropLocked(subscription)
+}
+
+func (subscription *Subscription) wants(eventType string) bool {
+	return len(subscription.types) == 0 || slices.Contains(subscription.types, eventType)
+}
+
+// Bus fans events out to whoever is subscribed and keeps the last few for replay
+type Bus struct {
+	mu            sync.Mutex
+	nextID        uint64
+	recent        []Event
+	subscriptions []*Subscription
+	closed        bool
+	onSubscribe   []func(subscribers int)
+}
+
+func NewBus() *Bus {
+	return &Bus{nextID: 1}
+}
+
+func (bus *Bus) Publish(eventType string, data any) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	if bus.closed {
+		return
+	}
+
+	event := Event{ID: bus.nextID, Type: eventType, Time: time.Now().UTC(), Data: data}
+	bus.nextID++
+
+	bus.recent = append(bus.recent, event)
+	if len(bus.recent) > replayBuffer {
+		bus.recent = slices.Delete(bus.recent, 0, len(bus.recent)-replayBuffer)
+	}
+
+	for _, subscription := range slices.Clone(bus.subscriptions) {
+		if !subscription.wants(eventType) {
+			continue
+		}
+
+		select {
+		case subscription.events <- event:
+		default:
+			// Better to drop a stuck client than to block everyone publishing
+			bus.dropLocked(subscription)
+		}
+	}
+}
+
+// Subscribe delivers events of the given types, all of them when types is empty. Events after
+// lastID that are still buffered are delivered first, pass 0 to only get new ones.
+func (bus *Bus) Subscribe(types []string, lastID uint64) *Subscription {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	events := make(chan Event, subscriberBuffer+replayBuffer)
+	subscription := &Subscription{C: events, bus: bus, events: events, types: types}
+
+	if bus.closed {
+		subscription.closed = true
+		close(events)
+		return subscription
+	}
+
+	if lastID > 0 {
+		for _, event := range bus.recent {
+			if event.ID > lastID && subscription.wants(event.Type) {
+				events <- event
+			}
+		}
+	}
+
+	bus.subscriptions = append(bus.subscriptions, subscription)
+	bus.notifyLocked()
+
+	return subscription
+}
+
+// Subscribers is how many subscriptions are open
+func (bus *Bus) Subscribers() int {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	return len(bus.subscriptions)
+}
+
+// OnSubscribersChanged registers a callback told the subscriber count whenever it changes, so
+// sources that poll can stop when nobody listens. It runs with the bus locked and must not block.
+func (bus *Bus) OnSubscribersChanged(callback func(subscribers int)) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	bus.onSubscribe = append(bus.onSubscribe, callback)
+}
+
+// Closed says whether the bus was closed. A subscription that ends while it isn't fell behind.
+func (bus *Bus) Closed() bool {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	return bus.closed
+}
+
+// Close ends every subscription, for shutdown, so open event streams don't hold up draining
+func (bus *Bus) Close() {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	bus.closed = true
+
+	for len(bus.subscriptions) > 0 {
+		bus.dropLocked(bus.subscriptions[0])
+	}
+}
+
+func (bus *Bus) dropLocked(subscription *Subscription) {
+	if subscription.closed {
+		return
+	}
+
+	subscription.closed = true
+	close(subscription.events)
+
+	bus.subscriptions = slices.DeleteFunc(bus.subscriptions, func(other *Subscription) bool {
+		return other == subscription
+	})
+	bus.notifyLocked()
+}
+
+func (bus *Bus) notifyLocked() {
+	for _, callback := range bus.onSubscribe {
+		callback(len(bus.subscriptions))
+	}
+}
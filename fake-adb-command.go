@@ -0,0 +1,111 @@
+package main
+
+import (
+	"adb-server/internal/adbtest/fakehost"
+	"adb-server/logging"
+	"adb-server/models"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// adb-server fake-adb [-listen 127.0.0.1:5037] [-devices 2] [-unauthorized 0] [-devices-file devices.json]
+// A stand-in adb server with simulated devices, for demos and CI runners without phones. The
+// real adb binary talks to it like to its own server; point adb.server-address at it when it's
+// not on adb's default port.
+func runFakeADB(args []string) int {
+	flags := flag.NewFlagSet("fake-adb", flag.ContinueOnError)
+	listen := flags.String("listen", "127.0.0.1:5037", "address to accept adb clients on, 5037 is where adb looks by default")
+	count := flags.Int("devices", 2, "how many authorized devices to simulate")
+	unauthorized := flags.Int("unauthorized", 0, "how many more devices to simulate that wait for the RSA prompt")
+	devicesFile := flags.String("devices-file", "", "JSON array of device specs (serial, model, packages, files, ...) instead of -devices")
+	logcatInterval := flags.Duration("logcat-interval", 250*time.Millisecond, "how often each device logs a made-up line")
+	rebootDelay := flags.Duration("reboot-delay", 3*time.Second, "how long a rebooting device is gone")
+	verbose := flags.Bool("verbose", false, "log every request")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return models.ExitOK
+		}
+		return models.ExitConfig
+	}
+
+	level := "info"
+	if *verbose {
+		level = "debug"
+	}
+
+	if err := logging.Setup(level, "text", os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return models.ExitConfig
+	}
+
+	var specs []fakehost.DeviceSpec
+
+	if *devicesFile != "" {
+		data, err := os.ReadFile(*devicesFile)
+		if err == nil {
+			err = json.Unmarshal(data, &specs)
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "reading %s: %v\n", *devicesFile, err)
+			return models.ExitConfig
+		}
+	} else {
+		for index := range *count + *unauthorized {
+			spec := fakehost.DeviceSpec{Serial: fmt.Sprintf("FAKE%04d", index+1)}
+			if index >= *count {
+				spec.State = "unauthorized"
+			}
+
+			specs = append(specs, spec)
+		}
+	}
+
+	server, err := fakehost.New(fakehost.Options{Devices: specs, LogcatInterval: *logcatInterval, RebootDelay: *rebootDelay})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return models.ExitConfig
+	}
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\nIs a real adb server running? Stop it with adb kill-server or pick another -listen address.\n", err)
+		return models.ExitError
+	}
+
+	fmt.Fprintf(os.Stderr, "Fake adb server on %s with %d devices\n", listener.Addr(), len(specs))
+	fmt.Fprintf(os.Stderr, "Use it with: adb -H %s -P %s devices, or adb.server-address = %q\n", hostOf(listener.Addr()), portOf(listener.Addr()), listener.Addr().String())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-signals
+		server.Close()
+	}()
+
+	if err := server.Serve(listener); err != nil && !errors.Is(err, net.ErrClosed) {
+		fmt.Fprintln(os.Stderr, err)
+		return models.ExitError
+	}
+
+	return models.ExitOK
+}
+
+func hostOf(address net.Addr) string {
+	host, _, _ := net.SplitHostPort(address.String())
+	return host
+}
+
+func portOf(address net.Addr) string {
+	_, port, _ := net.SplitHostPort(address.String())
+	return port
+}
@@ -1,20 +1,22 @@
 package handlers
 
 import (
+	"adb-server/audit"
 	"adb-server/internal/adb"
 	"adb-server/middleware"
+	"adb-server/models"
+	"adb-server/registry"
 	"adb-server/utilities"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 )
 
 func HandleListDevices(res http.ResponseWriter, req *http.Request) {
-	if req.Method != http.MethodGet {
-		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	// Get the ADB adbClient from the context
 	adbClient, ok := middleware.GetADBClient(req)
 	if !ok {
@@ -28,27 +30,107 @@ func HandleListDevices(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	utilities.WriteJSON(res, http.StatusOK, devices)
+	deviceRegistry, hasRegistry := middleware.GetRegistry(req)
+	if !hasRegistry {
+		utilities.WriteJSON(res, http.StatusOK, devices)
+		return
+	}
+
+	tag := strings.ToLower(req.URL.Query().Get("tag"))
+	includeOffline := req.URL.Query().Get("include-offline") == "true"
+
+	listings := []models.DeviceListing{}
+	connected := map[string]bool{}
+
+	for _, device := range devices {
+		connected[device.Serial] = true
+		listings = append(listings, deviceListing(deviceRegistry, device))
+	}
+
+	// Registered devices adb can't see right now, so people can tell which of their phones went missing
+	if includeOffline {
+		for _, info := range deviceRegistry.Devices() {
+			if !connected[info.Serial] {
+				listings = append(listings, deviceListing(deviceRegistry, adb.Device{Serial: info.Serial, State: "disconnected"}))
+			}
+		}
+	}
+
+	if tag != "" {
+		listings = slices.DeleteFunc(listings, func(listing models.DeviceListing) bool {
+			return !slices.Contains(listing.Tags, tag)
+		})
+	}
+
+	utilities.WriteJSON(res, http.StatusOK, listings)
 }
 
-func HandleListPackages(res http.ResponseWriter, req *http.Request) {
-	if req.Method != http.MethodGet {
-		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+// GET /v2/devices/{serial}, one device the way the device list shows it. Registered devices
+// adb can't see are still found, as disconnected.
+func HandleGetDevice(res http.ResponseWriter, req *http.Request) {
+	adbClient, ok := middleware.GetADBClient(req)
+	if !ok {
+		http.Error(res, "ADB client not available", http.StatusInternalServerError)
+		return
+	}
+
+	serial, ok := deviceSerialParam(res, req)
+	if !ok {
 		return
 	}
 
+	devices, err := adbClient.Devices(req.Context())
+	if err != nil {
+		http.Error(res, "error listing devices connected to adb", http.StatusInternalServerError)
+		return
+	}
+
+	deviceRegistry, hasRegistry := middleware.GetRegistry(req)
+
+	index := slices.IndexFunc(devices, func(device adb.Device) bool {
+		return device.Serial == serial
+	})
+
+	switch {
+	case index >= 0 && hasRegistry:
+		utilities.WriteJSON(res, http.StatusOK, deviceListing(deviceRegistry, devices[index]))
+	case index >= 0:
+		utilities.WriteJSON(res, http.StatusOK, models.DeviceListing{Device: devices[index]})
+	case hasRegistry:
+		if _, registered := deviceRegistry.Device(serial); registered {
+			utilities.WriteJSON(res, http.StatusOK, deviceListing(deviceRegistry, adb.Device{Serial: serial, State: "disconnected"}))
+			return
+		}
+
+		fallthrough
+	default:
+		http.Error(res, fmt.Sprintf("device %s not found", serial), http.StatusNotFound)
+	}
+}
+
+func deviceListing(deviceRegistry *registry.Registry, device adb.Device) models.DeviceListing {
+	listing := models.DeviceListing{Device: device, Groups: deviceRegistry.GroupsOf(device.Serial)}
+
+	if info, found := deviceRegistry.Device(device.Serial); found {
+		listing.Name = info.Name
+		listing.Tags = info.Tags
+		listing.Owner = info.Owner
+		listing.Notes = info.Notes
+	}
+
+	return listing
+}
+
+func HandleListPackages(res http.ResponseWriter, req *http.Request) {
 	adbClient, ok := middleware.GetADBClient(req)
 	if !ok {
 		http.Error(res, "ADB client not available", http.StatusInternalServerError)
 		return
 	}
 
-	// Get the device-id query parameter
-	deviceID := req.URL.Query().Get("device-id")
-
-	// Check if device-id is provided (it's a required parameter)
-	if deviceID == "" {
-		http.Error(res, "device-id parameter is required", http.StatusBadRequest)
+	// The {serial} path value, a serial or a registry selector
+	deviceID, ok := deviceSerialParam(res, req)
+	if !ok {
 		return
 	}
 
@@ -78,33 +160,50 @@ func HandleListPackages(res http.ResponseWriter, req *http.Request) {
 }
 
 func HandleInstallApp(res http.ResponseWriter, req *http.Request) {
-	if req.Method != http.MethodPost {
-		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	adbClient, ok := middleware.GetADBClient(req)
 	if !ok {
 		http.Error(res, "ADB client not available", http.StatusInternalServerError)
 		return
 	}
 
-	// Get the device-id query parameter
-	deviceID := req.URL.Query().Get("device-id")
-	if deviceID == "" {
-		http.Error(res, "device-id parameter is required", http.StatusBadRequest)
+	// The {serial} path value, a serial or a registry selector
+	deviceID, ok := deviceSerialParam(res, req)
+	if !ok {
 		return
 	}
 
-	// Get the required file path parameter
+	auditEntry := audit.Entry{
+		Operation: "install",
+		DeviceID:  deviceID,
+	}
+
+	// Either a file already on this machine, or the APK itself streamed in the body
 	filePath := req.URL.Query().Get("path")
-	if filePath == "" {
-		http.Error(res, "path parameter is required", http.StatusBadRequest)
-		return
+	if filePath != "" {
+		auditEntry.Arguments = map[string]string{"path": filePath}
+
+		// Hash before installing so the log says exactly which build went on the device
+		if apkHash, hashError := audit.HashFile(filePath); hashError == nil {
+			auditEntry.APKSHA256 = apkHash
+		}
+	} else {
+		apk, ok := receiveUpload(res, req)
+		if !ok {
+			return
+		}
+		defer apk.remove()
+
+		filePath = apk.path
+		auditEntry.Arguments = apk.auditArguments()
+		auditEntry.APKSHA256 = apk.sha256
 	}
 
+	started := time.Now()
+
 	// Install the APK using the simplified Install function
 	err := adbClient.Install(req.Context(), deviceID, filePath)
+	recordAudit(req, auditEntry, started, err)
+
 	if err != nil {
 		http.Error(res, fmt.Sprintf("error installing apk: %v", err), http.StatusInternalServerError)
 		return
@@ -115,27 +214,21 @@ func HandleInstallApp(res http.ResponseWriter, req *http.Request) {
 }
 
 func HandleUninstallApp(res http.ResponseWriter, req *http.Request) {
-	if req.Method != http.MethodDelete {
-		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	adbClient, ok := middleware.GetADBClient(req)
 	if !ok {
 		http.Error(res, "ADB client not available", http.StatusInternalServerError)
 		return
 	}
 
-	deviceID := req.URL.Query().Get("device-id")
-	packageName := req.URL.Query().Get("package")
-
-	if deviceID == "" {
-		http.Error(res, "device-id parameter is required", http.StatusBadRequest)
+	deviceID, ok := deviceSerialParam(res, req)
+	if !ok {
 		return
 	}
 
+	packageName := req.PathValue("name")
+
 	if packageName == "" {
-		http.Error(res, "package parameter is required", http.StatusBadRequest)
+		http.Error(res, "package name is required", http.StatusBadRequest)
 		return
 	}
 
@@ -153,7 +246,19 @@ func HandleUninstallApp(res http.ResponseWriter, req *http.Request) {
 		}
 	}
 
+	started := time.Now()
+
 	err := adbClient.Uninstall(req.Context(), deviceID, packageName, keepData, user)
+	recordAudit(req, audit.Entry{
+		Operation: "uninstall",
+		DeviceID:  deviceID,
+		Arguments: map[string]string{
+			"package":   packageName,
+			"keep-data": strconv.FormatBool(keepData),
+			"user":      strconv.Itoa(user),
+		},
+	}, started, err)
+
 	if err != nil {
 		http.Error(res, fmt.Sprintf("error uninstalling package: %v", err), http.StatusInternalServerError)
 		return
@@ -162,3 +267,91 @@ func HandleUninstallApp(res http.ResponseWriter, req *http.Request) {
 	// Return success response
 	utilities.WriteJSON(res, http.StatusOK, map[string]string{"message": "Package uninstalled successfully"})
 }
+
+// Shows which operation holds each device and what is queued behind it
+func HandleDeviceLocks(res http.ResponseWriter, req *http.Request) {
+	adbClient, ok := middleware.GetADBClient(req)
+	if !ok {
+		http.Error(res, "ADB client not available", http.StatusInternalServerError)
+		return
+	}
+
+	deviceID := req.URL.Query().Get("device-id")
+
+	locks := []adb.DeviceLockStatus{}
+
+	for _, lock := range adbClient.Locks() {
+		if deviceID == "" || lock.Serial == deviceID {
+			locks = append(locks, lock)
+		}
+	}
+
+	utilities.WriteJSON(res, http.StatusOK, locks)
+}
+
+// POST /v2/devices/{serial}/shell runs one command, the batch endpoint does the same for many devices
+func HandleShell(res http.ResponseWriter, req *http.Request) {
+	adbClient, ok := middleware.GetADBClient(req)
+	if !ok {
+		http.Error(res, "ADB client not available", http.StatusInternalServerError)
+		return
+	}
+
+	deviceID, ok := deviceSerialParam(res, req)
+	if !ok {
+		return
+	}
+
+	var shellRequest models.ShellRequest
+	if err := json.NewDecoder(req.Body).Decode(&shellRequest); err != nil {
+		http.Error(res, fmt.Sprintf("invalid shell request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(shellRequest.Command) == "" {
+		http.Error(res, "command is required", http.StatusBadRequest)
+		return
+	}
+
+	started := time.Now()
+
+	output, err := adbClient.Shell(req.Context(), deviceID, shellRequest.Command)
+	recordAudit(req, audit.Entry{
+		Operation: "shell",
+		DeviceID:  deviceID,
+		Arguments: map[string]string{"command": shellRequest.Command},
+	}, started, err)
+
+	if err != nil {
+		http.Error(res, fmt.Sprintf("error running shell command: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	utilities.WriteJSON(res, http.StatusOK, models.ShellResult{Command: shellRequest.Command, Output: output})
+}
+
+// GET /v2/devices/{serial}/screenshot, the screen as a PNG
+func HandleScreenshot(res http.ResponseWriter, req *http.Request) {
+	adbClient, ok := middleware.GetADBClient(req)
+	if !ok {
+		http.Error(res, "ADB client not available", http.StatusInternalServerError)
+		return
+	}
+
+	deviceID, ok := deviceSerialParam(res, req)
+	if !ok {
+		return
+	}
+
+	image, err := adbClient.Screenshot(req.Context(), deviceID)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("error taking screenshot: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "image/png")
+	res.Header().Set("Content-Length", strconv.Itoa(len(image)))
+	res.Header().Set("Cache-Control", "no-store")
+	res.WriteHeader(http.StatusOK)
+	res.Write(image)
+}
@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"adb-server/audit"
+	"adb-server/utilities"
+	"net/http"
+	"time"
+)
+
+// HandleShutdown asks the server to shut down gracefully. The response goes out before
+// draining starts, in-flight requests (including this one) are given time to finish.
+func HandleShutdown(requestShutdown func()) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		recordAudit(req, audit.Entry{Operation: "shutdown"}, time.Now(), nil)
+
+		utilities.WriteJSON(res, http.StatusAccepted, map[string]string{"message": "Server is shutting down"})
+
+		requestShutdown()
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"adb-server/audit"
+	"adb-server/logging"
+	"adb-server/middleware"
+	"adb-server/utilities"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// A query answers with at most this many entries, the log itself can hold far more
+const MaxAuditQueryLimit = 1000
+
+func HandleAuditQuery(res http.ResponseWriter, req *http.Request) {
+	auditLog, ok := middleware.GetAuditLog(req)
+	if !ok {
+		http.Error(res, "audit log not available", http.StatusInternalServerError)
+		return
+	}
+
+	query := req.URL.Query()
+
+	filter := audit.Filter{
+		SessionID: query.Get("session"),
+		DeviceID:  query.Get("device-id"),
+		Operation: query.Get("operation"),
+		Outcome:   query.Get("outcome"),
+		Limit:     100,
+	}
+
+	// since/until are RFC 3339 timestamps
+	var err error
+
+	if since := query.Get("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			http.Error(res, "invalid since parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if until := query.Get("until"); until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			http.Error(res, "invalid until parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 1 || filter.Limit > MaxAuditQueryLimit {
+			http.Error(res, fmt.Sprintf("invalid limit parameter, must be 1 to %d", MaxAuditQueryLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := auditLog.Query(filter)
+	if err != nil {
+		http.Error(res, "error reading audit log", http.StatusInternalServerError)
+		return
+	}
+
+	utilities.WriteJSON(res, http.StatusOK, entries)
+}
+
+// recordAudit writes what a handler just did to the device. Failing to audit is logged but doesn't
+// fail the request, the operation already happened by the time we get here.
+func recordAudit(req *http.Request, entry audit.Entry, started time.Time, operationError error) {
+	auditLog, ok := middleware.GetAuditLog(req)
+	if !ok {
+		return
+	}
+
+	if session, ok := middleware.GetSession(req); ok {
+		entry.SessionID = session.ID
+	}
+
+	entry.RequestID = logging.RequestID(req.Context())
+
+	writeAudit(auditLog, entry, started, operationError)
+}
+
+// writeAudit is recordAudit for work that outlives its request, like jobs
+func writeAudit(auditLog *audit.Logger, entry audit.Entry, started time.Time, operationError error) {
+	auditLog.RecordOutcome(entry, started, operationError)
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"adb-server/authentication"
+	"adb-server/middleware"
 	"adb-server/models"
 	"adb-server/utilities"
 	"net/http"
@@ -9,23 +10,37 @@ import (
 
 var authTokenLength int = 32
 
+// SHA-256 of the certificate we serve when TLS is on. It goes in the discovery file, not the
+// pairing response: a fingerprint sent over the connection it's meant to check pins nothing.
+var tlsFingerprint string
+
+func SetTLSFingerprint(fingerprint string) {
+	tlsFingerprint = fingerprint
+}
+
+func TLSFingerprint() string {
+	return tlsFingerprint
+}
+
 // I know this isnt the most secure function
 // But it is quick enough to implement and secure enough.
 // We change the port the server is running on every 60 seconds. ( I know you could still brute force it but lowers the chances )
 // The server closes after 180 seconds of no pairing
 func PairWithServer(responseWriter http.ResponseWriter, httpRequest *http.Request) {
-	if httpRequest.Method != http.MethodPost {
-		http.Error(responseWriter, "method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	// Only allow one pairing per session
 	if authentication.IsPaired() {
 		http.Error(responseWriter, "a client is already paired", http.StatusConflict)
 		return
 	}
 
-	serverAuthenticationToken, tokenError := authentication.GenerateAuthToken(authTokenLength)
+	// A client can ask for less than everything, e.g. a dashboard that only ever reads
+	scopes, scopeError := authentication.ParseScopes(httpRequest.URL.Query().Get("scopes"))
+	if scopeError != nil {
+		http.Error(responseWriter, scopeError.Error(), http.StatusBadRequest)
+		return
+	}
+
+	serverAuthenticationToken, tokenError := authentication.GenerateAuthToken(authTokenLength, scopes...)
 
 	if tokenError != nil {
 		http.Error(responseWriter, "problem generating auth token", http.StatusInternalServerError)
@@ -38,14 +53,63 @@ func PairWithServer(responseWriter http.ResponseWriter, httpRequest *http.Reques
 		Value:    serverAuthenticationToken,
 		Path:     "/",
 		HttpOnly: true,
+		Secure:   httpRequest.TLS != nil,
 		SameSite: http.SameSiteLaxMode,
 	}
 
 	http.SetCookie(responseWriter, cookie)
 
+	session, _ := authentication.LookupSession(serverAuthenticationToken)
+	csrfToken := authentication.CSRFToken(session)
+
+	responseWriter.Header().Set(middleware.CSRFHeader, csrfToken)
+
 	authenticationResponse := models.PairingResponse{
-		Status: "Success",
+		Status:    "Success",
+		CSRFToken: csrfToken,
+		Scopes:    scopes,
 	}
 
 	utilities.WriteJSON(responseWriter, 200, authenticationResponse)
 }
+
+// Forgets the calling client's session, since pairings survive restarts this is the way to hand over to another client
+func UnpairFromServer(responseWriter http.ResponseWriter, httpRequest *http.Request) {
+	// Certificate sessions have no cookie, there is nothing to revoke short of removing the cert from the CA
+	cookie, err := httpRequest.Cookie("X-Auth-Token")
+	if err != nil || cookie.Value == "" {
+		http.Error(responseWriter, "only cookie sessions can be unpaired", http.StatusBadRequest)
+		return
+	}
+
+	if err := authentication.RevokeSession(cookie.Value); err != nil {
+		http.Error(responseWriter, "problem revoking session", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(responseWriter, &http.Cookie{
+		Name:     "X-Auth-Token",
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	utilities.WriteJSON(responseWriter, http.StatusOK, models.PairingResponse{Status: "Unpaired"})
+}
+
+// Hands the CSRF token back to a client that lost it, state changing requests need it in the X-CSRF-Token header
+func HandleCSRFToken(responseWriter http.ResponseWriter, httpRequest *http.Request) {
+	session, ok := middleware.GetSession(httpRequest)
+	if !ok {
+		http.Error(responseWriter, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	csrfToken := authentication.CSRFToken(session)
+
+	responseWriter.Header().Set(middleware.CSRFHeader, csrfToken)
+
+	utilities.WriteJSON(responseWriter, http.StatusOK, models.CSRFTokenResponse{CSRFToken: csrfToken})
+}
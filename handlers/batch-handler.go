@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"adb-server/audit"
+	"adb-server/internal/adb"
+	"adb-server/middleware"
+	"adb-server/models"
+	"adb-server/registry"
+	"adb-server/utilities"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Upper bound for how many devices one batch works on at once, requests can only ask for less
+var batchConcurrency = 8
+
+func SetBatchConcurrency(concurrency int) {
+	batchConcurrency = concurrency
+}
+
+// deviceOperation is what a batch does to a single device
+type deviceOperation func(ctx context.Context, adbClient adb.Client, deviceID string) (output string, err error)
+
+func HandleBatchInstall(res http.ResponseWriter, req *http.Request) {
+	handleBatch(res, req, "install", func(batch models.BatchRequest) (deviceOperation, audit.Entry, error) {
+		if batch.Path == "" {
+			return nil, audit.Entry{}, errors.New("path is required")
+		}
+
+		// Same APK for every device, hash it once
+		auditEntry := audit.Entry{Arguments: map[string]string{"path": batch.Path}, APKSHA256: hashOrEmpty(batch.Path)}
+
+		return func(ctx context.Context, adbClient adb.Client, deviceID string) (string, error) {
+			return "", adbClient.Install(ctx, deviceID, batch.Path)
+		}, auditEntry, nil
+	})
+}
+
+func HandleBatchUninstall(res http.ResponseWriter, req *http.Request) {
+	handleBatch(res, req, "uninstall", func(batch models.BatchRequest) (deviceOperation, audit.Entry, error) {
+		if batch.Package == "" {
+			return nil, audit.Entry{}, errors.New("package is required")
+		}
+
+		user := -1 // all users, same as the single device endpoint
+		if batch.User != nil {
+			user = *batch.User
+		}
+
+		auditEntry := audit.Entry{Arguments: map[string]string{
+			"package":   batch.Package,
+			"keep-data": strconv.FormatBool(batch.KeepData),
+			"user":      strconv.Itoa(user),
+		}}
+
+		return func(ctx context.Context, adbClient adb.Client, deviceID string) (string, error) {
+			return "", adbClient.Uninstall(ctx, deviceID, batch.Package, batch.KeepData, user)
+		}, auditEntry, nil
+	})
+}
+
+func HandleBatchShell(res http.ResponseWriter, req *http.Request) {
+	handleBatch(res, req, "shell", func(batch models.BatchRequest) (deviceOperation, audit.Entry, error) {
+		if strings.TrimSpace(batch.Command) == "" {
+			return nil, audit.Entry{}, errors.New("command is required")
+		}
+
+		return func(ctx context.Context, adbClient adb.Client, deviceID string) (string, error) {
+			return adbClient.Shell(ctx, deviceID, batch.Command)
+		}, audit.Entry{Arguments: map[string]string{"command": batch.Command}}, nil
+	})
+}
+
+func HandleBatchReboot(res http.ResponseWriter, req *http.Request) {
+	handleBatch(res, req, "reboot", func(batch models.BatchRequest) (deviceOperation, audit.Entry, error) {
+		return func(ctx context.Context, adbClient adb.Client, deviceID string) (string, error) {
+			return "", adbClient.Reboot(ctx, deviceID, batch.Mode)
+		}, audit.Entry{Arguments: map[string]string{"mode": batch.Mode}}, nil
+	})
+}
+
+// handleBatch decodes the request, resolves the target devices and fans the operation out
+// over them with bounded concurrency. With ?stream=true (or Accept: application/x-ndjson)
+// each device's result is written as its own JSON line the moment it finishes, followed
+// by a summary line; otherwise everything comes back in one response.
+func handleBatch(res http.ResponseWriter, req *http.Request, operationName string, prepare func(batch models.BatchRequest) (deviceOperation, audit.Entry, error)) {
+	adbClient, ok := middleware.GetADBClient(req)
+	if !ok {
+		http.Error(res, "ADB client not available", http.StatusInternalServerError)
+		return
+	}
+
+	var batch models.BatchRequest
+	if err := json.NewDecoder(req.Body).Decode(&batch); err != nil {
+		http.Error(res, fmt.Sprintf("invalid batch request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	operation, auditEntry, err := prepare(batch)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	deviceIDs, err := resolveBatchDevices(req, adbClient, batch)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(deviceIDs) == 0 {
+		http.Error(res, "no devices matched", http.StatusBadRequest)
+		return
+	}
+
+	concurrency := batchConcurrency
+	if batch.Concurrency > 0 && batch.Concurrency < concurrency {
+		concurrency = batch.Concurrency
+	}
+
+	streaming := req.URL.Query().Get("stream") == "true" || strings.Contains(req.Header.Get("Accept"), "application/x-ndjson")
+
+	// Batches yield to anything interactive on the same device
+	ctx := adb.WithPriority(req.Context(), adb.PriorityBatch)
+
+	results := make(chan models.BatchDeviceResult)
+	slots := make(chan struct{}, concurrency)
+
+	var workers sync.WaitGroup
+
+	for _, deviceID := range deviceIDs {
+		workers.Add(1)
+
+		go func() {
+			defer workers.Done()
+
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+				results <- models.BatchDeviceResult{DeviceID: deviceID, Status: "failure", Error: ctx.Err().Error()}
+				return
+			}
+
+			started := time.Now()
+			output, err := operation(ctx, adbClient, deviceID)
+
+			deviceAudit := auditEntry
+			deviceAudit.Operation = "batch-" + operationName
+			deviceAudit.DeviceID = deviceID
+			recordAudit(req, deviceAudit, started, err)
+
+			result := models.BatchDeviceResult{
+				DeviceID:   deviceID,
+				Status:     "success",
+				Output:     output,
+				DurationMS: time.Since(started).Milliseconds(),
+			}
+
+			if err != nil {
+				result.Status = "failure"
+				result.Error = err.Error()
+			}
+
+			results <- result
+		}()
+	}
+
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	response := models.BatchResponse{Operation: operationName, Results: []models.BatchDeviceResult{}}
+
+	var encoder *json.Encoder
+	var controller *http.ResponseController
+
+	if streaming {
+		res.Header().Set("Content-Type", "application/x-ndjson")
+		res.WriteHeader(http.StatusOK)
+
+		encoder = json.NewEncoder(res)
+		controller = http.NewResponseController(res)
+	}
+
+	for result := range results {
+		if result.Status == "success" {
+			response.Succeeded++
+		} else {
+			response.Failed++
+		}
+
+		if streaming {
+			_ = encoder.Encode(result)
+			_ = controller.Flush()
+			continue
+		}
+
+		response.Results = append(response.Results, result)
+	}
+
+	if streaming {
+		// Summary line, results were already sent one by one
+		response.Results = nil
+		_ = encoder.Encode(response)
+		return
+	}
+
+	slices.SortFunc(response.Results, func(a, b models.BatchDeviceResult) int {
+		return strings.Compare(a.DeviceID, b.DeviceID)
+	})
+
+	utilities.WriteJSON(res, http.StatusOK, response)
+}
+
+// The explicit device list plus every device the selector matches, without duplicates
+func resolveBatchDevices(req *http.Request, adbClient adb.Client, batch models.BatchRequest) ([]string, error) {
+	deviceRegistry, hasRegistry := middleware.GetRegistry(req)
+
+	var deviceIDs []string
+
+	for _, deviceID := range batch.Devices {
+		if !hasRegistry {
+			deviceIDs = append(deviceIDs, deviceID)
+			continue
+		}
+
+		serials, isSelector, err := deviceRegistry.Resolve(deviceID)
+		if err != nil {
+			return nil, err
+		}
+
+		if !isSelector {
+			serials = []string{deviceID}
+		}
+
+		deviceIDs = append(deviceIDs, serials...)
+	}
+
+	if selector := batch.Selector; selector != nil {
+		if !selector.AllAuthorized && selector.Model == "" && selector.Tag == "" && selector.Group == "" {
+			return nil, errors.New("selector must set all_authorized, model, tag or group")
+		}
+
+		// Tags and groups narrow the connected devices down to the registry's idea of them
+		var registered []string
+
+		if selector.Tag != "" || selector.Group != "" {
+			if !hasRegistry {
+				return nil, errors.New("tag and group selectors need the device registry")
+			}
+
+			var err error
+			if registered, err = selectorSerials(deviceRegistry, selector); err != nil {
+				return nil, err
+			}
+		}
+
+		devices, err := adbClient.Devices(req.Context())
+		if err != nil {
+			return nil, fmt.Errorf("error listing devices: %v", err)
+		}
+
+		for _, device := range devices {
+			if selector.AllAuthorized && !device.IsAuthorized {
+				continue
+			}
+
+			if selector.Model != "" && !strings.EqualFold(device.Model, selector.Model) {
+				continue
+			}
+
+			if registered != nil && !slices.Contains(registered, device.Serial) {
+				continue
+			}
+
+			deviceIDs = append(deviceIDs, device.Serial)
+		}
+	}
+
+	slices.Sort(deviceIDs)
+
+	return slices.Compact(deviceIDs), nil
+}
+
+// Devices matching both the tag and the group when both are set. Never nil, so an empty
+// match still filters everything out.
+func selectorSerials(deviceRegistry *registry.Registry, selector *models.DeviceSelector) ([]string, error) {
+	var queries []string
+
+	if selector.Tag != "" {
+		queries = append(queries, "tag:"+selector.Tag)
+	}
+
+	if selector.Group != "" {
+		queries = append(queries, "group:"+selector.Group)
+	}
+
+	matched := []string{}
+
+	for index, query := range queries {
+		serials, _, err := deviceRegistry.Resolve(query)
+		if err != nil {
+			return nil, err
+		}
+
+		if index == 0 {
+			matched = append(matched, serials...)
+			continue
+		}
+
+		matched = slices.DeleteFunc(matched, func(serial string) bool { return !slices.Contains(serials, serial) })
+	}
+
+	return matched, nil
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"adb-server/authentication"
+	"adb-server/events"
+	"adb-server/jobs"
+	"adb-server/middleware"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Proxies drop connections that stay quiet too long, a comment line every so often keeps them open
+const eventHeartbeat = 15 * time.Second
+
+// Streams server events as text/event-stream. ?types=job.finished,device.connected narrows it
+// down, and a reconnecting client gets what it missed by sending Last-Event-ID. Operations are
+// audit entries and only go to sessions that may read the audit log, finished jobs only to
+// whoever may see the job.
+func HandleEvents(res http.ResponseWriter, req *http.Request) {
+	bus, ok := middleware.GetEvents(req)
+	if !ok {
+		http.Error(res, "events not available", http.StatusInternalServerError)
+		return
+	}
+
+	var types []string
+	for _, eventType := range strings.Split(req.URL.Query().Get("types"), ",") {
+		if eventType = strings.TrimSpace(eventType); eventType != "" {
+			types = append(types, eventType)
+		}
+	}
+
+	// Browsers' EventSource sends the header, ?last-event-id is for clients that can't set one
+	lastIDStr := req.Header.Get("Last-Event-ID")
+	if lastIDStr == "" {
+		lastIDStr = req.URL.Query().Get("last-event-id")
+	}
+
+	var lastID uint64
+	if lastIDStr != "" {
+		var err error
+		if lastID, err = strconv.ParseUint(lastIDStr, 10, 64); err != nil {
+			http.Error(res, "Last-Event-ID must be an event id", http.StatusBadRequest)
+			return
+		}
+	}
+
+	subscription := bus.Subscribe(types, lastID)
+	defer subscription.Close()
+
+	controller := http.NewResponseController(res)
+
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+
+	// Lets the client know it is subscribed before the first event comes along
+	fmt.Fprint(res, ": subscribed\n\n")
+	_ = controller.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(res, ": heartbeat\n\n")
+		case event, open := <-subscription.C:
+			if !open {
+				return
+			}
+
+			if !mayReceiveEvent(req, event) {
+				continue
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+
+			fmt.Fprintf(res, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+		}
+
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func mayReceiveEvent(req *http.Request, event events.Event) bool {
+	switch event.Type {
+	case events.TypeOperation:
+		session, ok := middleware.GetSession(req)
+		return ok && session.HasScope(authentication.ScopeAuditRead)
+
+	case events.TypeJobFinished:
+		job, ok := event.Data.(jobs.Job)
+		return ok && mayAccessJob(req, job)
+	}
+
+	return true
+}
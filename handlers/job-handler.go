@@ -0,0 +1,346 @@
+package handlers
+
+import (
+	"adb-server/audit"
+	"adb-server/authentication"
+	"adb-server/internal/adb"
+	"adb-server/jobs"
+	"adb-server/logging"
+	"adb-server/middleware"
+	"adb-server/tracing"
+	"adb-server/utilities"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+func HandleListJobs(res http.ResponseWriter, req *http.Request) {
+	jobManager, ok := middleware.GetJobManager(req)
+	if !ok {
+		http.Error(res, "job manager not available", http.StatusInternalServerError)
+		return
+	}
+
+	visible := []jobs.Job{}
+	for _, job := range jobManager.List() {
+		if mayAccessJob(req, job) {
+			visible = append(visible, job)
+		}
+	}
+
+	utilities.WriteJSON(res, http.StatusOK, visible)
+}
+
+// The job with its status, progress and logs
+func HandleJob(res http.ResponseWriter, req *http.Request) {
+	jobManager, ok := middleware.GetJobManager(req)
+	if !ok {
+		http.Error(res, "job manager not available", http.StatusInternalServerError)
+		return
+	}
+
+	job, err := getJob(req, jobManager, req.PathValue("id"))
+	writeJobResult(res, job, err)
+}
+
+// Cancelling a finished job is a conflict, the job is still returned as it ended
+func HandleCancelJob(res http.ResponseWriter, req *http.Request) {
+	jobManager, ok := middleware.GetJobManager(req)
+	if !ok {
+		http.Error(res, "job manager not available", http.StatusInternalServerError)
+		return
+	}
+
+	job, err := getJob(req, jobManager, req.PathValue("id"))
+	if err == nil {
+		job, err = jobManager.Cancel(job.ID)
+	}
+
+	writeJobResult(res, job, err)
+}
+
+// getJob is the job when the session may see it, other sessions' jobs are not found like
+// ones that never existed
+func getJob(req *http.Request, jobManager *jobs.Manager, id string) (jobs.Job, error) {
+	job, err := jobManager.Get(id)
+	if err != nil {
+		return job, err
+	}
+
+	if !mayAccessJob(req, job) {
+		return jobs.Job{}, jobs.ErrNotFound
+	}
+
+	return job, nil
+}
+
+// Jobs belong to the session that started them, pulled files and bugreports included, only
+// admins see everyone's
+func mayAccessJob(req *http.Request, job jobs.Job) bool {
+	session, ok := middleware.GetSession(req)
+	if !ok {
+		return false
+	}
+
+	return session.HasScope(authentication.ScopeAdmin) || job.SessionID == session.ID
+}
+
+func writeJobResult(res http.ResponseWriter, job jobs.Job, err error) {
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		http.Error(res, "job not found", http.StatusNotFound)
+	case errors.Is(err, jobs.ErrFinished):
+		http.Error(res, fmt.Sprintf("job already %s", job.Status), http.StatusConflict)
+	case err != nil:
+		http.Error(res, "error reading job", http.StatusInternalServerError)
+	default:
+		utilities.WriteJSON(res, http.StatusOK, job)
+	}
+}
+
+// Serves the file a pull or bugreport job produced
+func HandleJobArtifact(res http.ResponseWriter, req *http.Request) {
+	jobManager, ok := middleware.GetJobManager(req)
+	if !ok {
+		http.Error(res, "job manager not available", http.StatusInternalServerError)
+		return
+	}
+
+	job, err := getJob(req, jobManager, req.PathValue("id"))
+	if err != nil {
+		http.Error(res, "job not found", http.StatusNotFound)
+		return
+	}
+
+	if job.Status != jobs.StatusSucceeded || job.Artifact == "" {
+		http.Error(res, "job has no artifact", http.StatusNotFound)
+		return
+	}
+
+	res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(job.Artifact)))
+	http.ServeFile(res, req, job.Artifact)
+}
+
+func HandleInstallJob(res http.ResponseWriter, req *http.Request) {
+	deviceID, ok := deviceIDParam(res, req)
+	if !ok {
+		return
+	}
+
+	filePath := req.URL.Query().Get("path")
+	if filePath != "" {
+		submitJob(res, req, "install", deviceID, func(ctx context.Context, adbClient adb.Client, reporter *jobs.Reporter) (any, error) {
+			reporter.Logf("Installing %s on %s", filePath, deviceID)
+
+			return nil, adbClient.Install(ctx, deviceID, filePath)
+		}, audit.Entry{Arguments: map[string]string{"path": filePath}, APKSHA256: hashOrEmpty(filePath)})
+
+		return
+	}
+
+	// The upload is received before answering, the job only owns it once it was submitted
+	apk, ok := receiveUpload(res, req)
+	if !ok {
+		return
+	}
+
+	submitted := submitJob(res, req, "install", deviceID, func(ctx context.Context, adbClient adb.Client, reporter *jobs.Reporter) (any, error) {
+		defer apk.remove()
+
+		reporter.Logf("Installing uploaded %s (%d bytes) on %s", apk.name, apk.size, deviceID)
+
+		return nil, adbClient.Install(ctx, deviceID, apk.path)
+	}, audit.Entry{Arguments: apk.auditArguments(), APKSHA256: apk.sha256})
+
+	if !submitted {
+		apk.remove()
+	}
+}
+
+func HandlePushJob(res http.ResponseWriter, req *http.Request) {
+	deviceID, ok := deviceIDParam(res, req)
+	if !ok {
+		return
+	}
+
+	localPath, ok := requiredQuery(res, req, "path")
+	if !ok {
+		return
+	}
+
+	remotePath, ok := requiredQuery(res, req, "remote-path")
+	if !ok {
+		return
+	}
+
+	submitJob(res, req, "push", deviceID, func(ctx context.Context, adbClient adb.Client, reporter *jobs.Reporter) (any, error) {
+		reporter.Logf("Pushing %s to %s:%s", localPath, deviceID, remotePath)
+
+		return nil, adbClient.Push(ctx, deviceID, localPath, remotePath)
+	}, audit.Entry{Arguments: map[string]string{"path": localPath, "remote-path": remotePath}})
+}
+
+func HandlePullJob(res http.ResponseWriter, req *http.Request) {
+	deviceID, ok := deviceIDParam(res, req)
+	if !ok {
+		return
+	}
+
+	remotePath, ok := requiredQuery(res, req, "remote-path")
+	if !ok {
+		return
+	}
+
+	// The artifact is named after the file pulled, so it has to be one
+	name := path.Base(remotePath)
+	if strings.HasSuffix(remotePath, "/") || name == "." || name == ".." || name == "/" {
+		http.Error(res, "invalid remote-path parameter, must be a file", http.StatusBadRequest)
+		return
+	}
+
+	submitJob(res, req, "pull", deviceID, func(ctx context.Context, adbClient adb.Client, reporter *jobs.Reporter) (any, error) {
+		localPath, err := reporter.ArtifactPath(name)
+		if err != nil {
+			return nil, err
+		}
+
+		reporter.Logf("Pulling %s:%s", deviceID, remotePath)
+
+		if err := adbClient.Pull(ctx, deviceID, remotePath, localPath); err != nil {
+			return nil, err
+		}
+
+		return artifactResult(reporter), nil
+	}, audit.Entry{})
+}
+
+func HandleBugreportJob(res http.ResponseWriter, req *http.Request) {
+	deviceID, ok := deviceIDParam(res, req)
+	if !ok {
+		return
+	}
+
+	submitJob(res, req, "bugreport", deviceID, func(ctx context.Context, adbClient adb.Client, reporter *jobs.Reporter) (any, error) {
+		localPath, err := reporter.ArtifactPath(fmt.Sprintf("bugreport-%s-%s.zip", deviceID, time.Now().UTC().Format("20060102-150405")))
+		if err != nil {
+			return nil, err
+		}
+
+		reporter.Logf("Capturing bugreport from %s", deviceID)
+
+		if err := adbClient.Bugreport(ctx, deviceID, localPath); err != nil {
+			return nil, err
+		}
+
+		return artifactResult(reporter), nil
+	}, audit.Entry{})
+}
+
+// jobWork is a job body that gets the adb client handed to it
+type jobWork func(ctx context.Context, adbClient adb.Client, reporter *jobs.Reporter) (any, error)
+
+// submitJob starts work as a job and answers 202 with the job right away. The work runs on
+// the job manager's context, not the request's, so the caller can go away. adb output is
+// streamed into the job log and progress, and the outcome is audited like a synchronous call.
+// Returns whether the job was started, the response is written either way.
+func submitJob(res http.ResponseWriter, req *http.Request, jobType string, deviceID string, work jobWork, auditEntry audit.Entry) bool {
+	adbClient, ok := middleware.GetADBClient(req)
+	if !ok {
+		http.Error(res, "ADB client not available", http.StatusInternalServerError)
+		return false
+	}
+
+	jobManager, ok := middleware.GetJobManager(req)
+	if !ok {
+		http.Error(res, "job manager not available", http.StatusInternalServerError)
+		return false
+	}
+
+	auditLog, _ := middleware.GetAuditLog(req)
+
+	var sessionID string
+	if session, ok := middleware.GetSession(req); ok {
+		sessionID = session.ID
+	}
+
+	auditEntry.Operation = jobType
+	auditEntry.DeviceID = deviceID
+	auditEntry.SessionID = sessionID
+
+	// The job's log lines and adb invocations stay correlated with the request that started it
+	requestID := logging.RequestID(req.Context())
+	requestSpan := tracing.SpanContextFrom(req.Context())
+	auditEntry.RequestID = requestID
+
+	job, err := jobManager.Submit(jobs.Spec{
+		Type:      jobType,
+		DeviceID:  deviceID,
+		SessionID: sessionID,
+		Work: func(ctx context.Context, reporter *jobs.Reporter) (any, error) {
+			// Jobs are background work, anything interactive waiting on the same device goes first
+			ctx = logging.WithRequestID(ctx, requestID)
+
+			// Its own span in the request's trace, which will have ended long before the job does
+			ctx, span := tracing.Start(tracing.WithRemoteParent(ctx, requestSpan), "job "+jobType, tracing.KindInternal,
+				tracing.String("job.id", reporter.JobID()),
+				tracing.String("device.serial", deviceID),
+			)
+			defer span.End()
+
+			ctx = adb.WithPriority(ctx, adb.PriorityBatch)
+			ctx = adb.WithCaller(ctx, sessionID)
+			ctx = adb.WithProgress(ctx, func(percent int, line string) {
+				if percent >= 0 {
+					reporter.Progress(percent)
+				}
+
+				reporter.Logf("%s", line)
+			})
+
+			started := time.Now()
+
+			result, err := work(ctx, adbClient, reporter)
+			writeAudit(auditLog, auditEntry, started, err)
+			span.RecordError(err)
+
+			return result, err
+		},
+	})
+	if err != nil {
+		if errors.Is(err, jobs.ErrQueueFull) {
+			res.Header().Set("Retry-After", "5")
+		}
+
+		http.Error(res, fmt.Sprintf("error starting job: %v", err), http.StatusServiceUnavailable)
+		return false
+	}
+
+	res.Header().Set("Location", "/v1/jobs/"+job.ID)
+	utilities.WriteJSON(res, http.StatusAccepted, job)
+
+	return true
+}
+
+func artifactResult(reporter *jobs.Reporter) map[string]string {
+	return map[string]string{"artifact": "/v1/jobs/" + reporter.JobID() + "/artifact"}
+}
+
+func hashOrEmpty(filePath string) string {
+	apkHash, _ := audit.HashFile(filePath)
+	return apkHash
+}
+
+func requiredQuery(res http.ResponseWriter, req *http.Request, name string) (string, bool) {
+	value := req.URL.Query().Get(name)
+
+	if value == "" {
+		http.Error(res, name+" parameter is required", http.StatusBadRequest)
+		return "", false
+	}
+
+	return value, true
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"adb-server/metrics"
+	"crypto/subtle"
+	"log"
+	"net/http"
+)
+
+// HandleMetrics serves the Prometheus text format. Scrapers can't pair, so instead of a session
+// the endpoint takes an optional bearer token; without one it's open to anyone who passes the Host check.
+func HandleMetrics(registry *metrics.Registry, token string) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if token != "" && subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			res.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(res, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		res.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		if err := registry.WriteText(res); err != nil {
+			log.Printf("Error writing metrics: %v", err)
+		}
+	}
+}
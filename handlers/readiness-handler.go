@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"adb-server/internal/adb"
+	"adb-server/middleware"
+	"adb-server/models"
+	"adb-server/utilities"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// adb's default when no server address is configured
+const defaultADBServerAddress = "127.0.0.1:5037"
+
+const readinessTimeout = 5 * time.Second
+
+// Jobs above this share of jobs.max-active make the server degraded
+const jobSaturationWarning = 0.8
+
+// ReadinessOptions is what the readiness checks need to know that isn't in the request context
+type ReadinessOptions struct {
+	ADBPath       string
+	ServerAddress string // empty means adb's default
+	TempDir       string
+	Replaying     bool // adb answers come from a cassette, there's no binary or server to check
+}
+
+// HandleReadiness actually checks the things the server depends on: the adb binary, the adb
+// server, the devices, the temp dir and the job queue. 503 when unhealthy, degraded still
+// answers 200. It sits behind pairing like every other route, so only paired clients can poll it.
+func HandleReadiness(options ReadinessOptions) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		adbClient, ok := middleware.GetADBClient(req)
+		if !ok {
+			http.Error(res, "ADB client not available", http.StatusInternalServerError)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(req.Context(), readinessTimeout)
+		defer cancel()
+
+		// adb version and adb devices start the adb server when it isn't running, so it's
+		// dialed before they run or a server that was down would look fine
+		var adbServer models.ReadinessCheck
+		if !options.Replaying {
+			adbServer = checkADBServer(ctx, options.ServerAddress)
+		}
+
+		checks := map[string]func() models.ReadinessCheck{
+			"adb_binary": func() models.ReadinessCheck { return checkADBBinary(ctx, adbClient, options.ADBPath) },
+			"adb_server": func() models.ReadinessCheck { return adbServer },
+			"devices":    func() models.ReadinessCheck { return checkDevices(ctx, adbClient) },
+			"temp_dir":   func() models.ReadinessCheck { return checkTempDir(options.TempDir) },
+			"jobs":       func() models.ReadinessCheck { return checkJobs(req) },
+		}
+
+		if options.Replaying {
+			replaying := func() models.ReadinessCheck {
+				return models.ReadinessCheck{Status: models.ReadinessOK, Message: "replaying a cassette, adb isn't used"}
+			}
+
+			checks["adb_binary"] = replaying
+			checks["adb_server"] = replaying
+		}
+
+		response := models.ReadinessResponse{
+			Time:   time.Now().Format(time.RFC3339),
+			Status: models.ReadinessOK,
+			Checks: map[string]models.ReadinessCheck{},
+		}
+
+		var mu sync.Mutex
+		var checking sync.WaitGroup
+
+		for name, check := range checks {
+			checking.Add(1)
+
+			go func() {
+				defer checking.Done()
+
+				result := check()
+
+				mu.Lock()
+				defer mu.Unlock()
+
+				response.Checks[name] = result
+				response.Status = worseReadiness(response.Status, result.Status)
+			}()
+		}
+
+		checking.Wait()
+
+		status := http.StatusOK
+		if response.Status == models.ReadinessUnhealthy {
+			status = http.StatusServiceUnavailable
+		}
+
+		utilities.WriteJSON(res, status, response)
+	}
+}
+
+func checkADBBinary(ctx context.Context, adbClient adb.Client, adbPath string) models.ReadinessCheck {
+	resolved, err := exec.LookPath(adbPath)
+	if err != nil {
+		return models.ReadinessCheck{
+			Status:  models.ReadinessUnhealthy,
+			Message: fmt.Sprintf("adb not found at %q, install Android SDK Platform-Tools or set adb.path", adbPath),
+		}
+	}
+
+	details := map[string]any{"path": resolved}
+
+	output, err := adbClient.Version(ctx)
+	if err != nil {
+		return models.ReadinessCheck{Status: models.ReadinessUnhealthy, Message: fmt.Sprintf("adb version failed: %v", err), Details: details}
+	}
+
+	// Android Debug Bridge version 1.0.41
+	// Version 35.0.1-11580240
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+
+		if version, ok := strings.CutPrefix(line, "Android Debug Bridge version "); ok {
+			details["version"] = version
+		} else if platformTools, ok := strings.CutPrefix(line, "Version "); ok {
+			details["platform_tools"] = platformTools
+		}
+	}
+
+	return models.ReadinessCheck{Status: models.ReadinessOK, Details: details}
+}
+
+// Only dials, adb devices would start a local server if none was running and hide the problem
+func checkADBServer(ctx context.Context, serverAddress string) models.ReadinessCheck {
+	if serverAddress == "" {
+		serverAddress = defaultADBServerAddress
+	}
+
+	details := map[string]any{"address": serverAddress}
+
+	started := time.Now()
+
+	var dialer net.Dialer
+
+	connection, err := dialer.DialContext(ctx, "tcp", serverAddress)
+	if err != nil {
+		return models.ReadinessCheck{
+			Status:  models.ReadinessUnhealthy,
+			Message: fmt.Sprintf("adb server is not reachable: %v", err),
+			Details: details,
+		}
+	}
+
+	connection.Close()
+
+	details["latency_ms"] = time.Since(started).Milliseconds()
+
+	return models.ReadinessCheck{Status: models.ReadinessOK, Details: details}
+}
+
+func checkDevices(ctx context.Context, adbClient adb.Client) models.ReadinessCheck {
+	devices, err := adbClient.Devices(ctx)
+	if err != nil {
+		return models.ReadinessCheck{Status: models.ReadinessUnhealthy, Message: fmt.Sprintf("listing devices failed: %v", err)}
+	}
+
+	byState := map[string]int{}
+	for _, device := range devices {
+		byState[device.State]++
+	}
+
+	check := models.ReadinessCheck{
+		Status:  models.ReadinessOK,
+		Details: map[string]any{"total": len(devices), "by_state": byState},
+	}
+
+	// No devices is a perfectly healthy server, devices stuck on the authorization prompt need a human
+	switch {
+	case byState["unauthorized"] > 0:
+		check.Status = models.ReadinessDegraded
+		check.Message = fmt.Sprintf("%d device(s) waiting for USB debugging to be allowed on the device", byState["unauthorized"])
+	case byState["offline"] > 0:
+		check.Status = models.ReadinessDegraded
+		check.Message = fmt.Sprintf("%d device(s) offline", byState["offline"])
+	case len(devices) == 0:
+		check.Message = "no devices connected"
+	}
+
+	return check
+}
+
+func checkTempDir(tempDir string) models.ReadinessCheck {
+	details := map[string]any{"path": tempDir}
+
+	probe, err := os.CreateTemp(tempDir, ".ready-*")
+	if err == nil {
+		name := probe.Name()
+
+		_, err = probe.Write([]byte("ok"))
+		err = errors.Join(err, probe.Close(), os.Remove(name))
+	}
+
+	if err != nil {
+		return models.ReadinessCheck{Status: models.ReadinessUnhealthy, Message: fmt.Sprintf("temp dir is not writable: %v", err), Details: details}
+	}
+
+	return models.ReadinessCheck{Status: models.ReadinessOK, Details: details}
+}
+
+func checkJobs(req *http.Request) models.ReadinessCheck {
+	jobManager, ok := middleware.GetJobManager(req)
+	if !ok {
+		return models.ReadinessCheck{Status: models.ReadinessUnhealthy, Message: "job manager not available"}
+	}
+
+	active, maxActive := jobManager.Active(), jobManager.MaxActive()
+
+	check := models.ReadinessCheck{
+		Status:  models.ReadinessOK,
+		Details: map[string]any{"active": active, "max_active": maxActive},
+	}
+
+	if maxActive <= 0 {
+		return check
+	}
+
+	saturation := float64(active) / float64(maxActive)
+	check.Details["saturation"] = saturation
+
+	switch {
+	case active >= maxActive:
+		check.Status = models.ReadinessDegraded
+		check.Message = "job queue is full, new jobs are rejected"
+	case saturation >= jobSaturationWarning:
+		check.Status = models.ReadinessDegraded
+		check.Message = "job queue is almost full"
+	}
+
+	return check
+}
+
+func worseReadiness(current string, other string) string {
+	rank := map[string]int{models.ReadinessOK: 0, models.ReadinessDegraded: 1, models.ReadinessUnhealthy: 2}
+
+	if rank[other] > rank[current] {
+		return other
+	}
+
+	return current
+}
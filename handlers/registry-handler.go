@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"adb-server/middleware"
+	"adb-server/models"
+	"adb-server/registry"
+	"adb-server/utilities"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// GET /v1/registry/devices, everything the registry knows, connected or not
+func HandleRegistryDevices(res http.ResponseWriter, req *http.Request) {
+	deviceRegistry, ok := middleware.GetRegistry(req)
+	if !ok {
+		http.Error(res, "device registry not available", http.StatusInternalServerError)
+		return
+	}
+
+	utilities.WriteJSON(res, http.StatusOK, deviceRegistry.Devices())
+}
+
+// GET /v1/registry/devices/{device_id}
+func HandleRegistryDevice(res http.ResponseWriter, req *http.Request) {
+	deviceRegistry, ok := middleware.GetRegistry(req)
+	if !ok {
+		http.Error(res, "device registry not available", http.StatusInternalServerError)
+		return
+	}
+
+	device, found := deviceRegistry.Device(req.PathValue("device_id"))
+	if !found {
+		http.Error(res, "device not in registry", http.StatusNotFound)
+		return
+	}
+
+	utilities.WriteJSON(res, http.StatusOK, device)
+}
+
+// PUT /v1/registry/devices/{device_id}
+func HandlePutRegistryDevice(res http.ResponseWriter, req *http.Request) {
+	deviceRegistry, ok := middleware.GetRegistry(req)
+	if !ok {
+		http.Error(res, "device registry not available", http.StatusInternalServerError)
+		return
+	}
+
+	var metadata models.DeviceMetadataRequest
+	if err := json.NewDecoder(req.Body).Decode(&metadata); err != nil {
+		http.Error(res, fmt.Sprintf("invalid device metadata: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// A name that looks like a selector could never be selected by
+	if strings.Contains(metadata.Name, ":") {
+		http.Error(res, "name can't contain ':'", http.StatusBadRequest)
+		return
+	}
+
+	device, err := deviceRegistry.PutDevice(registry.DeviceInfo{
+		Serial: req.PathValue("device_id"),
+		Name:   strings.TrimSpace(metadata.Name),
+		Tags:   metadata.Tags,
+		Owner:  metadata.Owner,
+		Notes:  metadata.Notes,
+	})
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	utilities.WriteJSON(res, http.StatusOK, device)
+}
+
+// DELETE /v1/registry/devices/{device_id}
+func HandleDeleteRegistryDevice(res http.ResponseWriter, req *http.Request) {
+	deviceRegistry, ok := middleware.GetRegistry(req)
+	if !ok {
+		http.Error(res, "device registry not available", http.StatusInternalServerError)
+		return
+	}
+
+	if err := deviceRegistry.DeleteDevice(req.PathValue("device_id")); err != nil {
+		writeRegistryError(res, err)
+		return
+	}
+
+	utilities.WriteJSON(res, http.StatusOK, map[string]string{"message": "Device removed from registry"})
+}
+
+// GET /v1/registry/groups
+func HandleRegistryGroups(res http.ResponseWriter, req *http.Request) {
+	deviceRegistry, ok := middleware.GetRegistry(req)
+	if !ok {
+		http.Error(res, "device registry not available", http.StatusInternalServerError)
+		return
+	}
+
+	utilities.WriteJSON(res, http.StatusOK, deviceRegistry.Groups())
+}
+
+// PUT /v1/registry/groups/{name}
+func HandlePutRegistryGroup(res http.ResponseWriter, req *http.Request) {
+	deviceRegistry, ok := middleware.GetRegistry(req)
+	if !ok {
+		http.Error(res, "device registry not available", http.StatusInternalServerError)
+		return
+	}
+
+	var groupRequest models.GroupRequest
+	if err := json.NewDecoder(req.Body).Decode(&groupRequest); err != nil {
+		http.Error(res, fmt.Sprintf("invalid group: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	group, err := deviceRegistry.PutGroup(registry.Group{
+		Name:        req.PathValue("name"),
+		Description: groupRequest.Description,
+		Serials:     groupRequest.Devices,
+		Tags:        groupRequest.Tags,
+	})
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	utilities.WriteJSON(res, http.StatusOK, group)
+}
+
+// DELETE /v1/registry/groups/{name}
+func HandleDeleteRegistryGroup(res http.ResponseWriter, req *http.Request) {
+	deviceRegistry, ok := middleware.GetRegistry(req)
+	if !ok {
+		http.Error(res, "device registry not available", http.StatusInternalServerError)
+		return
+	}
+
+	if err := deviceRegistry.DeleteGroup(req.PathValue("name")); err != nil {
+		writeRegistryError(res, err)
+		return
+	}
+
+	utilities.WriteJSON(res, http.StatusOK, map[string]string{"message": "Group deleted"})
+}
+
+// deviceIDParam reads the device-id query parameter, which may also be a registry selector
+// (name:pixel-7-a, tag:qa, group:lab-1) as long as it picks out exactly one device
+func deviceIDParam(res http.ResponseWriter, req *http.Request) (string, bool) {
+	deviceID, ok := requiredQuery(res, req, "device-id")
+	if !ok {
+		return "", false
+	}
+
+	return resolveDevice(res, req, deviceID)
+}
+
+// deviceSerialParam is deviceIDParam for the {serial} in /v2/devices/{serial}/...
+func deviceSerialParam(res http.ResponseWriter, req *http.Request) (string, bool) {
+	serial := req.PathValue("serial")
+	if serial == "" {
+		http.Error(res, "device serial is required", http.StatusBadRequest)
+		return "", false
+	}
+
+	return resolveDevice(res, req, serial)
+}
+
+func resolveDevice(res http.ResponseWriter, req *http.Request, deviceID string) (string, bool) {
+	deviceRegistry, hasRegistry := middleware.GetRegistry(req)
+	if !hasRegistry {
+		return deviceID, true
+	}
+
+	serials, isSelector, err := deviceRegistry.Resolve(deviceID)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+
+	if !isSelector {
+		return deviceID, true
+	}
+
+	switch len(serials) {
+	case 1:
+		return serials[0], true
+	case 0:
+		http.Error(res, fmt.Sprintf("no device matches %s", deviceID), http.StatusBadRequest)
+	default:
+		http.Error(res, fmt.Sprintf("%s matches %d devices (%s), use a batch endpoint or a more specific selector", deviceID, len(serials), strings.Join(serials, ", ")), http.StatusBadRequest)
+	}
+
+	return "", false
+}
+
+func writeRegistryError(res http.ResponseWriter, err error) {
+	if errors.Is(err, registry.ErrNotFound) {
+		http.Error(res, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	http.Error(res, err.Error(), http.StatusInternalServerError)
+}
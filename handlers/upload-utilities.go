@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// Where streamed APK uploads are spooled before adb installs them, and how big they may get
+var uploadDir string
+var maxUploadBytes int64 = 1 << 30
+
+func SetUploadLimits(dir string, maxBytes int64) {
+	uploadDir = dir
+	maxUploadBytes = maxBytes
+}
+
+// upload is an APK the client sent in the request body instead of naming a file on the server
+type upload struct {
+	path   string
+	name   string
+	size   int64
+	sha256 string
+}
+
+func (apk upload) remove() {
+	os.Remove(apk.path)
+}
+
+func (apk upload) auditArguments() map[string]string {
+	return map[string]string{"upload": apk.name, "bytes": strconv.FormatInt(apk.size, 10)}
+}
+
+// receiveUpload spools the request body to a temp file, hashing it on the way, and writes the
+// error response itself when that fails. The caller removes the file when adb is done with it.
+func receiveUpload(res http.ResponseWriter, req *http.Request) (upload, bool) {
+	if req.ContentLength == 0 || req.Body == nil || req.Body == http.NoBody {
+		http.Error(res, "path parameter or an APK request body is required", http.StatusBadRequest)
+		return upload{}, false
+	}
+
+	// adb install refuses files that don't end in .apk
+	file, err := os.CreateTemp(uploadDir, "upload-*.apk")
+	if err != nil {
+		http.Error(res, "problem storing upload", http.StatusInternalServerError)
+		return upload{}, false
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	body := http.MaxBytesReader(res, req.Body, maxUploadBytes)
+
+	size, err := io.Copy(file, io.TeeReader(body, hash))
+	if err == nil {
+		err = file.Close()
+	}
+
+	if err != nil {
+		os.Remove(file.Name())
+
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(res, fmt.Sprintf("upload is larger than %d bytes", maxUploadBytes), http.StatusRequestEntityTooLarge)
+			return upload{}, false
+		}
+
+		http.Error(res, fmt.Sprintf("error receiving upload: %v", err), http.StatusBadRequest)
+		return upload{}, false
+	}
+
+	if size == 0 {
+		os.Remove(file.Name())
+		http.Error(res, "path parameter or an APK request body is required", http.StatusBadRequest)
+		return upload{}, false
+	}
+
+	name := req.URL.Query().Get("name")
+	if name == "" {
+		name = "upload.apk"
+	}
+
+	return upload{path: file.Name(), name: name, size: size, sha256: hex.EncodeToString(hash.Sum(nil))}, true
+}
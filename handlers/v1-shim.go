@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"net/http"
+)
+
+// V1 serves a v1 route with a v2 handler. v1 took in the query what v2 takes in the path,
+// pathValues says which query parameter each path value came in as, e.g. serial from device-id.
+func V1(handler http.HandlerFunc, pathValues map[string]string) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+
+		for name, param := range pathValues {
+			req.SetPathValue(name, query.Get(param))
+		}
+
+		handler(res, req)
+	}
+}
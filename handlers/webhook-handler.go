@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"adb-server/middleware"
+	"adb-server/models"
+	"adb-server/utilities"
+	"adb-server/webhooks"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// GET /v1/webhooks, without their secrets
+func HandleWebhooks(res http.ResponseWriter, req *http.Request) {
+	manager, ok := middleware.GetWebhooks(req)
+	if !ok {
+		http.Error(res, "webhooks not available", http.StatusInternalServerError)
+		return
+	}
+
+	utilities.WriteJSON(res, http.StatusOK, manager.Webhooks())
+}
+
+// POST /v1/webhooks, the answer is the only place the secret shows up
+func HandleCreateWebhook(res http.ResponseWriter, req *http.Request) {
+	manager, ok := middleware.GetWebhooks(req)
+	if !ok {
+		http.Error(res, "webhooks not available", http.StatusInternalServerError)
+		return
+	}
+
+	webhook, ok := decodeWebhook(res, req)
+	if !ok {
+		return
+	}
+
+	created, err := manager.Create(webhook)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	utilities.WriteJSON(res, http.StatusCreated, created)
+}
+
+// GET /v1/webhooks/{id}
+func HandleWebhook(res http.ResponseWriter, req *http.Request) {
+	manager, ok := middleware.GetWebhooks(req)
+	if !ok {
+		http.Error(res, "webhooks not available", http.StatusInternalServerError)
+		return
+	}
+
+	webhook, found := manager.Webhook(req.PathValue("id"))
+	if !found {
+		http.Error(res, webhooks.ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	utilities.WriteJSON(res, http.StatusOK, webhook)
+}
+
+// PUT /v1/webhooks/{id}
+func HandleUpdateWebhook(res http.ResponseWriter, req *http.Request) {
+	manager, ok := middleware.GetWebhooks(req)
+	if !ok {
+		http.Error(res, "webhooks not available", http.StatusInternalServerError)
+		return
+	}
+
+	webhook, ok := decodeWebhook(res, req)
+	if !ok {
+		return
+	}
+
+	updated, err := manager.Update(req.PathValue("id"), webhook)
+	if err != nil {
+		writeWebhookError(res, err, http.StatusBadRequest)
+		return
+	}
+
+	utilities.WriteJSON(res, http.StatusOK, updated)
+}
+
+// DELETE /v1/webhooks/{id}, deliveries still waiting for it are dropped
+func HandleDeleteWebhook(res http.ResponseWriter, req *http.Request) {
+	manager, ok := middleware.GetWebhooks(req)
+	if !ok {
+		http.Error(res, "webhooks not available", http.StatusInternalServerError)
+		return
+	}
+
+	if err := manager.Delete(req.PathValue("id")); err != nil {
+		writeWebhookError(res, err, http.StatusInternalServerError)
+		return
+	}
+
+	utilities.WriteJSON(res, http.StatusOK, map[string]string{"message": "Webhook deleted"})
+}
+
+// GET /v1/webhooks/{id}/deliveries
+func HandleWebhookDeliveries(res http.ResponseWriter, req *http.Request) {
+	manager, ok := middleware.GetWebhooks(req)
+	if !ok {
+		http.Error(res, "webhooks not available", http.StatusInternalServerError)
+		return
+	}
+
+	deliveries, err := manager.Deliveries(req.PathValue("id"))
+	if err != nil {
+		writeWebhookError(res, err, http.StatusInternalServerError)
+		return
+	}
+
+	utilities.WriteJSON(res, http.StatusOK, deliveries)
+}
+
+// POST /v1/webhooks/{id}/test. A receiver that fails still makes a 200, the delivery says how it failed.
+func HandleTestWebhook(res http.ResponseWriter, req *http.Request) {
+	manager, ok := middleware.GetWebhooks(req)
+	if !ok {
+		http.Error(res, "webhooks not available", http.StatusInternalServerError)
+		return
+	}
+
+	delivery, err := manager.Test(req.Context(), req.PathValue("id"))
+	if err != nil {
+		writeWebhookError(res, err, http.StatusInternalServerError)
+		return
+	}
+
+	utilities.WriteJSON(res, http.StatusOK, delivery)
+}
+
+func decodeWebhook(res http.ResponseWriter, req *http.Request) (webhooks.Webhook, bool) {
+	var webhookRequest models.WebhookRequest
+	if err := json.NewDecoder(req.Body).Decode(&webhookRequest); err != nil {
+		http.Error(res, fmt.Sprintf("invalid webhook: %v", err), http.StatusBadRequest)
+		return webhooks.Webhook{}, false
+	}
+
+	return webhooks.Webhook{
+		URL:         webhookRequest.URL,
+		Events:      webhookRequest.Events,
+		Description: webhookRequest.Description,
+		Secret:      webhookRequest.Secret,
+	}, true
+}
+
+func writeWebhookError(res http.ResponseWriter, err error, status int) {
+	if errors.Is(err, webhooks.ErrNotFound) {
+		http.Error(res, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	http.Error(res, err.Error(), status)
+}
@@ -0,0 +1,113 @@
+package adb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"adb-server/tracing"
+)
+
+var logcatBuffers = []string{"main", "system", "crash", "events", "radio", "all"}
+
+// tag:priority, or a bare priority for every tag
+var filterSpecPattern = regexp.MustCompile(`^([\w.*$-]+:)?[VDIWEFS]$`)
+
+// ValidateLogcatOptions rejects buffers and filters adb wouldn't take, or that would be
+// taken as other logcat flags
+func ValidateLogcatOptions(opts LogcatOptions) error {
+	if opts.Buffer != "" && !slices.Contains(logcatBuffers, opts.Buffer) {
+		return fmt.Errorf("unknown logcat buffer %q, use one of %s", opts.Buffer, strings.Join(logcatBuffers, ", "))
+	}
+
+	if opts.Tail < 0 {
+		return errors.New("tail can't be negative")
+	}
+
+	for _, filter := range opts.Filters {
+		if !filterSpecPattern.MatchString(filter) {
+			return fmt.Errorf("invalid logcat filter %q, use tag:priority like ActivityManager:I", filter)
+		}
+	}
+
+	return nil
+}
+
+// Logcat hands every log line to line until ctx is done, or until the buffer is printed with
+// Dump. It takes no device lock, logcat only reads and following it never ends.
+func (adbServerClient *client) Logcat(ctx context.Context, serial string, opts LogcatOptions, line func(string)) error {
+	if serial == "" {
+		return errors.New("serial is required")
+	}
+
+	if err := ValidateLogcatOptions(opts); err != nil {
+		return err
+	}
+
+	args := []string{"logcat"}
+
+	if opts.Buffer != "" {
+		args = append(args, "-b", opts.Buffer)
+	}
+
+	if opts.Dump {
+		args = append(args, "-d")
+	}
+
+	if opts.Tail > 0 {
+		args = append(args, "-T", strconv.Itoa(opts.Tail))
+	}
+
+	args = append(args, opts.Filters...)
+
+	errOut, err := adbServerClient.stream(ctx, serial, func(_ int, text string) { line(text) }, args...)
+
+	// Following ends by cancelling, that's not a failure
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if err != nil {
+		return fmt.Errorf("adb logcat failed: %v: %s", err, strings.TrimSpace(errOut))
+	}
+
+	return nil
+}
+
+// stream is run for commands that print until they're stopped: stdout goes to lines as it
+// comes instead of being kept. Not recorded to cassettes, there'd be no end to the recording.
+func (adbServerClient *client) stream(ctx context.Context, serial string, lines ProgressFunc, args ...string) (stderr string, err error) {
+	argumentsArray := adbServerClient.arguments(serial, args)
+
+	_, span := tracing.Start(ctx, "adb.stream", tracing.KindClient,
+		tracing.String("device.serial", serial),
+		tracing.Strings("process.command_args", append([]string{adbServerClient.adbPath}, argumentsArray...)),
+	)
+	defer span.End()
+
+	var errorBuf bytes.Buffer
+
+	stdoutWriter := &progressWriter{progress: lines}
+	defer stdoutWriter.flush()
+
+	if adbServerClient.player != nil {
+		_, err = adbServerClient.player.play(ctx, serial, args, stdoutWriter, &errorBuf)
+	} else {
+		adbCommand := exec.CommandContext(ctx, adbServerClient.adbPath, argumentsArray...)
+
+		adbCommand.Stdout = stdoutWriter
+		adbCommand.Stderr = &errorBuf
+
+		err = adbCommand.Run()
+	}
+
+	span.RecordError(err)
+
+	return errorBuf.String(), err
+}
@@ -12,6 +12,14 @@ type Client interface {
 	Packages(ctx context.Context, serial string, opts ListPackageOptions) ([]Package, error)
 	Uninstall(ctx context.Context, serial, pkg string, keepData bool, user int) error
 	Install(ctx context.Context, serial string, apkPath string) error // Updated signature
+	Push(ctx context.Context, serial, localPath, remotePath string) error
+	Pull(ctx context.Context, serial, remotePath, localPath string) error
+	Bugreport(ctx context.Context, serial, localPath string) error
+	Shell(ctx context.Context, serial, command string) (string, error)
+	Screenshot(ctx context.Context, serial string) ([]byte, error) // PNG
+	Reboot(ctx context.Context, serial, mode string) error
+	Logcat(ctx context.Context, serial string, opts LogcatOptions, line func(string)) error
+	Locks() []DeviceLockStatus
 }
 
 type Device struct {
@@ -33,18 +41,42 @@ type ListPackageOptions struct {
 	IncludeUninstalled bool // adds -u
 }
 
+type LogcatOptions struct {
+	Filters []string // filterspecs like ActivityManager:I or *:S
+	Buffer  string   // main, system, crash, events, radio or all, adb's default when empty
+	Dump    bool     // print what's there and stop instead of following
+	Tail    int      // only the last this many lines, 0 for everything
+}
+
 type Config struct {
-	ADBPath        string
-	ReadTimeout    time.Duration // generic list timeout
-	InstallTimeout time.Duration
-	TempDir        string
+	ADBPath          string
+	ServerAddress    string        // host:port of the adb server, the adb default (localhost:5037) when empty
+	ReadTimeout      time.Duration // generic list timeout
+	InstallTimeout   time.Duration
+	UninstallTimeout time.Duration // falls back to ReadTimeout
+	TransferTimeout  time.Duration // push and pull
+	BugreportTimeout time.Duration
+	TempDir          string
+	Observer         Observer // optional, told about every operation and lock wait
+
+	RecordTo       string // cassette file to record every adb invocation to
+	ReplayFrom     string // cassette file to answer from instead of running adb
+	ReplayRealTime bool   // replay at the recorded speed instead of instantly
 }
 
 type client struct {
-	adbPath        string
-	readTimeout    time.Duration
-	installTimeout time.Duration
-	tempDir        string
+	adbPath          string
+	serverHost       string
+	serverPort       string
+	readTimeout      time.Duration
+	installTimeout   time.Duration
+	uninstallTimeout time.Duration
+	transferTimeout  time.Duration
+	bugreportTimeout time.Duration
+	tempDir          string
+	observer         Observer
+	recorder         *cassetteRecorder
+	player           *cassettePlayer
 
-	perSerialMu sync.Map // map[string]*sync.Mutex, serialize installs/uninstalls per device
+	deviceQueues sync.Map // map[string]*deviceQueue, orders operations per device
 }
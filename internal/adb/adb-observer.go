@@ -0,0 +1,176 @@
+package adb
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Operation outcomes as reported to an Observer
+const (
+	OutcomeSuccess   = "success"
+	OutcomeFailure   = "failure"
+	OutcomeCancelled = "cancelled" // the caller went away or the server is shutting down
+)
+
+// OperationEvent describes one finished client call
+type OperationEvent struct {
+	Operation string
+	Serial    string // empty for calls that aren't about one device
+	Model     string // as last seen in a devices listing, empty if we haven't seen the device yet
+	Duration  time.Duration
+	Outcome   string
+	Err       error
+}
+
+// Observer gets told about every operation and every wait for a device lock, e.g. to keep metrics.
+// Both are called synchronously on the operation's goroutine, so they must be quick.
+type Observer interface {
+	OperationFinished(event OperationEvent)
+	LockWaited(operation string, waited time.Duration, acquired bool)
+}
+
+// observedClient reports every call on the wrapped client to an Observer
+type observedClient struct {
+	Client
+	observer Observer
+	models   sync.Map // serial -> model, refreshed by every devices listing
+}
+
+// Unobserved is adbClient without its Observer, for the server's own polling that shouldn't
+// count as adb traffic
+func Unobserved(adbClient Client) Client {
+	if observed, ok := adbClient.(*observedClient); ok {
+		return observed.Client
+	}
+
+	return adbClient
+}
+
+func (observed *observedClient) finish(ctx context.Context, operation string, serial string, started time.Time, err error) {
+	event := OperationEvent{
+		Operation: operation,
+		Serial:    serial,
+		Duration:  time.Since(started),
+		Outcome:   OutcomeSuccess,
+		Err:       err,
+	}
+
+	if model, ok := observed.models.Load(serial); ok {
+		event.Model = model.(string)
+	}
+
+	if err != nil {
+		event.Outcome = OutcomeFailure
+
+		if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+			event.Outcome = OutcomeCancelled
+		}
+	}
+
+	observed.observer.OperationFinished(event)
+}
+
+func (observed *observedClient) Version(ctx context.Context) (string, error) {
+	started := time.Now()
+	version, err := observed.Client.Version(ctx)
+	observed.finish(ctx, "version", "", started, err)
+
+	return version, err
+}
+
+func (observed *observedClient) Devices(ctx context.Context) ([]Device, error) {
+	started := time.Now()
+	devices, err := observed.Client.Devices(ctx)
+	observed.finish(ctx, "devices", "", started, err)
+
+	for _, device := range devices {
+		if device.Model != "" {
+			observed.models.Store(device.Serial, device.Model)
+		}
+	}
+
+	return devices, err
+}
+
+func (observed *observedClient) Packages(ctx context.Context, serial string, opts ListPackageOptions) ([]Package, error) {
+	started := time.Now()
+	packages, err := observed.Client.Packages(ctx, serial, opts)
+	observed.finish(ctx, "list-packages", serial, started, err)
+
+	return packages, err
+}
+
+func (observed *observedClient) Uninstall(ctx context.Context, serial, pkg string, keepData bool, user int) error {
+	started := time.Now()
+	err := observed.Client.Uninstall(ctx, serial, pkg, keepData, user)
+	observed.finish(ctx, "uninstall", serial, started, err)
+
+	return err
+}
+
+func (observed *observedClient) Install(ctx context.Context, serial string, apkPath string) error {
+	started := time.Now()
+	err := observed.Client.Install(ctx, serial, apkPath)
+	observed.finish(ctx, "install", serial, started, err)
+
+	return err
+}
+
+func (observed *observedClient) Push(ctx context.Context, serial, localPath, remotePath string) error {
+	started := time.Now()
+	err := observed.Client.Push(ctx, serial, localPath, remotePath)
+	observed.finish(ctx, "push", serial, started, err)
+
+	return err
+}
+
+func (observed *observedClient) Pull(ctx context.Context, serial, remotePath, localPath string) error {
+	started := time.Now()
+	err := observed.Client.Pull(ctx, serial, remotePath, localPath)
+	observed.finish(ctx, "pull", serial, started, err)
+
+	return err
+}
+
+func (observed *observedClient) Bugreport(ctx context.Context, serial, localPath string) error {
+	started := time.Now()
+	err := observed.Client.Bugreport(ctx, serial, localPath)
+	observed.finish(ctx, "bugreport", serial, started, err)
+
+	return err
+}
+
+func (observed *observedClient) Shell(ctx context.Context, serial, command string) (string, error) {
+	started := time.Now()
+	out, err := observed.Client.Shell(ctx, serial, command)
+	observed.finish(ctx, "shell", serial, started, err)
+
+	return out, err
+}
+
+func (observed *observedClient) Screenshot(ctx context.Context, serial string) ([]byte, error) {
+	started := time.Now()
+	png, err := observed.Client.Screenshot(ctx, serial)
+	observed.finish(ctx, "screenshot", serial, started, err)
+
+	return png, err
+}
+
+func (observed *observedClient) Reboot(ctx context.Context, serial, mode string) error {
+	started := time.Now()
+	err := observed.Client.Reboot(ctx, serial, mode)
+	observed.finish(ctx, "reboot", serial, started, err)
+
+	return err
+}
+
+// A followed logcat ends when the caller stops reading, so it's mostly counted as cancelled
+func (observed *observedClient) Logcat(ctx context.Context, serial string, opts LogcatOptions, line func(string)) error {
+	started := time.Now()
+	err := observed.Client.Logcat(ctx, serial, opts, line)
+	observed.finish(ctx, "logcat", serial, started, err)
+
+	return err
+}
@@ -0,0 +1,89 @@
+package adb
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strconv"
+)
+
+// ProgressFunc receives every line adb prints while a command runs, and the percentage
+// when the line carries one (-1 otherwise)
+type ProgressFunc func(percent int, line string)
+
+type progressKey struct{}
+
+// WithProgress asks long-running operations (install, push, pull, bugreport) to report
+// their output and progress as they go instead of only when they finish
+func WithProgress(ctx context.Context, progress ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, progress)
+}
+
+func progressFrom(ctx context.Context) ProgressFunc {
+	progress, _ := ctx.Value(progressKey{}).(ProgressFunc)
+	return progress
+}
+
+// adb push/pull print "[ 42%] /sdcard/file", bugreport prints "PROGRESS:420/1000"
+var percentPattern = regexp.MustCompile(`\[\s*(\d{1,3})%\]|(\d{1,3})%`)
+var fractionPattern = regexp.MustCompile(`PROGRESS:(\d+)/(\d+)`)
+
+func parsePercent(line string) int {
+	if match := fractionPattern.FindStringSubmatch(line); match != nil {
+		done, _ := strconv.Atoi(match[1])
+		total, _ := strconv.Atoi(match[2])
+
+		if total > 0 {
+			return min(100, done*100/total)
+		}
+	}
+
+	if match := percentPattern.FindStringSubmatch(line); match != nil {
+		digits := match[1]
+		if digits == "" {
+			digits = match[2]
+		}
+
+		percent, _ := strconv.Atoi(digits)
+		return min(100, percent)
+	}
+
+	return -1
+}
+
+// progressWriter splits whatever adb writes into lines. adb redraws progress with \r,
+// so both \r and \n end a line.
+type progressWriter struct {
+	progress ProgressFunc
+	pending  []byte
+}
+
+func (writer *progressWriter) Write(data []byte) (int, error) {
+	writer.pending = append(writer.pending, data...)
+
+	for {
+		index := bytes.IndexAny(writer.pending, "\r\n")
+		if index == -1 {
+			break
+		}
+
+		writer.emit(writer.pending[:index])
+		writer.pending = writer.pending[index+1:]
+	}
+
+	return len(data), nil
+}
+
+func (writer *progressWriter) flush() {
+	writer.emit(writer.pending)
+	writer.pending = nil
+}
+
+func (writer *progressWriter) emit(line []byte) {
+	text := string(bytes.TrimSpace(line))
+	if text == "" {
+		return
+	}
+
+	writer.progress(parsePercent(text), text)
+}
@@ -1,15 +1,19 @@
 package adb
 
 import (
+	"adb-server/tracing"
 	"bufio"
 	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"os"
 	"os/exec"
+	"slices"
 	"strings"
-	"sync"
 	"time"
 )
 
@@ -26,16 +30,73 @@ func New(cfg Config) (Client, error) {
 		cfg.InstallTimeout = 8 * time.Minute
 	}
 
+	if cfg.UninstallTimeout == 0 {
+		cfg.UninstallTimeout = cfg.ReadTimeout
+	}
+
+	if cfg.TransferTimeout == 0 {
+		cfg.TransferTimeout = cfg.InstallTimeout
+	}
+
+	if cfg.BugreportTimeout == 0 {
+		cfg.BugreportTimeout = 10 * time.Minute
+	}
+
 	if cfg.TempDir == "" {
 		cfg.TempDir = os.TempDir()
 	}
 
-	return &client{
-		adbPath:        cfg.ADBPath,
-		readTimeout:    cfg.ReadTimeout,
-		installTimeout: cfg.InstallTimeout,
-		tempDir:        cfg.TempDir,
-	}, nil
+	var serverHost, serverPort string
+
+	if cfg.ServerAddress != "" {
+		var err error
+
+		serverHost, serverPort, err = net.SplitHostPort(cfg.ServerAddress)
+		if err != nil {
+			return nil, fmt.Errorf("invalid adb server address %q: %w", cfg.ServerAddress, err)
+		}
+	}
+
+	adbClient := &client{
+		adbPath:          cfg.ADBPath,
+		serverHost:       serverHost,
+		serverPort:       serverPort,
+		readTimeout:      cfg.ReadTimeout,
+		installTimeout:   cfg.InstallTimeout,
+		uninstallTimeout: cfg.UninstallTimeout,
+		transferTimeout:  cfg.TransferTimeout,
+		bugreportTimeout: cfg.BugreportTimeout,
+		tempDir:          cfg.TempDir,
+		observer:         cfg.Observer,
+	}
+
+	if cfg.RecordTo != "" && cfg.ReplayFrom != "" {
+		return nil, errors.New("can't record and replay at the same time")
+	}
+
+	if cfg.RecordTo != "" {
+		recorder, err := newCassetteRecorder(cfg.RecordTo)
+		if err != nil {
+			return nil, err
+		}
+
+		adbClient.recorder = recorder
+	}
+
+	if cfg.ReplayFrom != "" {
+		player, err := loadCassette(cfg.ReplayFrom, cfg.ReplayRealTime)
+		if err != nil {
+			return nil, err
+		}
+
+		adbClient.player = player
+	}
+
+	if cfg.Observer != nil {
+		return &observedClient{Client: adbClient, observer: cfg.Observer}, nil
+	}
+
+	return adbClient, nil
 }
 
 func (adbServerClient *client) Version(ctx context.Context) (string, error) {
@@ -112,12 +173,15 @@ func (adbServerClient *client) Install(ctx context.Context, serial string, apkPa
 		return errors.New("apk path is required")
 	}
 
-	// Verify the file exists
-	if _, err := os.Stat(apkPath); os.IsNotExist(err) {
+	// Verify the file exists, a replayed install never reads it
+	if _, err := os.Stat(apkPath); os.IsNotExist(err) && adbServerClient.player == nil {
 		return fmt.Errorf("apk file does not exist: %s", apkPath)
 	}
 
-	unlock := adbServerClient.lock(serial)
+	unlock, err := adbServerClient.lock(ctx, serial, "install", LockExclusive)
+	if err != nil {
+		return err
+	}
 	defer unlock()
 
 	installCtx, cancel := context.WithTimeout(ctx, adbServerClient.installTimeout)
@@ -140,7 +204,10 @@ func (adbServerClient *client) Uninstall(ctx context.Context, serial, pkg string
 		return errors.New("serial is required")
 	}
 
-	unlock := adbServerClient.lock(serial)
+	unlock, err := adbServerClient.lock(ctx, serial, "uninstall", LockExclusive)
+	if err != nil {
+		return err
+	}
 
 	defer unlock()
 
@@ -159,7 +226,7 @@ func (adbServerClient *client) Uninstall(ctx context.Context, serial, pkg string
 
 	args = append(args, pkg)
 
-	uninstallCtx, cancel := context.WithTimeout(ctx, adbServerClient.readTimeout)
+	uninstallCtx, cancel := context.WithTimeout(ctx, adbServerClient.uninstallTimeout)
 
 	defer cancel()
 
@@ -195,6 +262,13 @@ func (adbServerClient *client) Packages(ctx context.Context, serial string, opts
 		args = append(args, "-3")
 	}
 
+	unlock, err := adbServerClient.lock(ctx, serial, "list-packages", LockShared)
+	if err != nil {
+		return nil, err
+	}
+
+	defer unlock()
+
 	listPackagesContext, cancel := context.WithTimeout(ctx, adbServerClient.readTimeout)
 
 	defer cancel()
@@ -241,31 +315,335 @@ func (adbServerClient *client) Packages(ctx context.Context, serial string, opts
 	return packages, nil
 }
 
-func (adbServerClient *client) lock(serial string) func() {
-	value, _ := adbServerClient.perSerialMu.LoadOrStore(serial, &sync.Mutex{})
+func (adbServerClient *client) Push(ctx context.Context, serial, localPath, remotePath string) error {
+	if serial == "" {
+		return errors.New("serial is required")
+	}
+	if localPath == "" || remotePath == "" {
+		return errors.New("local and remote paths are required")
+	}
+
+	if _, err := os.Stat(localPath); err != nil && adbServerClient.player == nil {
+		return fmt.Errorf("local file is not readable: %w", err)
+	}
+
+	unlock, err := adbServerClient.lock(ctx, serial, "push", LockExclusive)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	pushCtx, cancel := context.WithTimeout(ctx, adbServerClient.transferTimeout)
+	defer cancel()
 
-	mutex := value.(*sync.Mutex)
+	_, errOut, err := adbServerClient.run(pushCtx, serial, "push", localPath, remotePath)
+	if err != nil {
+		return fmt.Errorf("adb push failed: %v: %s", err, strings.TrimSpace(errOut))
+	}
 
-	mutex.Lock()
-	return mutex.Unlock
+	return nil
 }
 
-func (adbServerClient *client) run(ctx context.Context, serial string, args ...string) (stdout string, stderr string, err error) {
-	argumentsArray := make([]string, 0, len(args)+2) // Creating an array of strings for arguments
+func (adbServerClient *client) Pull(ctx context.Context, serial, remotePath, localPath string) error {
+	if serial == "" {
+		return errors.New("serial is required")
+	}
+	if localPath == "" || remotePath == "" {
+		return errors.New("local and remote paths are required")
+	}
 
-	if serial != "" {
-		argumentsArray = append(argumentsArray, "-s", serial) // Adding in the serial number of the device using the "-s" flag for commands where we need it
+	unlock, err := adbServerClient.lock(ctx, serial, "pull", LockShared)
+	if err != nil {
+		return err
 	}
+	defer unlock()
 
-	argumentsArray = append(argumentsArray, args...)
+	pullCtx, cancel := context.WithTimeout(ctx, adbServerClient.transferTimeout)
+	defer cancel()
 
-	adbCommand := exec.CommandContext(ctx, adbServerClient.adbPath, argumentsArray...)
+	_, errOut, err := adbServerClient.run(pullCtx, serial, "pull", remotePath, localPath)
+	if err != nil {
+		return fmt.Errorf("adb pull failed: %v: %s", err, strings.TrimSpace(errOut))
+	}
+
+	return nil
+}
+
+// Bugreport writes a bugreport zip to localPath
+func (adbServerClient *client) Bugreport(ctx context.Context, serial, localPath string) error {
+	if serial == "" {
+		return errors.New("serial is required")
+	}
+	if localPath == "" {
+		return errors.New("local path is required")
+	}
+
+	unlock, err := adbServerClient.lock(ctx, serial, "bugreport", LockShared)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	bugreportCtx, cancel := context.WithTimeout(ctx, adbServerClient.bugreportTimeout)
+	defer cancel()
+
+	_, errOut, err := adbServerClient.run(bugreportCtx, serial, "bugreport", localPath)
+	if err != nil {
+		return fmt.Errorf("adb bugreport failed: %v: %s", err, strings.TrimSpace(errOut))
+	}
+
+	return nil
+}
+
+// Shell runs a command on the device and returns what it printed. adb only passes the remote
+// exit status through on devices with the shell v2 protocol, older ones always look successful.
+func (adbServerClient *client) Shell(ctx context.Context, serial, command string) (string, error) {
+	if serial == "" {
+		return "", errors.New("serial is required")
+	}
+	if strings.TrimSpace(command) == "" {
+		return "", errors.New("command is required")
+	}
+
+	unlock, err := adbServerClient.lock(ctx, serial, "shell", LockExclusive)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	shellCtx, cancel := context.WithTimeout(ctx, adbServerClient.readTimeout)
+	defer cancel()
+
+	out, errOut, err := adbServerClient.run(shellCtx, serial, "shell", command)
+	if err != nil {
+		return out, fmt.Errorf("adb shell failed: %v: %s", err, strings.TrimSpace(errOut))
+	}
+
+	return out, nil
+}
+
+// Screenshot returns what the screen shows as a PNG. exec-out rather than shell, so no pty gets
+// between us and the image and turns its newlines into CRLF.
+func (adbServerClient *client) Screenshot(ctx context.Context, serial string) ([]byte, error) {
+	if serial == "" {
+		return nil, errors.New("serial is required")
+	}
+
+	unlock, err := adbServerClient.lock(ctx, serial, "screenshot", LockShared)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	screenshotCtx, cancel := context.WithTimeout(ctx, adbServerClient.readTimeout)
+	defer cancel()
+
+	out, errOut, err := adbServerClient.run(screenshotCtx, serial, "exec-out", "screencap", "-p")
+	if err != nil {
+		return nil, fmt.Errorf("adb screencap failed: %v: %s", err, strings.TrimSpace(errOut))
+	}
+
+	// A locked-down or sleeping device prints an error and exits 0
+	if !strings.HasPrefix(out, pngSignature) {
+		return nil, fmt.Errorf("adb screencap didn't return an image: %s", strings.TrimSpace(out+errOut))
+	}
+
+	return []byte(out), nil
+}
+
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// Reboot restarts the device, mode is "" for a normal boot, or bootloader/recovery/sideload
+func (adbServerClient *client) Reboot(ctx context.Context, serial, mode string) error {
+	if serial == "" {
+		return errors.New("serial is required")
+	}
+
+	switch mode {
+	case "", "bootloader", "recovery", "sideload", "sideload-auto-reboot":
+	default:
+		return fmt.Errorf("unknown reboot mode %q", mode)
+	}
+
+	unlock, err := adbServerClient.lock(ctx, serial, "reboot", LockExclusive)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rebootCtx, cancel := context.WithTimeout(ctx, adbServerClient.readTimeout)
+	defer cancel()
+
+	args := []string{"reboot"}
+	if mode != "" {
+		args = append(args, mode)
+	}
+
+	_, errOut, err := adbServerClient.run(rebootCtx, serial, args...)
+	if err != nil {
+		return fmt.Errorf("adb reboot failed: %v: %s", err, strings.TrimSpace(errOut))
+	}
+
+	return nil
+}
+
+// lock queues for the device, giving up when ctx is cancelled instead of blocking forever behind a stuck operation
+func (adbServerClient *client) lock(ctx context.Context, serial string, operation string, mode string) (func(), error) {
+	value, _ := adbServerClient.deviceQueues.LoadOrStore(serial, &deviceQueue{})
+
+	queue := value.(*deviceQueue)
+
+	started := time.Now()
+
+	_, span := tracing.Start(ctx, "adb.lock", tracing.KindInternal,
+		tracing.String("device.serial", serial),
+		tracing.String("adb.operation", operation),
+		tracing.String("lock.mode", mode),
+		tracing.Int("lock.priority", int(priorityFrom(ctx))),
+	)
+
+	// Only worth the queue's mutex when someone will see it
+	if span != nil {
+		span.SetAttributes(tracing.Int("lock.queue_depth", queue.status(serial).QueueDepth))
+	}
+
+	unlock, err := queue.acquire(ctx, LockHolder{
+		Operation: operation,
+		Caller:    callerFrom(ctx),
+		Mode:      mode,
+		Priority:  priorityFrom(ctx),
+	})
+
+	waited := time.Since(started)
+
+	span.SetAttributes(tracing.Int64("lock.wait_ms", waited.Milliseconds()))
+	span.RecordError(err)
+	span.End()
+
+	if adbServerClient.observer != nil {
+		adbServerClient.observer.LockWaited(operation, waited, err == nil)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("gave up waiting for %s to be free: %w", serial, err)
+	}
+
+	return unlock, nil
+}
+
+// Locks reports who holds and who waits for each device we've queued operations on
+func (adbServerClient *client) Locks() []DeviceLockStatus {
+	var statuses []DeviceLockStatus
+
+	adbServerClient.deviceQueues.Range(func(key, value any) bool {
+		statuses = append(statuses, value.(*deviceQueue).status(key.(string)))
+		return true
+	})
+
+	slices.SortFunc(statuses, func(a, b DeviceLockStatus) int {
+		return strings.Compare(a.Serial, b.Serial)
+	})
+
+	return statuses
+}
+
+func (adbServerClient *client) run(ctx context.Context, serial string, args ...string) (stdout string, stderr string, err error) {
+	argumentsArray := adbServerClient.arguments(serial, args)
+
+	_, span := tracing.Start(ctx, "adb.run", tracing.KindClient,
+		tracing.String("device.serial", serial),
+		tracing.Strings("process.command_args", append([]string{adbServerClient.adbPath}, argumentsArray...)),
+	)
+	defer span.End()
 
 	var outBuf, errorBuf bytes.Buffer
 
-	adbCommand.Stdout = &outBuf
-	adbCommand.Stderr = &errorBuf
+	var stdoutWriter, stderrWriter io.Writer = &outBuf, &errorBuf
+
+	// Someone wants to follow along, tee both streams through the line splitter
+	if progress := progressFrom(ctx); progress != nil {
+		outProgress := &progressWriter{progress: progress}
+		errorProgress := &progressWriter{progress: progress}
+
+		defer outProgress.flush()
+		defer errorProgress.flush()
+
+		stdoutWriter = io.MultiWriter(&outBuf, outProgress)
+		stderrWriter = io.MultiWriter(&errorBuf, errorProgress)
+	}
+
+	started := time.Now()
+
+	var exitCode int
+
+	if adbServerClient.player != nil {
+		exitCode, err = adbServerClient.player.play(ctx, serial, args, stdoutWriter, stderrWriter)
+	} else {
+		adbCommand := exec.CommandContext(ctx, adbServerClient.adbPath, argumentsArray...)
+
+		adbCommand.Stdout = stdoutWriter
+		adbCommand.Stderr = stderrWriter
+
+		err = adbCommand.Run()
+		exitCode = adbCommand.ProcessState.ExitCode()
+	}
+
+	if adbServerClient.recorder != nil {
+		interaction := Interaction{
+			Serial:     serial,
+			Args:       args,
+			Stderr:     errorBuf.String(),
+			ExitCode:   exitCode,
+			DurationMS: time.Since(started).Milliseconds(),
+			Time:       started.UTC(),
+		}
+
+		interaction.setStdout(outBuf.Bytes())
+
+		// A non-zero exit is in ExitCode already, anything else (not found, killed) has to be kept as text
+		var exitError *exec.ExitError
+		if err != nil && (!errors.As(err, &exitError) || exitCode < 0) {
+			interaction.Error = err.Error()
+		}
+
+		adbServerClient.recorder.record(interaction)
+	}
+
+	span.SetAttributes(
+		tracing.Int("process.exit.code", exitCode),
+		tracing.Int("process.stdout.bytes", outBuf.Len()),
+		tracing.Int("process.stderr.bytes", errorBuf.Len()),
+	)
+	span.RecordError(err)
+
+	// Debug only, a busy lab runs a lot of these. The request id comes along through ctx.
+	if err != nil {
+		slog.DebugContext(ctx, "adb command failed", "args", argumentsArray, "duration_ms", time.Since(started).Milliseconds(), "error", err, "stderr", strings.TrimSpace(errorBuf.String()))
+	} else {
+		slog.DebugContext(ctx, "adb command", "args", argumentsArray, "duration_ms", time.Since(started).Milliseconds())
+	}
 
-	err = adbCommand.Run()
 	return outBuf.String(), errorBuf.String(), err
 }
+
+// arguments puts the server address and the serial in front of args
+func (adbServerClient *client) arguments(serial string, args []string) []string {
+	argumentsArray := make([]string, 0, len(args)+6) // Creating an array of strings for arguments
+
+	// Talk to a specific adb server instead of the default localhost:5037
+	if adbServerClient.serverHost != "" {
+		argumentsArray = append(argumentsArray, "-H", adbServerClient.serverHost)
+	}
+
+	if adbServerClient.serverPort != "" {
+		argumentsArray = append(argumentsArray, "-P", adbServerClient.serverPort)
+	}
+
+	if serial != "" {
+		argumentsArray = append(argumentsArray, "-s", serial) // Adding in the serial number of the device using the "-s" flag for commands where we need it
+	}
+
+	argumentsArray = append(argumentsArray, args...)
+
+	return argumentsArray
+}
@@ -0,0 +1,303 @@
+package adb_test
+
+import (
+	"adb-server/internal/adb"
+	"adb-server/internal/adbtest"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var testScript = adbtest.Script{
+	Devices: []adbtest.ScriptDevice{
+		{Serial: "SER1", State: "device", Model: "Pixel_7a"},
+		{Serial: "SER2", State: "unauthorized"},
+		{Serial: "emulator-5554", State: "offline"},
+	},
+	Packages: map[string][]adbtest.ScriptPackage{
+		"SER1": {
+			{Name: "com.example.app", Path: "/data/app/~~abc/com.example.app-1/base.apk"},
+			{Name: "com.example.other", Path: "/data/app/~~def/com.example.other-1/base.apk"},
+			{Name: "com.android.settings", Path: "/system/priv-app/Settings/Settings.apk", System: true},
+		},
+	},
+	InstallOutput: map[string]string{"old.apk": "Failure [INSTALL_FAILED_VERSION_DOWNGRADE]"},
+}
+
+// newFakeClient is a real client running a fake adb built into a temp dir
+func newFakeClient(t *testing.T, config adb.Config) (*adbtest.FakeADB, adb.Client) {
+	t.Helper()
+
+	fake, err := adbtest.NewFakeADB(t.TempDir(), testScript)
+	if err != nil {
+		t.Fatalf("NewFakeADB: %v", err)
+	}
+
+	config.ADBPath = fake.Path
+
+	adbClient, err := adb.New(config)
+	if err != nil {
+		t.Fatalf("adb.New: %v", err)
+	}
+
+	return fake, adbClient
+}
+
+func lastInvocation(t *testing.T, fake *adbtest.FakeADB) []string {
+	t.Helper()
+
+	invocations, err := fake.Invocations()
+	if err != nil {
+		t.Fatalf("Invocations: %v", err)
+	}
+
+	if len(invocations) == 0 {
+		t.Fatal("adb was never run")
+	}
+
+	return invocations[len(invocations)-1]
+}
+
+func TestDevices(t *testing.T) {
+	fake, adbClient := newFakeClient(t, adb.Config{})
+
+	devices, err := adbClient.Devices(context.Background())
+	if err != nil {
+		t.Fatalf("Devices: %v", err)
+	}
+
+	want := []adb.Device{
+		{Serial: "SER1", State: "device", Model: "Pixel_7a", IsAuthorized: true},
+		{Serial: "SER2", State: "unauthorized"},
+		{Serial: "emulator-5554", State: "offline"},
+	}
+
+	if !slices.Equal(devices, want) {
+		t.Errorf("got %+v, want %+v", devices, want)
+	}
+
+	if args := lastInvocation(t, fake); !slices.Equal(args, []string{"devices", "-l"}) {
+		t.Errorf("ran adb %q, want adb devices -l", args)
+	}
+}
+
+func TestServerAddressIsPassedOn(t *testing.T) {
+	fake, adbClient := newFakeClient(t, adb.Config{ServerAddress: "10.0.0.5:5038"})
+
+	if _, err := adbClient.Shell(context.Background(), "SER1", "getprop ro.product.model"); err == nil {
+		t.Fatal("Shell succeeded for a command the script doesn't know")
+	}
+
+	want := []string{"-H", "10.0.0.5", "-P", "5038", "-s", "SER1", "shell", "getprop ro.product.model"}
+	if args := lastInvocation(t, fake); !slices.Equal(args, want) {
+		t.Errorf("ran adb %q, want %q", args, want)
+	}
+}
+
+func TestPackages(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  adb.ListPackageOptions
+		wantArgs []string
+		want     []adb.Package
+	}{
+		{
+			name:     "third party",
+			wantArgs: []string{"-s", "SER1", "shell", "pm", "list", "packages", "-f", "-3"},
+			want: []adb.Package{
+				{Name: "com.example.app", ApkPath: "/data/app/~~abc/com.example.app-1/base.apk"},
+				{Name: "com.example.other", ApkPath: "/data/app/~~def/com.example.other-1/base.apk"},
+			},
+		},
+		{
+			name:     "system",
+			options:  adb.ListPackageOptions{IncludeSystem: true},
+			wantArgs: []string{"-s", "SER1", "shell", "pm", "list", "packages", "-f", "-s"},
+			want: []adb.Package{
+				{Name: "com.android.settings", ApkPath: "/system/priv-app/Settings/Settings.apk", IsSystem: true},
+			},
+		},
+		{
+			name:     "uninstalled too",
+			options:  adb.ListPackageOptions{IncludeUninstalled: true},
+			wantArgs: []string{"-s", "SER1", "shell", "pm", "list", "packages", "-f", "-u", "-3"},
+			want: []adb.Package{
+				{Name: "com.example.app", ApkPath: "/data/app/~~abc/com.example.app-1/base.apk"},
+				{Name: "com.example.other", ApkPath: "/data/app/~~def/com.example.other-1/base.apk"},
+			},
+		},
+	}
+
+	fake, adbClient := newFakeClient(t, adb.Config{})
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			packages, err := adbClient.Packages(context.Background(), "SER1", test.options)
+			if err != nil {
+				t.Fatalf("Packages: %v", err)
+			}
+
+			if !slices.Equal(packages, test.want) {
+				t.Errorf("got %+v, want %+v", packages, test.want)
+			}
+
+			// adb shell joins its arguments into one command line, the fake sees them as given
+			if args := lastInvocation(t, fake); !slices.Equal(args, test.wantArgs) {
+				t.Errorf("ran adb %q, want %q", args, test.wantArgs)
+			}
+		})
+	}
+}
+
+func TestPackagesWithoutPaths(t *testing.T) {
+	fake, adbClient := newFakeClient(t, adb.Config{})
+
+	// What pm prints without -f, which has no = to split on: nothing usable
+	err := fake.SetScript(adbtest.Script{
+		Devices: testScript.Devices,
+		Failures: map[string]adbtest.ScriptFailure{
+			"shell:pm": {Stdout: "package:com.example.app\n"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("SetScript: %v", err)
+	}
+
+	packages, err := adbClient.Packages(context.Background(), "SER1", adb.ListPackageOptions{})
+	if err != nil {
+		t.Fatalf("Packages: %v", err)
+	}
+
+	if len(packages) != 0 {
+		t.Errorf("got %+v from lines without an APK path", packages)
+	}
+}
+
+func TestInstall(t *testing.T) {
+	fake, adbClient := newFakeClient(t, adb.Config{})
+
+	dir := t.TempDir()
+
+	app := filepath.Join(dir, "app.apk")
+	old := filepath.Join(dir, "old.apk")
+
+	for _, apk := range []string{app, old} {
+		if err := os.WriteFile(apk, []byte("PK"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := adbClient.Install(context.Background(), "SER1", app); err != nil {
+		t.Errorf("Install: %v", err)
+	}
+
+	if args := lastInvocation(t, fake); !slices.Equal(args, []string{"-s", "SER1", "install", "-r", app}) {
+		t.Errorf("ran adb %q", args)
+	}
+
+	err := adbClient.Install(context.Background(), "SER1", old)
+	if err == nil || !strings.Contains(err.Error(), "INSTALL_FAILED_VERSION_DOWNGRADE") {
+		t.Errorf("got %v, want the Failure pm printed", err)
+	}
+
+	if err := adbClient.Install(context.Background(), "SER1", filepath.Join(dir, "missing.apk")); err == nil {
+		t.Error("Install succeeded for an APK that doesn't exist")
+	}
+}
+
+func TestUninstall(t *testing.T) {
+	fake, adbClient := newFakeClient(t, adb.Config{})
+
+	if err := adbClient.Uninstall(context.Background(), "SER1", "com.example.app", false, 0); err != nil {
+		t.Fatalf("Uninstall: %v", err)
+	}
+
+	want := []string{"-s", "SER1", "shell", "pm", "uninstall", "--user", "0", "com.example.app"}
+	if args := lastInvocation(t, fake); !slices.Equal(args, want) {
+		t.Errorf("ran adb %q, want %q", args, want)
+	}
+
+	// It's gone now, pm says so the way it does
+	err := adbClient.Uninstall(context.Background(), "SER1", "com.example.app", true, -1)
+	if err == nil || !strings.Contains(err.Error(), "Failure [DELETE_FAILED_INTERNAL_ERROR]") {
+		t.Errorf("second uninstall got %v, want Failure [DELETE_FAILED_INTERNAL_ERROR]", err)
+	}
+
+	want = []string{"-s", "SER1", "shell", "pm", "uninstall", "-k", "com.example.app"}
+	if args := lastInvocation(t, fake); !slices.Equal(args, want) {
+		t.Errorf("ran adb %q, want %q", args, want)
+	}
+
+	packages, err := adbClient.Packages(context.Background(), "SER1", adb.ListPackageOptions{})
+	if err != nil {
+		t.Fatalf("Packages: %v", err)
+	}
+
+	if len(packages) != 1 || packages[0].Name != "com.example.other" {
+		t.Errorf("packages after uninstalling: %+v", packages)
+	}
+}
+
+func TestUnknownDevice(t *testing.T) {
+	_, adbClient := newFakeClient(t, adb.Config{})
+
+	_, err := adbClient.Packages(context.Background(), "NOPE", adb.ListPackageOptions{})
+	if err == nil || !strings.Contains(err.Error(), "device 'NOPE' not found") {
+		t.Errorf("got %v, want adb's device not found", err)
+	}
+}
+
+func TestCassetteReplaysUploads(t *testing.T) {
+	cassette := filepath.Join(t.TempDir(), "session.cassette")
+
+	// Uploads are spooled to random temp files, recorded under one name and replayed under another
+	upload := func() string {
+		t.Helper()
+
+		file, err := os.CreateTemp(t.TempDir(), "upload-*.apk")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		file.Close()
+
+		return file.Name()
+	}
+
+	fake, recording := newFakeClient(t, adb.Config{RecordTo: cassette})
+
+	if err := recording.Install(context.Background(), "SER1", upload()); err != nil {
+		t.Fatalf("recording Install: %v", err)
+	}
+
+	if err := recording.Push(context.Background(), "SER1", upload(), "/sdcard/app.apk"); err != nil {
+		t.Fatalf("recording Push: %v", err)
+	}
+
+	replaying, err := adb.New(adb.Config{ADBPath: fake.Path, ReplayFrom: cassette})
+	if err != nil {
+		t.Fatalf("adb.New: %v", err)
+	}
+
+	before, _ := fake.Invocations()
+
+	if err := replaying.Install(context.Background(), "SER1", upload()); err != nil {
+		t.Errorf("replayed Install: %v", err)
+	}
+
+	if err := replaying.Push(context.Background(), "SER1", upload(), "/sdcard/app.apk"); err != nil {
+		t.Errorf("replayed Push: %v", err)
+	}
+
+	// Where it goes on the device still matters
+	if err := replaying.Push(context.Background(), "SER1", upload(), "/sdcard/other.apk"); err == nil {
+		t.Error("replayed a push to a path that was never recorded")
+	}
+
+	if after, _ := fake.Invocations(); len(after) != len(before) {
+		t.Errorf("replaying ran adb %d time(s)", len(after)-len(before))
+	}
+}
@@ -0,0 +1,280 @@
+package adb
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// A cassette is a recording of every adb invocation a server made, one JSON object per line
+// after a header line. Recording appends as it goes so a crash still leaves a usable file.
+// Replaying serves the recorded output instead of running adb, so a cassette attached to a
+// bug report can be played against the handlers without the device.
+
+const cassetteVersion = 1
+
+// Files pull and bugreport produce are stored in the cassette up to this size
+const maxRecordedArtifact = 1 << 20
+
+type cassetteHeader struct {
+	Cassette   int       `json:"cassette"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// Interaction is one recorded adb invocation
+type Interaction struct {
+	Serial     string    `json:"serial,omitempty"`
+	Args       []string  `json:"args"` // without the adb path, -H/-P and -s
+	Stdout     string    `json:"stdout"`
+	Stderr     string    `json:"stderr,omitempty"`
+	ExitCode   int       `json:"exit_code"`
+	Error      string    `json:"error,omitempty"` // adb didn't run or didn't exit normally, e.g. killed on timeout
+	DurationMS int64     `json:"duration_ms"`
+	Time       time.Time `json:"time"`
+	Artifact   string    `json:"artifact,omitempty"` // base64 of the file pull/bugreport wrote
+
+	// Binary output like screencap's doesn't survive as a JSON string, it's kept as base64 instead
+	StdoutBase64 string `json:"stdout_base64,omitempty"`
+}
+
+func (interaction *Interaction) setStdout(out []byte) {
+	if utf8.Valid(out) {
+		interaction.Stdout = string(out)
+		return
+	}
+
+	interaction.StdoutBase64 = base64.StdEncoding.EncodeToString(out)
+}
+
+func (interaction Interaction) stdout() (string, error) {
+	if interaction.StdoutBase64 == "" {
+		return interaction.Stdout, nil
+	}
+
+	out, err := base64.StdEncoding.DecodeString(interaction.StdoutBase64)
+	if err != nil {
+		return "", fmt.Errorf("cassette stdout is corrupt: %w", err)
+	}
+
+	return string(out), nil
+}
+
+type cassetteRecorder struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newCassetteRecorder(path string) (*cassetteRecorder, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cassette: %w", err)
+	}
+
+	header, _ := json.Marshal(cassetteHeader{Cassette: cassetteVersion, RecordedAt: time.Now().UTC()})
+
+	if _, err := file.Write(append(header, '\n')); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write cassette: %w", err)
+	}
+
+	return &cassetteRecorder{file: file}, nil
+}
+
+func (recorder *cassetteRecorder) record(interaction Interaction) {
+	// The file a pull or bugreport wrote, replay has to hand it back
+	if outputPath := localOutputPath(interaction.Args); outputPath != "" && interaction.ExitCode == 0 && interaction.Error == "" {
+		if info, err := os.Stat(outputPath); err == nil && info.Size() <= maxRecordedArtifact {
+			if content, err := os.ReadFile(outputPath); err == nil {
+				interaction.Artifact = base64.StdEncoding.EncodeToString(content)
+			}
+		}
+	}
+
+	line, err := json.Marshal(interaction)
+	if err != nil {
+		return
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	// Best effort, recording must never break the operation being recorded
+	_, _ = recorder.file.Write(append(line, '\n'))
+}
+
+// cassettePlayer serves recorded interactions. Identical invocations are answered in the
+// order they were recorded, the last answer repeats once they run out.
+type cassettePlayer struct {
+	mu       sync.Mutex
+	realTime bool
+	byKey    map[string][]Interaction
+	served   map[string]int
+}
+
+func loadCassette(path string, realTime bool) (*cassettePlayer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cassette: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+
+	headerLine, err := reader.ReadBytes('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+
+	var header cassetteHeader
+	if err := json.Unmarshal(headerLine, &header); err != nil || header.Cassette == 0 {
+		return nil, errors.New("not a cassette file")
+	}
+
+	if header.Cassette > cassetteVersion {
+		return nil, fmt.Errorf("cassette version %d is newer than this server supports (%d)", header.Cassette, cassetteVersion)
+	}
+
+	player := &cassettePlayer{realTime: realTime, byKey: map[string][]Interaction{}, served: map[string]int{}}
+
+	decoder := json.NewDecoder(reader)
+
+	for {
+		var interaction Interaction
+
+		err := decoder.Decode(&interaction)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// A recording cut short by a crash ends in a partial line, keep what came before it
+			break
+		}
+
+		key := interactionKey(interaction.Serial, interaction.Args)
+		player.byKey[key] = append(player.byKey[key], interaction)
+	}
+
+	return player, nil
+}
+
+func (player *cassettePlayer) play(ctx context.Context, serial string, args []string, stdout io.Writer, stderr io.Writer) (exitCode int, err error) {
+	key := interactionKey(serial, args)
+
+	player.mu.Lock()
+
+	recorded := player.byKey[key]
+	if len(recorded) == 0 {
+		player.mu.Unlock()
+		return -1, fmt.Errorf("no recorded adb interaction for %q", strings.Join(append(serialArgs(serial), args...), " "))
+	}
+
+	index := min(player.served[key], len(recorded)-1)
+	player.served[key]++
+
+	interaction := recorded[index]
+
+	player.mu.Unlock()
+
+	if player.realTime {
+		select {
+		case <-time.After(time.Duration(interaction.DurationMS) * time.Millisecond):
+		case <-ctx.Done():
+			return -1, ctx.Err()
+		}
+	}
+
+	out, err := interaction.stdout()
+	if err != nil {
+		return -1, err
+	}
+
+	_, _ = io.WriteString(stdout, out)
+	_, _ = io.WriteString(stderr, interaction.Stderr)
+
+	if outputPath := localOutputPath(args); outputPath != "" && interaction.Artifact != "" {
+		content, err := base64.StdEncoding.DecodeString(interaction.Artifact)
+		if err != nil {
+			return -1, fmt.Errorf("cassette artifact is corrupt: %w", err)
+		}
+
+		if err := os.WriteFile(outputPath, content, 0o600); err != nil {
+			return -1, err
+		}
+	}
+
+	if interaction.Error != "" {
+		return interaction.ExitCode, errors.New(interaction.Error)
+	}
+
+	if interaction.ExitCode != 0 {
+		return interaction.ExitCode, fmt.Errorf("exit status %d", interaction.ExitCode)
+	}
+
+	return 0, nil
+}
+
+// Local paths differ between the machine that recorded and the one replaying, and uploads and
+// pull destinations are random temp files anyway, so commands are matched without them
+func interactionKey(serial string, args []string) string {
+	normalized := append([]string{serial}, args...)
+
+	if index := localPathIndex(args); index > 0 {
+		normalized[index+1] = ""
+	}
+
+	return strings.Join(normalized, "\x00")
+}
+
+// localPathIndex is where in args the local file a command reads or writes is, -1 if it has none
+func localPathIndex(args []string) int {
+	switch {
+	case len(args) >= 2 && args[0] == "install":
+		return len(args) - 1
+	case len(args) >= 3 && args[0] == "push":
+		return 1
+	}
+
+	return localOutputIndex(args)
+}
+
+// localOutputIndex is where in args the local file a command writes is, -1 if it writes none
+func localOutputIndex(args []string) int {
+	switch {
+	case len(args) >= 3 && args[0] == "pull":
+		return 2
+	case len(args) >= 2 && args[0] == "bugreport":
+		return 1
+	}
+
+	return -1
+}
+
+func localOutputPath(args []string) string {
+	if index := localOutputIndex(args); index > 0 {
+		return args[index]
+	}
+
+	return ""
+}
+
+func serialArgs(serial string) []string {
+	if serial == "" {
+		return nil
+	}
+
+	return []string{"-s", serial}
+}
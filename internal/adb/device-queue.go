@@ -0,0 +1,174 @@
+package adb
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Priority decides who goes first when several operations wait for the same device.
+// Interactive requests jump ahead of batch/background work, equal priorities are FIFO.
+type Priority int
+
+const (
+	PriorityBatch       Priority = 0
+	PriorityInteractive Priority = 10
+)
+
+const (
+	LockExclusive = "exclusive" // mutates the device: install, uninstall, push...
+	LockShared    = "shared"    // read-only, runs alongside other reads
+)
+
+type priorityKey struct{}
+type callerKey struct{}
+
+// WithPriority sets the queue priority for operations run with this context, interactive by default
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// WithCaller records who is running operations with this context, shown while they hold or wait for a device
+func WithCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+func priorityFrom(ctx context.Context) Priority {
+	if priority, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return priority
+	}
+
+	return PriorityInteractive
+}
+
+func callerFrom(ctx context.Context) string {
+	caller, _ := ctx.Value(callerKey{}).(string)
+	return caller
+}
+
+// LockHolder describes an operation holding or waiting for a device
+type LockHolder struct {
+	Operation string    `json:"operation"`
+	Caller    string    `json:"caller,omitempty"`
+	Mode      string    `json:"mode"`
+	Priority  Priority  `json:"priority"`
+	Since     time.Time `json:"since"`
+}
+
+type DeviceLockStatus struct {
+	Serial     string       `json:"device_id"`
+	Holders    []LockHolder `json:"holders"`
+	Waiting    []LockHolder `json:"waiting"`
+	QueueDepth int          `json:"queue_depth"`
+}
+
+type lockRequest struct {
+	holder  LockHolder
+	granted chan struct{}
+}
+
+func (request *lockRequest) exclusive() bool {
+	return request.holder.Mode == LockExclusive
+}
+
+// deviceQueue is a readers-writer lock with a priority queue in front of it.
+// Waiters are granted strictly in queue order so a stream of reads can't starve a write.
+type deviceQueue struct {
+	mu      sync.Mutex
+	holders []*lockRequest
+	waiting []*lockRequest
+}
+
+func (queue *deviceQueue) acquire(ctx context.Context, holder LockHolder) (func(), error) {
+	// Since means "waiting since" until granted, then "holding since"
+	holder.Since = time.Now().UTC()
+	request := &lockRequest{holder: holder, granted: make(chan struct{})}
+
+	queue.mu.Lock()
+
+	// Behind everyone of equal or higher priority, ahead of everyone lower
+	position := len(queue.waiting)
+	for index, waiting := range queue.waiting {
+		if waiting.holder.Priority < holder.Priority {
+			position = index
+			break
+		}
+	}
+
+	queue.waiting = slices.Insert(queue.waiting, position, request)
+	queue.dispatchLocked()
+
+	queue.mu.Unlock()
+
+	select {
+	case <-request.granted:
+		return func() { queue.release(request) }, nil
+
+	case <-ctx.Done():
+		queue.mu.Lock()
+		defer queue.mu.Unlock()
+
+		// Lost the race: we were granted just as the context ended, hand it straight back
+		select {
+		case <-request.granted:
+			queue.releaseLocked(request)
+		default:
+			queue.waiting = slices.DeleteFunc(queue.waiting, func(waiting *lockRequest) bool { return waiting == request })
+			queue.dispatchLocked()
+		}
+
+		return nil, ctx.Err()
+	}
+}
+
+func (queue *deviceQueue) release(request *lockRequest) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	queue.releaseLocked(request)
+}
+
+func (queue *deviceQueue) releaseLocked(request *lockRequest) {
+	queue.holders = slices.DeleteFunc(queue.holders, func(holder *lockRequest) bool { return holder == request })
+	queue.dispatchLocked()
+}
+
+// Grant the head of the queue for as long as it's compatible with the current holders
+func (queue *deviceQueue) dispatchLocked() {
+	for len(queue.waiting) > 0 {
+		head := queue.waiting[0]
+
+		if len(queue.holders) > 0 && (head.exclusive() || queue.holders[0].exclusive()) {
+			return
+		}
+
+		queue.waiting = queue.waiting[1:]
+		head.holder.Since = time.Now().UTC()
+		queue.holders = append(queue.holders, head)
+
+		close(head.granted)
+	}
+}
+
+func (queue *deviceQueue) status(serial string) DeviceLockStatus {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	status := DeviceLockStatus{
+		Serial:     serial,
+		Holders:    make([]LockHolder, 0, len(queue.holders)),
+		Waiting:    make([]LockHolder, 0, len(queue.waiting)),
+		QueueDepth: len(queue.waiting),
+	}
+
+	for _, holder := range queue.holders {
+		status.Holders = append(status.Holders, holder.holder)
+	}
+
+	for _, waiting := range queue.waiting {
+		status.Waiting = append(status.Waiting, waiting.holder)
+	}
+
+	return status
+}
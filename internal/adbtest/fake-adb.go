@@ -0,0 +1,87 @@
+package adbtest
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// FakeADB is a built fake adb executable and the script it answers from
+type FakeADB struct {
+	Path string // pass as adb.Config.ADBPath
+}
+
+// NewFakeADB builds the fake adb program into dir and gives it script. Building needs the
+// go toolchain, which tests have anyway.
+func NewFakeADB(dir string, script Script) (*FakeADB, error) {
+	name := "adb"
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+
+	fake := &FakeADB{Path: filepath.Join(dir, name)}
+
+	if err := buildFakeADB(fake.Path); err != nil {
+		return nil, err
+	}
+
+	if err := fake.SetScript(script); err != nil {
+		return nil, err
+	}
+
+	return fake, nil
+}
+
+// SetScript replaces what the fake answers with, from its next invocation on
+func (fake *FakeADB) SetScript(script Script) error {
+	return SaveScript(ScriptPath(fake.Path), script)
+}
+
+// Invocations is the arguments of every run so far, oldest first
+func (fake *FakeADB) Invocations() ([][]string, error) {
+	file, err := os.Open(InvocationLogPath(fake.Path))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var invocations [][]string
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var args []string
+		if err := json.Unmarshal(scanner.Bytes(), &args); err != nil {
+			return nil, fmt.Errorf("corrupt invocation log: %w", err)
+		}
+
+		invocations = append(invocations, args)
+	}
+
+	return invocations, scanner.Err()
+}
+
+func buildFakeADB(output string) error {
+	// The fakeadb package sits next to this file, build it from there so it works from any working dir
+	_, thisFile, _, ok := runtime.Caller(0)
+	if !ok {
+		return errors.New("can't locate the fakeadb sources")
+	}
+
+	build := exec.Command("go", "build", "-o", output, ".")
+	build.Dir = filepath.Join(filepath.Dir(thisFile), "fakeadb")
+
+	if out, err := build.CombinedOutput(); err != nil {
+		return fmt.Errorf("building fake adb failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
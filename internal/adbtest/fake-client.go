@@ -0,0 +1,360 @@
+package adbtest
+
+import (
+	"adb-server/internal/adb"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Call is one recorded FakeClient call
+type Call struct {
+	Operation string
+	Serial    string
+	Args      []string
+}
+
+// FakeClient is an in-memory adb.Client. Devices and packages are whatever the test put
+// there, installs and uninstalls change them, and every call is recorded.
+type FakeClient struct {
+	mu       sync.Mutex
+	version  string
+	devices  []adb.Device
+	packages map[string][]adb.Package
+	files    map[string]map[string][]byte // serial -> remote path -> content
+	shell    map[string]string
+	logcat   map[string][]string
+	failures map[string][]error
+	calls    []Call
+}
+
+var _ adb.Client = (*FakeClient)(nil)
+
+func NewFakeClient() *FakeClient {
+	return &FakeClient{
+		version:  "Android Debug Bridge version 1.0.41\nVersion 35.0.1-fake",
+		packages: map[string][]adb.Package{},
+		files:    map[string]map[string][]byte{},
+		shell:    map[string]string{},
+		logcat:   map[string][]string{},
+		failures: map[string][]error{},
+	}
+}
+
+// AddDevice connects a device, replacing any device with the same serial
+func (fake *FakeClient) AddDevice(device adb.Device) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	fake.devices = slices.DeleteFunc(fake.devices, func(existing adb.Device) bool { return existing.Serial == device.Serial })
+	fake.devices = append(fake.devices, device)
+}
+
+// RemoveDevice disconnects a device
+func (fake *FakeClient) RemoveDevice(serial string) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	fake.devices = slices.DeleteFunc(fake.devices, func(existing adb.Device) bool { return existing.Serial == serial })
+}
+
+func (fake *FakeClient) SetPackages(serial string, packages []adb.Package) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	fake.packages[serial] = slices.Clone(packages)
+}
+
+// SetShellOutput is what Shell returns for command
+func (fake *FakeClient) SetShellOutput(command string, output string) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	fake.shell[command] = output
+}
+
+// SetLogcat sets what Logcat prints for serial
+func (fake *FakeClient) SetLogcat(serial string, lines []string) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	fake.logcat[serial] = slices.Clone(lines)
+}
+
+func (fake *FakeClient) SetVersion(version string) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	fake.version = version
+}
+
+// FailNext makes the next call of operation (install, devices, ...) return err. Queued errors are used in order.
+func (fake *FakeClient) FailNext(operation string, err error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	fake.failures[operation] = append(fake.failures[operation], err)
+}
+
+// File is what was pushed to remotePath on the device
+func (fake *FakeClient) File(serial, remotePath string) ([]byte, bool) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	content, ok := fake.files[serial][remotePath]
+	return content, ok
+}
+
+// Calls is every call so far, oldest first
+func (fake *FakeClient) Calls() []Call {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	return slices.Clone(fake.calls)
+}
+
+// begin records the call and returns the error it should fail with, if any. Callers hold fake.mu.
+func (fake *FakeClient) begin(ctx context.Context, operation string, serial string, args ...string) error {
+	fake.calls = append(fake.calls, Call{Operation: operation, Serial: serial, Args: args})
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if queued := fake.failures[operation]; len(queued) > 0 {
+		fake.failures[operation] = queued[1:]
+		return queued[0]
+	}
+
+	if serial == "" {
+		return nil
+	}
+
+	for _, device := range fake.devices {
+		if device.Serial != serial {
+			continue
+		}
+
+		if device.State != "device" {
+			return fmt.Errorf("device %s is %s", serial, device.State)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("adb: device '%s' not found", serial)
+}
+
+func (fake *FakeClient) Version(ctx context.Context) (string, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	if err := fake.begin(ctx, "version", ""); err != nil {
+		return "", err
+	}
+
+	return fake.version, nil
+}
+
+func (fake *FakeClient) Devices(ctx context.Context) ([]adb.Device, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	if err := fake.begin(ctx, "devices", ""); err != nil {
+		return nil, err
+	}
+
+	return slices.Clone(fake.devices), nil
+}
+
+func (fake *FakeClient) Packages(ctx context.Context, serial string, opts adb.ListPackageOptions) ([]adb.Package, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	if err := fake.begin(ctx, "list-packages", serial); err != nil {
+		return nil, err
+	}
+
+	var packages []adb.Package
+	for _, pkg := range fake.packages[serial] {
+		if pkg.IsSystem == opts.IncludeSystem {
+			packages = append(packages, pkg)
+		}
+	}
+
+	return packages, nil
+}
+
+func (fake *FakeClient) Uninstall(ctx context.Context, serial, pkg string, keepData bool, user int) error {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	if err := fake.begin(ctx, "uninstall", serial, pkg, fmt.Sprint(keepData), fmt.Sprint(user)); err != nil {
+		return err
+	}
+
+	before := len(fake.packages[serial])
+	fake.packages[serial] = slices.DeleteFunc(fake.packages[serial], func(installed adb.Package) bool { return installed.Name == pkg })
+
+	if len(fake.packages[serial]) == before {
+		return errors.New("uninstall error: Failure [DELETE_FAILED_INTERNAL_ERROR]")
+	}
+
+	return nil
+}
+
+// Install "installs" a package named after the APK file, app.apk becomes package app
+func (fake *FakeClient) Install(ctx context.Context, serial string, apkPath string) error {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	if err := fake.begin(ctx, "install", serial, apkPath); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(apkPath); err != nil {
+		return fmt.Errorf("apk file does not exist: %s", apkPath)
+	}
+
+	name := strings.TrimSuffix(filepath.Base(apkPath), ".apk")
+
+	fake.packages[serial] = slices.DeleteFunc(fake.packages[serial], func(installed adb.Package) bool { return installed.Name == name })
+	fake.packages[serial] = append(fake.packages[serial], adb.Package{Name: name, ApkPath: "/data/app/" + name + "/base.apk"})
+
+	return nil
+}
+
+func (fake *FakeClient) Push(ctx context.Context, serial, localPath, remotePath string) error {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	if err := fake.begin(ctx, "push", serial, localPath, remotePath); err != nil {
+		return err
+	}
+
+	content, err := os.ReadFile(localPath)
+	if err != nil {
+		return fmt.Errorf("local file is not readable: %w", err)
+	}
+
+	if fake.files[serial] == nil {
+		fake.files[serial] = map[string][]byte{}
+	}
+
+	fake.files[serial][remotePath] = content
+
+	return nil
+}
+
+func (fake *FakeClient) Pull(ctx context.Context, serial, remotePath, localPath string) error {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	if err := fake.begin(ctx, "pull", serial, remotePath, localPath); err != nil {
+		return err
+	}
+
+	content, ok := fake.files[serial][remotePath]
+	if !ok {
+		return fmt.Errorf("adb pull failed: remote object '%s' does not exist", remotePath)
+	}
+
+	return os.WriteFile(localPath, content, 0o600)
+}
+
+func (fake *FakeClient) Bugreport(ctx context.Context, serial, localPath string) error {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	if err := fake.begin(ctx, "bugreport", serial, localPath); err != nil {
+		return err
+	}
+
+	return os.WriteFile(localPath, []byte("PK fake bugreport"), 0o600)
+}
+
+func (fake *FakeClient) Shell(ctx context.Context, serial, command string) (string, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	if err := fake.begin(ctx, "shell", serial, command); err != nil {
+		return "", err
+	}
+
+	output, ok := fake.shell[command]
+	if !ok {
+		return "", fmt.Errorf("adb shell failed: exit status 127: /system/bin/sh: %s: inaccessible or not found", command)
+	}
+
+	return output, nil
+}
+
+// Screenshot returns a PNG of a blank screen
+func (fake *FakeClient) Screenshot(ctx context.Context, serial string) ([]byte, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	if err := fake.begin(ctx, "screenshot", serial); err != nil {
+		return nil, err
+	}
+
+	return BlankScreenshot(), nil
+}
+
+func (fake *FakeClient) Reboot(ctx context.Context, serial, mode string) error {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	return fake.begin(ctx, "reboot", serial, mode)
+}
+
+// Logcat prints the lines SetLogcat gave the device, then waits for ctx like a followed
+// logcat would, unless opts.Dump is set
+func (fake *FakeClient) Logcat(ctx context.Context, serial string, opts adb.LogcatOptions, line func(string)) error {
+	fake.mu.Lock()
+
+	if err := fake.begin(ctx, "logcat", serial, opts.Filters...); err != nil {
+		fake.mu.Unlock()
+		return err
+	}
+
+	lines := fake.logcat[serial]
+	if opts.Tail > 0 && len(lines) > opts.Tail {
+		lines = lines[len(lines)-opts.Tail:]
+	}
+
+	fake.mu.Unlock()
+
+	for _, text := range lines {
+		line(text)
+	}
+
+	if opts.Dump {
+		return nil
+	}
+
+	<-ctx.Done()
+
+	return ctx.Err()
+}
+
+// Locks is always empty, the fake runs everything immediately
+func (fake *FakeClient) Locks() []adb.DeviceLockStatus {
+	return []adb.DeviceLockStatus{}
+}
+
+// BlankScreenshot is a small all-black PNG, what the fakes answer screencap with
+func BlankScreenshot() []byte {
+	var encoded bytes.Buffer
+	_ = png.Encode(&encoded, image.NewGray(image.Rect(0, 0, 108, 240)))
+
+	return encoded.Bytes()
+}
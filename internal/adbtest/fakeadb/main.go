@@ -0,0 +1,244 @@
+// fakeadb pretends to be adb for tests. It answers from the script next to its own
+// executable (adbtest.ScriptPath) and logs every invocation (adbtest.InvocationLogPath).
+package main
+
+import (
+	"adb-server/internal/adbtest"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+func main() {
+	executable, err := os.Executable()
+	if err != nil {
+		fail(1, "fakeadb: %v", err)
+	}
+
+	args := os.Args[1:]
+	logInvocation(executable, args)
+
+	scriptPath := adbtest.ScriptPath(executable)
+
+	script, err := adbtest.LoadScript(scriptPath)
+	if err != nil {
+		fail(1, "fakeadb: no script: %v", err)
+	}
+
+	// Global options come before the command, same as real adb
+	serial := ""
+
+	for len(args) >= 2 && (args[0] == "-s" || args[0] == "-H" || args[0] == "-P") {
+		if args[0] == "-s" {
+			serial = args[1]
+		}
+
+		args = args[2:]
+	}
+
+	if len(args) == 0 {
+		fail(1, "adb: no command")
+	}
+
+	command := args[0]
+
+	failureKey := command
+	if command == "shell" && len(args) > 1 {
+		if fields := strings.Fields(args[1]); len(fields) > 0 {
+			failureKey = "shell:" + fields[0]
+		}
+	}
+
+	if failure, ok := script.Failures[failureKey]; ok {
+		fmt.Fprint(os.Stdout, failure.Stdout)
+		fmt.Fprint(os.Stderr, failure.Stderr)
+		os.Exit(failure.ExitCode)
+	}
+
+	if serial != "" && !hasDevice(script, serial) {
+		fail(1, "adb: device '%s' not found", serial)
+	}
+
+	switch command {
+	case "version":
+		version := script.Version
+		if version == "" {
+			version = "1.0.41"
+		}
+
+		fmt.Printf("Android Debug Bridge version %s\nVersion 35.0.1-fake\nInstalled as %s\n", version, executable)
+
+	case "devices":
+		fmt.Println("List of devices attached")
+
+		for index, device := range script.Devices {
+			line := fmt.Sprintf("%s\t%s", device.Serial, device.State)
+
+			// Unauthorized devices don't report their details
+			if device.State == "device" {
+				model := device.Model
+				if model == "" {
+					model = "Fake"
+				}
+
+				line += fmt.Sprintf(" product:%s model:%s device:%s", strings.ToLower(model), model, strings.ToLower(model))
+			}
+
+			fmt.Printf("%s transport_id:%d\n", line, index+1)
+		}
+
+		fmt.Println()
+
+	case "install":
+		apk := args[len(args)-1]
+
+		if _, err := os.Stat(apk); err != nil {
+			fail(1, "adb: failed to stat %s: No such file or directory", apk)
+		}
+
+		output, ok := script.InstallOutput[filepath.Base(apk)]
+		if !ok {
+			output = "Success"
+		}
+
+		fmt.Printf("Performing Streamed Install\n%s\n", output)
+
+	case "shell":
+		shell(scriptPath, script, serial, args[1:])
+
+	case "push":
+		if len(args) < 3 {
+			fail(1, "adb: push requires an argument")
+		}
+
+		fmt.Printf("%s: 1 file pushed, 0 skipped.\n", args[1])
+
+	case "pull":
+		if len(args) < 3 {
+			fail(1, "adb: pull requires an argument")
+		}
+
+		if err := os.WriteFile(args[2], []byte("pulled from "+args[1]), 0o600); err != nil {
+			fail(1, "adb: error: %v", err)
+		}
+
+		fmt.Printf("%s: 1 file pulled, 0 skipped.\n", args[1])
+
+	case "bugreport":
+		for step := 1; step <= 3; step++ {
+			fmt.Printf("[%3d%%] generating bugreport\n", step*33)
+		}
+
+		if len(args) > 1 {
+			if err := os.WriteFile(args[1], []byte("PK fake bugreport"), 0o600); err != nil {
+				fail(1, "adb: error: %v", err)
+			}
+		}
+
+	case "exec-out":
+		if len(args) < 2 || args[1] != "screencap" {
+			fail(1, "fakeadb: only exec-out screencap is supported")
+		}
+
+		os.Stdout.Write(adbtest.BlankScreenshot())
+
+	case "reboot":
+
+	default:
+		fail(1, "adb: unknown command %s", command)
+	}
+}
+
+func shell(scriptPath string, script adbtest.Script, serial string, args []string) {
+	command := strings.Join(args, " ")
+	fields := strings.Fields(command)
+
+	if len(fields) == 0 {
+		fail(1, "fakeadb: interactive shells aren't supported")
+	}
+
+	switch {
+	case len(fields) >= 3 && fields[0] == "pm" && fields[1] == "list" && fields[2] == "packages":
+		system := contains(fields, "-s")
+		thirdParty := contains(fields, "-3")
+
+		for _, pkg := range script.Packages[serial] {
+			if (system && !pkg.System) || (thirdParty && pkg.System) {
+				continue
+			}
+
+			if contains(fields, "-f") {
+				fmt.Printf("package:%s=%s\n", pkg.Path, pkg.Name)
+			} else {
+				fmt.Printf("package:%s\n", pkg.Name)
+			}
+		}
+
+	case len(fields) >= 3 && fields[0] == "pm" && fields[1] == "uninstall":
+		name := fields[len(fields)-1]
+
+		for index, pkg := range script.Packages[serial] {
+			if pkg.Name == name {
+				// Gone for every later invocation too, like on a real device
+				script.Packages[serial] = slices.Delete(script.Packages[serial], index, index+1)
+
+				if err := adbtest.SaveScript(scriptPath, script); err != nil {
+					fail(1, "fakeadb: %v", err)
+				}
+
+				fmt.Println("Success")
+				return
+			}
+		}
+
+		// What pm really says for a package that isn't there
+		fmt.Println("Failure [DELETE_FAILED_INTERNAL_ERROR]")
+
+	default:
+		output, ok := script.Shell[command]
+		if !ok {
+			fail(127, "/system/bin/sh: %s: inaccessible or not found", fields[0])
+		}
+
+		fmt.Print(output)
+	}
+}
+
+func hasDevice(script adbtest.Script, serial string) bool {
+	for _, device := range script.Devices {
+		if device.Serial == serial {
+			return true
+		}
+	}
+
+	return false
+}
+
+func contains(fields []string, flag string) bool {
+	for _, field := range fields {
+		if field == flag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func logInvocation(executable string, args []string) {
+	file, err := os.OpenFile(adbtest.InvocationLogPath(executable), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	line, _ := json.Marshal(args)
+	_, _ = file.Write(append(line, '\n'))
+}
+
+func fail(exitCode int, format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(exitCode)
+}
@@ -0,0 +1,652 @@
+package fakehost
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Android's own tools, as far as the simulation goes
+
+func runGetprop(shell *shell, command *command) int {
+	shell.device.mu.Lock()
+	defer shell.device.mu.Unlock()
+
+	if len(command.args) > 1 {
+		fmt.Fprintln(command.stdout, shell.device.props[command.args[1]])
+		return 0
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(shell.device.props)) {
+		fmt.Fprintf(command.stdout, "[%s]: [%s]\n", key, shell.device.props[key])
+	}
+
+	return 0
+}
+
+func runSetprop(shell *shell, command *command) int {
+	if len(command.args) != 3 {
+		fmt.Fprintln(command.stderr, "usage: setprop NAME VALUE")
+		return 1
+	}
+
+	if strings.HasPrefix(command.args[1], "ro.") {
+		fmt.Fprintf(command.stderr, "Failed to set property '%s' to '%s'.\n", command.args[1], command.args[2])
+		return 1
+	}
+
+	shell.device.mu.Lock()
+	shell.device.props[command.args[1]] = command.args[2]
+	shell.device.mu.Unlock()
+
+	return 0
+}
+
+// cmd package is the same as pm on current Android
+func runCmd(shell *shell, command *command) int {
+	if len(command.args) < 2 || command.args[1] != "package" {
+		fmt.Fprintf(command.stderr, "Can't find service: %s\n", strings.Join(command.args[1:], " "))
+		return 20
+	}
+
+	command.args = append([]string{"pm"}, command.args[2:]...)
+
+	return runPm(shell, command)
+}
+
+func runPm(shell *shell, command *command) int {
+	if len(command.args) < 2 {
+		fmt.Fprintln(command.stderr, "usage: pm [list|path|install|uninstall|clear] ...")
+		return 1
+	}
+
+	database := shell.device.packages
+	args := command.args[2:]
+
+	switch command.args[1] {
+	case "list":
+		if len(args) == 0 || args[0] != "packages" {
+			fmt.Fprintln(command.stderr, "Error: unknown list type")
+			return 1
+		}
+
+		var set = map[string]bool{}
+		var filter string
+
+		for index := 1; index < len(args); index++ {
+			switch {
+			case args[index] == "--user":
+				index++
+			case strings.HasPrefix(args[index], "-"):
+				set[args[index]] = true
+			default:
+				filter = args[index]
+			}
+		}
+
+		for _, installed := range database.list() {
+			switch {
+			case !installed.Installed && !set["-u"],
+				set["-3"] && installed.System,
+				set["-s"] && !installed.System,
+				!strings.Contains(installed.Name, filter):
+				continue
+			}
+
+			if set["-f"] {
+				fmt.Fprintf(command.stdout, "package:%s=%s\n", installed.Path, installed.Name)
+			} else {
+				fmt.Fprintf(command.stdout, "package:%s\n", installed.Name)
+			}
+		}
+
+		return 0
+	case "path":
+		if len(args) == 0 {
+			return 1
+		}
+
+		installed, ok := database.get(args[len(args)-1])
+		if !ok || !installed.Installed {
+			return 1
+		}
+
+		fmt.Fprintf(command.stdout, "package:%s\n", installed.Path)
+
+		return 0
+	case "install":
+		return shell.pmInstall(command, args)
+	case "uninstall":
+		keepData, forUser := false, false
+		var name string
+
+		for index := 0; index < len(args); index++ {
+			switch args[index] {
+			case "-k":
+				keepData = true
+			case "--user":
+				forUser = true
+				index++
+			default:
+				name = args[index]
+			}
+		}
+
+		installed, _ := database.get(name)
+
+		// Without --user a system app can't go, with it pm just hides it from that user
+		if installed.System && !forUser {
+			fmt.Fprintln(command.stdout, "Failure [DELETE_FAILED_INTERNAL_ERROR]")
+			return 1
+		}
+
+		if !database.remove(name, keepData) {
+			if forUser {
+				fmt.Fprintln(command.stdout, "Failure [not installed for 0]")
+			} else {
+				fmt.Fprintln(command.stdout, "Failure [DELETE_FAILED_INTERNAL_ERROR]")
+			}
+
+			return 1
+		}
+
+		if !installed.System && !keepData {
+			_ = shell.device.files.remove(path.Dir(path.Dir(installed.Path)), true)
+		}
+
+		shell.device.screen.stop(name)
+		shell.device.logcat.log('I', "PackageManager", "Package %s uninstalled (keepData=%t)", name, keepData)
+		fmt.Fprintln(command.stdout, "Success")
+
+		return 0
+	case "clear":
+		if len(args) == 0 {
+			return 1
+		}
+
+		if _, ok := database.get(args[len(args)-1]); !ok {
+			fmt.Fprintln(command.stdout, "Failed")
+			return 1
+		}
+
+		fmt.Fprintln(command.stdout, "Success")
+
+		return 0
+	}
+
+	fmt.Fprintf(command.stderr, "Unknown command: %s\n", command.args[1])
+
+	return 1
+}
+
+func (shell *shell) pmInstall(command *command, args []string) int {
+	replace := false
+	var apkPath string
+
+	for index := 0; index < len(args); index++ {
+		switch args[index] {
+		case "-r":
+			replace = true
+		case "--user", "-i", "--install-reason", "--abi":
+			index++
+		default:
+			if !strings.HasPrefix(args[index], "-") {
+				apkPath = args[index]
+			}
+		}
+	}
+
+	if apkPath == "" {
+		fmt.Fprintln(command.stderr, "Error: no package specified")
+		return 1
+	}
+
+	data, err := shell.device.files.readFile(apkPath)
+	if err != nil {
+		fmt.Fprintf(command.stdout, "Failure [INSTALL_FAILED_INVALID_URI: Error: Unable to open file: %s]\n", apkPath)
+		return 1
+	}
+
+	name, err := apkPackageName(data, apkPath)
+	if err != nil {
+		fmt.Fprintf(command.stdout, "Failure [INSTALL_PARSE_FAILED_NOT_APK: Failed to parse %s: %v]\n", apkPath, err)
+		return 1
+	}
+
+	if installed, ok := shell.device.packages.get(name); ok && installed.Installed && !replace {
+		fmt.Fprintf(command.stdout, "Failure [INSTALL_FAILED_ALREADY_EXISTS: Attempt to re-install %s without first uninstalling.]\n", name)
+		return 1
+	}
+
+	installedPath := shell.device.packages.add(name)
+
+	if err := shell.device.files.writeFile(installedPath, data, 0o644, time.Now()); err != nil {
+		fmt.Fprintf(command.stdout, "Failure [INSTALL_FAILED_INTERNAL_ERROR: %v]\n", err)
+		return 1
+	}
+
+	shell.device.logcat.log('I', "PackageManager", "Package %s installed at %s", name, path.Dir(installedPath))
+	fmt.Fprintln(command.stdout, "Success")
+
+	return 0
+}
+
+func runAm(shell *shell, command *command) int {
+	if len(command.args) < 2 {
+		fmt.Fprintln(command.stderr, "usage: am [start|force-stop] ...")
+		return 1
+	}
+
+	args := command.args[2:]
+
+	switch command.args[1] {
+	case "start", "start-activity":
+		var component string
+
+		for index := 0; index < len(args); index++ {
+			if args[index] == "-n" && index+1 < len(args) {
+				component = args[index+1]
+				index++
+			}
+		}
+
+		name, _, _ := strings.Cut(component, "/")
+		if installed, ok := shell.device.packages.get(name); name == "" || !ok || !installed.Installed {
+			fmt.Fprintf(command.stderr, "Error: Activity class {%s} does not exist.\n", component)
+			return 1
+		}
+
+		fmt.Fprintf(command.stdout, "Starting: Intent { cmp=%s }\n", component)
+		shell.launch(name)
+
+		return 0
+	case "force-stop", "kill":
+		if len(args) > 0 {
+			shell.device.screen.stop(args[len(args)-1])
+		}
+
+		return 0
+	}
+
+	fmt.Fprintf(command.stderr, "Error: unknown command '%s'\n", command.args[1])
+
+	return 1
+}
+
+// monkey -p <package> -c android.intent.category.LAUNCHER 1 is the usual way to open an app by package
+func runMonkey(shell *shell, command *command) int {
+	for index := 1; index+1 < len(command.args); index++ {
+		if command.args[index] != "-p" {
+			continue
+		}
+
+		name := command.args[index+1]
+		if installed, ok := shell.device.packages.get(name); !ok || !installed.Installed {
+			fmt.Fprintf(command.stderr, "** No activities found to run, monkey aborted.\n")
+			return 251
+		}
+
+		shell.launch(name)
+		fmt.Fprintln(command.stdout, "Events injected: 1")
+
+		return 0
+	}
+
+	fmt.Fprintln(command.stderr, "** Error: no package given")
+
+	return 1
+}
+
+func (shell *shell) launch(name string) {
+	shell.device.screen.launch(name)
+	shell.device.logcat.log('I', "ActivityTaskManager", "START u0 {act=android.intent.action.MAIN cmp=%s/.MainActivity} from uid 2000", name)
+}
+
+// Key codes input keyevent understands, by number and by name
+var keyCodes = map[string]string{
+	"3": "HOME", "4": "BACK", "26": "POWER", "223": "SLEEP", "224": "WAKEUP",
+}
+
+func runInput(shell *shell, command *command) int {
+	args := command.args[1:]
+	if len(args) > 0 && (args[0] == "touchscreen" || args[0] == "keyboard") {
+		args = args[1:]
+	}
+
+	number := func(index int) (int, bool) {
+		if index >= len(args) {
+			return 0, false
+		}
+
+		value, err := strconv.ParseFloat(args[index], 64)
+
+		return int(value), err == nil
+	}
+
+	if len(args) == 0 {
+		fmt.Fprintln(command.stderr, "usage: input [tap|swipe|keyevent|text] ...")
+		return 1
+	}
+
+	switch args[0] {
+	case "tap", "swipe":
+		// A swipe is remembered where it ends
+		xIndex := 1
+		if args[0] == "swipe" {
+			xIndex = 3
+		}
+
+		x, okX := number(xIndex)
+		y, okY := number(xIndex + 1)
+
+		if !okX || !okY {
+			fmt.Fprintf(command.stderr, "Error: %s needs coordinates\n", args[0])
+			return 1
+		}
+
+		if launched := shell.device.screen.tap(x, y, shell.device.launcherApps()); launched != "" {
+			shell.launch(launched)
+		}
+
+		shell.device.logcat.log('D', "InputDispatcher", "%s at (%d, %d)", args[0], x, y)
+
+		return 0
+	case "keyevent":
+		for _, key := range args[1:] {
+			name := strings.TrimPrefix(key, "KEYCODE_")
+			if named, ok := keyCodes[name]; ok {
+				name = named
+			}
+
+			switch name {
+			case "HOME", "BACK":
+				shell.device.screen.home()
+			case "POWER":
+				shell.device.screen.togglePower()
+			case "SLEEP":
+				shell.device.screen.setPower(false)
+			case "WAKEUP":
+				shell.device.screen.setPower(true)
+			}
+
+			shell.device.logcat.log('D', "InputDispatcher", "key %s", name)
+		}
+
+		return 0
+	case "text":
+		shell.device.logcat.log('D', "InputDispatcher", "text %q", strings.Join(args[1:], " "))
+		return 0
+	}
+
+	fmt.Fprintf(command.stderr, "Error: Unknown command: %s\n", args[0])
+
+	return 1
+}
+
+func runWm(shell *shell, command *command) int {
+	if len(command.args) < 2 {
+		fmt.Fprintln(command.stderr, "usage: wm [size|density]")
+		return 1
+	}
+
+	switch command.args[1] {
+	case "size":
+		width, height := shell.device.screen.size()
+		fmt.Fprintf(command.stdout, "Physical size: %dx%d\n", width, height)
+	case "density":
+		shell.device.mu.Lock()
+		density := shell.device.props["ro.sf.lcd_density"]
+		shell.device.mu.Unlock()
+
+		fmt.Fprintf(command.stdout, "Physical density: %s\n", density)
+	default:
+		fmt.Fprintf(command.stderr, "Unknown command: %s\n", command.args[1])
+		return 1
+	}
+
+	return 0
+}
+
+// screencap [-p] [file]: PNG with -p or a .png file name, raw pixels otherwise
+func runScreencap(shell *shell, command *command) int {
+	set, operands := flags(command.args[1:])
+
+	asPNG := set['p'] || (len(operands) > 0 && strings.HasSuffix(operands[0], ".png"))
+	apps := shell.device.launcherApps()
+
+	var data []byte
+
+	if asPNG {
+		var err error
+		if data, err = shell.device.screen.png(apps); err != nil {
+			fmt.Fprintf(command.stderr, "screencap: %v\n", err)
+			return 1
+		}
+	} else {
+		data = shell.device.screen.raw(apps)
+	}
+
+	if len(operands) == 0 {
+		_, _ = command.stdout.Write(data)
+		return 0
+	}
+
+	if err := shell.device.files.writeFile(operands[0], data, 0o660, time.Now()); err != nil {
+		fmt.Fprintf(command.stderr, "Error opening file: %s (%v)\n", operands[0], err)
+		return 1
+	}
+
+	return 0
+}
+
+// Log priorities from least to most severe, S silences a tag
+const logPriorities = "VDIWEFS"
+
+func runLogcat(shell *shell, command *command) int {
+	dump, tail, maxCount := false, -1, -1
+	minimum := map[string]int{"*": 0}
+
+	args := command.args[1:]
+	for index := 0; index < len(args); index++ {
+		value := func() string {
+			if index+1 < len(args) {
+				index++
+				return args[index]
+			}
+			return ""
+		}
+
+		switch arg := args[index]; {
+		case arg == "-d":
+			dump = true
+		case arg == "-c":
+			shell.device.logcat.clear()
+			return 0
+		case arg == "-t" || arg == "-T":
+			tail, _ = strconv.Atoi(value())
+			dump = dump || arg == "-t"
+		case arg == "-m":
+			maxCount, _ = strconv.Atoi(value())
+		case arg == "-v" || arg == "-b" || arg == "-e" || arg == "--pid":
+			value()
+		case arg == "-s":
+			minimum["*"] = len(logPriorities) - 1
+		case strings.HasPrefix(arg, "-"):
+		default:
+			tag, priority, found := strings.Cut(arg, ":")
+			if level := strings.IndexByte(logPriorities, strings.ToUpper(priority + "V")[0]); found && level != -1 {
+				minimum[tag] = level
+			} else {
+				minimum[tag] = 0
+			}
+		}
+	}
+
+	shown := func(line logLine) bool {
+		level, ok := minimum[line.tag]
+		if !ok {
+			level = minimum["*"]
+		}
+
+		return strings.IndexByte(logPriorities, line.priority) >= level
+	}
+
+	var lines []logLine
+	var next int
+
+	if tail >= 0 {
+		lines, next = shell.device.logcat.tail(tail)
+	} else {
+		lines, next = shell.device.logcat.since(0)
+	}
+
+	printed := 0
+
+	write := func(lines []logLine) bool {
+		for _, line := range lines {
+			if maxCount >= 0 && printed >= maxCount {
+				return false
+			}
+
+			if !shown(line) {
+				continue
+			}
+
+			if _, err := fmt.Fprintln(command.stdout, line.String()); err != nil {
+				return false
+			}
+
+			printed++
+		}
+
+		return true
+	}
+
+	if !write(lines) || dump {
+		return 0
+	}
+
+	// Follow until the client hangs up
+	poll := time.NewTicker(min(max(shell.device.logcat.interval, 50*time.Millisecond), 250*time.Millisecond))
+	defer poll.Stop()
+
+	for {
+		select {
+		case <-shell.ctx.Done():
+			return 130
+		case <-poll.C:
+			lines, next = shell.device.logcat.since(next)
+
+			if !write(lines) {
+				return 0
+			}
+		}
+	}
+}
+
+// bugreportz -p is what adb bugreport <file> drives: progress lines, then OK:<path> to pull
+func runBugreportz(shell *shell, command *command) int {
+	set, _ := flags(command.args[1:])
+
+	if set['v'] {
+		fmt.Fprintln(command.stderr, "1.2")
+		return 0
+	}
+
+	device := shell.device
+	name := fmt.Sprintf("bugreport-%s-FAKE.%d-%s", device.spec.Product, device.spec.SDK, time.Now().Format("2006-01-02-15-04-05"))
+	zipPath := "/bugreports/" + name + ".zip"
+
+	if set['p'] {
+		fmt.Fprintf(command.stdout, "BEGIN:%s\n", zipPath)
+
+		for progress := 10; progress < 100; progress += 15 {
+			select {
+			case <-shell.ctx.Done():
+				return 130
+			case <-time.After(100 * time.Millisecond):
+			}
+
+			fmt.Fprintf(command.stdout, "PROGRESS:%d/100\n", progress)
+		}
+	}
+
+	var archive bytes.Buffer
+	writer := zip.NewWriter(&archive)
+
+	for _, file := range []struct{ name, content string }{
+		{name + ".txt", shell.bugreportText()},
+		{"main_entry.txt", name + ".txt"},
+		{"version.txt", "2.0"},
+	} {
+		entry, err := writer.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: time.Now()})
+		if err == nil {
+			_, err = entry.Write([]byte(file.content))
+		}
+
+		if err != nil {
+			fmt.Fprintf(command.stdout, "FAIL:%v\n", err)
+			return 1
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		fmt.Fprintf(command.stdout, "FAIL:%v\n", err)
+		return 1
+	}
+
+	if err := device.files.writeFile(zipPath, archive.Bytes(), 0o644, time.Now()); err != nil {
+		fmt.Fprintf(command.stdout, "FAIL:%v\n", err)
+		return 1
+	}
+
+	fmt.Fprintf(command.stdout, "OK:%s\n", zipPath)
+
+	return 0
+}
+
+// Plain bugreport is the old text-only flavour
+func runBugreport(shell *shell, command *command) int {
+	fmt.Fprint(command.stdout, shell.bugreportText())
+	return 0
+}
+
+func (shell *shell) bugreportText() string {
+	var report strings.Builder
+
+	fmt.Fprintf(&report, "========================================================\n== dumpstate: %s\n========================================================\n\n", time.Now().Format("2006-01-02 15:04:05"))
+
+	sections := []struct {
+		title string
+		line  string
+	}{
+		{"SYSTEM PROPERTIES", "getprop"},
+		{"PACKAGES", "pm list packages -f -u"},
+		{"SYSTEM LOG", "logcat -d"},
+	}
+
+	for _, section := range sections {
+		fmt.Fprintf(&report, "------ %s (%s) ------\n", section.title, section.line)
+		shell.device.runShell(shell.ctx, section.line, &report, &report)
+		report.WriteString("\n")
+	}
+
+	return report.String()
+}
+
+func runReboot(shell *shell, command *command) int {
+	mode := ""
+	if len(command.args) > 1 {
+		mode = command.args[1]
+	}
+
+	shell.device.reboot(mode)
+
+	return 0
+}
@@ -0,0 +1,285 @@
+package fakehost
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DeviceSpec describes a simulated device. Everything but Serial has a default.
+type DeviceSpec struct {
+	Serial       string            `json:"serial"`
+	State        string            `json:"state,omitempty"` // device, unauthorized or offline
+	Model        string            `json:"model,omitempty"` // as adb devices -l shows it, e.g. Pixel_7a
+	Manufacturer string            `json:"manufacturer,omitempty"`
+	Product      string            `json:"product,omitempty"` // also the device name, e.g. lynx
+	Release      string            `json:"release,omitempty"` // Android version, e.g. 14
+	SDK          int               `json:"sdk,omitempty"`
+	ScreenWidth  int               `json:"screen_width,omitempty"`
+	ScreenHeight int               `json:"screen_height,omitempty"`
+	Packages     []string          `json:"packages,omitempty"` // third-party apps installed from the start
+	Files        map[string]string `json:"files,omitempty"`    // device path -> content
+}
+
+// Presets handed out to specs without a model, in turn
+var devicePresets = []DeviceSpec{
+	{Model: "Pixel_7a", Manufacturer: "Google", Product: "lynx", Release: "14", SDK: 34, ScreenWidth: 1080, ScreenHeight: 2400},
+	{Model: "Pixel_8", Manufacturer: "Google", Product: "shiba", Release: "15", SDK: 35, ScreenWidth: 1080, ScreenHeight: 2400},
+	{Model: "SM_S911B", Manufacturer: "samsung", Product: "dm1q", Release: "13", SDK: 33, ScreenWidth: 1080, ScreenHeight: 2340},
+	{Model: "moto_g_power_5G", Manufacturer: "motorola", Product: "devonn", Release: "13", SDK: 33, ScreenWidth: 720, ScreenHeight: 1600},
+}
+
+// System apps that get a launcher tile, next to every third-party app
+var launcherSystemApps = []string{"com.android.chrome", "com.android.settings", "com.android.vending", "com.google.android.youtube"}
+
+// Device is one simulated phone. It's safe to poke at from tests while clients use it.
+type Device struct {
+	spec        DeviceSpec
+	transportID int64
+	rebootDelay time.Duration
+	changed     func() // tells the server device lists are stale
+
+	mu       sync.Mutex
+	state    string
+	hidden   bool // rebooting, not listed at all
+	bootedAt time.Time
+	props    map[string]string
+
+	files    *fileSystem
+	packages *packageDatabase
+	logcat   *logcat
+	screen   *screen
+}
+
+func newDevice(spec DeviceSpec, preset DeviceSpec, transportID int64, options Options, changed func()) (*Device, error) {
+	if spec.Serial == "" || strings.ContainsAny(spec.Serial, " \t\n") {
+		return nil, fmt.Errorf("device serial %q is empty or has spaces", spec.Serial)
+	}
+
+	switch spec.State {
+	case "":
+		spec.State = "device"
+	case "device", "unauthorized", "offline", "recovery", "sideload", "bootloader":
+	default:
+		return nil, fmt.Errorf("device %s: unknown state %q", spec.Serial, spec.State)
+	}
+
+	if spec.Model == "" {
+		spec.Model, spec.Manufacturer, spec.Product = preset.Model, preset.Manufacturer, preset.Product
+	}
+
+	spec.Manufacturer = cmp.Or(spec.Manufacturer, preset.Manufacturer)
+	spec.Product = cmp.Or(spec.Product, preset.Product)
+	spec.Release = cmp.Or(spec.Release, preset.Release)
+	spec.SDK = cmp.Or(spec.SDK, preset.SDK)
+	spec.ScreenWidth = cmp.Or(spec.ScreenWidth, preset.ScreenWidth)
+	spec.ScreenHeight = cmp.Or(spec.ScreenHeight, preset.ScreenHeight)
+
+	seed := fnv.New64a()
+	_, _ = seed.Write([]byte(spec.Serial))
+
+	device := &Device{
+		spec:        spec,
+		transportID: transportID,
+		rebootDelay: options.RebootDelay,
+		changed:     changed,
+		state:       spec.State,
+		bootedAt:    time.Now(),
+		files:       newFileSystem(),
+		packages:    newPackageDatabase(),
+		logcat:      newLogcat(options.LogcatInterval, seed.Sum64()),
+		screen:      newScreen(spec.ScreenWidth, spec.ScreenHeight, spec.Serial),
+	}
+
+	device.props = map[string]string{
+		"ro.product.model":           strings.ReplaceAll(spec.Model, "_", " "),
+		"ro.product.manufacturer":    spec.Manufacturer,
+		"ro.product.brand":           strings.ToLower(spec.Manufacturer),
+		"ro.product.name":            spec.Product,
+		"ro.product.device":          spec.Product,
+		"ro.build.version.release":   spec.Release,
+		"ro.build.version.sdk":       fmt.Sprint(spec.SDK),
+		"ro.build.type":              "user",
+		"ro.build.fingerprint":       fmt.Sprintf("%s/%s/%s:%s/FAKE.%d/1:user/release-keys", strings.ToLower(spec.Manufacturer), spec.Product, spec.Product, spec.Release, spec.SDK),
+		"ro.serialno":                spec.Serial,
+		"ro.debuggable":              "0",
+		"sys.boot_completed":         "1",
+		"persist.sys.timezone":       "UTC",
+		"ro.product.cpu.abi":         "arm64-v8a",
+		"ro.sf.lcd_density":          "420",
+		"ro.kernel.qemu":             "0",
+		"gsm.sim.operator.alpha":     "Fake Mobile",
+		"ro.boot.serialno":           spec.Serial,
+		"ro.product.first_api_level": fmt.Sprint(spec.SDK),
+	}
+
+	for _, dir := range []string{"/sdcard/Download", "/sdcard/DCIM/Camera", "/sdcard/Pictures", "/sdcard/Movies", "/data/local/tmp", "/data/app", "/bugreports", "/system/bin"} {
+		if err := device.files.mkdirAll(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	var buildProp strings.Builder
+	for _, key := range slices.Sorted(maps.Keys(device.props)) {
+		fmt.Fprintf(&buildProp, "%s=%s\n", key, device.props[key])
+	}
+
+	if err := device.files.writeFile("/system/build.prop", []byte(buildProp.String()), 0o644, device.bootedAt); err != nil {
+		return nil, err
+	}
+
+	for name, content := range spec.Files {
+		if err := device.files.writeFile(name, []byte(content), 0o660, device.bootedAt); err != nil {
+			return nil, fmt.Errorf("device %s: file %s: %w", spec.Serial, name, err)
+		}
+	}
+
+	for _, name := range spec.Packages {
+		if !packageNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("device %s: %q isn't a package name", spec.Serial, name)
+		}
+
+		device.packages.add(name)
+	}
+
+	return device, nil
+}
+
+func (device *Device) Serial() string {
+	return device.spec.Serial
+}
+
+func (device *Device) State() string {
+	device.mu.Lock()
+	defer device.mu.Unlock()
+
+	return device.state
+}
+
+// SetState flips the device to device, unauthorized, offline, ... as if the user accepted
+// the RSA prompt or pulled the cable halfway
+func (device *Device) SetState(state string) {
+	device.mu.Lock()
+	device.state = state
+	device.mu.Unlock()
+
+	device.changed()
+}
+
+// ReadFile is a file from the device's storage
+func (device *Device) ReadFile(name string) ([]byte, error) {
+	return device.files.readFile(name)
+}
+
+// WriteFile puts a file on the device's storage, creating its directories
+func (device *Device) WriteFile(name string, data []byte) error {
+	return device.files.writeFile(name, data, 0o660, time.Now())
+}
+
+// Packages lists installed package names, system ones included
+func (device *Device) Packages() []string {
+	var names []string
+
+	for _, installed := range device.packages.list() {
+		if installed.Installed {
+			names = append(names, installed.Name)
+		}
+	}
+
+	return names
+}
+
+// visible is whether the device shows up in adb devices, and as what
+func (device *Device) visible() (string, bool) {
+	device.mu.Lock()
+	defer device.mu.Unlock()
+
+	return device.state, !device.hidden
+}
+
+// errUnusable is what adb says when a device is listed but can't take commands
+func (device *Device) errUnusable() error {
+	state, visible := device.visible()
+
+	switch {
+	case !visible:
+		return fmt.Errorf("device '%s' not found", device.spec.Serial)
+	case state == "unauthorized":
+		return errors.New("device unauthorized.\nThis adb server's $ADB_VENDOR_KEYS is not set\nTry 'adb kill-server' if that seems wrong.\nOtherwise check for a confirmation dialog on your device.")
+	case state == "offline":
+		return errors.New("device offline")
+	case state == "bootloader":
+		return errors.New("device still connecting")
+	}
+
+	return nil
+}
+
+// launcherApps are the apps with a tile on the home screen, in tile order
+func (device *Device) launcherApps() []string {
+	var apps []string
+
+	for _, installed := range device.packages.list() {
+		if installed.Installed && (!installed.System || slices.Contains(launcherSystemApps, installed.Name)) {
+			apps = append(apps, installed.Name)
+		}
+	}
+
+	return apps
+}
+
+// reboot drops the device off the bus for a while and brings it back in the state the mode
+// boots into. The bootloader has no adb, pretend someone ran fastboot reboot.
+func (device *Device) reboot(mode string) {
+	device.mu.Lock()
+	device.hidden = true
+	device.mu.Unlock()
+
+	device.changed()
+
+	go func() {
+		time.Sleep(device.rebootDelay)
+
+		state := "device"
+		switch mode {
+		case "recovery":
+			state = "recovery"
+		case "sideload", "sideload-auto-reboot":
+			state = "sideload"
+		}
+
+		device.logcat.clear()
+		device.screen.reset()
+
+		device.mu.Lock()
+		device.hidden = false
+		device.state = state
+		device.bootedAt = time.Now()
+		device.mu.Unlock()
+
+		device.logcat.log('I', "Zygote", "System server process has started")
+		device.changed()
+	}()
+}
+
+// devicesLine is this device's line in adb devices (-l)
+func (device *Device) devicesLine(long bool) string {
+	state, _ := device.visible()
+
+	if !long {
+		return device.spec.Serial + "\t" + state
+	}
+
+	if state != "device" {
+		return fmt.Sprintf("%-22s %s usb:1-%d transport_id:%d", device.spec.Serial, state, device.transportID, device.transportID)
+	}
+
+	return fmt.Sprintf("%-22s %s usb:1-%d product:%s model:%s device:%s transport_id:%d",
+		device.spec.Serial, state, device.transportID, device.spec.Product, device.spec.Model, device.spec.Product, device.transportID)
+}
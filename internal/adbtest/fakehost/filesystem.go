@@ -0,0 +1,215 @@
+package fakehost
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Unix mode bits as adbd reports them over sync
+const (
+	modeDir  = 0o040000
+	modeFile = 0o100000
+)
+
+var (
+	errNotExist = errors.New("No such file or directory")
+	errIsDir    = errors.New("Is a directory")
+	errNotDir   = errors.New("Not a directory")
+	errNotEmpty = errors.New("Directory not empty")
+)
+
+type fileEntry struct {
+	dir     bool
+	perm    fs.FileMode
+	data    []byte
+	modTime time.Time
+}
+
+func (entry *fileEntry) mode() uint32 {
+	if entry.dir {
+		return modeDir | uint32(entry.perm)
+	}
+
+	return modeFile | uint32(entry.perm)
+}
+
+// fileSystem is a device's storage, flat paths to entries. Parents always exist.
+type fileSystem struct {
+	mu      sync.Mutex
+	entries map[string]*fileEntry
+}
+
+func newFileSystem() *fileSystem {
+	return &fileSystem{entries: map[string]*fileEntry{
+		"/": {dir: true, perm: 0o755, modTime: time.Now()},
+	}}
+}
+
+// cleanPath makes a device path absolute, the shell starts in /
+func cleanPath(name string) string {
+	return path.Clean("/" + name)
+}
+
+func (fileSystem *fileSystem) stat(name string) (fileEntry, bool) {
+	fileSystem.mu.Lock()
+	defer fileSystem.mu.Unlock()
+
+	entry, ok := fileSystem.entries[cleanPath(name)]
+	if !ok {
+		return fileEntry{}, false
+	}
+
+	return *entry, true
+}
+
+func (fileSystem *fileSystem) readFile(name string) ([]byte, error) {
+	fileSystem.mu.Lock()
+	defer fileSystem.mu.Unlock()
+
+	entry, ok := fileSystem.entries[cleanPath(name)]
+	if !ok {
+		return nil, errNotExist
+	}
+
+	if entry.dir {
+		return nil, errIsDir
+	}
+
+	return entry.data, nil
+}
+
+// writeFile replaces a file's content, creating missing parents like adbd does for pushes
+func (fileSystem *fileSystem) writeFile(name string, data []byte, perm fs.FileMode, modTime time.Time) error {
+	fileSystem.mu.Lock()
+	defer fileSystem.mu.Unlock()
+
+	name = cleanPath(name)
+
+	if entry, ok := fileSystem.entries[name]; ok && entry.dir {
+		return errIsDir
+	}
+
+	if err := fileSystem.mkdirAllLocked(path.Dir(name)); err != nil {
+		return err
+	}
+
+	fileSystem.entries[name] = &fileEntry{perm: perm, data: data, modTime: modTime}
+
+	return nil
+}
+
+func (fileSystem *fileSystem) appendFile(name string, data []byte) error {
+	existing, err := fileSystem.readFile(name)
+	if err != nil && !errors.Is(err, errNotExist) {
+		return err
+	}
+
+	return fileSystem.writeFile(name, append(slices.Clip(existing), data...), 0o644, time.Now())
+}
+
+func (fileSystem *fileSystem) mkdirAll(name string) error {
+	fileSystem.mu.Lock()
+	defer fileSystem.mu.Unlock()
+
+	return fileSystem.mkdirAllLocked(cleanPath(name))
+}
+
+func (fileSystem *fileSystem) mkdirAllLocked(name string) error {
+	if entry, ok := fileSystem.entries[name]; ok {
+		if !entry.dir {
+			return errNotDir
+		}
+
+		return nil
+	}
+
+	if err := fileSystem.mkdirAllLocked(path.Dir(name)); err != nil {
+		return err
+	}
+
+	fileSystem.entries[name] = &fileEntry{dir: true, perm: 0o771, modTime: time.Now()}
+
+	return nil
+}
+
+func (fileSystem *fileSystem) remove(name string, recursive bool) error {
+	fileSystem.mu.Lock()
+	defer fileSystem.mu.Unlock()
+
+	name = cleanPath(name)
+
+	entry, ok := fileSystem.entries[name]
+	if !ok {
+		return errNotExist
+	}
+
+	if name == "/" {
+		return errors.New("Read-only file system")
+	}
+
+	if entry.dir {
+		prefix := name + "/"
+
+		for child := range fileSystem.entries {
+			if !strings.HasPrefix(child, prefix) {
+				continue
+			}
+
+			if !recursive {
+				return errNotEmpty
+			}
+
+			delete(fileSystem.entries, child)
+		}
+	}
+
+	delete(fileSystem.entries, name)
+
+	return nil
+}
+
+type dirEntry struct {
+	name string
+	fileEntry
+}
+
+// list returns a directory's direct children sorted by name
+func (fileSystem *fileSystem) list(name string) ([]dirEntry, error) {
+	fileSystem.mu.Lock()
+	defer fileSystem.mu.Unlock()
+
+	name = cleanPath(name)
+
+	entry, ok := fileSystem.entries[name]
+	if !ok {
+		return nil, errNotExist
+	}
+
+	if !entry.dir {
+		return []dirEntry{{name: path.Base(name), fileEntry: *entry}}, nil
+	}
+
+	prefix := strings.TrimSuffix(name, "/") + "/"
+
+	var children []dirEntry
+
+	for child, childEntry := range fileSystem.entries {
+		rest, found := strings.CutPrefix(child, prefix)
+		if !found || rest == "" || strings.Contains(rest, "/") {
+			continue
+		}
+
+		children = append(children, dirEntry{name: rest, fileEntry: *childEntry})
+	}
+
+	slices.SortFunc(children, func(a, b dirEntry) int {
+		return strings.Compare(a.name, b.name)
+	})
+
+	return children, nil
+}
@@ -0,0 +1,150 @@
+package fakehost
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Keep about as much as a real device's main buffer holds for a quiet phone
+const logcatBufferLines = 5000
+
+type logLine struct {
+	time     time.Time
+	pid      int
+	tid      int
+	priority byte // V D I W E F
+	tag      string
+	message  string
+}
+
+// threadtime is logcat's default format
+func (line logLine) String() string {
+	return fmt.Sprintf("%s %5d %5d %c %s: %s", line.time.Format("01-02 15:04:05.000"), line.pid, line.tid, line.priority, line.tag, line.message)
+}
+
+// Chatter the generator picks from, roughly what an idle phone logs. Each takes two numbers.
+var logcatTemplates = []struct {
+	priority byte
+	tag      string
+	message  string
+}{
+	{'I', "ActivityManager", "Start proc %d:com.google.android.gms.persistent/u0a%d for service"},
+	{'D', "ConnectivityService", "NetworkAgentInfo [WIFI () - %d] validation passed in %dms"},
+	{'I', "chatty", "uid=%d(system) identical %d lines"},
+	{'D', "BatteryService", "level:%d, scale:100, status:2, health:2, temperature:%d"},
+	{'W', "ziparchive", "Unable to open '/data/app/%d/base.dm': No such file or directory (%d)"},
+	{'I', "WifiService", "getConnectionInfo uid=%d, rssi=-%d"},
+	{'D', "PowerManagerService", "acquireWakeLock flags=0x%x tag=*job* uid=%d"},
+	{'E', "GnssHal", "gnss_configuration: request %d failed, status %d"},
+	{'V', "AlarmManager", "Triggering alarm #%d at %d"},
+	{'I', "SurfaceFlinger", "Display %d HWC layers: %d"},
+}
+
+// logcat makes up lines at a steady rate, lazily: nothing is generated until someone reads
+type logcat struct {
+	mu        sync.Mutex
+	interval  time.Duration
+	lines     []logLine
+	first     int // sequence number of lines[0]
+	generated time.Time
+	random    *rand.Rand
+}
+
+func newLogcat(interval time.Duration, seed uint64) *logcat {
+	return &logcat{
+		interval:  interval,
+		generated: time.Now(),
+		random:    rand.New(rand.NewPCG(seed, seed>>1)),
+	}
+}
+
+// log adds a line right away, for things the device does on request (installs, taps)
+func (logcat *logcat) log(priority byte, tag, format string, args ...any) {
+	logcat.mu.Lock()
+	defer logcat.mu.Unlock()
+
+	logcat.generateLocked(time.Now())
+	logcat.appendLocked(logLine{
+		time:     time.Now(),
+		pid:      1000 + logcat.random.IntN(300),
+		tid:      1000 + logcat.random.IntN(3000),
+		priority: priority,
+		tag:      tag,
+		message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// since returns the lines from sequence number from on, and the number to ask for next
+func (logcat *logcat) since(from int) ([]logLine, int) {
+	logcat.mu.Lock()
+	defer logcat.mu.Unlock()
+
+	logcat.generateLocked(time.Now())
+
+	return logcat.sinceLocked(from)
+}
+
+// tail is the last count lines and the sequence number after them
+func (logcat *logcat) tail(count int) ([]logLine, int) {
+	logcat.mu.Lock()
+	defer logcat.mu.Unlock()
+
+	logcat.generateLocked(time.Now())
+
+	return logcat.sinceLocked(logcat.first + len(logcat.lines) - count)
+}
+
+func (logcat *logcat) sinceLocked(from int) ([]logLine, int) {
+	start := min(max(from-logcat.first, 0), len(logcat.lines))
+
+	return append([]logLine(nil), logcat.lines[start:]...), logcat.first + len(logcat.lines)
+}
+
+func (logcat *logcat) clear() {
+	logcat.mu.Lock()
+	defer logcat.mu.Unlock()
+
+	logcat.generateLocked(time.Now())
+	logcat.first += len(logcat.lines)
+	logcat.lines = nil
+}
+
+func (logcat *logcat) generateLocked(now time.Time) {
+	if logcat.interval <= 0 {
+		return
+	}
+
+	// A long idle gap only needs a buffer's worth, not every line since
+	if gap := now.Sub(logcat.generated); gap > logcat.interval*logcatBufferLines {
+		logcat.generated = now.Add(-logcat.interval * logcatBufferLines)
+	}
+
+	for next := logcat.generated.Add(logcat.interval); !next.After(now); next = next.Add(logcat.interval) {
+		template := logcatTemplates[logcat.random.IntN(len(logcatTemplates))]
+		pid := 500 + logcat.random.IntN(4000)
+
+		logcat.appendLocked(logLine{
+			time:     next,
+			pid:      pid,
+			tid:      pid + logcat.random.IntN(40),
+			priority: template.priority,
+			tag:      template.tag,
+			message:  fmt.Sprintf(template.message, logcat.random.IntN(10000), logcat.random.IntN(100)),
+		})
+
+		logcat.generated = next
+	}
+}
+
+func (logcat *logcat) appendLocked(line logLine) {
+	logcat.lines = append(logcat.lines, line)
+
+	// Trim in batches rather than copying the buffer for every line
+	if len(logcat.lines) > logcatBufferLines+logcatBufferLines/4 {
+		drop := len(logcat.lines) - logcatBufferLines
+		logcat.lines = append(logcat.lines[:0:0], logcat.lines[drop:]...)
+		logcat.first += drop
+	}
+}
@@ -0,0 +1,314 @@
+package fakehost
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf16"
+)
+
+// Preinstalled on every simulated device, listed by pm list packages -s
+var systemPackages = []string{
+	"android",
+	"com.android.chrome",
+	"com.android.phone",
+	"com.android.settings",
+	"com.android.systemui",
+	"com.android.vending",
+	"com.google.android.gms",
+	"com.google.android.youtube",
+}
+
+type installedPackage struct {
+	Name      string
+	Path      string
+	System    bool
+	Installed bool // false once uninstalled with -k or for a user, pm list packages -u still shows it
+}
+
+type packageDatabase struct {
+	mu       sync.Mutex
+	packages map[string]*installedPackage
+}
+
+func newPackageDatabase() *packageDatabase {
+	database := &packageDatabase{packages: map[string]*installedPackage{}}
+
+	for _, name := range systemPackages {
+		database.packages[name] = &installedPackage{
+			Name:      name,
+			Path:      "/system/app/" + name + "/" + name + ".apk",
+			System:    true,
+			Installed: true,
+		}
+	}
+
+	return database
+}
+
+func (database *packageDatabase) get(name string) (installedPackage, bool) {
+	database.mu.Lock()
+	defer database.mu.Unlock()
+
+	installed, ok := database.packages[name]
+	if !ok {
+		return installedPackage{}, false
+	}
+
+	return *installed, true
+}
+
+// list is sorted by name, pm itself doesn't promise an order
+func (database *packageDatabase) list() []installedPackage {
+	database.mu.Lock()
+	defer database.mu.Unlock()
+
+	packages := make([]installedPackage, 0, len(database.packages))
+	for _, installed := range database.packages {
+		packages = append(packages, *installed)
+	}
+
+	slices.SortFunc(packages, func(a, b installedPackage) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return packages
+}
+
+// add installs or replaces a third-party package, returning where its APK lives
+func (database *packageDatabase) add(name string) string {
+	database.mu.Lock()
+	defer database.mu.Unlock()
+
+	if installed, ok := database.packages[name]; ok && installed.Installed && !installed.System {
+		return installed.Path
+	}
+
+	// Same shape as Android 11+: /data/app/~~<random>/<package>-<random>/base.apk
+	apkPath := "/data/app/~~" + randomToken() + "/" + name + "-" + randomToken() + "/base.apk"
+
+	database.packages[name] = &installedPackage{Name: name, Path: apkPath, Installed: true}
+
+	return apkPath
+}
+
+func (database *packageDatabase) remove(name string, keepData bool) bool {
+	database.mu.Lock()
+	defer database.mu.Unlock()
+
+	installed, ok := database.packages[name]
+	if !ok || !installed.Installed {
+		return false
+	}
+
+	if keepData || installed.System {
+		installed.Installed = false
+	} else {
+		delete(database.packages, name)
+	}
+
+	return true
+}
+
+func randomToken() string {
+	token := make([]byte, 12)
+	_, _ = rand.Read(token)
+
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+var errNotAPK = errors.New("not a valid APK")
+
+var packageNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*(\.[A-Za-z][A-Za-z0-9_]*)+$`)
+
+// apkPackageName reads the package attribute out of an APK's binary AndroidManifest.xml. Zips
+// without a readable manifest fall back to the file name, so hand-made test APKs still install.
+func apkPackageName(data []byte, fileName string) (string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", errNotAPK
+	}
+
+	for _, file := range archive.File {
+		if file.Name != "AndroidManifest.xml" {
+			continue
+		}
+
+		reader, err := file.Open()
+		if err != nil {
+			break
+		}
+
+		manifest, err := io.ReadAll(io.LimitReader(reader, 16<<20))
+		reader.Close()
+
+		if err != nil {
+			break
+		}
+
+		if name := manifestPackage(manifest); packageNamePattern.MatchString(name) {
+			return name, nil
+		}
+
+		break
+	}
+
+	name := strings.TrimSuffix(path.Base(fileName), ".apk")
+	if packageNamePattern.MatchString(name) {
+		return name, nil
+	}
+
+	return "com.example." + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		if r >= 'A' && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return '_'
+	}, "app_"+name), nil
+}
+
+// Binary XML chunk types, see ResourceTypes.h in the Android framework
+const (
+	chunkStringPool   = 0x0001
+	chunkStartElement = 0x0102
+	stringPoolUTF8    = 1 << 8
+	typeString        = 0x03
+)
+
+// manifestPackage walks the chunks of a binary XML file to the <manifest> element. Returns ""
+// on anything it doesn't understand.
+func manifestPackage(manifest []byte) string {
+	u16 := func(offset int) int {
+		if offset < 0 || offset+2 > len(manifest) {
+			return -1
+		}
+		return int(binary.LittleEndian.Uint16(manifest[offset:]))
+	}
+	u32 := func(offset int) int {
+		if offset < 0 || offset+4 > len(manifest) {
+			return -1
+		}
+		return int(binary.LittleEndian.Uint32(manifest[offset:]))
+	}
+
+	var stringAt func(index int) string
+
+	// The file header is 8 bytes, chunks follow back to back
+	for offset := 8; offset+8 <= len(manifest); {
+		chunkType, headerSize, size := u16(offset), u16(offset+2), u32(offset+4)
+		if size < 8 || headerSize < 8 {
+			return ""
+		}
+
+		switch chunkType {
+		case chunkStringPool:
+			count, flags, stringsStart := u32(offset+8), u32(offset+16), u32(offset+20)
+			pool, poolHeader := offset, headerSize
+
+			stringAt = func(index int) string {
+				if index < 0 || index >= count {
+					return ""
+				}
+
+				start := pool + stringsStart + u32(pool+poolHeader+index*4)
+				if flags&stringPoolUTF8 != 0 {
+					return utf8PoolString(manifest, start)
+				}
+
+				return utf16PoolString(manifest, start)
+			}
+		case chunkStartElement:
+			if stringAt == nil {
+				return ""
+			}
+
+			body := offset + headerSize
+			if stringAt(u32(body+4)) != "manifest" {
+				return ""
+			}
+
+			attributeStart, attributeSize, attributeCount := u16(body+8), u16(body+10), u16(body+12)
+
+			for index := range max(attributeCount, 0) {
+				attribute := body + attributeStart + index*attributeSize
+				if attribute < 0 || attribute+20 > len(manifest) || stringAt(u32(attribute+4)) != "package" {
+					continue
+				}
+
+				if raw := u32(attribute + 8); raw != 0xffffffff {
+					return stringAt(raw)
+				}
+
+				if manifest[attribute+15] == typeString {
+					return stringAt(u32(attribute + 16))
+				}
+			}
+
+			return ""
+		}
+
+		offset += size
+	}
+
+	return ""
+}
+
+// Pool strings are prefixed by their length, which takes two units when the high bit is set
+func poolLength(data []byte, offset int, unit int) (int, int) {
+	read := func(at int) int {
+		if at+unit > len(data) {
+			return 0
+		}
+		if unit == 1 {
+			return int(data[at])
+		}
+		return int(binary.LittleEndian.Uint16(data[at:]))
+	}
+
+	high := 0x80 << (8 * (unit - 1))
+
+	length := read(offset)
+	if length&high != 0 {
+		return (length&^high)<<(8*unit) | read(offset+unit), offset + 2*unit
+	}
+
+	return length, offset + unit
+}
+
+func utf8PoolString(data []byte, offset int) string {
+	// UTF-16 length first, then the UTF-8 byte length
+	_, offset = poolLength(data, offset, 1)
+	length, offset := poolLength(data, offset, 1)
+
+	if offset < 0 || offset+length > len(data) {
+		return ""
+	}
+
+	return string(data[offset : offset+length])
+}
+
+func utf16PoolString(data []byte, offset int) string {
+	length, offset := poolLength(data, offset, 2)
+
+	if offset < 0 || offset+length*2 > len(data) {
+		return ""
+	}
+
+	units := make([]uint16, length)
+	for index := range units {
+		units[index] = binary.LittleEndian.Uint16(data[offset+index*2:])
+	}
+
+	return string(utf16.Decode(units))
+}
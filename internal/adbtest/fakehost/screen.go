@@ -0,0 +1,204 @@
+package fakehost
+
+import (
+	"bytes"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"sync"
+)
+
+// Taps stay drawn on the screen so screenshots show what a test did
+const maxDrawnTaps = 16
+
+const launcherColumns = 4
+
+type point struct{ x, y int }
+
+// screen is what screencap shows: a launcher with one tile per app, or the app in the foreground
+// filling the screen with its color. No text, it's for checking that pixels come through.
+type screen struct {
+	mu         sync.Mutex
+	width      int
+	height     int
+	on         bool
+	foreground string // package, "" is the launcher
+	taps       []point
+	wallpaper  color.RGBA
+}
+
+func newScreen(width, height int, seed string) *screen {
+	return &screen{width: width, height: height, on: true, wallpaper: colorFor(seed)}
+}
+
+// colorFor gives every package (or device) its own stable, not too dark color
+func colorFor(name string) color.RGBA {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(name))
+	sum := hash.Sum32()
+
+	return color.RGBA{R: 64 + uint8(sum)%160, G: 64 + uint8(sum>>8)%160, B: 64 + uint8(sum>>16)%160, A: 255}
+}
+
+func (screen *screen) size() (int, int) {
+	return screen.width, screen.height
+}
+
+func (screen *screen) iconRect(index int) image.Rectangle {
+	cell := screen.width / launcherColumns
+	icon := cell * 2 / 3
+	top := screen.height / 8
+
+	x := (index%launcherColumns)*cell + (cell-icon)/2
+	y := top + (index/launcherColumns)*cell + (cell-icon)/2
+
+	return image.Rect(x, y, x+icon, y+icon)
+}
+
+// tap records a touch, returning the app it launched when it hit a launcher tile
+func (screen *screen) tap(x, y int, apps []string) string {
+	screen.mu.Lock()
+	defer screen.mu.Unlock()
+
+	if !screen.on {
+		return ""
+	}
+
+	screen.taps = append(screen.taps, point{x, y})
+	if len(screen.taps) > maxDrawnTaps {
+		screen.taps = screen.taps[len(screen.taps)-maxDrawnTaps:]
+	}
+
+	if screen.foreground != "" {
+		return ""
+	}
+
+	for index, app := range apps {
+		if image.Pt(x, y).In(screen.iconRect(index)) {
+			screen.foreground = app
+			return app
+		}
+	}
+
+	return ""
+}
+
+func (screen *screen) launch(app string) {
+	screen.mu.Lock()
+	defer screen.mu.Unlock()
+
+	screen.on = true
+	screen.foreground = app
+}
+
+// stop sends the app to the background if it's showing, for force-stop and uninstall
+func (screen *screen) stop(app string) {
+	screen.mu.Lock()
+	defer screen.mu.Unlock()
+
+	if screen.foreground == app {
+		screen.foreground = ""
+	}
+}
+
+func (screen *screen) home() {
+	screen.mu.Lock()
+	defer screen.mu.Unlock()
+
+	screen.foreground = ""
+}
+
+func (screen *screen) togglePower() {
+	screen.mu.Lock()
+	defer screen.mu.Unlock()
+
+	screen.on = !screen.on
+}
+
+func (screen *screen) setPower(on bool) {
+	screen.mu.Lock()
+	defer screen.mu.Unlock()
+
+	screen.on = on
+}
+
+func (screen *screen) reset() {
+	screen.mu.Lock()
+	defer screen.mu.Unlock()
+
+	screen.on = true
+	screen.foreground = ""
+	screen.taps = nil
+}
+
+func (screen *screen) render(apps []string) *image.RGBA {
+	screen.mu.Lock()
+	defer screen.mu.Unlock()
+
+	canvas := image.NewRGBA(image.Rect(0, 0, screen.width, screen.height))
+
+	if !screen.on {
+		draw.Draw(canvas, canvas.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+		return canvas
+	}
+
+	background := screen.wallpaper
+	if screen.foreground != "" {
+		background = colorFor(screen.foreground)
+	}
+
+	fill := func(rect image.Rectangle, fillColor color.Color) {
+		draw.Draw(canvas, rect, image.NewUniform(fillColor), image.Point{}, draw.Src)
+	}
+
+	fill(canvas.Bounds(), background)
+
+	// Status and navigation bars
+	fill(image.Rect(0, 0, screen.width, screen.height/30), color.RGBA{A: 255})
+	fill(image.Rect(screen.width*3/8, screen.height-screen.height/60, screen.width*5/8, screen.height-screen.height/90), color.White)
+
+	if screen.foreground == "" {
+		for index, app := range apps {
+			fill(screen.iconRect(index), colorFor(app))
+		}
+	}
+
+	radius := max(screen.width/40, 2)
+
+	for _, tap := range screen.taps {
+		for dy := -radius; dy <= radius; dy++ {
+			for dx := -radius; dx <= radius; dx++ {
+				if dx*dx+dy*dy <= radius*radius {
+					canvas.SetRGBA(tap.x+dx, tap.y+dy, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+				}
+			}
+		}
+	}
+
+	return canvas
+}
+
+func (screen *screen) png(apps []string) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := encoder.Encode(&buffer, screen.render(apps)); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// raw is screencap without -p: width, height, pixel format (1 is RGBA_8888), color space, then pixels
+func (screen *screen) raw(apps []string) []byte {
+	canvas := screen.render(apps)
+
+	header := putUint32(nil, uint32(canvas.Rect.Dx()))
+	header = putUint32(header, uint32(canvas.Rect.Dy()))
+	header = putUint32(header, 1)
+	header = putUint32(header, 0)
+
+	return append(header, canvas.Pix...)
+}
@@ -0,0 +1,540 @@
+// Package fakehost is a stand-in adb server: it speaks the smart-socket protocol the adb
+// client talks to localhost:5037 with, and answers for simulated devices instead of phones.
+// Each device has a file system, a package database, a logcat generator and a screen, so the
+// real adb binary (and anything driving it, like this server) works against it unchanged:
+//
+//	server, err := fakehost.New(fakehost.Options{Devices: []fakehost.DeviceSpec{{Serial: "FAKE0001"}}})
+//	listener, err := net.Listen("tcp", "127.0.0.1:0")
+//	go server.Serve(listener)
+//	// adb -H 127.0.0.1 -P <port> shell pm list packages
+//
+// adb-server fake-adb runs one on its own.
+package fakehost
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServerVersion is what host:version answers. adb kills and restarts servers that don't
+// match its own, 41 is every adb since platform-tools 29.
+const ServerVersion = 41
+
+// Device features. Without cmd or abb the client installs by pushing and running pm install,
+// without stat_v2, ls_v2 and sendrecv_v2 it sticks to sync v1.
+const deviceFeatures = "shell_v2,fixed_push_mkdir,fixed_push_symlink_timestamp"
+
+type Options struct {
+	Devices        []DeviceSpec
+	LogcatInterval time.Duration // between made-up logcat lines, 250ms when 0, none when negative
+	RebootDelay    time.Duration // how long a rebooting device is gone, 3s when 0
+}
+
+type Server struct {
+	options Options
+
+	mu            sync.Mutex
+	devices       []*Device
+	nextTransport int64
+	changed       chan struct{} // closed and replaced whenever device lists change
+	listeners     []net.Listener
+	conns         map[net.Conn]struct{}
+	closed        bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func New(options Options) (*Server, error) {
+	if options.LogcatInterval == 0 {
+		options.LogcatInterval = 250 * time.Millisecond
+	}
+
+	if options.RebootDelay == 0 {
+		options.RebootDelay = 3 * time.Second
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	server := &Server{
+		options: options,
+		changed: make(chan struct{}),
+		conns:   map[net.Conn]struct{}{},
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	for _, spec := range options.Devices {
+		if _, err := server.AddDevice(spec); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+
+	return server, nil
+}
+
+// AddDevice plugs in another device, clients tracking devices see it right away
+func (server *Server) AddDevice(spec DeviceSpec) (*Device, error) {
+	server.mu.Lock()
+
+	for _, existing := range server.devices {
+		if existing.spec.Serial == spec.Serial {
+			server.mu.Unlock()
+			return nil, fmt.Errorf("device %s already exists", spec.Serial)
+		}
+	}
+
+	server.nextTransport++
+	preset := devicePresets[int(server.nextTransport-1)%len(devicePresets)]
+
+	device, err := newDevice(spec, preset, server.nextTransport, server.options, server.devicesChanged)
+	if err != nil {
+		server.mu.Unlock()
+		return nil, err
+	}
+
+	server.devices = append(server.devices, device)
+	server.mu.Unlock()
+
+	server.devicesChanged()
+
+	return device, nil
+}
+
+// RemoveDevice unplugs a device
+func (server *Server) RemoveDevice(serial string) bool {
+	server.mu.Lock()
+
+	removed := false
+	for index, device := range server.devices {
+		if device.spec.Serial == serial {
+			server.devices = append(server.devices[:index:index], server.devices[index+1:]...)
+			removed = true
+			break
+		}
+	}
+
+	server.mu.Unlock()
+
+	if removed {
+		server.devicesChanged()
+	}
+
+	return removed
+}
+
+func (server *Server) Device(serial string) (*Device, bool) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	for _, device := range server.devices {
+		if device.spec.Serial == serial {
+			return device, true
+		}
+	}
+
+	return nil, false
+}
+
+func (server *Server) Devices() []*Device {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	return append([]*Device(nil), server.devices...)
+}
+
+func (server *Server) devicesChanged() {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	close(server.changed)
+	server.changed = make(chan struct{})
+}
+
+// ListenAndServe listens on address (host:port) and serves until Close
+func (server *Server) ListenAndServe(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	return server.Serve(listener)
+}
+
+func (server *Server) Serve(listener net.Listener) error {
+	server.mu.Lock()
+	if server.closed {
+		server.mu.Unlock()
+		listener.Close()
+		return net.ErrClosed
+	}
+
+	server.listeners = append(server.listeners, listener)
+	server.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if server.ctx.Err() != nil {
+				return net.ErrClosed
+			}
+
+			return err
+		}
+
+		server.mu.Lock()
+		server.conns[conn] = struct{}{}
+		server.mu.Unlock()
+
+		go func() {
+			defer func() {
+				server.mu.Lock()
+				delete(server.conns, conn)
+				server.mu.Unlock()
+
+				conn.Close()
+			}()
+
+			server.handle(conn)
+		}()
+	}
+}
+
+// Close stops listening and hangs up on every client
+func (server *Server) Close() error {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.closed = true
+	server.cancel()
+
+	for _, listener := range server.listeners {
+		listener.Close()
+	}
+
+	for conn := range server.conns {
+		conn.Close()
+	}
+
+	return nil
+}
+
+// handle answers host requests until one switches the connection to a device, which then
+// gets exactly one service request. Same as the real server.
+func (server *Server) handle(conn net.Conn) {
+	for {
+		request, err := readRequest(conn)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && server.ctx.Err() == nil {
+				slog.Debug("fake adb: bad request", "error", err)
+			}
+			return
+		}
+
+		slog.Debug("fake adb request", "request", request)
+
+		device, keepOpen := server.serveHost(conn, request)
+		if !keepOpen {
+			return
+		}
+
+		if device == nil {
+			continue
+		}
+
+		service, err := readRequest(conn)
+		if err != nil {
+			return
+		}
+
+		slog.Debug("fake adb device service", "serial", device.spec.Serial, "service", service)
+		server.serveDevice(conn, device, service)
+
+		return
+	}
+}
+
+// selector picks devices the way adb -s, -t, -d and -e do
+type selector struct {
+	serial      string
+	transportID int64
+}
+
+func (server *Server) pick(choice selector) (*Device, error) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	var candidates []*Device
+
+	for _, device := range server.devices {
+		if _, visible := device.visible(); !visible {
+			continue
+		}
+
+		switch {
+		case choice.serial != "" && device.spec.Serial != choice.serial,
+			choice.transportID != 0 && device.transportID != choice.transportID:
+			continue
+		}
+
+		candidates = append(candidates, device)
+	}
+
+	switch {
+	case len(candidates) == 1:
+		return candidates[0], nil
+	case choice.serial != "":
+		return nil, fmt.Errorf("device '%s' not found", choice.serial)
+	case choice.transportID != 0:
+		return nil, fmt.Errorf("no device with transport id '%d'", choice.transportID)
+	case len(candidates) == 0:
+		return nil, errors.New("no devices/emulators found")
+	}
+
+	return nil, errors.New("more than one device/emulator")
+}
+
+func (server *Server) devicesText(long bool) string {
+	var text strings.Builder
+
+	for _, device := range server.Devices() {
+		if _, visible := device.visible(); visible {
+			text.WriteString(device.devicesLine(long) + "\n")
+		}
+	}
+
+	return text.String()
+}
+
+// serveHost answers one host request. It returns the device when the request switched the
+// connection over to it, and whether to keep the connection open at all.
+func (server *Server) serveHost(conn net.Conn, request string) (*Device, bool) {
+	var choice selector
+
+	// host-serial:<serial>:<request>, the serial can have colons in it (ip:port)
+	switch {
+	case strings.HasPrefix(request, "host-serial:"):
+		rest := strings.TrimPrefix(request, "host-serial:")
+
+		separator := strings.LastIndex(rest, ":")
+		if separator == -1 {
+			_ = writeFail(conn, "bad host-serial request")
+			return nil, false
+		}
+
+		choice.serial, request = rest[:separator], rest[separator+1:]
+	case strings.HasPrefix(request, "host-transport-id:"):
+		idText, rest, _ := strings.Cut(strings.TrimPrefix(request, "host-transport-id:"), ":")
+		choice.transportID, _ = strconv.ParseInt(idText, 10, 64)
+		request = rest
+	case strings.HasPrefix(request, "host-usb:"), strings.HasPrefix(request, "host-local:"), strings.HasPrefix(request, "host:"):
+		_, request, _ = strings.Cut(request, ":")
+	default:
+		_ = writeFail(conn, "unknown host service")
+		return nil, false
+	}
+
+	switch {
+	case request == "version":
+		_ = writeOkayString(conn, fmt.Sprintf("%04x", ServerVersion))
+	case request == "kill":
+		// A CI job's adb kill-server shouldn't take the fake down with it
+		_ = writeOkay(conn)
+		slog.Info("Ignoring adb kill-server, stop the fake adb server itself instead")
+	case request == "devices" || request == "devices-l":
+		_ = writeOkayString(conn, server.devicesText(request == "devices-l"))
+	case request == "track-devices" || request == "track-devices-l":
+		server.trackDevices(conn, request == "track-devices-l")
+	case request == "host-features":
+		_ = writeOkayString(conn, deviceFeatures)
+	case request == "features" || request == "get-state" || request == "get-serialno" || request == "get-devpath":
+		device, err := server.pick(choice)
+		if err != nil {
+			_ = writeFail(conn, err.Error())
+			return nil, false
+		}
+
+		answer := map[string]func() string{
+			"features":     func() string { return deviceFeatures },
+			"get-state":    func() string { state, _ := device.visible(); return state },
+			"get-serialno": func() string { return device.spec.Serial },
+			"get-devpath":  func() string { return fmt.Sprintf("usb:1-%d", device.transportID) },
+		}[request]()
+
+		_ = writeOkayString(conn, answer)
+	case strings.HasPrefix(request, "wait-for-"):
+		server.waitFor(conn, choice, request)
+	case strings.HasPrefix(request, "transport") || strings.HasPrefix(request, "tport:"):
+		return server.switchTransport(conn, choice, request)
+	default:
+		_ = writeFail(conn, "unknown host service")
+	}
+
+	return nil, false
+}
+
+func (server *Server) switchTransport(conn net.Conn, choice selector, request string) (*Device, bool) {
+	// tport: is the newer spelling, its OKAY is followed by the transport id
+	withID := strings.HasPrefix(request, "tport:")
+	request = strings.TrimPrefix(strings.TrimPrefix(request, "tport:"), "transport")
+
+	switch {
+	case strings.HasPrefix(request, ":"):
+		choice.serial = request[1:]
+	case strings.HasPrefix(request, "serial:"):
+		choice.serial = strings.TrimPrefix(request, "serial:")
+	case strings.HasPrefix(request, "-id:"), strings.HasPrefix(request, "transport-id:"):
+		choice.transportID, _ = strconv.ParseInt(request[strings.LastIndex(request, ":")+1:], 10, 64)
+	case request == "-any" || request == "-usb" || request == "any" || request == "usb":
+	case request == "-local" || request == "local":
+		_ = writeFail(conn, "no emulators found")
+		return nil, false
+	default:
+		_ = writeFail(conn, "unknown host service")
+		return nil, false
+	}
+
+	device, err := server.pick(choice)
+	if err == nil {
+		err = device.errUnusable()
+	}
+
+	if err != nil {
+		_ = writeFail(conn, err.Error())
+		return nil, false
+	}
+
+	if err := writeOkay(conn); err != nil {
+		return nil, false
+	}
+
+	if withID {
+		if _, err := conn.Write(append(putUint32(nil, uint32(device.transportID)), 0, 0, 0, 0)); err != nil {
+			return nil, false
+		}
+	}
+
+	return device, true
+}
+
+// hangup is closed once the client goes away, for requests that otherwise never end
+func hangup(conn net.Conn) <-chan struct{} {
+	done := make(chan struct{})
+
+	go func() {
+		_, _ = io.Copy(io.Discard, conn)
+		close(done)
+	}()
+
+	return done
+}
+
+func (server *Server) trackDevices(conn net.Conn, long bool) {
+	if err := writeOkay(conn); err != nil {
+		return
+	}
+
+	gone := hangup(conn)
+	last := "\x00"
+
+	for {
+		server.mu.Lock()
+		changed := server.changed
+		server.mu.Unlock()
+
+		if current := server.devicesText(long); current != last {
+			if err := writeString(conn, current); err != nil {
+				return
+			}
+
+			last = current
+		}
+
+		select {
+		case <-changed:
+		case <-gone:
+			return
+		case <-server.ctx.Done():
+			return
+		}
+	}
+}
+
+// wait-for-<transport>-<state>: one OKAY now, one more when a device is in that state
+func (server *Server) waitFor(conn net.Conn, choice selector, request string) {
+	wanted := request[strings.LastIndex(request, "-")+1:]
+
+	if err := writeOkay(conn); err != nil {
+		return
+	}
+
+	gone := hangup(conn)
+
+	for {
+		server.mu.Lock()
+		changed := server.changed
+		server.mu.Unlock()
+
+		device, err := server.pick(choice)
+		if wanted == "disconnect" && err != nil {
+			break
+		}
+
+		if err == nil {
+			if state, _ := device.visible(); state == wanted || (wanted == "any" && state != "offline") {
+				break
+			}
+		}
+
+		select {
+		case <-changed:
+		case <-gone:
+			return
+		case <-server.ctx.Done():
+			return
+		}
+	}
+
+	_ = writeOkay(conn)
+}
+
+func (server *Server) serveDevice(conn net.Conn, device *Device, service string) {
+	name, argument, _ := strings.Cut(service, ":")
+	options := strings.Split(name, ",")
+
+	switch options[0] {
+	case "shell":
+		server.serveShell(conn, device, argument, slices.Contains(options[1:], "v2"))
+	case "exec":
+		server.serveShell(conn, device, argument, false)
+	case "sync":
+		if writeOkay(conn) != nil {
+			return
+		}
+
+		if err := device.serveSync(conn); err != nil && server.ctx.Err() == nil {
+			slog.Debug("fake adb sync failed", "serial", device.spec.Serial, "error", err)
+		}
+	case "reboot":
+		_ = writeOkay(conn)
+		device.reboot(argument)
+	case "root":
+		_ = writeOkay(conn)
+		_, _ = io.WriteString(conn, "adbd cannot run as root in production builds\n")
+	case "unroot":
+		_ = writeOkay(conn)
+		_, _ = io.WriteString(conn, "adbd not running as root\n")
+	default:
+		_ = writeFail(conn, "unknown service "+name)
+	}
+}
@@ -0,0 +1,273 @@
+package fakehost_test
+
+import (
+	"adb-server/internal/adbtest/fakehost"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serve runs a fake adb server on a free port, the way CI would
+func serve(t *testing.T) (*fakehost.Server, string) {
+	t.Helper()
+
+	server, err := fakehost.New(fakehost.Options{
+		Devices: []fakehost.DeviceSpec{
+			{Serial: "FAKE0001", Files: map[string]string{"/sdcard/hello.txt": "hello from the device\n"}},
+			{Serial: "FAKE0002", State: "unauthorized"},
+		},
+		LogcatInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(func() { _ = server.Close() })
+
+	return server, listener.Addr().String()
+}
+
+// adbConn is the client end of the smart-socket protocol, written out here rather than shared
+// with the server so the two can't agree on the same mistake
+type adbConn struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+func dial(t *testing.T, address string) *adbConn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() { conn.Close() })
+
+	return &adbConn{t: t, conn: conn}
+}
+
+// request sends a request and returns the FAIL message, "" for OKAY
+func (client *adbConn) request(request string) string {
+	client.t.Helper()
+
+	if _, err := fmt.Fprintf(client.conn, "%04x%s", len(request), request); err != nil {
+		client.t.Fatalf("sending %s: %v", request, err)
+	}
+
+	status := client.read(4)
+
+	switch string(status) {
+	case "OKAY":
+		return ""
+	case "FAIL":
+		return client.readString()
+	}
+
+	client.t.Fatalf("%s got status %q", request, status)
+	return ""
+}
+
+func (client *adbConn) mustRequest(request string) {
+	client.t.Helper()
+
+	if failure := client.request(request); failure != "" {
+		client.t.Fatalf("%s failed: %s", request, failure)
+	}
+}
+
+func (client *adbConn) read(size int) []byte {
+	client.t.Helper()
+
+	buffer := make([]byte, size)
+	if _, err := io.ReadFull(client.conn, buffer); err != nil {
+		client.t.Fatalf("reading %d bytes: %v", size, err)
+	}
+
+	return buffer
+}
+
+// readString reads a 4 hex digit length and that many bytes
+func (client *adbConn) readString() string {
+	client.t.Helper()
+
+	length, err := strconv.ParseUint(string(client.read(4)), 16, 32)
+	if err != nil {
+		client.t.Fatalf("bad length: %v", err)
+	}
+
+	return string(client.read(int(length)))
+}
+
+// syncHeader reads a sync message's id and little-endian value
+func (client *adbConn) syncHeader() (string, uint32) {
+	client.t.Helper()
+
+	header := client.read(8)
+	return string(header[:4]), binary.LittleEndian.Uint32(header[4:])
+}
+
+func (client *adbConn) syncSend(id string, value uint32, data []byte) {
+	client.t.Helper()
+
+	message := binary.LittleEndian.AppendUint32([]byte(id), value)
+	if _, err := client.conn.Write(append(message, data...)); err != nil {
+		client.t.Fatalf("sending sync %s: %v", id, err)
+	}
+}
+
+func TestHostRequests(t *testing.T) {
+	_, address := serve(t)
+
+	version := dial(t, address)
+	version.mustRequest("host:version")
+
+	if got := version.readString(); got != fmt.Sprintf("%04x", fakehost.ServerVersion) {
+		t.Errorf("host:version answered %q", got)
+	}
+
+	devices := dial(t, address)
+	devices.mustRequest("host:devices-l")
+
+	lines := strings.Split(strings.TrimSuffix(devices.readString(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("host:devices-l listed %q", lines)
+	}
+
+	if fields := strings.Fields(lines[0]); len(fields) < 5 || fields[0] != "FAKE0001" || fields[1] != "device" || fields[4] != "model:Pixel_7a" {
+		t.Errorf("first device is %q", lines[0])
+	}
+
+	if fields := strings.Fields(lines[1]); len(fields) < 2 || fields[0] != "FAKE0002" || fields[1] != "unauthorized" {
+		t.Errorf("second device is %q", lines[1])
+	}
+
+	if failure := dial(t, address).request("host:transport:NOPE"); failure != "device 'NOPE' not found" {
+		t.Errorf("transport to an unknown device failed with %q", failure)
+	}
+
+	if failure := dial(t, address).request("host:transport:FAKE0002"); !strings.HasPrefix(failure, "device unauthorized.") {
+		t.Errorf("transport to an unauthorized device failed with %q", failure)
+	}
+}
+
+func TestShell(t *testing.T) {
+	_, address := serve(t)
+
+	raw := dial(t, address)
+	raw.mustRequest("host:transport:FAKE0001")
+	raw.mustRequest("shell:echo hello && cat /sdcard/hello.txt")
+
+	output, err := io.ReadAll(raw.conn)
+	if err != nil || string(output) != "hello\nhello from the device\n" {
+		t.Errorf("raw shell answered %q, %v", output, err)
+	}
+
+	// Shell v2 keeps stdout, stderr and the exit code apart
+	v2 := dial(t, address)
+	v2.mustRequest("host:transport:FAKE0001")
+	v2.mustRequest("shell,v2,raw:getprop ro.product.model; cat /sdcard/missing.txt")
+
+	var stdout, stderr bytes.Buffer
+	exitCode := -1
+
+	for exitCode == -1 {
+		header := v2.read(5)
+		data := v2.read(int(binary.LittleEndian.Uint32(header[1:])))
+
+		switch header[0] {
+		case 1:
+			stdout.Write(data)
+		case 2:
+			stderr.Write(data)
+		case 3:
+			exitCode = int(data[0])
+		}
+	}
+
+	// getprop has the model as the device names it, adb devices -l swaps the spaces for underscores
+	if stdout.String() != "Pixel 7a\n" || stderr.Len() == 0 || exitCode == 0 {
+		t.Errorf("shell v2 answered stdout %q, stderr %q, exit code %d", stdout.String(), stderr.String(), exitCode)
+	}
+}
+
+func TestSyncPushPull(t *testing.T) {
+	server, address := serve(t)
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 5000) // more than one 64k chunk
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	push := dial(t, address)
+	push.mustRequest("host:transport:FAKE0001")
+	push.mustRequest("sync:")
+
+	push.syncSend("SEND", uint32(len("/sdcard/Download/big.bin,33188")), []byte("/sdcard/Download/big.bin,33188"))
+	push.syncSend("DATA", 64*1024, content[:64*1024])
+	push.syncSend("DATA", uint32(len(content)-64*1024), content[64*1024:])
+	push.syncSend("DONE", uint32(modTime.Unix()), nil)
+
+	if id, _ := push.syncHeader(); id != "OKAY" {
+		t.Fatalf("push answered %s", id)
+	}
+
+	// It's on the device now, as a stat over the same connection says too
+	push.syncSend("STAT", uint32(len("/sdcard/Download/big.bin")), []byte("/sdcard/Download/big.bin"))
+
+	id, mode := push.syncHeader()
+	size := binary.LittleEndian.Uint32(push.read(4))
+	pushedAt := binary.LittleEndian.Uint32(push.read(4))
+
+	if id != "STAT" || mode&0o777 != 0o644 || int(size) != len(content) || int64(pushedAt) != modTime.Unix() {
+		t.Errorf("stat answered %s mode %o size %d time %d", id, mode, size, pushedAt)
+	}
+
+	push.syncSend("QUIT", 0, nil)
+
+	device, _ := server.Device("FAKE0001")
+	if stored, err := device.ReadFile("/sdcard/Download/big.bin"); err != nil || !bytes.Equal(stored, content) {
+		t.Errorf("device has %d bytes, %v, want the %d pushed", len(stored), err, len(content))
+	}
+
+	pull := dial(t, address)
+	pull.mustRequest("host:transport:FAKE0001")
+	pull.mustRequest("sync:")
+	pull.syncSend("RECV", uint32(len("/sdcard/Download/big.bin")), []byte("/sdcard/Download/big.bin"))
+
+	var pulled []byte
+
+	for {
+		id, length := pull.syncHeader()
+		if id == "DONE" {
+			break
+		}
+
+		if id != "DATA" {
+			t.Fatalf("pull answered %s", id)
+		}
+
+		pulled = append(pulled, pull.read(int(length))...)
+	}
+
+	if !bytes.Equal(pulled, content) {
+		t.Errorf("pulled %d bytes, want the %d pushed", len(pulled), len(content))
+	}
+
+	pull.syncSend("RECV", uint32(len("/sdcard/missing.txt")), []byte("/sdcard/missing.txt"))
+
+	if id, length := pull.syncHeader(); id != "FAIL" || !strings.Contains(string(pull.read(int(length))), "No such file") {
+		t.Errorf("pulling a missing file answered %s", id)
+	}
+}
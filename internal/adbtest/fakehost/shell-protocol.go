@@ -0,0 +1,102 @@
+package fakehost
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// Shell v2 wraps stdin, stdout, stderr and the exit code in packets: one id byte, a
+// little-endian uint32 length and the data. Without v2 output is raw and the exit code is lost.
+const (
+	shellStdout = 1
+	shellStderr = 2
+	shellExit   = 3
+
+	shellMaxInput = 1 << 20
+)
+
+type shellPacketWriter struct {
+	mu   *sync.Mutex
+	conn io.Writer
+	id   byte
+}
+
+func (writer shellPacketWriter) Write(data []byte) (int, error) {
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+
+	packet := putUint32([]byte{writer.id}, uint32(len(data)))
+
+	if _, err := writer.conn.Write(append(packet, data...)); err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+// serveShell runs one shell: or exec: command. There's no interactive shell, adb shell without a
+// command gets told so.
+func (server *Server) serveShell(conn net.Conn, device *Device, command string, v2 bool) {
+	if writeOkay(conn) != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(server.ctx)
+	defer cancel()
+
+	go watchShellInput(conn, v2, cancel)
+
+	var stdout, stderr io.Writer = conn, conn
+
+	var writing sync.Mutex
+	if v2 {
+		stdout = shellPacketWriter{mu: &writing, conn: conn, id: shellStdout}
+		stderr = shellPacketWriter{mu: &writing, conn: conn, id: shellStderr}
+	}
+
+	status := 1
+	if command == "" {
+		_, _ = io.WriteString(stderr, "The fake adb server has no interactive shell, pass a command: adb shell <command>\n")
+	} else {
+		status = device.runShell(ctx, command, stdout, stderr)
+	}
+
+	if v2 {
+		_, _ = shellPacketWriter{mu: &writing, conn: conn, id: shellExit}.Write([]byte{byte(status)})
+	}
+}
+
+// watchShellInput cancels the command when the client hangs up. Input itself is dropped,
+// nothing simulated reads stdin.
+func watchShellInput(conn net.Conn, v2 bool, cancel context.CancelFunc) {
+	if !v2 {
+		// Raw clients may just be done sending, only a broken connection means they left
+		if _, err := io.Copy(io.Discard, conn); err != nil && !errors.Is(err, net.ErrClosed) {
+			cancel()
+		}
+		return
+	}
+
+	defer cancel()
+
+	var header [5]byte
+
+	for {
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			return
+		}
+
+		length := binary.LittleEndian.Uint32(header[1:])
+		if length > shellMaxInput {
+			return
+		}
+
+		if _, err := io.CopyN(io.Discard, conn, int64(length)); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,607 @@
+package fakehost
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A very small sh: quoting, ; && || pipes and > >> redirects. Enough for what adb clients and
+// people typing adb shell "..." throw at it, no variables or globbing.
+
+type token struct {
+	text     string
+	operator bool
+}
+
+var errUnmatchedQuote = errors.New("syntax error: unmatched quote")
+
+func tokenize(line string) ([]token, error) {
+	var tokens []token
+	var current strings.Builder
+
+	inWord := false
+
+	flush := func() {
+		if inWord {
+			tokens = append(tokens, token{text: current.String()})
+			current.Reset()
+			inWord = false
+		}
+	}
+
+	for index := 0; index < len(line); index++ {
+		char := line[index]
+
+		switch {
+		case char == '\'':
+			end := strings.IndexByte(line[index+1:], '\'')
+			if end == -1 {
+				return nil, errUnmatchedQuote
+			}
+
+			current.WriteString(line[index+1 : index+1+end])
+			inWord = true
+			index += end + 1
+		case char == '"':
+			index++
+			for ; index < len(line) && line[index] != '"'; index++ {
+				if line[index] == '\\' && index+1 < len(line) && strings.IndexByte(`"\$`, line[index+1]) != -1 {
+					index++
+				}
+
+				current.WriteByte(line[index])
+			}
+
+			if index >= len(line) {
+				return nil, errUnmatchedQuote
+			}
+
+			inWord = true
+		case char == '\\' && index+1 < len(line):
+			index++
+			current.WriteByte(line[index])
+			inWord = true
+		case char == ' ' || char == '\t' || char == '\n':
+			flush()
+		case char == ';' || char == '|' || char == '&' || char == '>':
+			flush()
+
+			operator := string(char)
+			if index+1 < len(line) && (char != ';' && line[index+1] == char) {
+				operator += string(char)
+				index++
+			}
+
+			tokens = append(tokens, token{text: operator, operator: true})
+		default:
+			current.WriteByte(char)
+			inWord = true
+		}
+	}
+
+	flush()
+
+	return tokens, nil
+}
+
+// shell is one command line running on a device
+type shell struct {
+	ctx    context.Context
+	device *Device
+}
+
+type command struct {
+	args   []string
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+type builtin func(shell *shell, command *command) int
+
+// Filled in init, the table refers back to run through exec
+var builtins map[string]builtin
+
+// runShell runs a command line like sh -c would and returns its exit status
+func (device *Device) runShell(ctx context.Context, line string, stdout, stderr io.Writer) int {
+	tokens, err := tokenize(line)
+	if err != nil {
+		fmt.Fprintf(stderr, "/system/bin/sh: %v\n", err)
+		return 2
+	}
+
+	return (&shell{ctx: ctx, device: device}).run(tokens, stdout, stderr)
+}
+
+func (shell *shell) run(tokens []token, stdout, stderr io.Writer) int {
+	status := 0
+	connector := ";"
+
+	for len(tokens) > 0 {
+		end := len(tokens)
+		for index, current := range tokens {
+			if current.operator && (current.text == ";" || current.text == "&&" || current.text == "||" || current.text == "&") {
+				end = index
+				break
+			}
+		}
+
+		list := tokens[:end]
+
+		runIt := connector == ";" || connector == "&" || (connector == "&&" && status == 0) || (connector == "||" && status != 0)
+		if runIt && len(list) > 0 {
+			status = shell.pipeline(list, stdout, stderr)
+		}
+
+		if shell.ctx.Err() != nil {
+			return 130
+		}
+
+		if end == len(tokens) {
+			break
+		}
+
+		connector = tokens[end].text
+		tokens = tokens[end+1:]
+	}
+
+	return status
+}
+
+func (shell *shell) pipeline(tokens []token, stdout, stderr io.Writer) int {
+	var stages [][]token
+
+	start := 0
+	for index, current := range tokens {
+		if current.operator && current.text == "|" {
+			stages = append(stages, tokens[start:index])
+			start = index + 1
+		}
+	}
+
+	stages = append(stages, tokens[start:])
+
+	// Stages run one after the other through buffers, fine for the short outputs people pipe
+	var input io.Reader = strings.NewReader("")
+	status := 0
+
+	for index, stage := range stages {
+		var output bytes.Buffer
+
+		var stageOut io.Writer = stdout
+		if index < len(stages)-1 {
+			stageOut = &output
+		}
+
+		status = shell.simple(stage, input, stageOut, stderr)
+		input = &output
+	}
+
+	return status
+}
+
+// simple runs one command with its redirects
+func (shell *shell) simple(tokens []token, stdin io.Reader, stdout, stderr io.Writer) int {
+	var args []string
+	var redirect, redirectTo string
+
+	for index := 0; index < len(tokens); index++ {
+		current := tokens[index]
+
+		if current.operator && (current.text == ">" || current.text == ">>") {
+			if index+1 >= len(tokens) || tokens[index+1].operator {
+				fmt.Fprintln(stderr, "/system/bin/sh: syntax error: redirect without a file")
+				return 2
+			}
+
+			redirect, redirectTo = current.text, tokens[index+1].text
+			index++
+
+			continue
+		}
+
+		if current.operator {
+			fmt.Fprintf(stderr, "/system/bin/sh: syntax error: unexpected '%s'\n", current.text)
+			return 2
+		}
+
+		args = append(args, current.text)
+	}
+
+	// Leading NAME=value assignments only matter to real programs
+	for len(args) > 0 && strings.Contains(args[0], "=") && !strings.HasPrefix(args[0], "=") {
+		args = args[1:]
+	}
+
+	if len(args) == 0 {
+		return 0
+	}
+
+	var captured bytes.Buffer
+	if redirect != "" {
+		stdout = &captured
+	}
+
+	status := shell.exec(&command{args: args, stdin: stdin, stdout: stdout, stderr: stderr})
+
+	if redirect != "" {
+		var err error
+		if redirect == ">>" {
+			err = shell.device.files.appendFile(redirectTo, captured.Bytes())
+		} else {
+			err = shell.device.files.writeFile(redirectTo, captured.Bytes(), 0o660, time.Now())
+		}
+
+		if err != nil {
+			fmt.Fprintf(stderr, "/system/bin/sh: can't create %s: %v\n", redirectTo, err)
+			return 1
+		}
+	}
+
+	return status
+}
+
+func (shell *shell) exec(command *command) int {
+	run, ok := builtins[command.args[0]]
+	if !ok {
+		fmt.Fprintf(command.stderr, "/system/bin/sh: %s: inaccessible or not found\n", command.args[0])
+		return 127
+	}
+
+	return run(shell, command)
+}
+
+func init() {
+	builtins = map[string]builtin{
+		"true":  func(*shell, *command) int { return 0 },
+		"false": func(*shell, *command) int { return 1 },
+		"export": func(*shell, *command) int {
+			return 0
+		},
+		"cd": func(*shell, *command) int {
+			return 0
+		},
+		"pwd": func(shell *shell, command *command) int {
+			fmt.Fprintln(command.stdout, "/")
+			return 0
+		},
+		"id": func(shell *shell, command *command) int {
+			fmt.Fprintln(command.stdout, "uid=2000(shell) gid=2000(shell) groups=2000(shell),1004(input),1007(log),1015(sdcard_rw),3003(inet) context=u:r:shell:s0")
+			return 0
+		},
+		"whoami": func(shell *shell, command *command) int {
+			fmt.Fprintln(command.stdout, "shell")
+			return 0
+		},
+		"exec": func(shell *shell, command *command) int {
+			if len(command.args) == 1 {
+				return 0
+			}
+
+			command.args = command.args[1:]
+			return shell.exec(command)
+		},
+		"exit": func(shell *shell, command *command) int {
+			if len(command.args) > 1 {
+				status, _ := strconv.Atoi(command.args[1])
+				return status
+			}
+			return 0
+		},
+		"sleep":      runSleep,
+		"echo":       runEcho,
+		"cat":        runCat,
+		"ls":         runLs,
+		"rm":         runRm,
+		"mkdir":      runMkdir,
+		"touch":      runTouch,
+		"grep":       runGrep,
+		"head":       runHeadTail,
+		"tail":       runHeadTail,
+		"wc":         runWc,
+		"uptime":     runUptime,
+		"getprop":    runGetprop,
+		"setprop":    runSetprop,
+		"pm":         runPm,
+		"cmd":        runCmd,
+		"am":         runAm,
+		"monkey":     runMonkey,
+		"input":      runInput,
+		"wm":         runWm,
+		"screencap":  runScreencap,
+		"logcat":     runLogcat,
+		"bugreportz": runBugreportz,
+		"bugreport":  runBugreport,
+		"reboot":     runReboot,
+	}
+}
+
+func runSleep(shell *shell, command *command) int {
+	seconds := 1.0
+	if len(command.args) > 1 {
+		seconds, _ = strconv.ParseFloat(command.args[1], 64)
+	}
+
+	select {
+	case <-time.After(time.Duration(seconds * float64(time.Second))):
+		return 0
+	case <-shell.ctx.Done():
+		return 130
+	}
+}
+
+func runEcho(shell *shell, command *command) int {
+	args := command.args[1:]
+
+	newline := "\n"
+	if len(args) > 0 && args[0] == "-n" {
+		args, newline = args[1:], ""
+	}
+
+	fmt.Fprint(command.stdout, strings.Join(args, " ")+newline)
+
+	return 0
+}
+
+func runCat(shell *shell, command *command) int {
+	if len(command.args) == 1 {
+		_, _ = io.Copy(command.stdout, command.stdin)
+		return 0
+	}
+
+	status := 0
+
+	for _, name := range command.args[1:] {
+		data, err := shell.device.files.readFile(name)
+		if err != nil {
+			fmt.Fprintf(command.stderr, "cat: %s: %v\n", name, err)
+			status = 1
+			continue
+		}
+
+		_, _ = command.stdout.Write(data)
+	}
+
+	return status
+}
+
+// flags splits -abc style options from operands, stopping at --
+func flags(args []string) (map[byte]bool, []string) {
+	set := map[byte]bool{}
+	var operands []string
+
+	for index, arg := range args {
+		if arg == "--" {
+			return set, append(operands, args[index+1:]...)
+		}
+
+		if len(arg) > 1 && arg[0] == '-' {
+			for _, flag := range []byte(arg[1:]) {
+				set[flag] = true
+			}
+			continue
+		}
+
+		operands = append(operands, arg)
+	}
+
+	return set, operands
+}
+
+func runLs(shell *shell, command *command) int {
+	set, paths := flags(command.args[1:])
+	if len(paths) == 0 {
+		paths = []string{"/"}
+	}
+
+	status := 0
+
+	for _, name := range paths {
+		entries, err := shell.device.files.list(name)
+		if err != nil {
+			fmt.Fprintf(command.stderr, "ls: %s: %v\n", name, err)
+			status = 1
+			continue
+		}
+
+		if len(paths) > 1 {
+			fmt.Fprintf(command.stdout, "%s:\n", name)
+		}
+
+		for _, entry := range entries {
+			if !set['l'] {
+				fmt.Fprintln(command.stdout, entry.name)
+				continue
+			}
+
+			kind, size := "-", len(entry.data)
+			if entry.dir {
+				kind, size = "d", 4096
+			}
+
+			fmt.Fprintf(command.stdout, "%s%s 1 shell shell %8d %s %s\n", kind, entry.perm.Perm().String()[1:], size, entry.modTime.Format("2006-01-02 15:04"), entry.name)
+		}
+	}
+
+	return status
+}
+
+func runRm(shell *shell, command *command) int {
+	set, paths := flags(command.args[1:])
+	status := 0
+
+	for _, name := range paths {
+		if err := shell.device.files.remove(name, set['r'] || set['R']); err != nil && !(set['f'] && errors.Is(err, errNotExist)) {
+			fmt.Fprintf(command.stderr, "rm: %s: %v\n", name, err)
+			status = 1
+		}
+	}
+
+	return status
+}
+
+func runMkdir(shell *shell, command *command) int {
+	set, paths := flags(command.args[1:])
+	status := 0
+
+	for _, name := range paths {
+		if _, exists := shell.device.files.stat(name); exists && !set['p'] {
+			fmt.Fprintf(command.stderr, "mkdir: '%s': File exists\n", name)
+			status = 1
+			continue
+		}
+
+		if err := shell.device.files.mkdirAll(name); err != nil {
+			fmt.Fprintf(command.stderr, "mkdir: '%s': %v\n", name, err)
+			status = 1
+		}
+	}
+
+	return status
+}
+
+func runTouch(shell *shell, command *command) int {
+	for _, name := range command.args[1:] {
+		if _, exists := shell.device.files.stat(name); exists {
+			continue
+		}
+
+		if err := shell.device.files.writeFile(name, nil, 0o660, time.Now()); err != nil {
+			fmt.Fprintf(command.stderr, "touch: '%s': %v\n", name, err)
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// lines reads a command's input: its file operands, or stdin when there are none
+func (shell *shell) lines(command *command, files []string) ([]string, bool) {
+	var data []byte
+
+	if len(files) == 0 {
+		data, _ = io.ReadAll(command.stdin)
+	}
+
+	for _, name := range files {
+		content, err := shell.device.files.readFile(name)
+		if err != nil {
+			fmt.Fprintf(command.stderr, "%s: %s: %v\n", command.args[0], name, err)
+			return nil, false
+		}
+
+		data = append(data, content...)
+	}
+
+	var lines []string
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	return lines, true
+}
+
+func runGrep(shell *shell, command *command) int {
+	set, operands := flags(command.args[1:])
+	if len(operands) == 0 {
+		fmt.Fprintln(command.stderr, "usage: grep [-iv] PATTERN [FILE...]")
+		return 2
+	}
+
+	pattern := operands[0]
+	if set['i'] {
+		pattern = strings.ToLower(pattern)
+	}
+
+	lines, ok := shell.lines(command, operands[1:])
+	if !ok {
+		return 2
+	}
+
+	status := 1
+
+	for _, line := range lines {
+		subject := line
+		if set['i'] {
+			subject = strings.ToLower(line)
+		}
+
+		if strings.Contains(subject, pattern) != set['v'] {
+			fmt.Fprintln(command.stdout, line)
+			status = 0
+		}
+	}
+
+	return status
+}
+
+func runHeadTail(shell *shell, command *command) int {
+	count := 10
+	var files []string
+
+	args := command.args[1:]
+	for index := 0; index < len(args); index++ {
+		switch {
+		case args[index] == "-n" && index+1 < len(args):
+			count, _ = strconv.Atoi(args[index+1])
+			index++
+		case strings.HasPrefix(args[index], "-"):
+			count, _ = strconv.Atoi(strings.TrimPrefix(strings.TrimPrefix(args[index], "-n"), "-"))
+		default:
+			files = append(files, args[index])
+		}
+	}
+
+	lines, ok := shell.lines(command, files)
+	if !ok {
+		return 1
+	}
+
+	count = min(max(count, 0), len(lines))
+
+	if command.args[0] == "head" {
+		lines = lines[:count]
+	} else {
+		lines = lines[len(lines)-count:]
+	}
+
+	for _, line := range lines {
+		fmt.Fprintln(command.stdout, line)
+	}
+
+	return 0
+}
+
+func runWc(shell *shell, command *command) int {
+	_, files := flags(command.args[1:])
+
+	lines, ok := shell.lines(command, files)
+	if !ok {
+		return 1
+	}
+
+	fmt.Fprintln(command.stdout, len(lines))
+
+	return 0
+}
+
+func runUptime(shell *shell, command *command) int {
+	shell.device.mu.Lock()
+	up := time.Since(shell.device.bootedAt)
+	shell.device.mu.Unlock()
+
+	fmt.Fprintf(command.stdout, " %s up %d min,  0 users,  load average: 0.42, 0.38, 0.30\n", time.Now().Format("15:04:05"), int(up.Minutes()))
+
+	return 0
+}
@@ -0,0 +1,182 @@
+package fakehost
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The sync: service behind adb push, pull and ls. Every message is a 4 letter id and a
+// little-endian uint32, then for requests that many bytes of path. This is version 1 of the
+// protocol; the server doesn't advertise stat_v2, ls_v2 or sendrecv_v2 so clients stick to it.
+
+const syncMaxChunk = 64 * 1024
+
+func (device *Device) serveSync(conn io.ReadWriter) error {
+	var header [8]byte
+
+	for {
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		id, length := string(header[:4]), binary.LittleEndian.Uint32(header[4:])
+
+		if id == "QUIT" {
+			return nil
+		}
+
+		if length > 4096 {
+			return fmt.Errorf("sync %s path of %d bytes is too long", id, length)
+		}
+
+		pathBytes := make([]byte, length)
+		if _, err := io.ReadFull(conn, pathBytes); err != nil {
+			return err
+		}
+
+		name := string(pathBytes)
+
+		var err error
+
+		switch id {
+		case "STAT", "LSTA":
+			err = device.syncStat(conn, name)
+		case "LIST":
+			err = device.syncList(conn, name)
+		case "SEND":
+			err = device.syncSend(conn, name)
+		case "RECV":
+			err = device.syncRecv(conn, name)
+		default:
+			_ = syncFail(conn, "unknown sync request "+strconv.Quote(id))
+			return fmt.Errorf("unknown sync request %q", id)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func syncMessage(id string, values ...uint32) []byte {
+	message := []byte(id)
+	for _, value := range values {
+		message = putUint32(message, value)
+	}
+
+	return message
+}
+
+func syncFail(conn io.Writer, reason string) error {
+	_, err := conn.Write(append(syncMessage("FAIL", uint32(len(reason))), reason...))
+	return err
+}
+
+// A missing file stats as all zeroes, that's how v1 says it doesn't exist
+func (device *Device) syncStat(conn io.Writer, name string) error {
+	entry, ok := device.files.stat(name)
+	if !ok {
+		_, err := conn.Write(syncMessage("STAT", 0, 0, 0))
+		return err
+	}
+
+	_, err := conn.Write(syncMessage("STAT", entry.mode(), uint32(len(entry.data)), uint32(entry.modTime.Unix())))
+
+	return err
+}
+
+func (device *Device) syncList(conn io.Writer, name string) error {
+	entries, _ := device.files.list(name)
+
+	for _, entry := range entries {
+		size := uint32(len(entry.data))
+		if entry.dir {
+			size = 4096
+		}
+
+		message := syncMessage("DENT", entry.mode(), size, uint32(entry.modTime.Unix()), uint32(len(entry.name)))
+		if _, err := conn.Write(append(message, entry.name...)); err != nil {
+			return err
+		}
+	}
+
+	_, err := conn.Write(syncMessage("DONE", 0, 0, 0, 0))
+
+	return err
+}
+
+// SEND's path is "<path>,<mode>", then DATA chunks and a DONE carrying the mtime
+func (device *Device) syncSend(conn io.ReadWriter, spec string) error {
+	name, modeText, _ := strings.Cut(spec, ",")
+
+	perm := fs.FileMode(0o660)
+	if mode, err := strconv.ParseUint(modeText, 10, 32); err == nil {
+		perm = fs.FileMode(mode).Perm()
+	}
+
+	var data []byte
+	var header [8]byte
+
+	for {
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			return err
+		}
+
+		id, length := string(header[:4]), binary.LittleEndian.Uint32(header[4:])
+
+		switch id {
+		case "DATA":
+			if length > syncMaxChunk {
+				_ = syncFail(conn, "data chunk too large")
+				return fmt.Errorf("sync data chunk of %d bytes is too large", length)
+			}
+
+			chunk := make([]byte, length)
+			if _, err := io.ReadFull(conn, chunk); err != nil {
+				return err
+			}
+
+			data = append(data, chunk...)
+		case "DONE":
+			if err := device.files.writeFile(name, data, perm, time.Unix(int64(length), 0)); err != nil {
+				return syncFail(conn, fmt.Sprintf("couldn't create file: %v", err))
+			}
+
+			_, err := conn.Write(syncMessage("OKAY", 0))
+
+			return err
+		default:
+			_ = syncFail(conn, "unexpected "+strconv.Quote(id)+" while receiving a file")
+			return fmt.Errorf("unexpected sync %q while receiving a file", id)
+		}
+	}
+}
+
+func (device *Device) syncRecv(conn io.Writer, name string) error {
+	data, err := device.files.readFile(name)
+	if err != nil {
+		return syncFail(conn, err.Error())
+	}
+
+	for len(data) > 0 {
+		chunk := data[:min(len(data), syncMaxChunk)]
+		data = data[len(chunk):]
+
+		if _, err := conn.Write(append(syncMessage("DATA", uint32(len(chunk))), chunk...)); err != nil {
+			return err
+		}
+	}
+
+	_, err = conn.Write(syncMessage("DONE", 0))
+
+	return err
+}
@@ -0,0 +1,68 @@
+package fakehost
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Requests from the adb client and most host answers are a 4 hex digit length and a payload
+const maxRequestLength = 1024 * 1024
+
+func readRequest(reader io.Reader) (string, error) {
+	var header [4]byte
+
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return "", err
+	}
+
+	length, err := strconv.ParseUint(string(header[:]), 16, 32)
+	if err != nil {
+		return "", fmt.Errorf("bad request length %q", header[:])
+	}
+
+	if length > maxRequestLength {
+		return "", fmt.Errorf("request of %d bytes is too long", length)
+	}
+
+	payload := make([]byte, length)
+
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return "", err
+	}
+
+	return string(payload), nil
+}
+
+func writeOkay(writer io.Writer) error {
+	_, err := io.WriteString(writer, "OKAY")
+	return err
+}
+
+func writeString(writer io.Writer, value string) error {
+	_, err := fmt.Fprintf(writer, "%04x%s", len(value), value)
+	return err
+}
+
+// writeOkayString answers a query, like host:version or host:devices
+func writeOkayString(writer io.Writer, value string) error {
+	if err := writeOkay(writer); err != nil {
+		return err
+	}
+
+	return writeString(writer, value)
+}
+
+func writeFail(writer io.Writer, message string) error {
+	if _, err := io.WriteString(writer, "FAIL"); err != nil {
+		return err
+	}
+
+	return writeString(writer, message)
+}
+
+// Sync and shell v2 frames use little-endian binary lengths instead
+func putUint32(buffer []byte, value uint32) []byte {
+	return binary.LittleEndian.AppendUint32(buffer, value)
+}
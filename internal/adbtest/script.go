@@ -0,0 +1,84 @@
+// Package adbtest has stand-ins for adb so the server can be exercised without phones:
+// FakeClient is an in-memory adb.Client, FakeADB is a fake adb executable that replays a
+// Script so the real client's argument building and output parsing run end to end.
+//
+//	fake, err := adbtest.NewFakeADB(t.TempDir(), adbtest.Script{
+//		Devices:  []adbtest.ScriptDevice{{Serial: "SER1", State: "device", Model: "Pixel_7a"}},
+//		Packages: map[string][]adbtest.ScriptPackage{"SER1": {{Name: "com.example", Path: "/data/app/x/base.apk"}}},
+//	})
+//	client, err := adb.New(adb.Config{ADBPath: fake.Path})
+package adbtest
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// Script is what the fake adb executable answers with
+type Script struct {
+	Version  string                     `json:"version,omitempty"` // first line of adb version
+	Devices  []ScriptDevice             `json:"devices,omitempty"`
+	Packages map[string][]ScriptPackage `json:"packages,omitempty"` // by serial, pm uninstall removes from here
+
+	// Output of install by APK file name, "Success" when missing
+	InstallOutput map[string]string `json:"install_output,omitempty"`
+
+	// Output of adb shell <command> by command, for anything that isn't pm
+	Shell map[string]string `json:"shell,omitempty"`
+
+	// Makes a command fail, keyed by the adb command (install, push, devices, ...) or
+	// "shell:<first word>" for shell commands (shell:pm)
+	Failures map[string]ScriptFailure `json:"failures,omitempty"`
+}
+
+type ScriptDevice struct {
+	Serial string `json:"serial"`
+	State  string `json:"state"` // device, unauthorized, offline, ...
+	Model  string `json:"model,omitempty"`
+}
+
+type ScriptPackage struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	System bool   `json:"system,omitempty"`
+}
+
+type ScriptFailure struct {
+	Stdout   string `json:"stdout,omitempty"`
+	Stderr   string `json:"stderr,omitempty"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// ScriptPath is where the fake executable at executablePath reads its script from
+func ScriptPath(executablePath string) string {
+	return executablePath + ".json"
+}
+
+// InvocationLogPath is where the fake executable at executablePath appends its arguments, one JSON array per line
+func InvocationLogPath(executablePath string) string {
+	return executablePath + ".log"
+}
+
+// LoadScript reads a script written by FakeADB.SetScript
+func LoadScript(path string) (Script, error) {
+	var script Script
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return script, err
+	}
+
+	err = json.Unmarshal(data, &script)
+
+	return script, err
+}
+
+// SaveScript writes script where LoadScript reads it from
+func SaveScript(path string, script Script) error {
+	data, err := json.MarshalIndent(script, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o600)
+}
@@ -0,0 +1,426 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// Keep the tail of a chatty job's output, not all of it
+const maxLogLines = 500
+
+var ErrNotFound = errors.New("job not found")
+var ErrFinished = errors.New("job already finished")
+var ErrQueueFull = errors.New("too many jobs queued or running, try again later")
+
+// Job is the snapshot handed out to callers, the manager keeps the live copy
+type Job struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	DeviceID   string     `json:"device_id,omitempty"`
+	SessionID  string     `json:"session_id,omitempty"`
+	Status     string     `json:"status"`
+	Progress   int        `json:"progress"`
+	Logs       []string   `json:"logs"`
+	Result     any        `json:"result,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Artifact   string     `json:"-"` // file the job produced (pull, bugreport), served separately
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+func (job Job) Finished() bool {
+	return job.FinishedAt != nil
+}
+
+// Work is what a job actually does. It must stop when ctx is cancelled.
+type Work func(ctx context.Context, reporter *Reporter) (any, error)
+
+type Spec struct {
+	Type      string
+	DeviceID  string
+	SessionID string
+	Work      Work
+}
+
+// Reporter lets running work update its job's progress, log and artifact
+type Reporter struct {
+	manager *Manager
+	id      string
+	dir     string
+}
+
+func (reporter *Reporter) JobID() string {
+	return reporter.id
+}
+
+func (reporter *Reporter) Progress(percent int) {
+	reporter.manager.update(reporter.id, func(job *Job) {
+		// Progress only moves forward, adb sometimes repeats or resets per file
+		job.Progress = max(job.Progress, min(100, percent))
+	})
+}
+
+func (reporter *Reporter) Logf(format string, args ...any) {
+	line := fmt.Sprintf(format, args...)
+
+	reporter.manager.update(reporter.id, func(job *Job) {
+		job.Logs = append(job.Logs, line)
+
+		if len(job.Logs) > maxLogLines {
+			job.Logs = job.Logs[len(job.Logs)-maxLogLines:]
+		}
+	})
+}
+
+// ArtifactPath returns where the job should write a file it produces, and records it
+func (reporter *Reporter) ArtifactPath(name string) (string, error) {
+	if err := os.MkdirAll(reporter.dir, 0o700); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(reporter.dir, filepath.Base(name))
+
+	reporter.manager.update(reporter.id, func(job *Job) {
+		job.Artifact = path
+	})
+
+	return path, nil
+}
+
+type entry struct {
+	job    Job
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Manager runs jobs detached from the HTTP request that created them, so a dropped
+// connection doesn't kill an install, and keeps finished jobs around for Retention.
+type Manager struct {
+	mu        sync.Mutex
+	jobs      map[string]*entry
+	retention time.Duration
+	maxActive int // 0 means unlimited
+	dir       string
+	ctx       context.Context
+	cancelAll context.CancelFunc
+	closing   bool
+	running   sync.WaitGroup
+	onFinish  []func(Job)
+}
+
+func NewManager(dir string, retention time.Duration) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	manager := &Manager{
+		jobs:      map[string]*entry{},
+		retention: retention,
+		dir:       dir,
+		ctx:       ctx,
+		cancelAll: cancel,
+	}
+
+	go manager.evictLoop()
+
+	return manager
+}
+
+// SetRetention changes how long finished jobs are kept around
+func (manager *Manager) SetRetention(retention time.Duration) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.retention = retention
+}
+
+// SetMaxActive caps how many jobs may be queued or running at once, 0 lifts the cap
+func (manager *Manager) SetMaxActive(maxActive int) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.maxActive = maxActive
+}
+
+// MaxActive is the cap set by SetMaxActive
+func (manager *Manager) MaxActive() int {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	return manager.maxActive
+}
+
+// OnFinish registers a callback run whenever a job reaches a final state
+func (manager *Manager) OnFinish(callback func(Job)) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.onFinish = append(manager.onFinish, callback)
+}
+
+func (manager *Manager) Submit(spec Spec) (Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return Job{}, err
+	}
+
+	ctx, cancel := context.WithCancel(manager.ctx)
+
+	jobEntry := &entry{
+		job: Job{
+			ID:        id,
+			Type:      spec.Type,
+			DeviceID:  spec.DeviceID,
+			SessionID: spec.SessionID,
+			Status:    StatusQueued,
+			Logs:      []string{},
+			CreatedAt: time.Now().UTC(),
+		},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	manager.mu.Lock()
+	if manager.closing {
+		manager.mu.Unlock()
+		cancel()
+		return Job{}, errors.New("server is shutting down")
+	}
+
+	if manager.maxActive > 0 && manager.activeLocked() >= manager.maxActive {
+		manager.mu.Unlock()
+		cancel()
+		return Job{}, ErrQueueFull
+	}
+
+	manager.jobs[id] = jobEntry
+	manager.running.Add(1)
+
+	// Taken before the work starts changing it
+	job := snapshot(jobEntry.job)
+	manager.mu.Unlock()
+
+	reporter := &Reporter{manager: manager, id: id, dir: filepath.Join(manager.dir, id)}
+
+	go manager.run(ctx, jobEntry, reporter, spec.Work)
+
+	return job, nil
+}
+
+func (manager *Manager) Get(id string) (Job, error) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	jobEntry, ok := manager.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+
+	return snapshot(jobEntry.job), nil
+}
+
+// List returns every job we still know about, newest first
+func (manager *Manager) List() []Job {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	jobs := make([]Job, 0, len(manager.jobs))
+	for _, jobEntry := range manager.jobs {
+		jobs = append(jobs, snapshot(jobEntry.job))
+	}
+
+	slices.SortFunc(jobs, func(a, b Job) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+
+	return jobs
+}
+
+// Active is the number of jobs queued or running
+func (manager *Manager) Active() int {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	return manager.activeLocked()
+}
+
+func (manager *Manager) activeLocked() int {
+	active := 0
+	for _, jobEntry := range manager.jobs {
+		if !jobEntry.job.Finished() {
+			active++
+		}
+	}
+
+	return active
+}
+
+// Cancel stops a running job, the job ends up cancelled once its work notices
+func (manager *Manager) Cancel(id string) (Job, error) {
+	manager.mu.Lock()
+	jobEntry, ok := manager.jobs[id]
+	manager.mu.Unlock()
+
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+
+	jobEntry.cancel()
+
+	// Give the work a moment to unwind so the caller sees the final state
+	select {
+	case <-jobEntry.done:
+	case <-time.After(2 * time.Second):
+	}
+
+	job, err := manager.Get(id)
+	if err != nil {
+		return Job{}, err
+	}
+
+	if job.Finished() && job.Status != StatusCancelled {
+		return job, ErrFinished
+	}
+
+	return job, nil
+}
+
+// Shutdown stops taking new jobs and waits for running ones until ctx is done, then cancels whatever is left
+func (manager *Manager) Shutdown(ctx context.Context) error {
+	manager.mu.Lock()
+	manager.closing = true
+	manager.mu.Unlock()
+
+	drained := make(chan struct{})
+
+	go func() {
+		manager.running.Wait()
+		close(drained)
+	}()
+
+	var err error
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+		manager.cancelAll()
+		<-drained
+	}
+
+	// Also stops the eviction loop
+	manager.cancelAll()
+
+	return err
+}
+
+func (manager *Manager) run(ctx context.Context, jobEntry *entry, reporter *Reporter, work Work) {
+	defer manager.running.Done()
+	defer close(jobEntry.done)
+	defer jobEntry.cancel()
+
+	manager.update(jobEntry.job.ID, func(job *Job) {
+		now := time.Now().UTC()
+		job.Status = StatusRunning
+		job.StartedAt = &now
+	})
+
+	result, err := work(ctx, reporter)
+
+	var finished Job
+	var callbacks []func(Job)
+
+	manager.mu.Lock()
+
+	now := time.Now().UTC()
+	job := &jobEntry.job
+	job.FinishedAt = &now
+	job.Result = result
+
+	switch {
+	case ctx.Err() != nil:
+		job.Status = StatusCancelled
+		job.Error = "cancelled"
+	case err != nil:
+		job.Status = StatusFailed
+		job.Error = err.Error()
+	default:
+		job.Status = StatusSucceeded
+		job.Progress = 100
+	}
+
+	finished = snapshot(*job)
+	callbacks = slices.Clone(manager.onFinish)
+
+	manager.mu.Unlock()
+
+	for _, callback := range callbacks {
+		callback(finished)
+	}
+}
+
+func (manager *Manager) update(id string, change func(job *Job)) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if jobEntry, ok := manager.jobs[id]; ok && !jobEntry.job.Finished() {
+		change(&jobEntry.job)
+	}
+}
+
+func (manager *Manager) evictLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-manager.ctx.Done():
+			return
+		case <-ticker.C:
+			manager.evict(time.Now())
+		}
+	}
+}
+
+func (manager *Manager) evict(now time.Time) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	for id, jobEntry := range manager.jobs {
+		if jobEntry.job.Finished() && now.Sub(*jobEntry.job.FinishedAt) > manager.retention {
+			delete(manager.jobs, id)
+			_ = os.RemoveAll(filepath.Join(manager.dir, id))
+		}
+	}
+}
+
+// Copy the slices so callers can't race with the running job
+func snapshot(job Job) Job {
+	job.Logs = slices.Clone(job.Logs)
+	return job
+}
+
+func newJobID() (string, error) {
+	idBytes := make([]byte, 8)
+
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", fmt.Errorf("failed to generate job id: %w", err)
+	}
+
+	return hex.EncodeToString(idBytes), nil
+}
@@ -0,0 +1,288 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// waitFinished polls until the job reaches a final state
+func waitFinished(t *testing.T, manager *Manager, id string) Job {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+		job, err := manager.Get(id)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+
+		if job.Finished() {
+			return job
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatalf("job %s didn't finish", id)
+	return Job{}
+}
+
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+
+	manager := NewManager(t.TempDir(), time.Hour)
+	t.Cleanup(func() { _ = manager.Shutdown(context.Background()) })
+
+	return manager
+}
+
+func TestSubmitRunsToCompletion(t *testing.T) {
+	manager := newTestManager(t)
+
+	finished := make(chan Job, 1)
+	manager.OnFinish(func(job Job) { finished <- job })
+
+	submitted, err := manager.Submit(Spec{
+		Type:      "install",
+		DeviceID:  "SER1",
+		SessionID: "session-1",
+		Work: func(ctx context.Context, reporter *Reporter) (any, error) {
+			reporter.Progress(40)
+			reporter.Progress(10) // never goes back
+			reporter.Logf("installing %s", "app.apk")
+
+			return map[string]string{"package": "com.example"}, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+
+	if submitted.ID == "" || submitted.SessionID != "session-1" || submitted.Finished() {
+		t.Errorf("Submit returned %+v", submitted)
+	}
+
+	job := waitFinished(t, manager, submitted.ID)
+
+	if job.Status != StatusSucceeded || job.Progress != 100 || job.StartedAt == nil || job.Error != "" {
+		t.Errorf("finished job is %+v", job)
+	}
+
+	if len(job.Logs) != 1 || job.Logs[0] != "installing app.apk" {
+		t.Errorf("logs are %q", job.Logs)
+	}
+
+	if result, ok := job.Result.(map[string]string); !ok || result["package"] != "com.example" {
+		t.Errorf("result is %#v", job.Result)
+	}
+
+	select {
+	case callback := <-finished:
+		if callback.ID != job.ID || callback.Status != StatusSucceeded {
+			t.Errorf("OnFinish got %+v", callback)
+		}
+	case <-time.After(time.Second):
+		t.Error("OnFinish wasn't called")
+	}
+
+	if jobs := manager.List(); len(jobs) != 1 || jobs[0].ID != job.ID {
+		t.Errorf("List is %+v", jobs)
+	}
+
+	if _, err := manager.Get("nope"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of an unknown job got %v", err)
+	}
+}
+
+func TestFailedWork(t *testing.T) {
+	manager := newTestManager(t)
+
+	submitted, err := manager.Submit(Spec{Type: "push", Work: func(context.Context, *Reporter) (any, error) {
+		return nil, errors.New("device offline")
+	}})
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+
+	if job := waitFinished(t, manager, submitted.ID); job.Status != StatusFailed || job.Error != "device offline" {
+		t.Errorf("finished job is %+v", job)
+	}
+}
+
+func TestQueueFullAtMaxActive(t *testing.T) {
+	manager := newTestManager(t)
+	manager.SetMaxActive(2)
+
+	release := make(chan struct{})
+	blocked := func(ctx context.Context, reporter *Reporter) (any, error) {
+		<-release
+		return nil, nil
+	}
+
+	var ids []string
+	for range 2 {
+		job, err := manager.Submit(Spec{Type: "install", Work: blocked})
+		if err != nil {
+			t.Fatalf("Submit: %v", err)
+		}
+
+		ids = append(ids, job.ID)
+	}
+
+	if active := manager.Active(); active != 2 {
+		t.Errorf("Active is %d, want 2", active)
+	}
+
+	if _, err := manager.Submit(Spec{Type: "install", Work: blocked}); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("third Submit got %v, want ErrQueueFull", err)
+	}
+
+	close(release)
+
+	for _, id := range ids {
+		waitFinished(t, manager, id)
+	}
+
+	// Finished jobs don't count against the cap
+	job, err := manager.Submit(Spec{Type: "install", Work: blocked})
+	if err != nil {
+		t.Fatalf("Submit after the others finished: %v", err)
+	}
+
+	waitFinished(t, manager, job.ID)
+}
+
+func TestCancelRunningJob(t *testing.T) {
+	manager := newTestManager(t)
+
+	started := make(chan struct{})
+
+	submitted, err := manager.Submit(Spec{Type: "bugreport", Work: func(ctx context.Context, reporter *Reporter) (any, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}})
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+
+	<-started
+
+	job, err := manager.Cancel(submitted.ID)
+	if err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+
+	if job.Status != StatusCancelled || job.Error != "cancelled" {
+		t.Errorf("cancelled job is %+v", job)
+	}
+
+	// Once it's over there's nothing left to cancel
+	if _, err := manager.Cancel(submitted.ID); err != nil {
+		t.Errorf("cancelling a cancelled job got %v", err)
+	}
+
+	if _, err := manager.Cancel("nope"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Cancel of an unknown job got %v", err)
+	}
+}
+
+func TestCancelFinishedJob(t *testing.T) {
+	manager := newTestManager(t)
+
+	submitted, err := manager.Submit(Spec{Type: "install", Work: func(context.Context, *Reporter) (any, error) {
+		return nil, nil
+	}})
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+
+	waitFinished(t, manager, submitted.ID)
+
+	if job, err := manager.Cancel(submitted.ID); !errors.Is(err, ErrFinished) || job.Status != StatusSucceeded {
+		t.Errorf("got %+v, %v, want the succeeded job and ErrFinished", job, err)
+	}
+}
+
+func TestEvictAfterRetention(t *testing.T) {
+	manager := newTestManager(t)
+	manager.SetRetention(time.Minute)
+
+	submitted, err := manager.Submit(Spec{Type: "pull", Work: func(ctx context.Context, reporter *Reporter) (any, error) {
+		path, err := reporter.ArtifactPath("../../screen.png")
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, os.WriteFile(path, []byte("PNG"), 0o600)
+	}})
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+
+	job := waitFinished(t, manager, submitted.ID)
+
+	// The name can't climb out of the job's own directory
+	if content, err := os.ReadFile(job.Artifact); err != nil || string(content) != "PNG" {
+		t.Fatalf("artifact %q: %q, %v", job.Artifact, content, err)
+	}
+
+	manager.evict(job.FinishedAt.Add(30 * time.Second))
+
+	if _, err := manager.Get(job.ID); err != nil {
+		t.Fatalf("evicted before retention ran out: %v", err)
+	}
+
+	manager.evict(job.FinishedAt.Add(2 * time.Minute))
+
+	if _, err := manager.Get(job.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after retention got %v, want ErrNotFound", err)
+	}
+
+	if _, err := os.Stat(job.Artifact); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("artifact still there after eviction: %v", err)
+	}
+}
+
+func TestShutdownDrainsThenCancels(t *testing.T) {
+	manager := NewManager(t.TempDir(), time.Hour)
+
+	quick, err := manager.Submit(Spec{Type: "install", Work: func(context.Context, *Reporter) (any, error) {
+		time.Sleep(20 * time.Millisecond)
+		return nil, nil
+	}})
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+
+	stuck, err := manager.Submit(Spec{Type: "bugreport", Work: func(ctx context.Context, reporter *Reporter) (any, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}})
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	if err := manager.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown got %v, want the deadline it gave up at", err)
+	}
+
+	if job, _ := manager.Get(quick.ID); job.Status != StatusSucceeded {
+		t.Errorf("quick job ended %s, want it drained", job.Status)
+	}
+
+	if job, _ := manager.Get(stuck.ID); job.Status != StatusCancelled {
+		t.Errorf("stuck job ended %s, want it cancelled", job.Status)
+	}
+
+	if _, err := manager.Submit(Spec{Type: "install", Work: func(context.Context, *Reporter) (any, error) { return nil, nil }}); err == nil {
+		t.Error("Submit succeeded after Shutdown")
+	}
+}
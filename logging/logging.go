@@ -0,0 +1,163 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const redacted = "[redacted]"
+
+// Attribute keys whose values never make it into a log line, matched case-insensitively anywhere in the key
+var sensitiveKeys = []string{"token", "authorization", "cookie", "csrf", "secret", "password", "key"}
+
+// Setup makes slog the process logger. log.Printf calls from before this existed end up
+// going through the same handler, at info level.
+func Setup(level string, format string, out io.Writer) error {
+	var slogLevel slog.Level
+	if err := slogLevel.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("unknown log level %q, use debug, info, warn or error", level)
+	}
+
+	options := &slog.HandlerOptions{Level: slogLevel, ReplaceAttr: redactAttr}
+
+	var handler slog.Handler
+
+	switch format {
+	case "text":
+		handler = slog.NewTextHandler(out, options)
+	case "json":
+		handler = slog.NewJSONHandler(out, options)
+	default:
+		return fmt.Errorf("unknown log format %q, use text or json", format)
+	}
+
+	slog.SetDefault(slog.New(&contextHandler{Handler: handler}))
+
+	// SetDefault points the log package at slog, drop the timestamp it would add on top of ours
+	log.SetFlags(0)
+
+	return nil
+}
+
+func redactAttr(groups []string, attr slog.Attr) slog.Attr {
+	if IsSensitive(attr.Key) {
+		return slog.String(attr.Key, redacted)
+	}
+
+	return attr
+}
+
+// IsSensitive says whether a header, query parameter or attribute name carries a credential
+func IsSensitive(name string) bool {
+	name = strings.ToLower(name)
+
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(name, sensitive) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RedactQuery is the query string safe for logging
+func RedactQuery(query url.Values) string {
+	names := slices.Sorted(maps.Keys(query))
+	pairs := make([]string, 0, len(names))
+
+	for _, name := range names {
+		for _, value := range query[name] {
+			if IsSensitive(name) {
+				value = redacted
+			} else {
+				value = url.QueryEscape(value)
+			}
+
+			pairs = append(pairs, url.QueryEscape(name)+"="+value)
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+type contextKey string
+
+const requestKey contextKey = "request"
+
+// requestInfo is shared by everything handling one request. Middleware further in adds to it
+// (e.g. the session once it's known) and every log line made with that context carries it.
+type requestInfo struct {
+	id    string
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+// WithRequestID starts a new request scope in ctx
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestKey, &requestInfo{id: requestID})
+}
+
+// RequestID is the id of the request ctx belongs to, empty outside of one
+func RequestID(ctx context.Context) string {
+	if info, ok := ctx.Value(requestKey).(*requestInfo); ok {
+		return info.id
+	}
+
+	return ""
+}
+
+// AddAttrs attaches attributes to every later log line of the request ctx belongs to
+func AddAttrs(ctx context.Context, attrs ...slog.Attr) {
+	info, ok := ctx.Value(requestKey).(*requestInfo)
+	if !ok {
+		return
+	}
+
+	info.mu.Lock()
+	defer info.mu.Unlock()
+
+	info.attrs = append(info.attrs, attrs...)
+}
+
+// NewRequestID is 16 random hex characters, plenty to grep for
+func NewRequestID() string {
+	idBytes := make([]byte, 8)
+	_, _ = rand.Read(idBytes)
+
+	return hex.EncodeToString(idBytes)
+}
+
+// contextHandler adds the request id and attributes from the context to each record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (handler *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if info, ok := ctx.Value(requestKey).(*requestInfo); ok {
+		record.AddAttrs(slog.String("request_id", info.id))
+
+		info.mu.Lock()
+		record.AddAttrs(info.attrs...)
+		info.mu.Unlock()
+	}
+
+	return handler.Handler.Handle(ctx, record)
+}
+
+func (handler *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: handler.Handler.WithAttrs(attrs)}
+}
+
+func (handler *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: handler.Handler.WithGroup(name)}
+}
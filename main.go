@@ -1,41 +1,485 @@
 package main
 
 import (
+	"adb-server/audit"
+	"adb-server/authentication"
+	"adb-server/config"
+	"adb-server/discovery"
+	"adb-server/events"
 	"adb-server/handlers"
+	"adb-server/internal/adb"
+	"adb-server/logging"
+	"adb-server/metrics"
 	"adb-server/middleware"
 	"adb-server/models"
+	"adb-server/registry"
+	"adb-server/rpc"
+	"adb-server/tracing"
+	"adb-server/transport"
 	"adb-server/utilities"
+	"adb-server/webhooks"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
-	"net/http"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 )
 
 func main() {
-	port := utilities.PickRandomPort(35000, 49151)
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(runConfigCommand(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "trace-collector" {
+		os.Exit(runTraceCollector(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "fake-adb" {
+		os.Exit(runFakeADB(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && clientCommands[os.Args[1]] != nil {
+		os.Exit(runClientCommand(os.Args[1], os.Args[2:]))
+	}
+
+	os.Exit(runServer(os.Args[1:]))
+}
 
-	server := models.NewServer(port)
+func runServer(args []string) int {
+	configDir, err := utilities.ConfigDir()
+	if err != nil {
+		log.Print(err)
+		return models.ExitError
+	}
+
+	cfg, err := config.Load(args, configDir)
+	if errors.Is(err, flag.ErrHelp) {
+		return models.ExitOK
+	}
+	if err != nil {
+		log.Print(err)
+		return models.ExitConfig
+	}
+
+	if err := logging.Setup(cfg.Log.Level, cfg.Log.Format, os.Stderr); err != nil {
+		log.Print(err)
+		return models.ExitConfig
+	}
+
+	if cfg.File != "" {
+		slog.Info("Using config file", "path", cfg.File)
+	}
 
-	// Set up protected routes
-	server.ProtectedMux.HandleFunc("/v1/health", handlers.HandleServerHealth)
-	server.ProtectedMux.HandleFunc("/v1/adb/list-devices", handlers.HandleListDevices)
-	server.ProtectedMux.HandleFunc("/v1/adb/list-packages", handlers.HandleListPackages)
-	server.ProtectedMux.HandleFunc("/v1/adb/install-package", handlers.HandleInstallApp)
-	server.ProtectedMux.HandleFunc("/v1/adb/uninstall-package", handlers.HandleUninstallApp)
+	if cfg.Tracing.Endpoint != "" {
+		exporter, err := startTracing(cfg.Tracing)
+		if err != nil {
+			log.Print(err)
+			return models.ExitConfig
+		}
 
-	protectedRouteHandler := middleware.ProtectedRoute(server.ProtectedMux)
+		// After Run, so spans from requests and jobs that were drained still go out
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := exporter.Shutdown(ctx); err != nil {
+				slog.Warn("Failed to flush traces", "error", err)
+			}
+		}()
+	}
+
+	if err := loadCredentials(cfg, configDir); err != nil {
+		log.Print(err)
+		return models.ExitError
+	}
+
+	var listener net.Listener
+	var port int
+
+	if cfg.Server.UnixSocket != "" {
+		listener, err = transport.ListenUnix(cfg.Server.UnixSocket)
+	} else {
+		listener, port, err = utilities.ListenRandomPort(cfg.Server.BindAddress, cfg.Server.PortMin, cfg.Server.PortMax)
+	}
+
+	if err != nil {
+		log.Print(err)
+		return models.ExitError
+	}
+
+	serverMetrics := metrics.NewServerMetrics()
+
+	server, err := models.NewServer(port, adb.Config{
+		ADBPath:          cfg.ADB.Path,
+		ServerAddress:    cfg.ADB.ServerAddress,
+		ReadTimeout:      cfg.ADB.ReadTimeout,
+		InstallTimeout:   cfg.ADB.InstallTimeout,
+		UninstallTimeout: cfg.ADB.UninstallTimeout,
+		TransferTimeout:  cfg.ADB.TransferTimeout,
+		BugreportTimeout: cfg.ADB.BugreportTimeout,
+		Observer:         serverMetrics,
+		RecordTo:         cfg.ADB.RecordTo,
+		ReplayFrom:       cfg.ADB.ReplayFrom,
+		ReplayRealTime:   cfg.ADB.ReplayRealTime,
+	})
+	if err != nil {
+		listener.Close()
+		log.Print(err)
+		return models.ExitError
+	}
+
+	switch {
+	case cfg.ADB.ReplayFrom != "":
+		slog.Warn("Replaying adb from a cassette, no devices are being talked to", "cassette", cfg.ADB.ReplayFrom)
+	case cfg.ADB.RecordTo != "":
+		slog.Info("Recording adb invocations", "cassette", cfg.ADB.RecordTo)
+	}
+
+	// Keep running, /v1/health/ready reports it so the desktop app can tell the user what to install
+	if _, err := exec.LookPath(cfg.ADB.Path); err != nil && cfg.ADB.ReplayFrom == "" {
+		slog.Warn("adb not found, install Android SDK Platform-Tools or set adb.path", "adb_path", cfg.ADB.Path)
+	}
+
+	server.BindAddress = cfg.Server.BindAddress
+	server.Listener = listener
+	server.ShutdownTimeout = cfg.Server.ShutdownTimeout
+	server.ReadHeaderTimeout = cfg.Limits.ReadHeaderTimeout
+	server.MaxHeaderBytes = cfg.Limits.MaxHeaderBytes
+	server.Jobs.SetRetention(cfg.Jobs.Retention)
+	server.Jobs.SetMaxActive(cfg.Jobs.MaxActive)
+	handlers.SetBatchConcurrency(cfg.Limits.BatchConcurrency)
+	authentication.SetClientCertificateScopes(cfg.TLS.ClientScopes)
+	handlers.SetUploadLimits(server.ADBConfig.TempDir, int64(cfg.Limits.MaxUploadBytes))
+
+	if server.AuditLog, err = openAuditLog(cfg.Server.AuditLog, configDir); err != nil {
+		log.Print(err)
+		return models.ExitError
+	}
+
+	server.AuditLog.OnRecord(func(entry audit.Entry) {
+		server.Events.Publish(events.TypeOperation, entry)
+	})
+
+	if server.Registry, err = openRegistry(cfg.Server.RegistryFile, configDir); err != nil {
+		log.Print(err)
+		return models.ExitError
+	}
+
+	if server.Webhooks, err = openWebhooks(cfg.Webhooks, configDir); err != nil {
+		log.Print(err)
+		return models.ExitError
+	}
+
+	server.Webhooks.Watch(server.Events)
+	server.OnDrain(server.Webhooks.Stop)
+
+	if cfg.TLS.Enabled {
+		tlsConfig, fingerprint, err := transport.ServerTLSConfig(transport.TLSOptions{
+			CertFile:     cfg.TLS.CertFile,
+			KeyFile:      cfg.TLS.KeyFile,
+			StateDir:     configDir,
+			Hosts:        append([]string{"localhost", "127.0.0.1", "::1", cfg.Server.BindAddress}, cfg.Server.AllowedHosts...),
+			ClientCAFile: cfg.TLS.ClientCAFile,
+			ClientAuth:   cfg.TLS.ClientAuth,
+		})
+		if err != nil {
+			log.Print(err)
+			return models.ExitError
+		}
+
+		server.TLSConfig = tlsConfig
+		handlers.SetTLSFingerprint(fingerprint)
+
+		// Clients only get it from here or the discovery file, anything the server says over the
+		// connection being checked proves nothing
+		log.Printf("TLS certificate fingerprint (SHA-256): %s, give it to clients out of band (adb-server pair -fingerprint)", fingerprint)
+	} else if cfg.Server.UnixSocket == "" && !config.IsLoopback(cfg.Server.BindAddress) {
+		log.Printf("Warning: serving plain HTTP on %s, auth cookies can be sniffed, use -tls", cfg.Server.BindAddress)
+	}
+
+	// Every route is described in apiRoutes, which also serves the description at /v1/openapi.json
+	readiness := handlers.HandleReadiness(handlers.ReadinessOptions{
+		ADBPath:       cfg.ADB.Path,
+		ServerAddress: cfg.ADB.ServerAddress,
+		TempDir:       server.ADBConfig.TempDir,
+		Replaying:     cfg.ADB.ReplayFrom != "",
+	})
+
+	apiRoutes(server, readiness, int64(cfg.Limits.MaxUploadBytes)).Register(server.MainMux, server.ProtectedMux)
+
+	// The gRPC service shares the protected mux, and with it sessions, scopes and the audit log
+	grpcServer := rpc.NewServer(rpc.Options{UploadDir: server.ADBConfig.TempDir, MaxUploadBytes: int64(cfg.Limits.MaxUploadBytes)})
+	grpcServer.Register(server.ProtectedMux)
+	server.OnDrain(grpcServer.Stop)
+
+	protectedRouteHandler := middleware.ProtectedRoute(middleware.CSRFProtect(server.ProtectedMux))
 
 	// Applying ADB client middleware it to all protected routes since ADB operations would be protected
 	// Must change in the future though
-	adbRouteHandler := middleware.WithADBClient(server.ADBClient)(protectedRouteHandler)
+	adbRouteHandler := middleware.WithADBClient(server.ADBClient)(
+		middleware.WithAuditLog(server.AuditLog)(
+			middleware.WithJobManager(server.Jobs)(
+				middleware.WithEvents(server.Events)(
+					middleware.WithRegistry(server.Registry)(
+						middleware.WithWebhooks(server.Webhooks)(protectedRouteHandler),
+					),
+				),
+			),
+		),
+	)
+
+	if cfg.Metrics.Enabled {
+		registerStateGauges(serverMetrics, server)
+		server.MainMux.HandleFunc("GET /metrics", handlers.HandleMetrics(serverMetrics.Registry, cfg.Metrics.Token))
+	}
+
+	for _, prefix := range models.ProtectedPrefixes {
+		server.MainMux.Handle(prefix, adbRouteHandler)
+	}
+
+	// Outermost first: wrong Host, then CORS preflights, then cross-origin browsers
+	corsPolicy := middleware.CORSPolicy{
+		AllowedOrigins: cfg.Server.AllowedOrigins,
+		MaxAge:         10 * time.Minute,
+	}
+
+	// Logging, tracing and metrics go around everything so rejected requests are seen too
+	server.Handler = middleware.WithRequestLogging(
+		middleware.WithTracing(server.RoutePattern)(
+			middleware.WithHTTPMetrics(serverMetrics, server.RoutePattern)(
+				middleware.HostGuard(hostsFor(cfg.Server.BindAddress, port, cfg.Server.AllowedHosts))(
+					middleware.CORS(corsPolicy)(
+						middleware.OriginGuard(corsPolicy.AllowedOrigins)(server.MainMux),
+					),
+				),
+			),
+		),
+	)
+
+	// So adb-server devices and friends can find us without being told where
+	discoveryPath := discovery.Path(configDir)
+
+	if err := discovery.Write(discoveryPath, discoveryInfo(server)); err != nil {
+		slog.Warn("Failed to write discovery file", "path", discoveryPath, "error", err)
+	}
+
+	err = server.Run()
+	if err != nil {
+		log.Print(err)
+	}
+
+	if err := discovery.Remove(discoveryPath); err != nil {
+		slog.Warn("Failed to remove discovery file", "path", discoveryPath, "error", err)
+	}
+
+	return models.ExitCode(err)
+}
 
-	server.MainMux.HandleFunc("/v1/pair", handlers.PairWithServer)
-	server.MainMux.Handle("/v1/", adbRouteHandler)
+// Pairings are kept in the user config dir so restarts don't force everyone to pair again.
+// auth.credentials-key (32 bytes, hex or base64) encrypts the file with that key,
+// auth.keyring does the same with a key we generate and keep next to it.
+func loadCredentials(cfg *config.Config, configDir string) error {
+	var key []byte
+	var err error
 
-	serverAddress := fmt.Sprintf("127.0.0.1:%d", port)
-	log.Printf("Server starting on http://%s", serverAddress)
-	log.Printf("Pairing code: %d", port)
+	if cfg.Auth.CredentialsKey != "" {
+		key, err = authentication.ParseCredentialsKey(cfg.Auth.CredentialsKey)
+	} else if cfg.Auth.Keyring {
+		key, err = authentication.KeyringKey(configDir)
+	}
 
-	if err := http.ListenAndServe(serverAddress, server.MainMux); err != nil {
-		log.Fatal(err)
+	if err != nil {
+		return err
 	}
+
+	credentialsPath := cfg.Auth.CredentialsFile
+	if credentialsPath == "" {
+		credentialsPath = authentication.DefaultCredentialsPath(configDir)
+	}
+
+	if err := authentication.LoadCredentials(credentialsPath, key); err != nil {
+		return err
+	}
+
+	if cfg.ResetPairings {
+		return authentication.ResetSessions()
+	}
+
+	return nil
+}
+
+// discoveryInfo is how local clients reach this server, written to the discovery file
+func discoveryInfo(server *models.Server) discovery.Info {
+	info := discovery.Info{PID: os.Getpid(), TLSFingerprint: handlers.TLSFingerprint(), StartedAt: time.Now().UTC()}
+
+	address := server.Listener.Addr()
+	if address.Network() == "unix" {
+		info.UnixSocket = address.String()
+		return info
+	}
+
+	host, port, _ := net.SplitHostPort(address.String())
+
+	// Listening on every interface, loopback is the one that's sure to reach it from here
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+
+	scheme := "http"
+	if server.TLSConfig != nil {
+		scheme = "https"
+	}
+
+	info.URL = scheme + "://" + net.JoinHostPort(host, port)
+
+	return info
+}
+
+func openAuditLog(path string, configDir string) (*audit.Logger, error) {
+	if path == "" {
+		path = filepath.Join(configDir, "audit", "audit.log")
+	}
+
+	return audit.Open(audit.Options{Path: path})
+}
+
+func startTracing(tracingConfig config.TracingConfig) (*tracing.Exporter, error) {
+	headers := map[string]string{}
+
+	for _, header := range tracingConfig.Headers {
+		name, value, _ := strings.Cut(header, "=")
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	exporter, err := tracing.NewExporter(tracing.ExporterOptions{
+		Endpoint:    tracingConfig.Endpoint,
+		ServiceName: tracingConfig.ServiceName,
+		Headers:     headers,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tracing.SetExporter(exporter)
+	slog.Info("Exporting traces", "endpoint", tracingConfig.Endpoint)
+
+	return exporter, nil
+}
+
+// How long the adb_server_devices gauge reuses one adb devices
+const devicesGaugeTTL = 10 * time.Second
+
+// Gauges read state the server already keeps, on every scrape
+func registerStateGauges(serverMetrics *metrics.ServerMetrics, server *models.Server) {
+	// Scrapes don't count as adb operations, and several scrapers in a row share one adb devices
+	adbClient := adb.Unobserved(server.ADBClient)
+
+	var (
+		devicesMu      sync.Mutex
+		devicesSamples []metrics.GaugeSample
+		devicesAt      time.Time
+	)
+
+	serverMetrics.Registry.NewGaugeFunc("adb_server_devices", "Devices adb reports, by state.", func() []metrics.GaugeSample {
+		devicesMu.Lock()
+		defer devicesMu.Unlock()
+
+		if time.Since(devicesAt) < devicesGaugeTTL {
+			return devicesSamples
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		devices, err := adbClient.Devices(ctx)
+		if err != nil {
+			return nil
+		}
+
+		counts := map[string]float64{}
+		for _, device := range devices {
+			counts[device.State]++
+		}
+
+		var samples []metrics.GaugeSample
+		for state, count := range counts {
+			samples = append(samples, metrics.GaugeSample{Labels: []string{state}, Value: count})
+		}
+
+		devicesSamples, devicesAt = samples, time.Now()
+
+		return samples
+	}, "state")
+
+	serverMetrics.Registry.NewGaugeFunc("adb_server_jobs_active", "Jobs queued or running.", func() []metrics.GaugeSample {
+		return []metrics.GaugeSample{{Value: float64(server.Jobs.Active())}}
+	})
+
+	serverMetrics.Registry.NewGaugeFunc("adb_server_sessions", "Paired client sessions.", func() []metrics.GaugeSample {
+		return []metrics.GaugeSample{{Value: float64(authentication.SessionCount())}}
+	})
+
+	serverMetrics.Registry.NewGaugeFunc("adb_server_device_lock_waiters", "Operations waiting for a device lock.", func() []metrics.GaugeSample {
+		waiting := 0
+		for _, lock := range server.ADBClient.Locks() {
+			waiting += lock.QueueDepth
+		}
+
+		return []metrics.GaugeSample{{Value: float64(waiting)}}
+	})
+}
+
+func openRegistry(path string, configDir string) (*registry.Registry, error) {
+	if path == "" {
+		path = filepath.Join(configDir, "registry.json")
+	}
+
+	return registry.Open(path)
+}
+
+func openWebhooks(webhooksConfig config.WebhooksConfig, configDir string) (*webhooks.Manager, error) {
+	path := webhooksConfig.File
+	if path == "" {
+		path = filepath.Join(configDir, "webhooks.json")
+	}
+
+	return webhooks.Open(webhooks.Options{
+		Path:         path,
+		Timeout:      webhooksConfig.Timeout,
+		MaxAttempts:  webhooksConfig.MaxAttempts,
+		AllowedHosts: webhooksConfig.AllowedHosts,
+	})
+}
+
+// The Host headers a legitimate client can send us. Validate makes sure wildcard binds come
+// with allowed hosts, we can't know our own names for those.
+func hostsFor(bindAddress string, port int, extraHosts []string) []string {
+	var hosts []string
+
+	if config.IsLoopback(bindAddress) {
+		hosts = append(hosts, "127.0.0.1", "localhost", "[::1]")
+	} else if ip := net.ParseIP(bindAddress); ip == nil || !ip.IsUnspecified() {
+		hosts = append(hosts, bindAddress)
+	}
+
+	hosts = append(hosts, extraHosts...)
+
+	withPorts := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if strings.Contains(host, ":") && !strings.HasPrefix(host, "[") {
+			host = "[" + host + "]" // bare IPv6
+		}
+
+		withPorts = append(withPorts, fmt.Sprintf("%s:%d", host, port))
+	}
+
+	return withPorts
 }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"adb-server/models"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// adb-server mcp [-server URL]
+// Serves MCP over stdio for assistants that start their tools as processes. Every message is
+// passed on to the running server's /v1/mcp with the session adb-server pair stored, so the
+// tools get that session's scopes and end up in the audit log under it.
+func runMCPCommand(ctx context.Context, args []string) int {
+	flags, common := newClientFlagSet("mcp", "mcp [-server URL]")
+	if _, code, ok := parseClientFlags(flags, args); !ok {
+		return code
+	}
+
+	adbServer, address, err := connectClient(common)
+	if err != nil {
+		return clientError(err)
+	}
+
+	// stdout is the protocol's, anything for people goes to stderr
+	logger := log.New(os.Stderr, "adb-server mcp: ", 0)
+	logger.Printf("passing messages on to %s", address)
+
+	var stdout sync.Mutex
+	var pending sync.WaitGroup
+
+	write := func(message []byte) {
+		stdout.Lock()
+		defer stdout.Unlock()
+
+		os.Stdout.Write(append(bytes.TrimSpace(message), '\n'))
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+
+	for {
+		line, readError := reader.ReadBytes('\n')
+
+		if message := bytes.TrimSpace(line); len(message) > 0 && !json.Valid(message) {
+			// The client would refuse to send it, the server's answer would be the same
+			write([]byte(`{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"invalid JSON-RPC message"}}`))
+		} else if len(message) > 0 {
+			// A slow tool call mustn't hold up a ping or a cancellation behind it
+			pending.Add(1)
+			go func() {
+				defer pending.Done()
+
+				reply, err := adbServer.MCP(ctx, message)
+				if err != nil {
+					logger.Print(err)
+					reply = mcpTransportError(message, err)
+				}
+
+				if len(reply) > 0 {
+					write(reply)
+				}
+			}()
+		}
+
+		if readError != nil {
+			if !errors.Is(readError, io.EOF) {
+				logger.Print(readError)
+			}
+
+			break
+		}
+	}
+
+	pending.Wait()
+
+	return models.ExitOK
+}
+
+// mcpTransportError answers a request the server never saw, notifications need no answer
+func mcpTransportError(message []byte, err error) []byte {
+	var request struct {
+		ID json.RawMessage `json:"id"`
+	}
+
+	if json.Unmarshal(message, &request) != nil || len(request.ID) == 0 {
+		return nil
+	}
+
+	reply, _ := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      request.ID,
+		"error":   map[string]any{"code": -32603, "message": fmt.Sprintf("adb-server: %v", err)},
+	})
+
+	return reply
+}
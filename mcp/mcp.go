@@ -0,0 +1,186 @@
+// Package mcp serves the API's tools over the Model Context Protocol, so assistants can drive
+// devices. The tools are the routes marked with a Tool name, and a tool call is served by the
+// route itself, so scopes, validation, device locks and the audit log are the same as over HTTP.
+//
+// Only the streamable HTTP transport lives here, and only its request/response half: every
+// message is a POST answered with JSON. adb-server mcp bridges stdio clients to it.
+package mcp
+
+import (
+	"adb-server/routes"
+	"adb-server/utilities"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// LatestProtocolVersion is what we answer clients that ask for a version we don't know
+const LatestProtocolVersion = "2025-06-18"
+
+var protocolVersions = []string{LatestProtocolVersion, "2025-03-26", "2024-11-05"}
+
+// JSON-RPC error codes
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+)
+
+type Options struct {
+	Name            string
+	Version         string
+	MaxMessageBytes int64 // a base64 APK makes for a big tools/call
+}
+
+type Server struct {
+	options Options
+	handler http.Handler // serves the routes tool calls turn into
+	tools   func() map[string]routes.Route
+}
+
+// NewServer offers api's tools, calling them through handler. The tools are read on first use,
+// so routes added after this are still found.
+func NewServer(options Options, api *routes.Registry, handler http.Handler) *Server {
+	if options.MaxMessageBytes == 0 {
+		options.MaxMessageBytes = 4 << 20
+	}
+
+	return &Server{
+		options: options,
+		handler: handler,
+		tools: sync.OnceValue(func() map[string]routes.Route {
+			tools := map[string]routes.Route{}
+			for _, route := range api.Tools() {
+				tools[route.Tool] = route
+			}
+
+			return tools
+		}),
+	}
+}
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func errorf(code int, format string, args ...any) *rpcError {
+	return &rpcError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// ServeHTTP handles one message. Requests are answered with JSON, notifications and
+// responses from the client with a 202.
+func (server *Server) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	if !strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		http.Error(res, "content type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	if version := req.Header.Get("MCP-Protocol-Version"); version != "" && !slices.Contains(protocolVersions, version) {
+		http.Error(res, fmt.Sprintf("unsupported MCP protocol version %s", version), http.StatusBadRequest)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(res, req.Body, server.options.MaxMessageBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(res, fmt.Sprintf("message is larger than %d bytes", server.options.MaxMessageBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		http.Error(res, fmt.Sprintf("error reading message: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var message request
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		utilities.WriteJSON(res, http.StatusOK, response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: errorf(codeInvalidRequest, "batches aren't supported, send one message per request")})
+		return
+	}
+
+	if err := json.Unmarshal(data, &message); err != nil {
+		utilities.WriteJSON(res, http.StatusOK, response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: errorf(codeParseError, "invalid JSON-RPC message: %v", err)})
+		return
+	}
+
+	// Notifications and the client's answers to us, there's nothing we wait for
+	if len(message.ID) == 0 || message.Method == "" {
+		res.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	result, rpcErr := server.handle(req, message)
+
+	reply := response{JSONRPC: "2.0", ID: message.ID, Result: result, Error: rpcErr}
+	if rpcErr == nil && result == nil {
+		reply.Result = struct{}{}
+	}
+
+	utilities.WriteJSON(res, http.StatusOK, reply)
+}
+
+func (server *Server) handle(req *http.Request, message request) (any, *rpcError) {
+	if message.JSONRPC != "2.0" {
+		return nil, errorf(codeInvalidRequest, "jsonrpc must be 2.0")
+	}
+
+	switch message.Method {
+	case "initialize":
+		return server.initialize(message.Params)
+	case "ping":
+		return nil, nil
+	case "tools/list":
+		return server.listTools(req), nil
+	case "tools/call":
+		return server.callTool(req, message.Params)
+	}
+
+	return nil, errorf(codeMethodNotFound, "unknown method %s", message.Method)
+}
+
+func (server *Server) initialize(params json.RawMessage) (any, *rpcError) {
+	var initialize struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+
+	if err := json.Unmarshal(params, &initialize); err != nil {
+		return nil, errorf(codeInvalidParams, "invalid initialize params: %v", err)
+	}
+
+	// We speak what the client asked for if we can, our latest otherwise and the client decides
+	version := initialize.ProtocolVersion
+	if !slices.Contains(protocolVersions, version) {
+		version = LatestProtocolVersion
+	}
+
+	return map[string]any{
+		"protocolVersion": version,
+		"capabilities":    map[string]any{"tools": map[string]any{"listChanged": false}},
+		"serverInfo":      map[string]any{"name": server.options.Name, "version": server.options.Version},
+		"instructions": "Tools for Android devices connected to this machine over adb. Devices are named by serial " +
+			"or a registry selector (name:, tag:, group:) matching one device, list_devices shows what's there.",
+	}, nil
+}
@@ -0,0 +1,175 @@
+package mcp
+
+import (
+	"adb-server/authentication"
+	"adb-server/middleware"
+	"adb-server/routes"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+type tool struct {
+	Name        string         `json:"name"`
+	Title       string         `json:"title,omitempty"`
+	Description string         `json:"description,omitempty"`
+	InputSchema map[string]any `json:"inputSchema"`
+	Annotations map[string]any `json:"annotations,omitempty"`
+}
+
+type content struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	Data     string `json:"data,omitempty"`
+	MIMEType string `json:"mimeType,omitempty"`
+}
+
+type toolResult struct {
+	Content           []content `json:"content"`
+	StructuredContent any       `json:"structuredContent,omitempty"`
+	IsError           bool      `json:"isError,omitempty"`
+}
+
+// listTools offers the tools the session has the scopes for, the others would only fail
+func (server *Server) listTools(req *http.Request) map[string]any {
+	session, _ := middleware.GetSession(req)
+
+	tools := []tool{}
+
+	for _, route := range server.sortedTools() {
+		if !hasScopes(session, route.Scopes) {
+			continue
+		}
+
+		description := route.Summary
+		if route.Description != "" {
+			description += ". " + route.Description
+		}
+
+		tools = append(tools, tool{
+			Name:        route.Tool,
+			Title:       route.Summary,
+			Description: description,
+			InputSchema: route.InputSchema(),
+			Annotations: map[string]any{
+				"readOnlyHint":    route.Method == http.MethodGet,
+				"destructiveHint": route.Method == http.MethodDelete,
+				"idempotentHint":  route.Method == http.MethodGet || route.Method == http.MethodDelete,
+				"openWorldHint":   false,
+			},
+		})
+	}
+
+	return map[string]any{"tools": tools}
+}
+
+func (server *Server) sortedTools() []routes.Route {
+	var tools []routes.Route
+	for _, route := range server.tools() {
+		tools = append(tools, route)
+	}
+
+	slices.SortFunc(tools, func(a, b routes.Route) int {
+		return strings.Compare(a.Tool, b.Tool)
+	})
+
+	return tools
+}
+
+func hasScopes(session authentication.Session, scopes []string) bool {
+	for _, scope := range scopes {
+		if !session.HasScope(scope) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// callTool runs the tool's route in process, with the caller's session. What the route answers
+// becomes the result, errors included, so the model sees what went wrong.
+func (server *Server) callTool(req *http.Request, params json.RawMessage) (any, *rpcError) {
+	var call struct {
+		Name      string         `json:"name"`
+		Arguments map[string]any `json:"arguments"`
+	}
+
+	if err := json.Unmarshal(params, &call); err != nil {
+		return nil, errorf(codeInvalidParams, "invalid tools/call params: %v", err)
+	}
+
+	route, found := server.tools()[call.Name]
+	if !found {
+		return nil, errorf(codeInvalidParams, "unknown tool %s", call.Name)
+	}
+
+	toolRequest, err := route.Request(req.Context(), call.Arguments)
+	if err != nil {
+		return errorResult(err.Error()), nil
+	}
+
+	toolRequest.RemoteAddr = req.RemoteAddr
+	toolRequest.TLS = req.TLS
+
+	recorder := newRecorder()
+	server.handler.ServeHTTP(recorder, toolRequest)
+
+	return resultOf(recorder), nil
+}
+
+func resultOf(recorder *recorder) toolResult {
+	body := recorder.body.Bytes()
+	contentType := recorder.header.Get("Content-Type")
+
+	if recorder.status >= 300 {
+		return errorResult(fmt.Sprintf("%d %s: %s", recorder.status, http.StatusText(recorder.status), strings.TrimSpace(string(body))))
+	}
+
+	if strings.HasPrefix(contentType, "image/") {
+		return toolResult{Content: []content{{Type: "image", Data: base64.StdEncoding.EncodeToString(body), MIMEType: contentType}}}
+	}
+
+	result := toolResult{Content: []content{{Type: "text", Text: string(body)}}}
+
+	// Clients that understand structured content get objects as they are
+	var object map[string]any
+	if strings.HasPrefix(contentType, "application/json") && json.Unmarshal(body, &object) == nil {
+		result.StructuredContent = object
+	}
+
+	return result
+}
+
+func errorResult(message string) toolResult {
+	return toolResult{Content: []content{{Type: "text", Text: message}}, IsError: true}
+}
+
+// recorder keeps what a route answers a tool call with
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newRecorder() *recorder {
+	return &recorder{header: http.Header{}}
+}
+
+func (recorder *recorder) Header() http.Header {
+	return recorder.header
+}
+
+func (recorder *recorder) WriteHeader(status int) {
+	if recorder.status == 0 {
+		recorder.status = status
+	}
+}
+
+func (recorder *recorder) Write(data []byte) (int, error) {
+	recorder.WriteHeader(http.StatusOK)
+	return recorder.body.Write(data)
+}
@@ -0,0 +1,320 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Just enough of the Prometheus data model for our own use: counters and histograms with
+// labels, gauges computed at scrape time, and the text exposition format.
+
+// DefaultBuckets suit everything from a devices listing (tens of ms) to an install (minutes)
+var DefaultBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+type metric interface {
+	write(out io.Writer) error
+}
+
+// Registry is the set of metrics one /metrics endpoint exposes, written in registration order
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (registry *Registry) register(entry metric) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.metrics = append(registry.metrics, entry)
+}
+
+// WriteText writes every metric in the Prometheus text format (version 0.0.4)
+func (registry *Registry) WriteText(out io.Writer) error {
+	registry.mu.Lock()
+	entries := slices.Clone(registry.metrics)
+	registry.mu.Unlock()
+
+	for _, entry := range entries {
+		if err := entry.write(out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type descriptor struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (desc descriptor) header(out io.Writer, kind string) error {
+	_, err := fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", desc.name, escapeHelp(desc.help), desc.name, kind)
+	return err
+}
+
+// key joins label values so a vector can index its series by them
+func (desc descriptor) key(values []string) string {
+	if len(values) != len(desc.labels) {
+		panic(fmt.Sprintf("metric %s wants %d label values, got %d", desc.name, len(desc.labels), len(values)))
+	}
+
+	return strings.Join(values, "\xff")
+}
+
+// CounterVec is a family of counters, one per combination of label values
+type CounterVec struct {
+	descriptor
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	labels []string
+	value  float64
+}
+
+func (registry *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	counter := &CounterVec{descriptor: descriptor{name, help, labels}, series: map[string]*counterSeries{}}
+	registry.register(counter)
+
+	return counter
+}
+
+func (counter *CounterVec) Add(delta float64, labelValues ...string) {
+	key := counter.key(labelValues)
+
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+
+	series, ok := counter.series[key]
+	if !ok {
+		series = &counterSeries{labels: slices.Clone(labelValues)}
+		counter.series[key] = series
+	}
+
+	series.value += delta
+}
+
+func (counter *CounterVec) Inc(labelValues ...string) {
+	counter.Add(1, labelValues...)
+}
+
+func (counter *CounterVec) write(out io.Writer) error {
+	if err := counter.header(out, "counter"); err != nil {
+		return err
+	}
+
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+
+	for _, key := range sortedKeys(counter.series) {
+		series := counter.series[key]
+
+		if _, err := fmt.Fprintf(out, "%s%s %s\n", counter.name, labelString(counter.labels, series.labels, "", ""), formatValue(series.value)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// HistogramVec is a family of histograms, one per combination of label values
+type HistogramVec struct {
+	descriptor
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels []string
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func (registry *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	histogram := &HistogramVec{
+		descriptor: descriptor{name, help, labels},
+		buckets:    slices.Sorted(slices.Values(buckets)),
+		series:     map[string]*histogramSeries{},
+	}
+	registry.register(histogram)
+
+	return histogram
+}
+
+func (histogram *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := histogram.key(labelValues)
+
+	histogram.mu.Lock()
+	defer histogram.mu.Unlock()
+
+	series, ok := histogram.series[key]
+	if !ok {
+		series = &histogramSeries{labels: slices.Clone(labelValues), counts: make([]uint64, len(histogram.buckets))}
+		histogram.series[key] = series
+	}
+
+	if index, _ := slices.BinarySearch(histogram.buckets, value); index < len(histogram.buckets) {
+		series.counts[index]++
+	}
+
+	series.count++
+	series.sum += value
+}
+
+func (histogram *HistogramVec) write(out io.Writer) error {
+	if err := histogram.header(out, "histogram"); err != nil {
+		return err
+	}
+
+	histogram.mu.Lock()
+	defer histogram.mu.Unlock()
+
+	for _, key := range sortedKeys(histogram.series) {
+		series := histogram.series[key]
+
+		var cumulative uint64
+
+		for index, bound := range histogram.buckets {
+			cumulative += series.counts[index]
+
+			if _, err := fmt.Fprintf(out, "%s_bucket%s %d\n", histogram.name, labelString(histogram.labels, series.labels, "le", formatValue(bound)), cumulative); err != nil {
+				return err
+			}
+		}
+
+		labels := labelString(histogram.labels, series.labels, "", "")
+
+		if _, err := fmt.Fprintf(out, "%s_bucket%s %d\n%s_sum%s %s\n%s_count%s %d\n",
+			histogram.name, labelString(histogram.labels, series.labels, "le", "+Inf"), series.count,
+			histogram.name, labels, formatValue(series.sum),
+			histogram.name, labels, series.count); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GaugeSample is one series of a gauge collected at scrape time
+type GaugeSample struct {
+	Labels []string
+	Value  float64
+}
+
+// GaugeFunc asks collect for its current values on every scrape, for things we already
+// track elsewhere (jobs, sessions, connected devices) and would only get out of sync copying
+type GaugeFunc struct {
+	descriptor
+	collect func() []GaugeSample
+}
+
+func (registry *Registry) NewGaugeFunc(name, help string, collect func() []GaugeSample, labels ...string) *GaugeFunc {
+	gauge := &GaugeFunc{descriptor: descriptor{name, help, labels}, collect: collect}
+	registry.register(gauge)
+
+	return gauge
+}
+
+func (gauge *GaugeFunc) write(out io.Writer) error {
+	if err := gauge.header(out, "gauge"); err != nil {
+		return err
+	}
+
+	samples := gauge.collect()
+
+	slices.SortFunc(samples, func(a, b GaugeSample) int {
+		return strings.Compare(strings.Join(a.Labels, "\xff"), strings.Join(b.Labels, "\xff"))
+	})
+
+	for _, sample := range samples {
+		if _, err := fmt.Fprintf(out, "%s%s %s\n", gauge.name, labelString(gauge.labels, sample.Labels, "", ""), formatValue(sample.Value)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func labelString(names []string, values []string, extraName string, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+
+	var builder strings.Builder
+
+	builder.WriteByte('{')
+
+	for index, name := range names {
+		if index > 0 {
+			builder.WriteByte(',')
+		}
+
+		value := ""
+		if index < len(values) {
+			value = values[index]
+		}
+
+		builder.WriteString(name + `="` + escapeLabel(value) + `"`)
+	}
+
+	if extraName != "" {
+		if len(names) > 0 {
+			builder.WriteByte(',')
+		}
+
+		builder.WriteString(extraName + `="` + extraValue + `"`)
+	}
+
+	builder.WriteByte('}')
+
+	return builder.String()
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func sortedKeys[V any](series map[string]V) []string {
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+
+	slices.Sort(keys)
+
+	return keys
+}
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"adb-server/internal/adb"
+	"strconv"
+	"time"
+)
+
+// ServerMetrics are the metrics the server keeps itself. It is the adb client's Observer,
+// HTTP metrics come in through ObserveHTTP and state gauges are added by whoever owns the state.
+type ServerMetrics struct {
+	Registry *Registry
+
+	adbOperations *CounterVec
+	adbDuration   *HistogramVec
+	lockWait      *HistogramVec
+	httpRequests  *CounterVec
+	httpDuration  *HistogramVec
+}
+
+func NewServerMetrics() *ServerMetrics {
+	registry := NewRegistry()
+
+	return &ServerMetrics{
+		Registry: registry,
+
+		adbOperations: registry.NewCounterVec("adb_server_adb_operations_total",
+			"ADB operations by outcome.", "operation", "outcome", "model"),
+		adbDuration: registry.NewHistogramVec("adb_server_adb_operation_duration_seconds",
+			"How long ADB operations took, including waiting for the device.", DefaultBuckets, "operation", "outcome", "model"),
+		lockWait: registry.NewHistogramVec("adb_server_device_lock_wait_seconds",
+			"How long operations queued for a device, outcome is acquired or abandoned.", DefaultBuckets, "operation", "outcome"),
+		httpRequests: registry.NewCounterVec("adb_server_http_requests_total",
+			"HTTP requests by route, method and status code.", "route", "method", "code"),
+		httpDuration: registry.NewHistogramVec("adb_server_http_request_duration_seconds",
+			"How long HTTP requests took to serve.", DefaultBuckets, "route", "method"),
+	}
+}
+
+func (serverMetrics *ServerMetrics) OperationFinished(event adb.OperationEvent) {
+	// Model rather than serial keeps the number of series bounded on big device labs
+	model := event.Model
+	if model == "" && event.Serial != "" {
+		model = "unknown"
+	}
+
+	serverMetrics.adbOperations.Inc(event.Operation, event.Outcome, model)
+	serverMetrics.adbDuration.Observe(event.Duration.Seconds(), event.Operation, event.Outcome, model)
+}
+
+func (serverMetrics *ServerMetrics) LockWaited(operation string, waited time.Duration, acquired bool) {
+	outcome := "acquired"
+	if !acquired {
+		outcome = "abandoned"
+	}
+
+	serverMetrics.lockWait.Observe(waited.Seconds(), operation, outcome)
+}
+
+// ObserveHTTP records one served request. route must be the matched pattern, never the raw path.
+func (serverMetrics *ServerMetrics) ObserveHTTP(route string, method string, code int, duration time.Duration) {
+	serverMetrics.httpRequests.Inc(route, method, statusCode(code))
+	serverMetrics.httpDuration.Observe(duration.Seconds(), route, method)
+}
+
+func statusCode(code int) string {
+	if code == 0 {
+		code = 200 // nothing written, net/http sends 200
+	}
+
+	return strconv.Itoa(code)
+}
@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"adb-server/audit"
+	"context"
+	"net/http"
+)
+
+const auditLogKey contextKey = "auditLog"
+
+func WithAuditLog(logger *audit.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				ctx := context.WithValue(r.Context(), auditLogKey, logger)
+
+				r = r.WithContext(ctx)
+
+				next.ServeHTTP(w, r)
+			},
+		)
+	}
+}
+
+func GetAuditLog(r *http.Request) (*audit.Logger, bool) {
+	logger, ok := r.Context().Value(auditLogKey).(*audit.Logger)
+	return logger, ok && logger != nil
+}
@@ -2,29 +2,121 @@ package middleware
 
 import (
 	"adb-server/authentication"
+	"adb-server/internal/adb"
+	"adb-server/logging"
+	"adb-server/tracing"
+	"adb-server/transport"
+	"context"
+	"log/slog"
 	"net/http"
+	"strings"
+)
+
+const sessionKey contextKey = "session"
+const authMethodKey contextKey = "authMethod"
+
+// How a request's session was authenticated, see GetAuthMethod
+const (
+	AuthMethodCookie            = "cookie"
+	AuthMethodBearer            = "bearer"
+	AuthMethodClientCertificate = "client-certificate"
+	AuthMethodLocalSocket       = "local-socket"
 )
 
 func ProtectedRoute(next http.Handler) http.Handler {
 	return http.HandlerFunc(
 		func(res http.ResponseWriter, req *http.Request) {
-			// Get the cookie from the request
-			cookie, err := req.Cookie("X-Auth-Token")
+			_, span := tracing.Start(req.Context(), "auth", tracing.KindInternal)
 
-			// If the cookie is not found or is empty, deny access
-			if err != nil || cookie.Value == "" {
-				http.Error(res, "Unauthorized", http.StatusUnauthorized)
-				return
+			method := AuthMethodCookie
+			session, ok := sessionFromCookie(req)
+
+			// The same token, for clients that can set a header but don't keep cookies, like MCP clients
+			if !ok {
+				method = AuthMethodBearer
+				session, ok = sessionFromBearer(req)
+			}
+
+			// Lab machines on mTLS authenticate with their client certificate instead of pairing
+			if !ok {
+				method = AuthMethodClientCertificate
+				session, ok = sessionFromClientCertificate(req)
 			}
 
-			// Check if the token is valid
-			if !authentication.VerifyAuthToken(cookie.Value) {
+			// Only the socket's owner can connect to it, the filesystem already did the auth
+			if !ok && transport.IsLocalSocket(req.Context()) {
+				method = AuthMethodLocalSocket
+				session, ok = authentication.LocalSocketSession(), true
+			}
+
+			if !ok {
+				span.SetAttributes(tracing.Bool("auth.authenticated", false))
+				span.End()
+
 				http.Error(res, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
 
+			span.SetAttributes(
+				tracing.Bool("auth.authenticated", true),
+				tracing.String("auth.method", method),
+				tracing.String("session.id", session.ID),
+			)
+			span.End()
+
+			// Handlers further down want to know who is calling, and so does the device queue
+			ctx := context.WithValue(req.Context(), sessionKey, session)
+			ctx = context.WithValue(ctx, authMethodKey, method)
+			ctx = adb.WithCaller(ctx, session.ID)
+
+			logging.AddAttrs(ctx, slog.String("session", session.ID))
+
+			req = req.WithContext(ctx)
+
 			// If everything is okay, call the next handler in the chain
 			next.ServeHTTP(res, req)
 		},
 	)
 }
+
+func GetSession(r *http.Request) (authentication.Session, bool) {
+	session, ok := r.Context().Value(sessionKey).(authentication.Session)
+	return session, ok
+}
+
+// GetAuthMethod is one of the AuthMethod constants, empty outside ProtectedRoute
+func GetAuthMethod(r *http.Request) string {
+	method, _ := r.Context().Value(authMethodKey).(string)
+	return method
+}
+
+func sessionFromCookie(req *http.Request) (authentication.Session, bool) {
+	// Get the cookie from the request
+	cookie, err := req.Cookie("X-Auth-Token")
+
+	// If the cookie is not found or is empty, deny access
+	if err != nil || cookie.Value == "" {
+		return authentication.Session{}, false
+	}
+
+	// Check if the token is valid
+	return authentication.LookupSession(cookie.Value)
+}
+
+func sessionFromBearer(req *http.Request) (authentication.Session, bool) {
+	token, found := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !found || token == "" {
+		return authentication.Session{}, false
+	}
+
+	return authentication.LookupSession(token)
+}
+
+// Only chains the TLS stack verified against our client CA count, a self-presented cert is ignored
+func sessionFromClientCertificate(req *http.Request) (authentication.Session, bool) {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return authentication.Session{}, false
+	}
+
+	return authentication.SessionForClientCertificate(req.TLS.VerifiedChains[0][0]), true
+}
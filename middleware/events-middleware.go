@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"adb-server/events"
+	"context"
+	"net/http"
+)
+
+const eventBusKey contextKey = "eventBus"
+
+func WithEvents(bus *events.Bus) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				ctx := context.WithValue(r.Context(), eventBusKey, bus)
+
+				r = r.WithContext(ctx)
+
+				next.ServeHTTP(w, r)
+			},
+		)
+	}
+}
+
+func GetEvents(r *http.Request) (*events.Bus, bool) {
+	bus, ok := r.Context().Value(eventBusKey).(*events.Bus)
+	return bus, ok && bus != nil
+}
@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"adb-server/jobs"
+	"context"
+	"net/http"
+)
+
+const jobManagerKey contextKey = "jobManager"
+
+func WithJobManager(manager *jobs.Manager) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				ctx := context.WithValue(r.Context(), jobManagerKey, manager)
+
+				r = r.WithContext(ctx)
+
+				next.ServeHTTP(w, r)
+			},
+		)
+	}
+}
+
+func GetJobManager(r *http.Request) (*jobs.Manager, bool) {
+	manager, ok := r.Context().Value(jobManagerKey).(*jobs.Manager)
+	return manager, ok && manager != nil
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"adb-server/logging"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// Incoming ids are reused so a proxy's or client's id shows up in our logs, as long as they look like ids
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// WithRequestLogging gives every request an id, hands it back in X-Request-ID, puts it in the
+// context for anything that logs on the request's behalf (down to each adb invocation) and
+// logs one line per request once it's done.
+func WithRequestLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			requestID := r.Header.Get(RequestIDHeader)
+			if !validRequestID.MatchString(requestID) {
+				requestID = logging.NewRequestID()
+			}
+
+			w.Header().Set(RequestIDHeader, requestID)
+
+			r = r.WithContext(logging.WithRequestID(r.Context(), requestID))
+
+			started := time.Now()
+			recorder := &statusRecorder{ResponseWriter: w}
+
+			next.ServeHTTP(recorder, r)
+
+			status := recorder.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			level := slog.LevelInfo
+			if status >= http.StatusInternalServerError {
+				level = slog.LevelWarn
+			}
+
+			slog.Log(r.Context(), level, "request",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"query", logging.RedactQuery(r.URL.Query()),
+				"status", status,
+				"bytes", recorder.bytes,
+				"duration_ms", time.Since(started).Milliseconds(),
+				"remote", r.RemoteAddr,
+			)
+		},
+	)
+}
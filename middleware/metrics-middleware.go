@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"adb-server/metrics"
+	"net/http"
+	"time"
+)
+
+// WithHTTPMetrics counts and times every request. routeFor maps a request to the pattern
+// that serves it so label values stay bounded no matter what paths clients make up.
+func WithHTTPMetrics(serverMetrics *metrics.ServerMetrics, routeFor func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				started := time.Now()
+				recorder := &statusRecorder{ResponseWriter: w}
+
+				next.ServeHTTP(recorder, r)
+
+				serverMetrics.ObserveHTTP(routeFor(r), methodLabel(r.Method), recorder.status, time.Since(started))
+			},
+		)
+	}
+}
+
+// Methods are as made up as paths, anything the API doesn't serve is counted together
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodHead, http.MethodOptions:
+		return method
+	}
+
+	return "OTHER"
+}
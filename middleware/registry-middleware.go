@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"adb-server/registry"
+	"context"
+	"net/http"
+)
+
+const registryKey contextKey = "registry"
+
+func WithRegistry(deviceRegistry *registry.Registry) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				ctx := context.WithValue(r.Context(), registryKey, deviceRegistry)
+
+				r = r.WithContext(ctx)
+
+				next.ServeHTTP(w, r)
+			},
+		)
+	}
+}
+
+func GetRegistry(r *http.Request) (*registry.Registry, bool) {
+	deviceRegistry, ok := r.Context().Value(registryKey).(*registry.Registry)
+	return deviceRegistry, ok && deviceRegistry != nil
+}
@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"adb-server/authentication"
+	"adb-server/transport"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const CSRFHeader = "X-CSRF-Token"
+
+// CORSPolicy decides which web origins (our own frontend, mostly) may call the API from a browser
+type CORSPolicy struct {
+	AllowedOrigins []string
+	AllowedHeaders []string
+	MaxAge         time.Duration
+}
+
+// HostGuard rejects requests whose Host header isn't one of the addresses we are bound to.
+// A DNS rebinding attack resolves evil.example to 127.0.0.1 but the browser still sends Host: evil.example.
+func HostGuard(allowedHosts []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(res http.ResponseWriter, req *http.Request) {
+				// Browsers can't reach the unix socket, and its clients send whatever Host they like
+				if transport.IsLocalSocket(req.Context()) {
+					next.ServeHTTP(res, req)
+					return
+				}
+
+				if len(allowedHosts) > 0 && !slices.ContainsFunc(allowedHosts, func(host string) bool {
+					return strings.EqualFold(host, req.Host)
+				}) {
+					http.Error(res, "invalid host header", http.StatusMisdirectedRequest)
+					return
+				}
+
+				next.ServeHTTP(res, req)
+			},
+		)
+	}
+}
+
+// OriginGuard only lets browsers through when the request comes from our own origin or an allowlisted one.
+// Requests without an Origin header (curl, the Go client, the desktop app) are left alone.
+func OriginGuard(allowedOrigins []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(res http.ResponseWriter, req *http.Request) {
+				origin := req.Header.Get("Origin")
+
+				if origin == "" {
+					// Older browsers skip Origin on same-origin GETs but still tell us about cross-site ones
+					if req.Header.Get("Sec-Fetch-Site") == "cross-site" {
+						http.Error(res, "cross-site request rejected", http.StatusForbidden)
+						return
+					}
+
+					next.ServeHTTP(res, req)
+					return
+				}
+
+				if !isSameOrigin(origin, req.Host) && !slices.Contains(allowedOrigins, origin) {
+					http.Error(res, "origin not allowed", http.StatusForbidden)
+					return
+				}
+
+				next.ServeHTTP(res, req)
+			},
+		)
+	}
+}
+
+// CORS answers preflights and adds the CORS headers for allowlisted origins. Credentials are allowed
+// since the auth cookie is the whole point, which is also why "*" is never used.
+func CORS(policy CORSPolicy) func(http.Handler) http.Handler {
+	allowedHeaders := append([]string{"Content-Type", CSRFHeader}, policy.AllowedHeaders...)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(res http.ResponseWriter, req *http.Request) {
+				origin := req.Header.Get("Origin")
+
+				if origin == "" {
+					next.ServeHTTP(res, req)
+					return
+				}
+
+				res.Header().Add("Vary", "Origin")
+
+				isPreflight := req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != ""
+
+				if !slices.Contains(policy.AllowedOrigins, origin) {
+					if isPreflight {
+						http.Error(res, "origin not allowed", http.StatusForbidden)
+						return
+					}
+
+					next.ServeHTTP(res, req)
+					return
+				}
+
+				res.Header().Set("Access-Control-Allow-Origin", origin)
+				res.Header().Set("Access-Control-Allow-Credentials", "true")
+				res.Header().Set("Access-Control-Expose-Headers", CSRFHeader)
+
+				if isPreflight {
+					res.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
+					res.Header().Set("Access-Control-Allow-Headers", strings.Join(allowedHeaders, ", "))
+
+					if policy.MaxAge > 0 {
+						res.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge.Seconds())))
+					}
+
+					res.WriteHeader(http.StatusNoContent)
+					return
+				}
+
+				next.ServeHTTP(res, req)
+			},
+		)
+	}
+}
+
+// CSRFProtect requires the session's CSRF token in a header on anything that changes state.
+// Must sit behind ProtectedRoute since the token is tied to the session.
+func CSRFProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(res http.ResponseWriter, req *http.Request) {
+			switch req.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				next.ServeHTTP(res, req)
+				return
+			}
+
+			// No browser can send a request over the unix socket, so there's no CSRF to defend against.
+			// Nor a gRPC one without a preflight, and CORS and OriginGuard turn those away. Browsers
+			// don't add bearer tokens on their own either, but they do add cookies, client certificates
+			// and cached Basic credentials, so only sessions that came from a bearer token are exempt.
+			if transport.IsLocalSocket(req.Context()) || strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc") || GetAuthMethod(req) == AuthMethodBearer {
+				next.ServeHTTP(res, req)
+				return
+			}
+
+			session, ok := GetSession(req)
+			if !ok {
+				http.Error(res, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if !authentication.VerifyCSRFToken(session, req.Header.Get(CSRFHeader)) {
+				http.Error(res, "missing or invalid CSRF token", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(res, req)
+		},
+	)
+}
+
+func isSameOrigin(origin string, host string) bool {
+	originURL, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(originURL.Host, host)
+}
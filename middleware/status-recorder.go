@@ -0,0 +1,34 @@
+package middleware
+
+import "net/http"
+
+// statusRecorder remembers the status code and how much was written. Unwrap lets http.ResponseController still reach
+// Flush on the real writer, which the streaming endpoints depend on.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	if recorder.status == 0 {
+		recorder.status = status
+	}
+
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *statusRecorder) Write(data []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+
+	written, err := recorder.ResponseWriter.Write(data)
+	recorder.bytes += int64(written)
+
+	return written, err
+}
+
+func (recorder *statusRecorder) Unwrap() http.ResponseWriter {
+	return recorder.ResponseWriter
+}
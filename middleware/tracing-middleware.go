@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"adb-server/logging"
+	"adb-server/tracing"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// WithTracing starts a server span per request, continuing the caller's trace when it sends a
+// W3C traceparent. routeFor names the span after the pattern, same as the metrics.
+func WithTracing(routeFor func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				ctx := r.Context()
+
+				if parent, ok := tracing.ParseTraceparent(r.Header.Get("traceparent")); ok {
+					ctx = tracing.WithRemoteParent(ctx, parent)
+				}
+
+				ctx, span := tracing.Start(ctx, r.Method, tracing.KindServer,
+					tracing.String("http.request.method", r.Method),
+					tracing.String("url.path", r.URL.Path),
+					tracing.String("client.address", r.RemoteAddr),
+					tracing.String("request.id", logging.RequestID(ctx)),
+				)
+				defer span.End()
+
+				if span != nil {
+					logging.AddAttrs(ctx, slog.String("trace_id", span.TraceID()))
+				}
+
+				recorder := &statusRecorder{ResponseWriter: w}
+
+				next.ServeHTTP(recorder, r.WithContext(ctx))
+
+				status := recorder.status
+				if status == 0 {
+					status = http.StatusOK
+				}
+
+				route := routeFor(r)
+
+				span.SetName(r.Method + " " + route)
+				span.SetAttributes(
+					tracing.String("http.route", route),
+					tracing.Int("http.response.status_code", status),
+					tracing.Int64("http.response.body.size", recorder.bytes),
+				)
+
+				// Client errors are the client's problem, only 5xx fail the span
+				if status >= http.StatusInternalServerError {
+					span.SetStatus(tracing.StatusError, strconv.Itoa(status)+" "+http.StatusText(status))
+				}
+			},
+		)
+	}
+}
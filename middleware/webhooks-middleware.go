@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"adb-server/webhooks"
+	"context"
+	"net/http"
+)
+
+const webhooksKey contextKey = "webhooks"
+
+func WithWebhooks(manager *webhooks.Manager) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				ctx := context.WithValue(r.Context(), webhooksKey, manager)
+
+				r = r.WithContext(ctx)
+
+				next.ServeHTTP(w, r)
+			},
+		)
+	}
+}
+
+func GetWebhooks(r *http.Request) (*webhooks.Manager, bool) {
+	manager, ok := r.Context().Value(webhooksKey).(*webhooks.Manager)
+	return manager, ok && manager != nil
+}
@@ -0,0 +1,39 @@
+package models
+
+// DeviceSelector picks devices by what they are instead of by serial. All set fields must match.
+type DeviceSelector struct {
+	AllAuthorized bool   `json:"all_authorized,omitempty"`
+	Model         string `json:"model,omitempty"`
+	Tag           string `json:"tag,omitempty"`   // registry tag
+	Group         string `json:"group,omitempty"` // registry group
+}
+
+// BatchRequest targets the listed devices plus whatever the selector matches.
+// Devices may be serials or registry selectors (name:, tag:, group:).
+type BatchRequest struct {
+	Devices     []string        `json:"devices,omitempty"`
+	Selector    *DeviceSelector `json:"selector,omitempty"`
+	Concurrency int             `json:"concurrency,omitempty"`
+
+	Path     string `json:"path,omitempty"`      // install
+	Package  string `json:"package,omitempty"`   // uninstall
+	KeepData bool   `json:"keep_data,omitempty"` // uninstall
+	User     *int   `json:"user,omitempty"`      // uninstall
+	Command  string `json:"command,omitempty"`   // shell
+	Mode     string `json:"mode,omitempty"`      // reboot
+}
+
+type BatchDeviceResult struct {
+	DeviceID   string `json:"device_id"`
+	Status     string `json:"status"` // success or failure
+	Output     string `json:"output,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+type BatchResponse struct {
+	Operation string              `json:"operation"`
+	Results   []BatchDeviceResult `json:"results,omitempty"` // left out of the summary line when streaming
+	Succeeded int                 `json:"succeeded"`
+	Failed    int                 `json:"failed"`
+}
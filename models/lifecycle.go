@@ -0,0 +1,171 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Process exit codes, so supervisors and the desktop app can tell a clean stop from a crash
+const (
+	ExitOK           = 0
+	ExitError        = 1 // failed to start or the listener died
+	ExitDrainTimeout = 2 // shut down, but in-flight operations had to be cancelled
+	ExitConfig       = 3 // invalid configuration, nothing was started
+)
+
+// How long cancelled requests get to respond once the drain deadline has passed
+const cancelGracePeriod = 2 * time.Second
+
+var ErrDrainTimeout = errors.New("in-flight requests did not finish before the shutdown deadline")
+
+// ExitCode maps what Run returned to the status the process should exit with
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, ErrDrainTimeout):
+		return ExitDrainTimeout
+	default:
+		return ExitError
+	}
+}
+
+// Run serves until SIGINT/SIGTERM or RequestShutdown, then stops accepting connections, gives
+// in-flight requests ShutdownTimeout to finish, cancels whatever is still running (which kills
+// the adb processes through their contexts) and cleans up after itself.
+// A second signal while draining skips straight to cancelling.
+func (server *Server) Run() error {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	if err := server.prepare(); err != nil {
+		server.cleanup()
+		return err
+	}
+
+	serveErrors := make(chan error, 1)
+
+	go func() {
+		serveErrors <- server.serve()
+	}()
+
+	select {
+	case err := <-serveErrors:
+		server.cleanup()
+		return err
+	case received := <-signals:
+		log.Printf("Received %s, shutting down", received)
+	case <-server.shutdown:
+		log.Printf("Shutdown requested, shutting down")
+	}
+
+	drainContext, cancelDrain := context.WithTimeout(context.Background(), server.ShutdownTimeout)
+	defer cancelDrain()
+
+	// Second Ctrl-C means the user doesn't want to wait
+	go func() {
+		select {
+		case <-signals:
+			log.Printf("Received second signal, cancelling in-flight requests")
+			cancelDrain()
+		case <-drainContext.Done():
+		}
+	}()
+
+	if count := server.inFlight.Load(); count > 0 {
+		log.Printf("Waiting up to %s for %d in-flight request(s)", server.ShutdownTimeout, count)
+	}
+
+	// Event streams never finish on their own, end them so they don't hold up the drain
+	server.Events.Close()
+
+	for _, drain := range server.onDrain {
+		drain()
+	}
+
+	shutdownError := server.HTTPServer.Shutdown(drainContext)
+
+	// Jobs aren't tied to a request, they share whatever is left of the same deadline
+	if active := server.Jobs.Active(); active > 0 {
+		log.Printf("Waiting for %d running job(s)", active)
+	}
+
+	if err := server.Jobs.Shutdown(drainContext); err != nil && shutdownError == nil {
+		shutdownError = err
+	}
+
+	// Cancelling the base context cancels every request context and with it every adb command
+	server.cancelBase()
+
+	if shutdownError != nil {
+		// Cancelled requests usually wrap up right away, let them send their error response before closing
+		graceContext, cancelGrace := context.WithTimeout(context.Background(), cancelGracePeriod)
+		defer cancelGrace()
+
+		if server.HTTPServer.Shutdown(graceContext) != nil {
+			_ = server.HTTPServer.Close()
+		}
+
+		shutdownError = ErrDrainTimeout
+	}
+
+	if err := <-serveErrors; err != nil && !errors.Is(err, http.ErrServerClosed) && shutdownError == nil {
+		shutdownError = err
+	}
+
+	server.cleanup()
+
+	return shutdownError
+}
+
+// RequestShutdown starts a graceful shutdown from inside the process, e.g. the admin endpoint
+func (server *Server) RequestShutdown() {
+	server.shutdownOnce.Do(func() {
+		close(server.shutdown)
+	})
+}
+
+// OnDrain registers a callback run when shutdown starts, before waiting for in-flight requests.
+// For streams that never finish on their own, like the event bus's.
+func (server *Server) OnDrain(callback func()) {
+	server.onDrain = append(server.onDrain, callback)
+}
+
+func (server *Server) trackInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(res http.ResponseWriter, req *http.Request) {
+			server.inFlight.Add(1)
+			defer server.inFlight.Add(-1)
+
+			next.ServeHTTP(res, req)
+		},
+	)
+}
+
+func (server *Server) cleanup() {
+	server.cancelBase()
+
+	// No-op after a graceful drain, makes sure nothing is left writing into the temp dir otherwise
+	_ = server.Jobs.Shutdown(server.baseContext)
+
+	if server.ADBConfig.TempDir != "" {
+		if err := os.RemoveAll(server.ADBConfig.TempDir); err != nil {
+			log.Printf("Error removing temp dir %s: %v", server.ADBConfig.TempDir, err)
+		}
+	}
+
+	if server.AuditLog != nil {
+		if err := server.AuditLog.Close(); err != nil {
+			log.Printf("Error closing audit log: %v", err)
+		}
+	}
+
+	log.Printf("Shutdown complete")
+}
@@ -5,6 +5,40 @@ type HealthResponse struct {
 	Status string `json:"status"`
 }
 
+// Readiness statuses, worst wins
+const (
+	ReadinessOK        = "ok"
+	ReadinessDegraded  = "degraded"
+	ReadinessUnhealthy = "unhealthy"
+)
+
+type ReadinessResponse struct {
+	Time   string                    `json:"time"`
+	Status string                    `json:"status"`
+	Checks map[string]ReadinessCheck `json:"checks"`
+}
+
+type ReadinessCheck struct {
+	Status  string         `json:"status"`
+	Message string         `json:"message,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
 type PairingResponse struct {
-	Status string `json:"status"`
+	Status    string   `json:"status"`
+	CSRFToken string   `json:"csrf_token,omitempty"`
+	Scopes    []string `json:"scopes,omitempty"` // every scope when empty
+}
+
+type CSRFTokenResponse struct {
+	CSRFToken string `json:"csrf_token"`
+}
+
+type ShellRequest struct {
+	Command string `json:"command"`
+}
+
+type ShellResult struct {
+	Command string `json:"command"`
+	Output  string `json:"output"`
 }
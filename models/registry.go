@@ -0,0 +1,28 @@
+package models
+
+import "adb-server/internal/adb"
+
+// DeviceListing is a device as adb sees it plus whatever the registry knows about it
+type DeviceListing struct {
+	adb.Device
+	Name   string   `json:"name,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	Owner  string   `json:"owner,omitempty"`
+	Notes  string   `json:"notes,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// DeviceMetadataRequest is the body of PUT /v1/registry/devices/{device_id}
+type DeviceMetadataRequest struct {
+	Name  string   `json:"name"`
+	Tags  []string `json:"tags"`
+	Owner string   `json:"owner"`
+	Notes string   `json:"notes"`
+}
+
+// GroupRequest is the body of PUT /v1/registry/groups/{name}
+type GroupRequest struct {
+	Description string   `json:"description"`
+	Devices     []string `json:"devices"`
+	Tags        []string `json:"tags"`
+}
@@ -1,58 +1,194 @@
 package models
 
 import (
+	"adb-server/audit"
+	"adb-server/events"
 	"adb-server/internal/adb"
-	"fmt"
+	"adb-server/jobs"
+	"adb-server/registry"
+	"adb-server/transport"
+	"adb-server/webhooks"
+	"context"
+	"crypto/tls"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Server struct {
 	Port         int
+	BindAddress  string
+	Listener     net.Listener // already bound TCP or unix listener, bound from BindAddress/Port when nil
+	TLSConfig    *tls.Config  // nil serves plain HTTP
+	Handler      http.Handler // wraps MainMux with the global middleware, MainMux is used when nil
 	ADBClient    adb.Client
+	AuditLog     *audit.Logger
+	Jobs         *jobs.Manager
+	Events       *events.Bus
+	Registry     *registry.Registry
+	Webhooks     *webhooks.Manager
 	ADBConfig    adb.Config
 	HTTPServer   *http.Server
 	MainMux      *http.ServeMux
 	ProtectedMux *http.ServeMux
 	ADBMux       *http.ServeMux
 	mu           sync.RWMutex // For thread safety if needed
+
+	ShutdownTimeout   time.Duration // how long in-flight requests get to finish before they're cancelled
+	ReadHeaderTimeout time.Duration
+	MaxHeaderBytes    int
+
+	baseContext  context.Context // parent of every request context, cancelled when draining times out
+	cancelBase   context.CancelFunc
+	shutdownOnce sync.Once
+	shutdown     chan struct{}
+	inFlight     atomic.Int64
+	onDrain      []func()
 }
 
-func NewServer(port int) *Server {
-	// Initialize ADB config
-	adbConfig := adb.Config{
-		ADBPath:        "adb",
-		ReadTimeout:    30 * time.Second,
-		InstallTimeout: 120 * time.Second,
-		TempDir:        "",
+func NewServer(port int, adbConfig adb.Config) (*Server, error) {
+	// Our own temp dir so shutdown can remove whatever got left in it
+	tempDir, err := os.MkdirTemp(adbConfig.TempDir, "adb-server-*")
+	if err != nil {
+		return nil, err
 	}
 
+	adbConfig.TempDir = tempDir
+
 	// Create ADB client
 	adbClient, err := adb.New(adbConfig)
 	if err != nil {
-		// Handle error appropriately
-		panic(err)
+		os.RemoveAll(tempDir)
+		return nil, err
 	}
 
+	baseContext, cancelBase := context.WithCancel(context.Background())
+
+	jobManager := jobs.NewManager(filepath.Join(tempDir, "jobs"), time.Hour)
+	eventBus := events.NewBus()
+
+	jobManager.OnFinish(func(job jobs.Job) {
+		// Whoever cares about the output can fetch the job, events stay small
+		job.Logs = nil
+		eventBus.Publish(events.TypeJobFinished, job)
+	})
+
+	go events.WatchDevices(baseContext, eventBus, adbClient, 2*time.Second)
+
 	return &Server{
-		Port:         port,
-		ADBClient:    adbClient,
-		ADBConfig:    adbConfig,
-		MainMux:      http.NewServeMux(),
-		ProtectedMux: http.NewServeMux(),
+		Port:            port,
+		BindAddress:     "127.0.0.1",
+		ADBClient:       adbClient,
+		ADBConfig:       adbConfig,
+		MainMux:         http.NewServeMux(),
+		ProtectedMux:    http.NewServeMux(),
+		Jobs:            jobManager,
+		Events:          eventBus,
+		ShutdownTimeout: 30 * time.Second,
+		baseContext:     baseContext,
+		cancelBase:      cancelBase,
+		shutdown:        make(chan struct{}),
+	}, nil
+}
+
+// RoutePattern is the path pattern that serves req, "unmatched" when nothing does.
+// Used as a metrics label, so it must never be the raw path. The method is a label of its own.
+func (server *Server) RoutePattern(req *http.Request) string {
+	if _, pattern := server.ProtectedMux.Handler(req); pattern != "" {
+		return pathOf(pattern)
+	}
+
+	// "/v1/" and "/v2/" only hand off to the protected mux, which didn't know the path either
+	if _, pattern := server.MainMux.Handler(req); pattern != "" && !slices.Contains(ProtectedPrefixes, pattern) {
+		return pathOf(pattern)
 	}
+
+	return "unmatched"
+}
+
+// ProtectedPrefixes are mounted on the main mux and served by the protected mux. The last is
+// the gRPC service, see the rpc package.
+var ProtectedPrefixes = []string{"/v1/", "/v2/", "/adbserver.v1.ADB/"}
+
+// pathOf drops the method from "GET /v2/devices"
+func pathOf(pattern string) string {
+	if _, path, found := strings.Cut(pattern, " "); found {
+		return path
+	}
+
+	return pattern
 }
 
 func (server *Server) Start() error {
+	if err := server.prepare(); err != nil {
+		return err
+	}
+
+	return server.serve()
+}
+
+// prepare binds the listener (unless we were handed one) and builds the http.Server
+func (server *Server) prepare() error {
+	handler := server.Handler
+	if handler == nil {
+		handler = server.MainMux
+	}
+
+	if server.Listener == nil {
+		listener, err := net.Listen("tcp", net.JoinHostPort(server.BindAddress, strconv.Itoa(server.Port)))
+		if err != nil {
+			return err
+		}
+
+		server.Listener = listener
+	}
+
 	server.HTTPServer = &http.Server{
-		Addr:    fmt.Sprintf("127.0.0.1:%d", server.Port),
-		Handler: server.MainMux,
+		Addr:        server.Listener.Addr().String(),
+		Handler:     server.trackInFlight(handler),
+		TLSConfig:   server.TLSConfig,
+		ConnContext: transport.ConnContext,
+		BaseContext: func(net.Listener) context.Context { return server.baseContext },
+
+		ReadHeaderTimeout: server.ReadHeaderTimeout,
+		MaxHeaderBytes:    server.MaxHeaderBytes,
+	}
+
+	// gRPC needs HTTP/2, which TLS negotiates on its own. Plain connections get it through h2c.
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+	server.HTTPServer.Protocols = protocols
+
+	return nil
+}
+
+func (server *Server) serve() error {
+	if server.Listener.Addr().Network() == "unix" {
+		log.Printf("Server starting on unix socket %s", server.HTTPServer.Addr)
+	} else {
+		scheme := "http"
+		if server.TLSConfig != nil {
+			scheme = "https"
+		}
+
+		log.Printf("Server starting on %s://%s", scheme, server.HTTPServer.Addr)
+		log.Printf("Pairing code: %d", server.Port)
 	}
 
-	log.Printf("Server starting on http://%s", server.HTTPServer.Addr)
-	log.Printf("Pairing code: %d", server.Port)
+	if server.TLSConfig != nil {
+		// Certificates are already in TLSConfig
+		return server.HTTPServer.ServeTLS(server.Listener, "", "")
+	}
 
-	return server.HTTPServer.ListenAndServe()
+	return server.HTTPServer.Serve(server.Listener)
 }
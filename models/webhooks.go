@@ -0,0 +1,9 @@
+package models
+
+// WebhookRequest is the body of POST /v1/webhooks and PUT /v1/webhooks/{id}
+type WebhookRequest struct {
+	URL         string   `json:"url"`
+	Events      []string `json:"events,omitempty"` // every event when empty
+	Description string   `json:"description,omitempty"`
+	Secret      string   `json:"secret,omitempty"` // generated on create when empty, kept on update when empty
+}
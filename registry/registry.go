@@ -0,0 +1,329 @@
+package registry
+
+import (
+	"adb-server/utilities"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const registryFileVersion = 1
+
+var ErrNotFound = errors.New("not found")
+
+// DeviceInfo is what people know about a device that adb doesn't
+type DeviceInfo struct {
+	Serial    string    `json:"device_id"`
+	Name      string    `json:"name,omitempty"`
+	Tags      []string  `json:"tags,omitempty"`
+	Owner     string    `json:"owner,omitempty"`
+	Notes     string    `json:"notes,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Group is a named set of devices: the listed serials plus every device carrying one of the tags
+type Group struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Serials     []string  `json:"devices,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type registryFile struct {
+	Version int          `json:"version"`
+	Devices []DeviceInfo `json:"devices"`
+	Groups  []Group      `json:"groups"`
+}
+
+// Registry is a small JSON file backed store, rewritten on every change
+type Registry struct {
+	mu      sync.RWMutex
+	path    string
+	devices map[string]DeviceInfo
+	groups  map[string]Group
+}
+
+func Open(path string) (*Registry, error) {
+	registry := &Registry{
+		path:    path,
+		devices: map[string]DeviceInfo{},
+		groups:  map[string]Group{},
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return registry, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read device registry: %w", err)
+	}
+
+	var file registryFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("device registry is corrupt: %w", err)
+	}
+
+	if file.Version > registryFileVersion {
+		return nil, fmt.Errorf("device registry version %d is newer than this server supports (%d)", file.Version, registryFileVersion)
+	}
+
+	for _, device := range file.Devices {
+		registry.devices[device.Serial] = device
+	}
+
+	for _, group := range file.Groups {
+		registry.groups[group.Name] = group
+	}
+
+	return registry, nil
+}
+
+func (registry *Registry) Device(serial string) (DeviceInfo, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	device, ok := registry.devices[serial]
+	return device, ok
+}
+
+func (registry *Registry) Devices() []DeviceInfo {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	devices := make([]DeviceInfo, 0, len(registry.devices))
+	for _, device := range registry.devices {
+		devices = append(devices, device)
+	}
+
+	slices.SortFunc(devices, func(a, b DeviceInfo) int { return strings.Compare(a.Serial, b.Serial) })
+
+	return devices
+}
+
+// PutDevice replaces everything we know about a serial
+func (registry *Registry) PutDevice(device DeviceInfo) (DeviceInfo, error) {
+	if device.Serial == "" {
+		return DeviceInfo{}, errors.New("device id is required")
+	}
+
+	device.Tags = normalizeTags(device.Tags)
+	device.UpdatedAt = time.Now().UTC()
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	// Names are how people refer to devices, two devices with the same name would make selectors ambiguous
+	if device.Name != "" {
+		for serial, existing := range registry.devices {
+			if serial != device.Serial && strings.EqualFold(existing.Name, device.Name) {
+				return DeviceInfo{}, fmt.Errorf("name %q is already used by %s", device.Name, serial)
+			}
+		}
+	}
+
+	previous, existed := registry.devices[device.Serial]
+	registry.devices[device.Serial] = device
+
+	if err := registry.saveLocked(); err != nil {
+		if existed {
+			registry.devices[device.Serial] = previous
+		} else {
+			delete(registry.devices, device.Serial)
+		}
+
+		return DeviceInfo{}, err
+	}
+
+	return device, nil
+}
+
+func (registry *Registry) DeleteDevice(serial string) error {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if _, ok := registry.devices[serial]; !ok {
+		return ErrNotFound
+	}
+
+	delete(registry.devices, serial)
+
+	return registry.saveLocked()
+}
+
+func (registry *Registry) Groups() []Group {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	groups := make([]Group, 0, len(registry.groups))
+	for _, group := range registry.groups {
+		groups = append(groups, group)
+	}
+
+	slices.SortFunc(groups, func(a, b Group) int { return strings.Compare(a.Name, b.Name) })
+
+	return groups
+}
+
+func (registry *Registry) PutGroup(group Group) (Group, error) {
+	if group.Name == "" {
+		return Group{}, errors.New("group name is required")
+	}
+
+	group.Tags = normalizeTags(group.Tags)
+	slices.Sort(group.Serials)
+	group.Serials = slices.Compact(group.Serials)
+	group.UpdatedAt = time.Now().UTC()
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	previous, existed := registry.groups[group.Name]
+	registry.groups[group.Name] = group
+
+	if err := registry.saveLocked(); err != nil {
+		if existed {
+			registry.groups[group.Name] = previous
+		} else {
+			delete(registry.groups, group.Name)
+		}
+
+		return Group{}, err
+	}
+
+	return group, nil
+}
+
+func (registry *Registry) DeleteGroup(name string) error {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if _, ok := registry.groups[name]; !ok {
+		return ErrNotFound
+	}
+
+	delete(registry.groups, name)
+
+	return registry.saveLocked()
+}
+
+// GroupsOf lists the groups a device belongs to, directly or through its tags
+func (registry *Registry) GroupsOf(serial string) []string {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	device := registry.devices[serial]
+
+	var names []string
+
+	for _, group := range registry.groups {
+		if slices.Contains(group.Serials, serial) || slices.ContainsFunc(group.Tags, func(tag string) bool {
+			return slices.Contains(device.Tags, tag)
+		}) {
+			names = append(names, group.Name)
+		}
+	}
+
+	slices.Sort(names)
+
+	return names
+}
+
+// Resolve turns a selector into serials. Selectors are "name:<name>", "tag:<tag>" or "group:<group>".
+// ok is false when the string isn't a selector at all, i.e. it's a plain serial.
+func (registry *Registry) Resolve(selector string) (serials []string, ok bool, err error) {
+	kind, value, found := strings.Cut(selector, ":")
+	if !found {
+		return nil, false, nil
+	}
+
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	switch kind {
+	case "name":
+		for serial, device := range registry.devices {
+			if strings.EqualFold(device.Name, value) {
+				serials = append(serials, serial)
+			}
+		}
+
+	case "tag":
+		tag := strings.ToLower(value)
+
+		for serial, device := range registry.devices {
+			if slices.Contains(device.Tags, tag) {
+				serials = append(serials, serial)
+			}
+		}
+
+	case "group":
+		group, exists := registry.groups[value]
+		if !exists {
+			return nil, true, fmt.Errorf("unknown group %q", value)
+		}
+
+		serials = append(serials, group.Serials...)
+
+		for serial, device := range registry.devices {
+			if slices.ContainsFunc(group.Tags, func(tag string) bool { return slices.Contains(device.Tags, tag) }) {
+				serials = append(serials, serial)
+			}
+		}
+
+	default:
+		// Serials can contain colons (emulator-5554 doesn't, 192.168.1.5:5555 does), so only known prefixes count
+		return nil, false, nil
+	}
+
+	slices.Sort(serials)
+
+	return slices.Compact(serials), true, nil
+}
+
+func (registry *Registry) saveLocked() error {
+	file := registryFile{Version: registryFileVersion, Devices: []DeviceInfo{}, Groups: []Group{}}
+
+	for _, device := range registry.devices {
+		file.Devices = append(file.Devices, device)
+	}
+
+	for _, group := range registry.groups {
+		file.Groups = append(file.Groups, group)
+	}
+
+	// Stable order keeps the file diffable
+	slices.SortFunc(file.Devices, func(a, b DeviceInfo) int { return strings.Compare(a.Serial, b.Serial) })
+	slices.SortFunc(file.Groups, func(a, b Group) int { return strings.Compare(a.Name, b.Name) })
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := utilities.WriteFileAtomic(registry.path, data); err != nil {
+		return fmt.Errorf("failed to save device registry: %w", err)
+	}
+
+	return nil
+}
+
+// Tags are case-insensitive, stored lower case without duplicates
+func normalizeTags(tags []string) []string {
+	var normalized []string
+
+	for _, tag := range tags {
+		if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" {
+			normalized = append(normalized, tag)
+		}
+	}
+
+	slices.Sort(normalized)
+
+	return slices.Compact(normalized)
+}
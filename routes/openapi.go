@@ -0,0 +1,236 @@
+package routes
+
+import (
+	"adb-server/utilities"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var pathParamPattern = regexp.MustCompile(`\{([^}]+?)(\.\.\.)?\}`)
+
+// OpenAPI builds an OpenAPI 3.1 document out of every registered route
+func (registry *Registry) OpenAPI(version string) map[string]any {
+	builder := newSchemaBuilder("#/components/schemas/")
+	paths := map[string]any{}
+
+	for _, route := range registry.routes {
+		path := pathParamPattern.ReplaceAllString(route.Pattern, "{$1}")
+
+		item, ok := paths[path].(map[string]any)
+		if !ok {
+			item = map[string]any{}
+			paths[path] = item
+		}
+
+		item[strings.ToLower(route.Method)] = operation(builder, route)
+	}
+
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":       "adb-server API",
+			"version":     version,
+			"description": "Manage Android devices through adb. Pair with POST /v1/pair first, the cookie it sets authenticates everything else.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": builder.components,
+			"securitySchemes": map[string]any{
+				"cookieAuth": map[string]any{"type": "apiKey", "in": "cookie", "name": "X-Auth-Token"},
+				"csrfToken":  map[string]any{"type": "apiKey", "in": "header", "name": "X-CSRF-Token", "description": "Required on anything but GET, from pairing or /v1/csrf-token"},
+				"mutualTLS":  map[string]any{"type": "mutualTLS"},
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer", "description": "The X-Auth-Token cookie's value, for clients that don't keep cookies. Needs no CSRF token."},
+			},
+		},
+	}
+}
+
+// ServeOpenAPI answers with the document, built once since routes don't change after startup
+func (registry *Registry) ServeOpenAPI(version string) http.HandlerFunc {
+	document := sync.OnceValue(func() map[string]any {
+		return registry.OpenAPI(version)
+	})
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		utilities.WriteJSON(res, http.StatusOK, document())
+	}
+}
+
+func operation(builder *schemaBuilder, route Route) map[string]any {
+	op := map[string]any{
+		"operationId": operationID(route),
+		"summary":     route.Summary,
+	}
+
+	if route.Description != "" {
+		op["description"] = route.Description
+	}
+
+	if route.Tag != "" {
+		op["tags"] = []string{route.Tag}
+	}
+
+	if route.Deprecated {
+		op["deprecated"] = true
+	}
+
+	var parameters []any
+	for _, param := range route.Params {
+		parameters = append(parameters, parameter(param))
+	}
+
+	if len(parameters) > 0 {
+		op["parameters"] = parameters
+	}
+
+	if route.Body != nil {
+		op["requestBody"] = map[string]any{
+			"description": route.Body.Description,
+			"required":    route.Body.Required,
+			"content":     content(builder, route.Body),
+		}
+	}
+
+	responses := map[string]any{}
+	for _, response := range route.Responses {
+		described := map[string]any{"description": response.Description}
+		if response.Body != nil {
+			described["content"] = content(builder, response.Body)
+		}
+
+		responses[strconv.Itoa(response.Status)] = described
+	}
+
+	plainError := func(description string) map[string]any {
+		return map[string]any{
+			"description": description,
+			"content":     map[string]any{"text/plain": map[string]any{"schema": map[string]any{"type": "string"}}},
+		}
+	}
+
+	if len(route.Params) > 0 || route.Body != nil {
+		setDefault(responses, "400", plainError("The request doesn't match what the route accepts"))
+	}
+
+	if route.Public {
+		op["security"] = []any{}
+	} else {
+		scopes := route.Scopes
+		if scopes == nil {
+			scopes = []string{}
+		}
+
+		op["security"] = []any{
+			map[string]any{"cookieAuth": scopes, "csrfToken": []string{}},
+			map[string]any{"bearerAuth": scopes},
+			map[string]any{"mutualTLS": scopes},
+		}
+
+		if len(route.Scopes) > 0 {
+			op["x-required-scopes"] = route.Scopes
+			setDefault(responses, "403", plainError("The session lacks a required scope"))
+		}
+
+		setDefault(responses, "401", plainError("Not paired"))
+	}
+
+	op["responses"] = responses
+
+	return op
+}
+
+func parameter(param Param) map[string]any {
+	in := param.In
+	if in == "" {
+		in = InQuery
+	}
+
+	described := map[string]any{
+		"name":     param.Name,
+		"in":       in,
+		"required": param.Required || in == InPath,
+		"schema":   paramSchema(param),
+	}
+
+	if param.Description != "" {
+		described["description"] = param.Description
+	}
+
+	return described
+}
+
+func paramSchema(param Param) map[string]any {
+	paramType := param.Type
+	if paramType == "" {
+		paramType = TypeString
+	}
+
+	schema := map[string]any{"type": paramType}
+	if len(param.Enum) > 0 {
+		schema["enum"] = param.Enum
+	}
+
+	if param.Minimum != nil {
+		schema["minimum"] = *param.Minimum
+	}
+
+	if param.Maximum != nil {
+		schema["maximum"] = *param.Maximum
+	}
+
+	return schema
+}
+
+func content(builder *schemaBuilder, body *Body) map[string]any {
+	contentType := body.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+
+	schema := map[string]any{"type": "string", "format": "binary"}
+	if isJSON(contentType) {
+		schema = builder.schemaOf(body.Schema)
+	}
+
+	return map[string]any{contentType: map[string]any{"schema": schema}}
+}
+
+// operationID turns "DELETE /v1/jobs/{id}" into delete-v1-jobs-id
+func operationID(route Route) string {
+	id := strings.ToLower(route.Method)
+
+	for _, character := range route.Pattern {
+		switch {
+		case character >= 'a' && character <= 'z', character >= '0' && character <= '9':
+			id += string(character)
+		case character >= 'A' && character <= 'Z':
+			id += string(character + 'a' - 'A')
+		case character == '{', character == '}', character == '.':
+		default:
+			if id[len(id)-1] != '-' {
+				id += "-"
+			}
+		}
+	}
+
+	return id
+}
+
+func setDefault(responses map[string]any, status string, response map[string]any) {
+	if _, ok := responses[status]; !ok {
+		responses[status] = response
+	}
+}
+
+// Minimum helps write Param{Minimum: routes.Minimum(0)}
+func Minimum(value int) *int {
+	return &value
+}
+
+// Maximum helps write Param{Maximum: routes.Maximum(1000)}
+func Maximum(value int) *int {
+	return &value
+}
@@ -0,0 +1,137 @@
+// Package routes is the one place the API is described: every route says its method,
+// parameters, bodies and the scopes it needs. The same description registers the handlers,
+// checks incoming requests and is published as an OpenAPI document.
+package routes
+
+import (
+	"adb-server/authentication"
+	"adb-server/middleware"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Parameter types, as OpenAPI spells them
+const (
+	TypeString  = "string"
+	TypeBoolean = "boolean"
+	TypeInteger = "integer"
+)
+
+// Where a parameter goes
+const (
+	InQuery  = "query"
+	InPath   = "path"
+	InHeader = "header"
+)
+
+type Param struct {
+	Name        string
+	In          string // InQuery when empty
+	Type        string // TypeString when empty
+	Required    bool
+	Enum        []string
+	Minimum     *int
+	Maximum     *int
+	Description string
+}
+
+// Body is what a route accepts or answers with. Schema is a value of the Go type the JSON
+// decodes into, e.g. models.BatchRequest{}, or nil for bodies that aren't JSON.
+type Body struct {
+	ContentType string // application/json when empty
+	Schema      any
+	Required    bool
+	Description string
+}
+
+type Response struct {
+	Status      int
+	Description string
+	Body        *Body
+}
+
+type Route struct {
+	Method      string
+	Pattern     string // a ServeMux path pattern, {name} for path parameters
+	Summary     string
+	Description string
+	Tag         string
+	Params      []Param
+	Body        *Body
+	Responses   []Response
+	Scopes      []string // the session needs every one of them
+	Public      bool     // served without authentication, on the main mux
+	Deprecated  bool     // still served, clients should move to what Description points at
+	Tool        string   // name of the MCP tool that calls the route, not a tool when empty
+	Handler     http.HandlerFunc
+}
+
+// Registry collects routes and serves them
+type Registry struct {
+	routes []Route
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (registry *Registry) Add(routes ...Route) {
+	registry.routes = append(registry.routes, routes...)
+}
+
+// Routes returns every route in the order they were added
+func (registry *Registry) Routes() []Route {
+	return slices.Clone(registry.routes)
+}
+
+// Register puts every route on its mux as "METHOD pattern", public routes on public and the
+// rest on protected. The mux answers other methods with a 405 and an Allow header.
+func (registry *Registry) Register(public *http.ServeMux, protected *http.ServeMux) {
+	for _, route := range registry.routes {
+		mux := protected
+		if route.Public {
+			mux = public
+		}
+
+		mux.Handle(route.Method+" "+route.Pattern, serve(route))
+	}
+}
+
+func serve(route Route) http.Handler {
+	return http.HandlerFunc(
+		func(res http.ResponseWriter, req *http.Request) {
+			if !route.Public {
+				session, ok := middleware.GetSession(req)
+				if !ok {
+					http.Error(res, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+
+				if missing := missingScopes(session, route.Scopes); len(missing) > 0 {
+					http.Error(res, "missing scope "+strings.Join(missing, ", "), http.StatusForbidden)
+					return
+				}
+			}
+
+			if err := validate(res, req, route); err != nil {
+				http.Error(res, err.Error(), err.status)
+				return
+			}
+
+			route.Handler(res, req)
+		},
+	)
+}
+
+func missingScopes(session authentication.Session, scopes []string) []string {
+	var missing []string
+
+	for _, scope := range scopes {
+		if !session.HasScope(scope) {
+			missing = append(missing, scope)
+		}
+	}
+
+	return missing
+}
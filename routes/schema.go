@@ -0,0 +1,157 @@
+package routes
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// schemaBuilder turns Go types into JSON Schema the way encoding/json would marshal them.
+// Named structs go into components once and are referenced from everywhere else.
+type schemaBuilder struct {
+	components map[string]any
+	names      map[reflect.Type]string
+	refPrefix  string // where components end up, #/components/schemas/ in OpenAPI
+}
+
+func newSchemaBuilder(refPrefix string) *schemaBuilder {
+	return &schemaBuilder{components: map[string]any{}, names: map[reflect.Type]string{}, refPrefix: refPrefix}
+}
+
+var (
+	timeType       = reflect.TypeFor[time.Time]()
+	rawMessageType = reflect.TypeFor[json.RawMessage]()
+)
+
+func (builder *schemaBuilder) schemaOf(value any) map[string]any {
+	if value == nil {
+		return map[string]any{}
+	}
+
+	return builder.schemaFor(reflect.TypeOf(value))
+}
+
+func (builder *schemaBuilder) schemaFor(valueType reflect.Type) map[string]any {
+	for valueType.Kind() == reflect.Pointer {
+		valueType = valueType.Elem()
+	}
+
+	switch valueType {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case rawMessageType:
+		return map[string]any{}
+	}
+
+	switch valueType.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if valueType.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+
+		return map[string]any{"type": "array", "items": builder.schemaFor(valueType.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": builder.schemaFor(valueType.Elem())}
+	case reflect.Struct:
+		return builder.structRef(valueType)
+	}
+
+	// any, whatever the handler puts there
+	return map[string]any{}
+}
+
+func (builder *schemaBuilder) structRef(structType reflect.Type) map[string]any {
+	if structType.Name() == "" {
+		return builder.structSchema(structType)
+	}
+
+	name, known := builder.names[structType]
+	if !known {
+		name = builder.componentName(structType)
+		builder.names[structType] = name
+
+		// Registered before it's built so types that refer to themselves end up as a $ref
+		builder.components[name] = map[string]any{}
+		builder.components[name] = builder.structSchema(structType)
+	}
+
+	return map[string]any{"$ref": builder.refPrefix + name}
+}
+
+// Type names are unique enough, when two packages use the same one the package tells them apart
+func (builder *schemaBuilder) componentName(structType reflect.Type) string {
+	name := structType.Name()
+
+	for other, taken := range builder.names {
+		if taken == name && other != structType {
+			packageName := structType.PkgPath()[strings.LastIndex(structType.PkgPath(), "/")+1:]
+			return strings.ToUpper(packageName[:1]) + packageName[1:] + name
+		}
+	}
+
+	return name
+}
+
+func (builder *schemaBuilder) structSchema(structType reflect.Type) map[string]any {
+	properties := map[string]any{}
+	var required []string
+
+	builder.addFields(structType, properties, &required)
+
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	return schema
+}
+
+func (builder *schemaBuilder) addFields(structType reflect.Type, properties map[string]any, required *[]string) {
+	for index := range structType.NumField() {
+		field := structType.Field(index)
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, options, _ := strings.Cut(tag, ",")
+
+		// Untagged embedded structs are flattened, like encoding/json does
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+
+			if embedded.Kind() == reflect.Struct {
+				builder.addFields(embedded, properties, required)
+				continue
+			}
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = builder.schemaFor(field.Type)
+
+		if !strings.Contains(options, "omitempty") && field.Type.Kind() != reflect.Pointer {
+			*required = append(*required, name)
+		}
+	}
+}
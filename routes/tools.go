@@ -0,0 +1,223 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Routes with a Tool name are offered to MCP clients. A tool takes the route's parameters as
+// one JSON object. A JSON object body's fields are arguments of their own, any other body is
+// the "body" argument.
+const bodyArgument = "body"
+
+// Tools returns the routes that are MCP tools, in the order they were added
+func (registry *Registry) Tools() []Route {
+	var tools []Route
+
+	for _, route := range registry.routes {
+		if route.Tool != "" {
+			tools = append(tools, route)
+		}
+	}
+
+	return tools
+}
+
+// InputSchema is the JSON Schema of a tool's arguments. JSON bodies keep their own schema,
+// anything else is a base64 string.
+func (route Route) InputSchema() map[string]any {
+	builder := newSchemaBuilder("#/$defs/")
+	properties := map[string]any{}
+	required := []string{}
+
+	for _, param := range route.Params {
+		if param.In == InHeader {
+			continue
+		}
+
+		schema := paramSchema(param)
+		if param.Description != "" {
+			schema["description"] = param.Description
+		}
+
+		properties[param.Name] = schema
+
+		if param.Required || param.In == InPath {
+			required = append(required, param.Name)
+		}
+	}
+
+	if fields, fieldsRequired, ok := route.bodyFields(builder); ok {
+		for name, schema := range fields {
+			if _, taken := properties[name]; !taken {
+				properties[name] = schema
+			}
+		}
+
+		if route.Body.Required {
+			required = append(required, fieldsRequired...)
+		}
+	} else if route.Body != nil {
+		schema := map[string]any{"type": "string", "contentEncoding": "base64", "contentMediaType": route.Body.ContentType}
+		if isJSON(route.Body.ContentType) {
+			schema = builder.schemaOf(route.Body.Schema)
+		}
+
+		if route.Body.Description != "" {
+			schema["description"] = route.Body.Description
+		}
+
+		properties[bodyArgument] = schema
+
+		if route.Body.Required {
+			required = append(required, bodyArgument)
+		}
+	}
+
+	inputSchema := map[string]any{"type": "object", "properties": properties, "required": required}
+	if len(builder.components) > 0 {
+		inputSchema["$defs"] = builder.components
+	}
+
+	return inputSchema
+}
+
+// Request builds the HTTP request a tool call stands for. It only places the arguments,
+// checking them is left to the route like for any other request.
+func (route Route) Request(ctx context.Context, arguments map[string]any) (*http.Request, error) {
+	path := route.Pattern
+	query := url.Values{}
+
+	for _, param := range route.Params {
+		argument, present := arguments[param.Name]
+		if !present || argument == nil {
+			// There's no route to leave this one to, the mux would redirect the empty segment away
+			if param.In == InPath {
+				return nil, fmt.Errorf("%s parameter is required", param.Name)
+			}
+
+			continue
+		}
+
+		value, err := argumentString(param, argument)
+		if err != nil {
+			return nil, err
+		}
+
+		switch param.In {
+		case InPath:
+			path = strings.Replace(path, "{"+param.Name+"}", url.PathEscape(value), 1)
+		case InHeader:
+		default:
+			query.Set(param.Name, value)
+		}
+	}
+
+	var body io.Reader
+	contentType := ""
+
+	if fields, _, ok := route.bodyFields(newSchemaBuilder("")); ok {
+		object := map[string]any{}
+		for name := range fields {
+			if argument, present := arguments[name]; present {
+				object[name] = argument
+			}
+		}
+
+		data, err := json.Marshal(object)
+		if err != nil {
+			return nil, err
+		}
+
+		body = bytes.NewReader(data)
+		contentType = "application/json"
+	} else if argument, present := arguments[bodyArgument]; present && route.Body != nil {
+		contentType = route.Body.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+
+		data, err := bodyBytes(contentType, argument)
+		if err != nil {
+			return nil, err
+		}
+
+		body = bytes.NewReader(data)
+	}
+
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, route.Method, path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	return req, nil
+}
+
+// bodyFields is the fields of a JSON object body, by their JSON names
+func (route Route) bodyFields(builder *schemaBuilder) (map[string]any, []string, bool) {
+	if route.Body == nil || route.Body.Schema == nil || !isJSON(route.Body.ContentType) {
+		return nil, nil, false
+	}
+
+	bodyType := reflect.TypeOf(route.Body.Schema)
+	if bodyType.Kind() != reflect.Struct {
+		return nil, nil, false
+	}
+
+	properties := map[string]any{}
+	var required []string
+
+	builder.addFields(bodyType, properties, &required)
+
+	return properties, required, true
+}
+
+func argumentString(param Param, argument any) (string, error) {
+	switch value := argument.(type) {
+	case string:
+		return value, nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case json.Number:
+		return value.String(), nil
+	}
+
+	return "", fmt.Errorf("%s must be a %s", param.Name, paramSchema(param)["type"])
+}
+
+func bodyBytes(contentType string, argument any) ([]byte, error) {
+	if isJSON(contentType) {
+		return json.Marshal(argument)
+	}
+
+	encoded, ok := argument.(string)
+	if !ok {
+		return nil, fmt.Errorf("%s must be a base64 string", bodyArgument)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%s isn't valid base64: %v", bodyArgument, err)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,135 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// JSON request bodies are small, anything bigger than this is a mistake or an attack
+const maxJSONBody = 1 << 20
+
+type validationError struct {
+	status  int
+	message string
+}
+
+func (err *validationError) Error() string {
+	return err.message
+}
+
+func badRequest(format string, args ...any) *validationError {
+	return &validationError{status: http.StatusBadRequest, message: fmt.Sprintf(format, args...)}
+}
+
+// validate checks the request against what the route declares. Parameters the route doesn't
+// know about are let through, older clients send a few.
+func validate(res http.ResponseWriter, req *http.Request, route Route) *validationError {
+	query := req.URL.Query()
+
+	for _, param := range route.Params {
+		var value string
+		var present bool
+
+		switch param.In {
+		case InPath:
+			value = req.PathValue(param.Name)
+			present = value != ""
+		case InHeader:
+			value = req.Header.Get(param.Name)
+			present = value != ""
+		default:
+			value = query.Get(param.Name)
+			present = query.Has(param.Name)
+		}
+
+		if !present {
+			if param.Required {
+				return badRequest("%s parameter is required", param.Name)
+			}
+
+			continue
+		}
+
+		if err := checkParam(param, value); err != nil {
+			return err
+		}
+	}
+
+	if route.Body != nil && route.Body.Schema != nil && isJSON(route.Body.ContentType) {
+		return validateJSONBody(res, req, route.Body)
+	}
+
+	return nil
+}
+
+func checkParam(param Param, value string) *validationError {
+	switch param.Type {
+	case TypeBoolean:
+		// The handlers have always taken 1 for true as well
+		if !slices.Contains([]string{"true", "false", "1", "0"}, value) {
+			return badRequest("invalid %s parameter, must be true or false", param.Name)
+		}
+	case TypeInteger:
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return badRequest("invalid %s parameter, must be an integer", param.Name)
+		}
+
+		if param.Minimum != nil && number < *param.Minimum {
+			return badRequest("invalid %s parameter, must be at least %d", param.Name, *param.Minimum)
+		}
+
+		if param.Maximum != nil && number > *param.Maximum {
+			return badRequest("invalid %s parameter, must be at most %d", param.Name, *param.Maximum)
+		}
+	}
+
+	if len(param.Enum) > 0 && !slices.Contains(param.Enum, value) {
+		return badRequest("invalid %s parameter, must be one of %s", param.Name, strings.Join(param.Enum, ", "))
+	}
+
+	return nil
+}
+
+// validateJSONBody reads the body, makes sure it decodes into the declared type and puts it
+// back for the handler
+func validateJSONBody(res http.ResponseWriter, req *http.Request, body *Body) *validationError {
+	data, err := io.ReadAll(http.MaxBytesReader(res, req.Body, maxJSONBody))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return &validationError{status: http.StatusRequestEntityTooLarge, message: fmt.Sprintf("request body is larger than %d bytes", maxJSONBody)}
+		}
+
+		return badRequest("error reading request body: %v", err)
+	}
+
+	req.Body = io.NopCloser(bytes.NewReader(data))
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		if body.Required {
+			return badRequest("request body is required")
+		}
+
+		return nil
+	}
+
+	target := reflect.New(reflect.TypeOf(body.Schema))
+	if err := json.Unmarshal(data, target.Interface()); err != nil {
+		return badRequest("invalid request body: %v", err)
+	}
+
+	return nil
+}
+
+func isJSON(contentType string) bool {
+	return contentType == "" || contentType == "application/json"
+}
@@ -0,0 +1,186 @@
+package rpc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Status codes, see https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+const (
+	codeOK                 = 0
+	codeCanceled           = 1
+	codeUnknown            = 2
+	codeInvalidArgument    = 3
+	codeDeadlineExceeded   = 4
+	codeNotFound           = 5
+	codePermissionDenied   = 7
+	codeResourceExhausted  = 8
+	codeFailedPrecondition = 9
+	codeUnimplemented      = 12
+	codeInternal           = 13
+	codeUnavailable        = 14
+	codeUnauthenticated    = 16
+)
+
+// gRPC's default limit, chunk uploads well below it
+const maxMessageBytes = 4 << 20
+
+type statusError struct {
+	code    int
+	message string
+}
+
+func (err *statusError) Error() string {
+	return err.message
+}
+
+func statusf(code int, format string, args ...any) *statusError {
+	return &statusError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// statusOf picks the code for err, whatever isn't already a statusError is an adb failure
+func statusOf(ctx context.Context, err error) (int, string) {
+	var status *statusError
+
+	switch {
+	case err == nil:
+		return codeOK, ""
+	case errors.As(err, &status):
+		return status.code, status.message
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return codeDeadlineExceeded, err.Error()
+	case errors.Is(err, context.Canceled) || ctx.Err() != nil:
+		return codeCanceled, err.Error()
+	default:
+		return codeInternal, err.Error()
+	}
+}
+
+// stream is one call. Unary calls are streams of one message each way.
+type stream struct {
+	ctx        context.Context
+	res        http.ResponseWriter
+	req        *http.Request
+	controller *http.ResponseController
+}
+
+// receive reads the next request message, io.EOF when the client has sent them all
+func (call *stream) receive(message Message) error {
+	var prefix [5]byte
+
+	if _, err := io.ReadFull(call.req.Body, prefix[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.EOF
+		}
+
+		return statusf(codeCanceled, "error reading request: %v", err)
+	}
+
+	if prefix[0] != 0 {
+		return statusf(codeUnimplemented, "compressed messages aren't supported")
+	}
+
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > maxMessageBytes {
+		return statusf(codeResourceExhausted, "message of %d bytes is larger than %d", size, maxMessageBytes)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(call.req.Body, data); err != nil {
+		return statusf(codeCanceled, "error reading request: %v", err)
+	}
+
+	if err := message.Unmarshal(data); err != nil {
+		return statusf(codeInvalidArgument, "invalid request message: %v", err)
+	}
+
+	return nil
+}
+
+// receiveOne is receive for the single message of a unary or server streaming call
+func (call *stream) receiveOne(message Message) error {
+	err := call.receive(message)
+	if errors.Is(err, io.EOF) {
+		return statusf(codeInvalidArgument, "request message is missing")
+	}
+
+	return err
+}
+
+// send writes a response message and flushes it, so streams arrive as they happen
+func (call *stream) send(message Message) error {
+	data := message.Marshal()
+
+	frame := make([]byte, 5, 5+len(data))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(data)))
+	frame = append(frame, data...)
+
+	if _, err := call.res.Write(frame); err != nil {
+		return err
+	}
+
+	return call.controller.Flush()
+}
+
+// finish sends the status as trailers. Before any message they go out right after the headers,
+// which is what gRPC calls a trailers-only response.
+func finish(res http.ResponseWriter, code int, message string) {
+	res.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+
+	if message != "" {
+		res.Header().Set(http.TrailerPrefix+"Grpc-Message", encodeMessage(message))
+	}
+}
+
+// grpc-message is percent encoded, anything outside printable ASCII and % itself
+func encodeMessage(message string) string {
+	var encoded strings.Builder
+
+	for index := 0; index < len(message); index++ {
+		character := message[index]
+
+		if character < ' ' || character > '~' || character == '%' {
+			fmt.Fprintf(&encoded, "%%%02X", character)
+			continue
+		}
+
+		encoded.WriteByte(character)
+	}
+
+	return encoded.String()
+}
+
+// parseTimeout reads grpc-timeout, e.g. 100m for 100 milliseconds
+func parseTimeout(value string) (time.Duration, bool) {
+	if len(value) < 2 {
+		return 0, false
+	}
+
+	amount, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || amount < 0 {
+		return 0, false
+	}
+
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+
+	unit, ok := units[value[len(value)-1]]
+	if !ok {
+		return 0, false
+	}
+
+	return time.Duration(amount) * unit, true
+}
@@ -0,0 +1,507 @@
+package rpc
+
+// The messages of adb.proto, field numbers must stay in step with it
+
+type Message interface {
+	Marshal() []byte
+	Unmarshal(data []byte) error
+}
+
+type Empty struct{}
+
+func (message *Empty) Marshal() []byte { return nil }
+
+func (message *Empty) Unmarshal(data []byte) error {
+	dec := decoder{data: data}
+	for dec.more() {
+		_, wireType := dec.next()
+		dec.skip(wireType)
+	}
+
+	return dec.err
+}
+
+type Device struct {
+	Serial       string
+	State        string
+	Model        string
+	Manufacturer string
+	Authorized   bool
+	Name         string   // from the registry
+	Tags         []string // from the registry
+}
+
+func (message *Device) Marshal() []byte {
+	var enc encoder
+	enc.string(1, message.Serial)
+	enc.string(2, message.State)
+	enc.string(3, message.Model)
+	enc.string(4, message.Manufacturer)
+	enc.bool(5, message.Authorized)
+	enc.string(6, message.Name)
+	enc.strings(7, message.Tags)
+
+	return enc.buf
+}
+
+func (message *Device) Unmarshal(data []byte) error {
+	dec := decoder{data: data}
+
+	for dec.more() {
+		switch field, wireType := dec.next(); {
+		case field == 1 && wireType == wireBytes:
+			message.Serial = dec.string()
+		case field == 2 && wireType == wireBytes:
+			message.State = dec.string()
+		case field == 3 && wireType == wireBytes:
+			message.Model = dec.string()
+		case field == 4 && wireType == wireBytes:
+			message.Manufacturer = dec.string()
+		case field == 5 && wireType == wireVarint:
+			message.Authorized = dec.varint() != 0
+		case field == 6 && wireType == wireBytes:
+			message.Name = dec.string()
+		case field == 7 && wireType == wireBytes:
+			message.Tags = append(message.Tags, dec.string())
+		default:
+			dec.skip(wireType)
+		}
+	}
+
+	return dec.err
+}
+
+type DevicesResponse struct {
+	Devices []*Device
+}
+
+func (message *DevicesResponse) Marshal() []byte {
+	var enc encoder
+	for _, device := range message.Devices {
+		enc.message(1, device)
+	}
+
+	return enc.buf
+}
+
+func (message *DevicesResponse) Unmarshal(data []byte) error {
+	dec := decoder{data: data}
+
+	for dec.more() {
+		switch field, wireType := dec.next(); {
+		case field == 1 && wireType == wireBytes:
+			device := &Device{}
+			dec.message(device)
+			message.Devices = append(message.Devices, device)
+		default:
+			dec.skip(wireType)
+		}
+	}
+
+	return dec.err
+}
+
+type PackagesRequest struct {
+	Serial             string
+	IncludeSystem      bool
+	IncludeUninstalled bool
+}
+
+func (message *PackagesRequest) Marshal() []byte {
+	var enc encoder
+	enc.string(1, message.Serial)
+	enc.bool(2, message.IncludeSystem)
+	enc.bool(3, message.IncludeUninstalled)
+
+	return enc.buf
+}
+
+func (message *PackagesRequest) Unmarshal(data []byte) error {
+	dec := decoder{data: data}
+
+	for dec.more() {
+		switch field, wireType := dec.next(); {
+		case field == 1 && wireType == wireBytes:
+			message.Serial = dec.string()
+		case field == 2 && wireType == wireVarint:
+			message.IncludeSystem = dec.varint() != 0
+		case field == 3 && wireType == wireVarint:
+			message.IncludeUninstalled = dec.varint() != 0
+		default:
+			dec.skip(wireType)
+		}
+	}
+
+	return dec.err
+}
+
+type Package struct {
+	Name    string
+	APKPath string
+	System  bool
+}
+
+func (message *Package) Marshal() []byte {
+	var enc encoder
+	enc.string(1, message.Name)
+	enc.string(2, message.APKPath)
+	enc.bool(3, message.System)
+
+	return enc.buf
+}
+
+func (message *Package) Unmarshal(data []byte) error {
+	dec := decoder{data: data}
+
+	for dec.more() {
+		switch field, wireType := dec.next(); {
+		case field == 1 && wireType == wireBytes:
+			message.Name = dec.string()
+		case field == 2 && wireType == wireBytes:
+			message.APKPath = dec.string()
+		case field == 3 && wireType == wireVarint:
+			message.System = dec.varint() != 0
+		default:
+			dec.skip(wireType)
+		}
+	}
+
+	return dec.err
+}
+
+type PackagesResponse struct {
+	Packages []*Package
+}
+
+func (message *PackagesResponse) Marshal() []byte {
+	var enc encoder
+	for _, pkg := range message.Packages {
+		enc.message(1, pkg)
+	}
+
+	return enc.buf
+}
+
+func (message *PackagesResponse) Unmarshal(data []byte) error {
+	dec := decoder{data: data}
+
+	for dec.more() {
+		switch field, wireType := dec.next(); {
+		case field == 1 && wireType == wireBytes:
+			pkg := &Package{}
+			dec.message(pkg)
+			message.Packages = append(message.Packages, pkg)
+		default:
+			dec.skip(wireType)
+		}
+	}
+
+	return dec.err
+}
+
+// InstallRequest is streamed: the first one names the device, every one may carry a piece of the APK
+type InstallRequest struct {
+	Serial string
+	Name   string // file name, for the audit log
+	Chunk  []byte
+}
+
+func (message *InstallRequest) Marshal() []byte {
+	var enc encoder
+	enc.string(1, message.Serial)
+	enc.string(2, message.Name)
+	enc.bytes(3, message.Chunk)
+
+	return enc.buf
+}
+
+func (message *InstallRequest) Unmarshal(data []byte) error {
+	dec := decoder{data: data}
+
+	for dec.more() {
+		switch field, wireType := dec.next(); {
+		case field == 1 && wireType == wireBytes:
+			message.Serial = dec.string()
+		case field == 2 && wireType == wireBytes:
+			message.Name = dec.string()
+		case field == 3 && wireType == wireBytes:
+			message.Chunk = dec.bytes()
+		default:
+			dec.skip(wireType)
+		}
+	}
+
+	return dec.err
+}
+
+type InstallResponse struct {
+	SHA256 string
+	Bytes  int64
+}
+
+func (message *InstallResponse) Marshal() []byte {
+	var enc encoder
+	enc.string(1, message.SHA256)
+	enc.int(2, message.Bytes)
+
+	return enc.buf
+}
+
+func (message *InstallResponse) Unmarshal(data []byte) error {
+	dec := decoder{data: data}
+
+	for dec.more() {
+		switch field, wireType := dec.next(); {
+		case field == 1 && wireType == wireBytes:
+			message.SHA256 = dec.string()
+		case field == 2 && wireType == wireVarint:
+			message.Bytes = int64(dec.varint())
+		default:
+			dec.skip(wireType)
+		}
+	}
+
+	return dec.err
+}
+
+type UninstallRequest struct {
+	Serial   string
+	Package  string
+	KeepData bool
+	User     *int32 // every user when unset
+}
+
+func (message *UninstallRequest) Marshal() []byte {
+	var enc encoder
+	enc.string(1, message.Serial)
+	enc.string(2, message.Package)
+	enc.bool(3, message.KeepData)
+	enc.optionalInt(4, message.User)
+
+	return enc.buf
+}
+
+func (message *UninstallRequest) Unmarshal(data []byte) error {
+	dec := decoder{data: data}
+
+	for dec.more() {
+		switch field, wireType := dec.next(); {
+		case field == 1 && wireType == wireBytes:
+			message.Serial = dec.string()
+		case field == 2 && wireType == wireBytes:
+			message.Package = dec.string()
+		case field == 3 && wireType == wireVarint:
+			message.KeepData = dec.varint() != 0
+		case field == 4 && wireType == wireVarint:
+			user := int32(dec.varint())
+			message.User = &user
+		default:
+			dec.skip(wireType)
+		}
+	}
+
+	return dec.err
+}
+
+type WatchDevicesRequest struct {
+	LastEventID uint64 // replay what came after this event, like SSE's Last-Event-ID
+}
+
+func (message *WatchDevicesRequest) Marshal() []byte {
+	var enc encoder
+	enc.uint(1, message.LastEventID)
+
+	return enc.buf
+}
+
+func (message *WatchDevicesRequest) Unmarshal(data []byte) error {
+	dec := decoder{data: data}
+
+	for dec.more() {
+		switch field, wireType := dec.next(); {
+		case field == 1 && wireType == wireVarint:
+			message.LastEventID = dec.varint()
+		default:
+			dec.skip(wireType)
+		}
+	}
+
+	return dec.err
+}
+
+type DeviceEvent struct {
+	ID            uint64
+	Type          string // device.connected, device.disconnected or device.state-changed
+	Device        *Device
+	PreviousState string
+}
+
+func (message *DeviceEvent) Marshal() []byte {
+	var enc encoder
+	enc.uint(1, message.ID)
+	enc.string(2, message.Type)
+
+	if message.Device != nil {
+		enc.message(3, message.Device)
+	}
+
+	enc.string(4, message.PreviousState)
+
+	return enc.buf
+}
+
+func (message *DeviceEvent) Unmarshal(data []byte) error {
+	dec := decoder{data: data}
+
+	for dec.more() {
+		switch field, wireType := dec.next(); {
+		case field == 1 && wireType == wireVarint:
+			message.ID = dec.varint()
+		case field == 2 && wireType == wireBytes:
+			message.Type = dec.string()
+		case field == 3 && wireType == wireBytes:
+			message.Device = &Device{}
+			dec.message(message.Device)
+		case field == 4 && wireType == wireBytes:
+			message.PreviousState = dec.string()
+		default:
+			dec.skip(wireType)
+		}
+	}
+
+	return dec.err
+}
+
+type LogcatRequest struct {
+	Serial  string
+	Filters []string // tag:priority, like ActivityManager:I or *:S
+	Buffer  string
+	Dump    bool // print what's there and end the stream instead of following
+	Tail    int32
+}
+
+func (message *LogcatRequest) Marshal() []byte {
+	var enc encoder
+	enc.string(1, message.Serial)
+	enc.strings(2, message.Filters)
+	enc.string(3, message.Buffer)
+	enc.bool(4, message.Dump)
+	enc.int(5, int64(message.Tail))
+
+	return enc.buf
+}
+
+func (message *LogcatRequest) Unmarshal(data []byte) error {
+	dec := decoder{data: data}
+
+	for dec.more() {
+		switch field, wireType := dec.next(); {
+		case field == 1 && wireType == wireBytes:
+			message.Serial = dec.string()
+		case field == 2 && wireType == wireBytes:
+			message.Filters = append(message.Filters, dec.string())
+		case field == 3 && wireType == wireBytes:
+			message.Buffer = dec.string()
+		case field == 4 && wireType == wireVarint:
+			message.Dump = dec.varint() != 0
+		case field == 5 && wireType == wireVarint:
+			message.Tail = int32(dec.varint())
+		default:
+			dec.skip(wireType)
+		}
+	}
+
+	return dec.err
+}
+
+type LogcatLine struct {
+	Line string
+}
+
+func (message *LogcatLine) Marshal() []byte {
+	var enc encoder
+	enc.string(1, message.Line)
+
+	return enc.buf
+}
+
+func (message *LogcatLine) Unmarshal(data []byte) error {
+	dec := decoder{data: data}
+
+	for dec.more() {
+		switch field, wireType := dec.next(); {
+		case field == 1 && wireType == wireBytes:
+			message.Line = dec.string()
+		default:
+			dec.skip(wireType)
+		}
+	}
+
+	return dec.err
+}
+
+// ShellRequest is streamed, the device only has to be named in the first one
+type ShellRequest struct {
+	Serial  string
+	Command string
+}
+
+func (message *ShellRequest) Marshal() []byte {
+	var enc encoder
+	enc.string(1, message.Serial)
+	enc.string(2, message.Command)
+
+	return enc.buf
+}
+
+func (message *ShellRequest) Unmarshal(data []byte) error {
+	dec := decoder{data: data}
+
+	for dec.more() {
+		switch field, wireType := dec.next(); {
+		case field == 1 && wireType == wireBytes:
+			message.Serial = dec.string()
+		case field == 2 && wireType == wireBytes:
+			message.Command = dec.string()
+		default:
+			dec.skip(wireType)
+		}
+	}
+
+	return dec.err
+}
+
+// ShellResponse answers one ShellRequest, a failed command sets Error and keeps the stream going
+type ShellResponse struct {
+	Command string
+	Output  string
+	Error   string
+}
+
+func (message *ShellResponse) Marshal() []byte {
+	var enc encoder
+	enc.string(1, message.Command)
+	enc.string(2, message.Output)
+	enc.string(3, message.Error)
+
+	return enc.buf
+}
+
+func (message *ShellResponse) Unmarshal(data []byte) error {
+	dec := decoder{data: data}
+
+	for dec.more() {
+		switch field, wireType := dec.next(); {
+		case field == 1 && wireType == wireBytes:
+			message.Command = dec.string()
+		case field == 2 && wireType == wireBytes:
+			message.Output = dec.string()
+		case field == 3 && wireType == wireBytes:
+			message.Error = dec.string()
+		default:
+			dec.skip(wireType)
+		}
+	}
+
+	return dec.err
+}
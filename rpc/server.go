@@ -0,0 +1,125 @@
+// Package rpc serves the adbserver.v1.ADB gRPC service described in adb.proto. It runs on the
+// same port as the HTTP API, behind the same middleware, so sessions, scopes, the audit log and
+// the per-device queues are shared. Plain HTTP connections need h2c, which the server enables.
+package rpc
+
+import (
+	"adb-server/authentication"
+	"adb-server/middleware"
+	"context"
+	"net/http"
+	"strings"
+)
+
+// ServicePath prefixes every method, /adbserver.v1.ADB/Devices and so on
+const ServicePath = "/adbserver.v1.ADB/"
+
+type Options struct {
+	UploadDir      string // where Install spools APKs
+	MaxUploadBytes int64
+}
+
+type Server struct {
+	options  Options
+	stopping context.Context
+	stop     context.CancelFunc
+}
+
+func NewServer(options Options) *Server {
+	stopping, stop := context.WithCancel(context.Background())
+
+	return &Server{options: options, stopping: stopping, stop: stop}
+}
+
+// Stop ends the calls that would otherwise never finish, followed logcats and device
+// watches, so a graceful shutdown doesn't sit waiting for them
+func (server *Server) Stop() {
+	server.stop()
+}
+
+type method struct {
+	name   string
+	scope  string
+	handle func(call *stream) error
+}
+
+func (server *Server) methods() []method {
+	return []method{
+		{name: "Devices", scope: authentication.ScopeDevicesRead, handle: server.devices},
+		{name: "Packages", scope: authentication.ScopeDevicesRead, handle: server.packages},
+		{name: "Install", scope: authentication.ScopeDevicesWrite, handle: server.install},
+		{name: "Uninstall", scope: authentication.ScopeDevicesWrite, handle: server.uninstall},
+		{name: "WatchDevices", scope: authentication.ScopeDevicesRead, handle: server.watchDevices},
+		{name: "Logcat", scope: authentication.ScopeDevicesRead, handle: server.logcat},
+		{name: "Shell", scope: authentication.ScopeDevicesWrite, handle: server.shell},
+	}
+}
+
+// Register puts every method on mux, which should sit behind the protected middleware
+func (server *Server) Register(mux *http.ServeMux) {
+	for _, method := range server.methods() {
+		mux.Handle("POST "+ServicePath+method.name, server.serve(method))
+	}
+
+	// A newer client may know methods we don't
+	mux.Handle("POST "+ServicePath, server.serve(method{
+		handle: func(call *stream) error {
+			return statusf(codeUnimplemented, "unknown method %s", strings.TrimPrefix(call.req.URL.Path, ServicePath))
+		},
+	}))
+}
+
+func (server *Server) serve(method method) http.Handler {
+	return http.HandlerFunc(
+		func(res http.ResponseWriter, req *http.Request) {
+			if req.ProtoMajor != 2 {
+				http.Error(res, "gRPC needs HTTP/2", http.StatusHTTPVersionNotSupported)
+				return
+			}
+
+			if !strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc") {
+				http.Error(res, "content type must be application/grpc", http.StatusUnsupportedMediaType)
+				return
+			}
+
+			ctx := req.Context()
+
+			if timeout, ok := parseTimeout(req.Header.Get("Grpc-Timeout")); ok {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+
+			res.Header().Set("Content-Type", "application/grpc")
+
+			call := &stream{
+				ctx:        ctx,
+				res:        res,
+				req:        req.WithContext(ctx),
+				controller: http.NewResponseController(res),
+			}
+
+			err := authorize(req, method.scope)
+			if err == nil {
+				err = method.handle(call)
+			}
+
+			code, message := statusOf(ctx, err)
+			finish(res, code, message)
+		},
+	)
+}
+
+// The middleware already turned away requests without a session, this is for the scopes
+func authorize(req *http.Request, scope string) error {
+	session, ok := middleware.GetSession(req)
+	if !ok {
+		return statusf(codeUnauthenticated, "not paired")
+	}
+
+	if scope != "" && !session.HasScope(scope) {
+		return statusf(codePermissionDenied, "missing scope %s", scope)
+	}
+
+	return nil
+}
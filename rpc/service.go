@@ -0,0 +1,455 @@
+package rpc
+
+import (
+	"adb-server/audit"
+	"adb-server/events"
+	"adb-server/internal/adb"
+	"adb-server/logging"
+	"adb-server/middleware"
+	"adb-server/registry"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (server *Server) devices(call *stream) error {
+	adbClient, err := clientOf(call)
+	if err != nil {
+		return err
+	}
+
+	if err := call.receiveOne(&Empty{}); err != nil {
+		return err
+	}
+
+	devices, err := adbClient.Devices(call.ctx)
+	if err != nil {
+		return statusf(codeUnavailable, "error listing devices connected to adb: %v", err)
+	}
+
+	deviceRegistry, _ := middleware.GetRegistry(call.req)
+
+	response := &DevicesResponse{}
+	for _, device := range devices {
+		response.Devices = append(response.Devices, deviceMessage(deviceRegistry, device))
+	}
+
+	return call.send(response)
+}
+
+func (server *Server) packages(call *stream) error {
+	adbClient, err := clientOf(call)
+	if err != nil {
+		return err
+	}
+
+	request := &PackagesRequest{}
+	if err := call.receiveOne(request); err != nil {
+		return err
+	}
+
+	serial, err := resolve(call, request.Serial)
+	if err != nil {
+		return err
+	}
+
+	packages, err := adbClient.Packages(call.ctx, serial, adb.ListPackageOptions{
+		IncludeSystem:      request.IncludeSystem,
+		IncludeUninstalled: request.IncludeUninstalled,
+	})
+	if err != nil {
+		return err
+	}
+
+	response := &PackagesResponse{}
+	for _, pkg := range packages {
+		response.Packages = append(response.Packages, &Package{Name: pkg.Name, APKPath: pkg.ApkPath, System: pkg.IsSystem})
+	}
+
+	return call.send(response)
+}
+
+// install spools the streamed APK to a temp file, the same as an HTTP upload, and installs it
+// once the client closes its side
+func (server *Server) install(call *stream) error {
+	adbClient, err := clientOf(call)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(server.options.UploadDir, "upload-*.apk")
+	if err != nil {
+		return statusf(codeInternal, "problem storing upload")
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	hash := sha256.New()
+	var deviceID, name string
+	var size int64
+
+	for {
+		request := &InstallRequest{}
+
+		err := call.receive(request)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if deviceID == "" {
+			deviceID = request.Serial
+		}
+		if name == "" {
+			name = request.Name
+		}
+
+		size += int64(len(request.Chunk))
+		if server.options.MaxUploadBytes > 0 && size > server.options.MaxUploadBytes {
+			return statusf(codeResourceExhausted, "upload is larger than %d bytes", server.options.MaxUploadBytes)
+		}
+
+		hash.Write(request.Chunk)
+		if _, err := file.Write(request.Chunk); err != nil {
+			return statusf(codeInternal, "problem storing upload")
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		return statusf(codeInternal, "problem storing upload")
+	}
+
+	if size == 0 {
+		return statusf(codeInvalidArgument, "the APK is missing, send it in the chunk field")
+	}
+
+	serial, err := resolve(call, deviceID)
+	if err != nil {
+		return err
+	}
+
+	if name == "" {
+		name = "upload.apk"
+	}
+
+	apkHash := hex.EncodeToString(hash.Sum(nil))
+	started := time.Now()
+
+	err = adbClient.Install(call.ctx, serial, file.Name())
+	record(call, audit.Entry{
+		Operation: "install",
+		DeviceID:  serial,
+		Arguments: map[string]string{"upload": name, "bytes": strconv.FormatInt(size, 10)},
+		APKSHA256: apkHash,
+	}, started, err)
+
+	if err != nil {
+		return err
+	}
+
+	return call.send(&InstallResponse{SHA256: apkHash, Bytes: size})
+}
+
+func (server *Server) uninstall(call *stream) error {
+	adbClient, err := clientOf(call)
+	if err != nil {
+		return err
+	}
+
+	request := &UninstallRequest{}
+	if err := call.receiveOne(request); err != nil {
+		return err
+	}
+
+	if request.Package == "" {
+		return statusf(codeInvalidArgument, "package name is required")
+	}
+
+	serial, err := resolve(call, request.Serial)
+	if err != nil {
+		return err
+	}
+
+	user := -1 // all users
+	if request.User != nil {
+		user = int(*request.User)
+	}
+
+	started := time.Now()
+
+	err = adbClient.Uninstall(call.ctx, serial, request.Package, request.KeepData, user)
+	record(call, audit.Entry{
+		Operation: "uninstall",
+		DeviceID:  serial,
+		Arguments: map[string]string{
+			"package":   request.Package,
+			"keep-data": strconv.FormatBool(request.KeepData),
+			"user":      strconv.Itoa(user),
+		},
+	}, started, err)
+
+	if err != nil {
+		return err
+	}
+
+	return call.send(&Empty{})
+}
+
+// watchDevices is the device part of the event stream, until the client hangs up
+func (server *Server) watchDevices(call *stream) error {
+	bus, ok := middleware.GetEvents(call.req)
+	if !ok {
+		return statusf(codeUnimplemented, "events not available")
+	}
+
+	request := &WatchDevicesRequest{}
+	if err := call.receiveOne(request); err != nil {
+		return err
+	}
+
+	subscription := bus.Subscribe([]string{
+		events.TypeDeviceConnected,
+		events.TypeDeviceDisconnected,
+		events.TypeDeviceStateChanged,
+	}, request.LastEventID)
+	defer subscription.Close()
+
+	// Lets the client know it is subscribed before the first event comes along
+	call.res.WriteHeader(http.StatusOK)
+	if err := call.controller.Flush(); err != nil {
+		return err
+	}
+
+	deviceRegistry, _ := middleware.GetRegistry(call.req)
+
+	for {
+		select {
+		case <-call.ctx.Done():
+			return call.ctx.Err()
+		case <-server.stopping.Done():
+			return statusf(codeUnavailable, "server is shutting down")
+		case event, open := <-subscription.C:
+			if !open {
+				return statusf(codeUnavailable, "event stream ended, the server is shutting down or this client fell behind")
+			}
+
+			change, ok := event.Data.(events.DeviceChange)
+			if !ok {
+				continue
+			}
+
+			err := call.send(&DeviceEvent{
+				ID:            event.ID,
+				Type:          event.Type,
+				Device:        deviceMessage(deviceRegistry, change.Device),
+				PreviousState: change.PreviousState,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (server *Server) logcat(call *stream) error {
+	adbClient, err := clientOf(call)
+	if err != nil {
+		return err
+	}
+
+	request := &LogcatRequest{}
+	if err := call.receiveOne(request); err != nil {
+		return err
+	}
+
+	options := adb.LogcatOptions{
+		Filters: request.Filters,
+		Buffer:  request.Buffer,
+		Dump:    request.Dump,
+		Tail:    int(request.Tail),
+	}
+
+	if err := adb.ValidateLogcatOptions(options); err != nil {
+		return statusf(codeInvalidArgument, "%v", err)
+	}
+
+	serial, err := resolve(call, request.Serial)
+	if err != nil {
+		return err
+	}
+
+	// A quiet device may not log anything for a while, the client still learns the call started
+	call.res.WriteHeader(http.StatusOK)
+	if err := call.controller.Flush(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(call.ctx)
+	defer cancel()
+
+	stopListening := context.AfterFunc(server.stopping, cancel)
+	defer stopListening()
+
+	// A client that stops reading ends the stream rather than holding up adb
+	var sendError error
+
+	err = adbClient.Logcat(ctx, serial, options, func(line string) {
+		if sendError != nil {
+			return
+		}
+
+		if sendError = call.send(&LogcatLine{Line: line}); sendError != nil {
+			cancel()
+		}
+	})
+
+	switch {
+	case sendError != nil:
+		return sendError
+	case server.stopping.Err() != nil && call.ctx.Err() == nil:
+		return statusf(codeUnavailable, "server is shutting down")
+	}
+
+	return err
+}
+
+// shell runs each command the client sends and answers it, in order, until the client closes
+// its side. A command that fails is answered with its error and the stream carries on.
+func (server *Server) shell(call *stream) error {
+	adbClient, err := clientOf(call)
+	if err != nil {
+		return err
+	}
+
+	var serial string
+
+	for {
+		request := &ShellRequest{}
+
+		err := call.receive(request)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if serial == "" {
+			if serial, err = resolve(call, request.Serial); err != nil {
+				return err
+			}
+		}
+
+		if server.stopping.Err() != nil {
+			return statusf(codeUnavailable, "server is shutting down")
+		}
+
+		if strings.TrimSpace(request.Command) == "" {
+			return statusf(codeInvalidArgument, "command is required")
+		}
+
+		started := time.Now()
+
+		output, err := adbClient.Shell(call.ctx, serial, request.Command)
+		record(call, audit.Entry{
+			Operation: "shell",
+			DeviceID:  serial,
+			Arguments: map[string]string{"command": request.Command},
+		}, started, err)
+
+		if call.ctx.Err() != nil {
+			return call.ctx.Err()
+		}
+
+		response := &ShellResponse{Command: request.Command, Output: output}
+		if err != nil {
+			response.Error = err.Error()
+		}
+
+		if err := call.send(response); err != nil {
+			return err
+		}
+	}
+}
+
+func clientOf(call *stream) (adb.Client, error) {
+	adbClient, ok := middleware.GetADBClient(call.req)
+	if !ok {
+		return nil, statusf(codeInternal, "ADB client not available")
+	}
+
+	return adbClient, nil
+}
+
+// resolve turns a serial or registry selector into the one serial it means, like the HTTP API
+func resolve(call *stream, deviceID string) (string, error) {
+	if deviceID == "" {
+		return "", statusf(codeInvalidArgument, "device serial is required")
+	}
+
+	deviceRegistry, hasRegistry := middleware.GetRegistry(call.req)
+	if !hasRegistry {
+		return deviceID, nil
+	}
+
+	serials, isSelector, err := deviceRegistry.Resolve(deviceID)
+	if err != nil {
+		return "", statusf(codeInvalidArgument, "%v", err)
+	}
+
+	if !isSelector {
+		return deviceID, nil
+	}
+
+	switch len(serials) {
+	case 1:
+		return serials[0], nil
+	case 0:
+		return "", statusf(codeInvalidArgument, "no device matches %s", deviceID)
+	default:
+		return "", statusf(codeInvalidArgument, "%s matches %d devices (%s), use a more specific selector", deviceID, len(serials), strings.Join(serials, ", "))
+	}
+}
+
+func deviceMessage(deviceRegistry *registry.Registry, device adb.Device) *Device {
+	message := &Device{
+		Serial:       device.Serial,
+		State:        device.State,
+		Model:        device.Model,
+		Manufacturer: device.Manufacturer,
+		Authorized:   device.IsAuthorized,
+	}
+
+	if deviceRegistry != nil {
+		if info, found := deviceRegistry.Device(device.Serial); found {
+			message.Name = info.Name
+			message.Tags = info.Tags
+		}
+	}
+
+	return message
+}
+
+func record(call *stream, entry audit.Entry, started time.Time, operationError error) {
+	auditLog, ok := middleware.GetAuditLog(call.req)
+	if !ok {
+		return
+	}
+
+	if session, ok := middleware.GetSession(call.req); ok {
+		entry.SessionID = session.ID
+	}
+
+	entry.RequestID = logging.RequestID(call.ctx)
+
+	auditLog.RecordOutcome(entry, started, operationError)
+}
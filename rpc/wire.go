@@ -0,0 +1,174 @@
+package rpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Just enough of the protobuf wire format for the messages in adb.proto: varints and
+// length-delimited strings, bytes and messages. Fields we don't know are skipped.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("protobuf message is truncated")
+
+type encoder struct {
+	buf []byte
+}
+
+func (enc *encoder) tag(field int, wireType int) {
+	enc.buf = binary.AppendUvarint(enc.buf, uint64(field)<<3|uint64(wireType))
+}
+
+// Zero values are left out, that's how proto3 says "not set"
+func (enc *encoder) uint(field int, value uint64) {
+	if value == 0 {
+		return
+	}
+
+	enc.tag(field, wireVarint)
+	enc.buf = binary.AppendUvarint(enc.buf, value)
+}
+
+// Negative numbers take ten bytes, the same as protobuf's int32 and int64
+func (enc *encoder) int(field int, value int64) {
+	enc.uint(field, uint64(value))
+}
+
+func (enc *encoder) bool(field int, value bool) {
+	if value {
+		enc.uint(field, 1)
+	}
+}
+
+func (enc *encoder) string(field int, value string) {
+	enc.bytes(field, []byte(value))
+}
+
+func (enc *encoder) bytes(field int, value []byte) {
+	if len(value) == 0 {
+		return
+	}
+
+	enc.tag(field, wireBytes)
+	enc.buf = binary.AppendUvarint(enc.buf, uint64(len(value)))
+	enc.buf = append(enc.buf, value...)
+}
+
+// Repeated strings keep their empty ones, the position matters
+func (enc *encoder) strings(field int, values []string) {
+	for _, value := range values {
+		enc.tag(field, wireBytes)
+		enc.buf = binary.AppendUvarint(enc.buf, uint64(len(value)))
+		enc.buf = append(enc.buf, value...)
+	}
+}
+
+// Optional fields are written even when zero, that's the point of them
+func (enc *encoder) optionalInt(field int, value *int32) {
+	if value == nil {
+		return
+	}
+
+	enc.tag(field, wireVarint)
+	enc.buf = binary.AppendUvarint(enc.buf, uint64(int64(*value)))
+}
+
+// Messages are written even when empty, so the reader knows they're there
+func (enc *encoder) message(field int, value Message) {
+	data := value.Marshal()
+
+	enc.tag(field, wireBytes)
+	enc.buf = binary.AppendUvarint(enc.buf, uint64(len(data)))
+	enc.buf = append(enc.buf, data...)
+}
+
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (dec *decoder) more() bool {
+	return dec.err == nil && len(dec.data) > 0
+}
+
+func (dec *decoder) next() (field int, wireType int) {
+	key := dec.varint()
+	return int(key >> 3), int(key & 7)
+}
+
+func (dec *decoder) varint() uint64 {
+	value, size := binary.Uvarint(dec.data)
+	if size <= 0 {
+		dec.fail(errTruncated)
+		return 0
+	}
+
+	dec.data = dec.data[size:]
+
+	return value
+}
+
+func (dec *decoder) bytes() []byte {
+	size := dec.varint()
+	if dec.err != nil {
+		return nil
+	}
+
+	if size > uint64(len(dec.data)) {
+		dec.fail(errTruncated)
+		return nil
+	}
+
+	value := dec.data[:size]
+	dec.data = dec.data[size:]
+
+	return value
+}
+
+func (dec *decoder) string() string {
+	return string(dec.bytes())
+}
+
+func (dec *decoder) message(value Message) {
+	data := dec.bytes()
+	if dec.err == nil {
+		dec.fail(value.Unmarshal(data))
+	}
+}
+
+// skip steps over a field we have no use for, or one that came with a wire type we don't expect
+func (dec *decoder) skip(wireType int) {
+	switch wireType {
+	case wireVarint:
+		dec.varint()
+	case wireBytes:
+		dec.bytes()
+	case wireFixed64:
+		dec.advance(8)
+	case wireFixed32:
+		dec.advance(4)
+	default:
+		dec.fail(fmt.Errorf("unsupported protobuf wire type %d", wireType))
+	}
+}
+
+func (dec *decoder) advance(size int) {
+	if size > len(dec.data) {
+		dec.fail(errTruncated)
+		return
+	}
+
+	dec.data = dec.data[size:]
+}
+
+func (dec *decoder) fail(err error) {
+	if dec.err == nil {
+		dec.err = err
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"adb-server/models"
+	"adb-server/tracing"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// adb-server trace-collector [-listen 127.0.0.1:4318] [-json]
+// A stand-in OTLP collector that prints every span it receives, for checking tracing
+// without running a real collector: point tracing.endpoint at it.
+func runTraceCollector(args []string) int {
+	flags := flag.NewFlagSet("trace-collector", flag.ContinueOnError)
+	listen := flags.String("listen", "127.0.0.1:4318", "address to accept OTLP/HTTP JSON on")
+	asJSON := flags.Bool("json", false, "print spans as JSON lines instead of text")
+
+	if err := flags.Parse(args); err != nil {
+		return models.ExitConfig
+	}
+
+	var printing sync.Mutex
+	encoder := json.NewEncoder(os.Stdout)
+
+	collector := tracing.NewCollector()
+	collector.OnSpan = func(span tracing.CollectedSpan) {
+		printing.Lock()
+		defer printing.Unlock()
+
+		if *asJSON {
+			_ = encoder.Encode(span)
+			return
+		}
+
+		parent := span.ParentSpanID
+		if parent == "" {
+			parent = "root"
+		}
+
+		var attributes []string
+		for key, value := range span.Attributes {
+			attributes = append(attributes, fmt.Sprintf("%s=%v", key, value))
+		}
+
+		slices.Sort(attributes)
+
+		status := ""
+		if span.StatusCode == tracing.StatusError {
+			status = " ERROR " + span.StatusMessage
+		}
+
+		fmt.Printf("%s %s <- %s %-32s %8s%s %s\n", span.TraceID[:8], span.SpanID, parent, span.Name, span.Duration().Round(100_000), status, strings.Join(attributes, " "))
+	}
+
+	fmt.Fprintf(os.Stderr, "Collecting traces on http://%s/v1/traces\n", *listen)
+
+	if err := http.ListenAndServe(*listen, collector); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return models.ExitError
+	}
+
+	return models.ExitOK
+}
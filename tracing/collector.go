@@ -0,0 +1,169 @@
+package tracing
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CollectedSpan is a span as the collector received it
+type CollectedSpan struct {
+	Service       string         `json:"service"`
+	TraceID       string         `json:"trace_id"`
+	SpanID        string         `json:"span_id"`
+	ParentSpanID  string         `json:"parent_span_id,omitempty"`
+	Name          string         `json:"name"`
+	Kind          int            `json:"kind"`
+	Start         time.Time      `json:"start"`
+	End           time.Time      `json:"end"`
+	Attributes    map[string]any `json:"attributes,omitempty"`
+	StatusCode    int            `json:"status_code"`
+	StatusMessage string         `json:"status_message,omitempty"`
+}
+
+func (span CollectedSpan) Duration() time.Duration {
+	return span.End.Sub(span.Start)
+}
+
+// Collector is a stand-in for an OpenTelemetry collector: it accepts OTLP/HTTP JSON on
+// /v1/traces and keeps the spans in memory. Point tracing.endpoint at one to see what we send.
+type Collector struct {
+	mu     sync.Mutex
+	spans  []CollectedSpan
+	OnSpan func(span CollectedSpan) // optional, called for every span as it arrives
+}
+
+func NewCollector() *Collector {
+	return &Collector{}
+}
+
+func (collector *Collector) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/v1/traces" {
+		http.NotFound(res, req)
+		return
+	}
+
+	if req.Method != http.MethodPost {
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType != "application/json" {
+		http.Error(res, "only OTLP/HTTP JSON is supported", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var request otlpRequest
+	if err := json.NewDecoder(http.MaxBytesReader(res, req.Body, 16<<20)).Decode(&request); err != nil {
+		http.Error(res, fmt.Sprintf("invalid OTLP request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var received []CollectedSpan
+
+	for _, resourceSpans := range request.ResourceSpans {
+		resourceAttributes := decodeAttributes(resourceSpans.Resource.Attributes)
+		service, _ := resourceAttributes["service.name"].(string)
+
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			for _, span := range scopeSpans.Spans {
+				if !isHexID(span.TraceID, 16) || !isHexID(span.SpanID, 8) || (span.ParentSpanID != "" && !isHexID(span.ParentSpanID, 8)) {
+					http.Error(res, fmt.Sprintf("invalid OTLP request: span %q needs a 32 hex digit trace_id and 16 hex digit span ids", span.Name), http.StatusBadRequest)
+					return
+				}
+
+				received = append(received, CollectedSpan{
+					Service:       service,
+					TraceID:       span.TraceID,
+					SpanID:        span.SpanID,
+					ParentSpanID:  span.ParentSpanID,
+					Name:          span.Name,
+					Kind:          int(span.Kind),
+					Start:         unixNano(span.StartTimeUnixNano),
+					End:           unixNano(span.EndTimeUnixNano),
+					Attributes:    decodeAttributes(span.Attributes),
+					StatusCode:    span.Status.Code,
+					StatusMessage: span.Status.Message,
+				})
+			}
+		}
+	}
+
+	collector.mu.Lock()
+	collector.spans = append(collector.spans, received...)
+	collector.mu.Unlock()
+
+	if collector.OnSpan != nil {
+		for _, span := range received {
+			collector.OnSpan(span)
+		}
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	_, _ = res.Write([]byte("{}"))
+}
+
+// Spans returns everything received so far, oldest first
+func (collector *Collector) Spans() []CollectedSpan {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+
+	return slices.Clone(collector.spans)
+}
+
+func (collector *Collector) Reset() {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+
+	collector.spans = nil
+}
+
+// isHexID reports whether id is size bytes in hex, the way OTLP/HTTP JSON writes trace and span ids
+func isHexID(id string, size int) bool {
+	decoded, err := hex.DecodeString(id)
+	return err == nil && len(decoded) == size
+}
+
+func decodeAttributes(attributes []otlpKeyValue) map[string]any {
+	decoded := make(map[string]any, len(attributes))
+
+	for _, attribute := range attributes {
+		decoded[attribute.Key] = decodeValue(attribute.Value)
+	}
+
+	return decoded
+}
+
+func decodeValue(value otlpValue) any {
+	switch {
+	case value.StringValue != nil:
+		return *value.StringValue
+	case value.BoolValue != nil:
+		return *value.BoolValue
+	case value.IntValue != nil:
+		number, _ := strconv.ParseInt(*value.IntValue, 10, 64)
+		return number
+	case value.DoubleValue != nil:
+		return *value.DoubleValue
+	case value.ArrayValue != nil:
+		items := make([]any, 0, len(value.ArrayValue.Values))
+		for _, item := range value.ArrayValue.Values {
+			items = append(items, decodeValue(item))
+		}
+
+		return items
+	}
+
+	return nil
+}
+
+func unixNano(encoded string) time.Time {
+	nanos, _ := strconv.ParseInt(encoded, 10, 64)
+	return time.Unix(0, nanos).UTC()
+}
@@ -0,0 +1,320 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	exportBatchSize = 256
+	exportInterval  = 5 * time.Second
+	exportQueueSize = 2048
+)
+
+type ExporterOptions struct {
+	Endpoint    string            // OTLP/HTTP base URL, e.g. http://127.0.0.1:4318, /v1/traces is added unless present
+	ServiceName string            // service.name resource attribute
+	Headers     map[string]string // sent with every export, e.g. an API key for a hosted collector
+}
+
+// Exporter batches ended spans and posts them as OTLP/HTTP JSON. Spans that don't fit in the
+// queue are dropped rather than slowing requests down.
+type Exporter struct {
+	url         string
+	serviceName string
+	headers     map[string]string
+	httpClient  *http.Client
+
+	queue    chan *Span
+	flush    chan chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func NewExporter(options ExporterOptions) (*Exporter, error) {
+	if !strings.HasPrefix(options.Endpoint, "http://") && !strings.HasPrefix(options.Endpoint, "https://") {
+		return nil, fmt.Errorf("tracing endpoint %q must be an http(s) URL", options.Endpoint)
+	}
+
+	url := strings.TrimSuffix(options.Endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+
+	if options.ServiceName == "" {
+		options.ServiceName = "adb-server"
+	}
+
+	exporter := &Exporter{
+		url:         url,
+		serviceName: options.ServiceName,
+		headers:     options.Headers,
+		httpClient:  &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan *Span, exportQueueSize),
+		flush:       make(chan chan struct{}),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	go exporter.run()
+
+	return exporter, nil
+}
+
+func (exporter *Exporter) enqueue(span *Span) {
+	select {
+	case exporter.queue <- span:
+	default:
+		slog.Debug("tracing queue full, dropping span", "span", span.name)
+	}
+}
+
+// Flush exports everything queued so far and waits for it
+func (exporter *Exporter) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+
+	select {
+	case exporter.flush <- flushed:
+	case <-exporter.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown exports what's left and stops the exporter
+func (exporter *Exporter) Shutdown(ctx context.Context) error {
+	exporter.stopOnce.Do(func() { close(exporter.stop) })
+
+	select {
+	case <-exporter.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (exporter *Exporter) run() {
+	defer close(exporter.done)
+
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	var batch []*Span
+
+	send := func() {
+		if len(batch) > 0 {
+			exporter.export(batch)
+			batch = nil
+		}
+	}
+
+	// Everything already queued, without waiting for more
+	drain := func() {
+		for {
+			select {
+			case span := <-exporter.queue:
+				batch = append(batch, span)
+			default:
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case span := <-exporter.queue:
+			batch = append(batch, span)
+
+			if len(batch) >= exportBatchSize {
+				send()
+			}
+
+		case <-ticker.C:
+			send()
+
+		case flushed := <-exporter.flush:
+			drain()
+			send()
+			close(flushed)
+
+		case <-exporter.stop:
+			drain()
+			send()
+			return
+		}
+	}
+}
+
+func (exporter *Exporter) export(batch []*Span) {
+	body, err := json.Marshal(encodeSpans(exporter.serviceName, batch))
+	if err != nil {
+		slog.Warn("failed to encode spans", "error", err)
+		return
+	}
+
+	request, err := http.NewRequest(http.MethodPost, exporter.url, bytes.NewReader(body))
+	if err != nil {
+		slog.Warn("failed to export spans", "error", err)
+		return
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range exporter.headers {
+		request.Header.Set(name, value)
+	}
+
+	response, err := exporter.httpClient.Do(request)
+	if err != nil {
+		slog.Warn("failed to export spans", "endpoint", exporter.url, "error", err)
+		return
+	}
+
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode >= 300 {
+		slog.Warn("collector rejected spans", "endpoint", exporter.url, "status", response.StatusCode, "spans", len(batch))
+	}
+}
+
+// OTLP JSON encoding, see opentelemetry-proto's JSON mapping: ids are hex, 64-bit ints are strings
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string         `json:"stringValue,omitempty"`
+	BoolValue   *bool           `json:"boolValue,omitempty"`
+	IntValue    *string         `json:"intValue,omitempty"`
+	DoubleValue *float64        `json:"doubleValue,omitempty"`
+	ArrayValue  *otlpArrayValue `json:"arrayValue,omitempty"`
+}
+
+type otlpArrayValue struct {
+	Values []otlpValue `json:"values"`
+}
+
+func encodeSpans(serviceName string, batch []*Span) otlpRequest {
+	spans := make([]otlpSpan, 0, len(batch))
+
+	for _, span := range batch {
+		span.mu.Lock()
+
+		encoded := otlpSpan{
+			TraceID:           span.context.TraceID.String(),
+			SpanID:            span.context.SpanID.String(),
+			Name:              span.name,
+			Kind:              span.kind,
+			StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.end.UnixNano(), 10),
+			Attributes:        encodeAttributes(span.attributes),
+			Status:            otlpStatus{Code: span.statusCode, Message: span.statusMessage},
+		}
+
+		if span.parent.IsValid() {
+			encoded.ParentSpanID = span.parent.String()
+		}
+
+		span.mu.Unlock()
+
+		spans = append(spans, encoded)
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: encodeAttributes([]Attribute{String("service.name", serviceName)})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "adb-server"}, Spans: spans}},
+	}}}
+}
+
+func encodeAttributes(attributes []Attribute) []otlpKeyValue {
+	encoded := make([]otlpKeyValue, 0, len(attributes))
+
+	for _, attribute := range attributes {
+		encoded = append(encoded, otlpKeyValue{Key: attribute.Key, Value: encodeValue(attribute.Value)})
+	}
+
+	return encoded
+}
+
+func encodeValue(value any) otlpValue {
+	switch typed := value.(type) {
+	case string:
+		return otlpValue{StringValue: &typed}
+	case bool:
+		return otlpValue{BoolValue: &typed}
+	case int64:
+		formatted := strconv.FormatInt(typed, 10)
+		return otlpValue{IntValue: &formatted}
+	case float64:
+		return otlpValue{DoubleValue: &typed}
+	case []string:
+		array := &otlpArrayValue{Values: make([]otlpValue, 0, len(typed))}
+		for _, item := range typed {
+			array.Values = append(array.Values, encodeValue(item))
+		}
+
+		return otlpValue{ArrayValue: array}
+	default:
+		formatted := fmt.Sprint(typed)
+		return otlpValue{StringValue: &formatted}
+	}
+}
@@ -0,0 +1,136 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExporterToCollector(t *testing.T) {
+	collector := NewCollector()
+
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	exporter, err := NewExporter(ExporterOptions{Endpoint: server.URL, ServiceName: "test-service"})
+	if err != nil {
+		t.Fatalf("NewExporter: %v", err)
+	}
+
+	SetExporter(exporter)
+	t.Cleanup(func() { SetExporter(nil) })
+
+	ctx, parent := Start(context.Background(), "GET /v1/devices", KindServer,
+		String("http.method", "GET"),
+		Int("http.status_code", 200),
+		Bool("cached", false),
+		Strings("tags", []string{"lab", "ci"}),
+		Attribute{Key: "ratio", Value: 0.5},
+	)
+
+	_, child := Start(ctx, "adb devices", KindClient)
+	child.RecordError(errors.New("device offline"))
+	child.End()
+
+	parent.SetStatus(StatusOK, "")
+	parent.End()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := exporter.Shutdown(shutdownCtx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	spans := collector.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("collector got %d spans, want 2", len(spans))
+	}
+
+	gotChild, gotParent := spans[0], spans[1]
+
+	if gotParent.Service != "test-service" || gotParent.Name != "GET /v1/devices" || gotParent.Kind != int(KindServer) {
+		t.Errorf("parent came back as %+v", gotParent)
+	}
+
+	if gotParent.TraceID != parent.TraceID() || gotParent.SpanID != parent.context.SpanID.String() || gotParent.ParentSpanID != "" {
+		t.Errorf("parent ids came back as trace %s span %s parent %q", gotParent.TraceID, gotParent.SpanID, gotParent.ParentSpanID)
+	}
+
+	if gotChild.TraceID != gotParent.TraceID || gotChild.ParentSpanID != gotParent.SpanID {
+		t.Errorf("child isn't under the parent: %+v", gotChild)
+	}
+
+	if gotChild.StatusCode != StatusError || gotChild.StatusMessage != "device offline" {
+		t.Errorf("child status came back as %d %q", gotChild.StatusCode, gotChild.StatusMessage)
+	}
+
+	if gotParent.StatusCode != StatusOK {
+		t.Errorf("parent status came back as %d", gotParent.StatusCode)
+	}
+
+	if !gotParent.Start.Equal(parent.start) || !gotParent.End.Equal(parent.end) || gotParent.Duration() < 0 {
+		t.Errorf("parent times came back as %s to %s, want %s to %s", gotParent.Start, gotParent.End, parent.start, parent.end)
+	}
+
+	attributes := gotParent.Attributes
+
+	if attributes["http.method"] != "GET" || attributes["http.status_code"] != int64(200) || attributes["cached"] != false || attributes["ratio"] != 0.5 {
+		t.Errorf("attributes came back as %v", attributes)
+	}
+
+	if tags, ok := attributes["tags"].([]any); !ok || !slices.Equal(tags, []any{"lab", "ci"}) {
+		t.Errorf("tags came back as %#v", attributes["tags"])
+	}
+}
+
+// spanBody is an OTLP/HTTP JSON request carrying one span with the given ids
+func spanBody(traceID, spanID, parentSpanID string) string {
+	return fmt.Sprintf(`{"resourceSpans":[{"scopeSpans":[{"spans":[{"traceId":%q,"spanId":%q,"parentSpanId":%q,"name":"GET /v1/devices"}]}]}]}`, traceID, spanID, parentSpanID)
+}
+
+func TestCollectorRejects(t *testing.T) {
+	collector := NewCollector()
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		want        int
+	}{
+		{name: "wrong path", method: "POST", path: "/v1/metrics", contentType: "application/json", body: "{}", want: 404},
+		{name: "wrong method", method: "GET", path: "/v1/traces", want: 405},
+		{name: "protobuf", method: "POST", path: "/v1/traces", contentType: "application/x-protobuf", body: "", want: 415},
+		{name: "bad json", method: "POST", path: "/v1/traces", contentType: "application/json", body: "{", want: 400},
+		{name: "short trace id", method: "POST", path: "/v1/traces", contentType: "application/json", body: spanBody("abc", "00f067aa0ba902b7", ""), want: 400},
+		{name: "span id not hex", method: "POST", path: "/v1/traces", contentType: "application/json", body: spanBody("4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902bz", ""), want: 400},
+		{name: "short parent id", method: "POST", path: "/v1/traces", contentType: "application/json", body: spanBody("4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", "00f0"), want: 400},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			if test.contentType != "" {
+				req.Header.Set("Content-Type", test.contentType)
+			}
+
+			res := httptest.NewRecorder()
+			collector.ServeHTTP(res, req)
+
+			if res.Code != test.want {
+				t.Errorf("got %d, want %d", res.Code, test.want)
+			}
+		})
+	}
+
+	if spans := collector.Spans(); len(spans) != 0 {
+		t.Errorf("collector kept %d spans from rejected requests", len(spans))
+	}
+}
@@ -0,0 +1,268 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A small tracer that speaks OTLP, enough to see where a request spends its time.
+// Without an exporter every call here is a no-op on a nil *Span.
+
+type SpanKind int
+
+// Same numbers as OTLP
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+const (
+	StatusUnset = 0
+	StatusOK    = 1
+	StatusError = 2
+)
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+func (id SpanID) IsValid() bool  { return id != SpanID{} }
+
+// SpanContext is what crosses process and goroutine boundaries
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (spanContext SpanContext) IsValid() bool {
+	return spanContext.TraceID.IsValid() && spanContext.SpanID.IsValid()
+}
+
+// Attribute values are string, bool, int, int64, float64 or []string
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+func String(key, value string) Attribute           { return Attribute{key, value} }
+func Int(key string, value int) Attribute          { return Attribute{key, int64(value)} }
+func Int64(key string, value int64) Attribute      { return Attribute{key, value} }
+func Bool(key string, value bool) Attribute        { return Attribute{key, value} }
+func Strings(key string, value []string) Attribute { return Attribute{key, value} }
+
+type Span struct {
+	mu            sync.Mutex
+	name          string
+	kind          SpanKind
+	context       SpanContext
+	parent        SpanID
+	start         time.Time
+	end           time.Time
+	attributes    []Attribute
+	statusCode    int
+	statusMessage string
+	ended         bool
+	exporter      *Exporter
+}
+
+var globalExporter atomic.Pointer[Exporter]
+
+// SetExporter turns tracing on, nil turns it off again
+func SetExporter(exporter *Exporter) {
+	globalExporter.Store(exporter)
+}
+
+type contextKey string
+
+const spanKey contextKey = "span"
+const remoteKey contextKey = "remoteParent"
+
+// Start begins a span as a child of whatever span (or remote parent) ctx carries
+func Start(ctx context.Context, name string, kind SpanKind, attributes ...Attribute) (context.Context, *Span) {
+	exporter := globalExporter.Load()
+	if exporter == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: attributes,
+		exporter:   exporter,
+	}
+
+	parent := SpanContextFrom(ctx)
+
+	// A trace the caller chose not to sample stays unsampled all the way down, we only start
+	// sampled ones
+	if parent.IsValid() {
+		span.context.TraceID = parent.TraceID
+		span.context.Sampled = parent.Sampled
+		span.parent = parent.SpanID
+	} else {
+		_, _ = rand.Read(span.context.TraceID[:])
+		span.context.Sampled = true
+	}
+
+	_, _ = rand.Read(span.context.SpanID[:])
+
+	return context.WithValue(ctx, spanKey, span), span
+}
+
+// SpanContextFrom is the current span's context, or the remote parent's when there's no local span
+func SpanContextFrom(ctx context.Context) SpanContext {
+	if span, ok := ctx.Value(spanKey).(*Span); ok && span != nil {
+		return span.context
+	}
+
+	if remote, ok := ctx.Value(remoteKey).(SpanContext); ok {
+		return remote
+	}
+
+	return SpanContext{}
+}
+
+// WithRemoteParent makes spans started from ctx children of a span from elsewhere,
+// another process (traceparent) or a request a background job outlives
+func WithRemoteParent(ctx context.Context, parent SpanContext) context.Context {
+	if !parent.IsValid() {
+		return ctx
+	}
+
+	return context.WithValue(ctx, remoteKey, parent)
+}
+
+func (span *Span) SetAttributes(attributes ...Attribute) {
+	if span == nil {
+		return
+	}
+
+	span.mu.Lock()
+	defer span.mu.Unlock()
+
+	span.attributes = append(span.attributes, attributes...)
+}
+
+func (span *Span) SetName(name string) {
+	if span == nil {
+		return
+	}
+
+	span.mu.Lock()
+	defer span.mu.Unlock()
+
+	span.name = name
+}
+
+// RecordError marks the span failed, nil errors are ignored so it can be called unconditionally
+func (span *Span) RecordError(err error) {
+	if span == nil || err == nil {
+		return
+	}
+
+	span.mu.Lock()
+	defer span.mu.Unlock()
+
+	span.statusCode = StatusError
+	span.statusMessage = err.Error()
+}
+
+func (span *Span) SetStatus(code int, message string) {
+	if span == nil {
+		return
+	}
+
+	span.mu.Lock()
+	defer span.mu.Unlock()
+
+	span.statusCode = code
+	span.statusMessage = message
+}
+
+// End hands the span to the exporter unless it isn't sampled, only the first call counts
+func (span *Span) End() {
+	if span == nil {
+		return
+	}
+
+	span.mu.Lock()
+	if span.ended {
+		span.mu.Unlock()
+		return
+	}
+
+	span.ended = true
+	span.end = time.Now()
+	span.mu.Unlock()
+
+	if span.context.Sampled {
+		span.exporter.enqueue(span)
+	}
+}
+
+// TraceID for log correlation, empty when not tracing
+func (span *Span) TraceID() string {
+	if span == nil {
+		return ""
+	}
+
+	return span.context.TraceID.String()
+}
+
+// Traceparent formats a span context as a W3C traceparent header
+func Traceparent(spanContext SpanContext) string {
+	flags := "00"
+	if spanContext.Sampled {
+		flags = "01"
+	}
+
+	return fmt.Sprintf("00-%s-%s-%s", spanContext.TraceID, spanContext.SpanID, flags)
+}
+
+// ParseTraceparent reads a W3C traceparent header, ok is false for anything malformed
+func ParseTraceparent(header string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}, false
+	}
+
+	// Later versions may add fields after the flags, version 00 has exactly four
+	if version, err := hex.DecodeString(parts[0]); err != nil || (version[0] == 0 && len(parts) != 4) {
+		return SpanContext{}, false
+	}
+
+	var spanContext SpanContext
+
+	traceID, err := hex.DecodeString(parts[1])
+	if err != nil || len(traceID) != 16 {
+		return SpanContext{}, false
+	}
+
+	spanID, err := hex.DecodeString(parts[2])
+	if err != nil || len(spanID) != 8 {
+		return SpanContext{}, false
+	}
+
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 {
+		return SpanContext{}, false
+	}
+
+	copy(spanContext.TraceID[:], traceID)
+	copy(spanContext.SpanID[:], spanID)
+	spanContext.Sampled = flags[0]&1 == 1
+
+	return spanContext, spanContext.IsValid()
+}
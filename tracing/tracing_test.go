@@ -0,0 +1,110 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+
+	tests := []struct {
+		name        string
+		header      string
+		wantOK      bool
+		wantSampled bool
+	}{
+		{name: "sampled", header: "00-" + traceID + "-" + spanID + "-01", wantOK: true, wantSampled: true},
+		{name: "not sampled", header: "00-" + traceID + "-" + spanID + "-00", wantOK: true},
+		{name: "other flags with sampled", header: "00-" + traceID + "-" + spanID + "-03", wantOK: true, wantSampled: true},
+		{name: "surrounding space", header: "  00-" + traceID + "-" + spanID + "-01 ", wantOK: true, wantSampled: true},
+		{name: "later version with more fields", header: "cc-" + traceID + "-" + spanID + "-01-what-comes-next", wantOK: true, wantSampled: true},
+		{name: "version 00 with more fields", header: "00-" + traceID + "-" + spanID + "-01-extra"},
+		{name: "version ff", header: "ff-" + traceID + "-" + spanID + "-01"},
+		{name: "version not hex", header: "0g-" + traceID + "-" + spanID + "-01"},
+		{name: "too few parts", header: "00-" + traceID + "-" + spanID},
+		{name: "short trace id", header: "00-4bf92f3577b34da6-" + spanID + "-01"},
+		{name: "trace id not hex", header: "00-4bf92f3577b34da6a3ce929d0e0e473z-" + spanID + "-01"},
+		{name: "short span id", header: "00-" + traceID + "-00f067aa-01"},
+		{name: "flags too long", header: "00-" + traceID + "-" + spanID + "-001"},
+		{name: "zero trace id", header: "00-00000000000000000000000000000000-" + spanID + "-01"},
+		{name: "zero span id", header: "00-" + traceID + "-0000000000000000-01"},
+		{name: "empty", header: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spanContext, ok := ParseTraceparent(test.header)
+			if ok != test.wantOK {
+				t.Fatalf("ParseTraceparent(%q) ok = %v, want %v", test.header, ok, test.wantOK)
+			}
+
+			if !ok {
+				return
+			}
+
+			if spanContext.TraceID.String() != traceID || spanContext.SpanID.String() != spanID {
+				t.Errorf("got trace %s span %s, want %s %s", spanContext.TraceID, spanContext.SpanID, traceID, spanID)
+			}
+
+			if spanContext.Sampled != test.wantSampled {
+				t.Errorf("sampled = %v, want %v", spanContext.Sampled, test.wantSampled)
+			}
+		})
+	}
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	for _, sampled := range []bool{true, false} {
+		header := Traceparent(SpanContext{
+			TraceID: TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+			SpanID:  SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+			Sampled: sampled,
+		})
+
+		spanContext, ok := ParseTraceparent(header)
+		if !ok || spanContext.Sampled != sampled || Traceparent(spanContext) != header {
+			t.Errorf("%s came back as %s (ok %v)", header, Traceparent(spanContext), ok)
+		}
+	}
+}
+
+func TestStartInheritsSampling(t *testing.T) {
+	exporter := &Exporter{queue: make(chan *Span, 8)}
+	SetExporter(exporter)
+	t.Cleanup(func() { SetExporter(nil) })
+
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+
+	ctx, unsampled := Start(WithRemoteParent(context.Background(), remote), "unsampled", KindServer)
+	_, child := Start(ctx, "child", KindInternal)
+
+	if unsampled.context.Sampled || child.context.Sampled {
+		t.Error("spans under an unsampled remote parent are sampled")
+	}
+
+	if unsampled.context.TraceID != remote.TraceID || unsampled.parent != remote.SpanID {
+		t.Error("span didn't join the remote parent's trace")
+	}
+
+	child.End()
+	unsampled.End()
+
+	if len(exporter.queue) != 0 {
+		t.Errorf("%d unsampled span(s) were queued for export", len(exporter.queue))
+	}
+
+	_, root := Start(context.Background(), "root", KindServer)
+	if !root.context.Sampled {
+		t.Error("a new trace isn't sampled")
+	}
+
+	root.End()
+
+	if len(exporter.queue) != 1 {
+		t.Errorf("got %d queued spans, want the root", len(exporter.queue))
+	}
+}
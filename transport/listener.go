@@ -0,0 +1,84 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type contextKey string
+
+const localSocketKey contextKey = "localSocket"
+
+// ListenUnix opens a unix domain socket that only the current user can connect to. Access control is
+// the filesystem's: the socket is 0600 and its directory must not be writable by anyone else.
+// Windows 10+ supports AF_UNIX too, which is what we use there instead of named pipes.
+func ListenUnix(path string) (net.Listener, error) {
+	dir := filepath.Dir(path)
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create socket dir: %w", err)
+	}
+
+	if err := checkSocketDir(dir); err != nil {
+		return nil, err
+	}
+
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	listener, err := listenOwnerOnly(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+
+	// Already so where there's a umask, Windows goes by the directory's ACL anyway
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict socket permissions: %w", err)
+	}
+
+	return listener, nil
+}
+
+// ConnContext marks requests that arrived over a unix socket, use it as http.Server.ConnContext
+func ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	if conn.LocalAddr().Network() == "unix" {
+		return context.WithValue(ctx, localSocketKey, true)
+	}
+
+	return ctx
+}
+
+// IsLocalSocket reports whether the request came in over our owner-only unix socket
+func IsLocalSocket(ctx context.Context) bool {
+	local, _ := ctx.Value(localSocketKey).(bool)
+	return local
+}
+
+// A socket left behind by a crashed server makes Listen fail, remove it unless something still answers on it
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("another server is already listening on %s", path)
+	}
+
+	return os.Remove(path)
+}
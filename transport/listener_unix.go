@@ -0,0 +1,34 @@
+//go:build !windows
+
+package transport
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// listenOwnerOnly creates the socket 0600 to begin with. Chmod after Listen would leave a moment
+// where anyone could connect, and the socket skips auth. The umask is the process's, this runs
+// at startup before anything else creates files.
+func listenOwnerOnly(path string) (net.Listener, error) {
+	previous := syscall.Umask(0o177)
+	defer syscall.Umask(previous)
+
+	return net.Listen("unix", path)
+}
+
+func checkSocketDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+
+	// Anyone who can write the dir can swap the socket out from under us
+	if info.Mode().Perm()&0o022 != 0 && info.Mode()&os.ModeSticky == 0 {
+		return fmt.Errorf("socket dir %s is writable by other users", dir)
+	}
+
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package transport
+
+import "net"
+
+// Unix permission bits mean nothing on Windows, the socket inherits the ACL of its directory
+// which for anything under the user's profile is already owner-only.
+func checkSocketDir(dir string) error {
+	return nil
+}
+
+// No umask here, the directory's ACL is what keeps others out
+func listenOwnerOnly(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
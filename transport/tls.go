@@ -0,0 +1,190 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const selfSignedCertFile = "tls-cert.pem"
+const selfSignedKeyFile = "tls-key.pem"
+
+// Regenerate the self-signed cert when it's this close to expiring
+const selfSignedRenewBefore = 30 * 24 * time.Hour
+
+// Client certificate modes for mutual TLS
+const (
+	ClientAuthNone     = "none"
+	ClientAuthOptional = "optional"
+	ClientAuthRequire  = "require"
+)
+
+type TLSOptions struct {
+	CertFile     string   // user provided cert, self-signed is generated when empty
+	KeyFile      string   //
+	StateDir     string   // where the self-signed cert/key are kept between runs
+	Hosts        []string // names/IPs the self-signed cert is valid for
+	ClientCAFile string   // PEM bundle of CAs client certs must chain to
+	ClientAuth   string   // none, optional or require
+}
+
+// ServerTLSConfig builds the listener TLS config and returns the SHA-256 fingerprint of the
+// certificate we serve, so clients can pin it when pairing.
+func ServerTLSConfig(options TLSOptions) (*tls.Config, string, error) {
+	var certificate tls.Certificate
+	var err error
+
+	switch {
+	case options.CertFile != "" && options.KeyFile != "":
+		certificate, err = tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+	case options.CertFile != "" || options.KeyFile != "":
+		err = errors.New("both a TLS certificate and key are required")
+	default:
+		certificate, err = loadOrCreateSelfSigned(options.StateDir, options.Hosts)
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{certificate},
+	}
+
+	clientAuth := options.ClientAuth
+	if clientAuth == "" {
+		clientAuth = ClientAuthNone
+	}
+
+	if clientAuth != ClientAuthNone {
+		if options.ClientCAFile == "" {
+			return nil, "", errors.New("client certificate auth needs a client CA file")
+		}
+
+		caPEM, err := os.ReadFile(options.ClientCAFile)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read client CA file: %w", err)
+		}
+
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caPEM) {
+			return nil, "", errors.New("client CA file has no usable certificates")
+		}
+
+		tlsConfig.ClientCAs = clientCAs
+
+		switch clientAuth {
+		case ClientAuthOptional:
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		case ClientAuthRequire:
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		default:
+			return nil, "", fmt.Errorf("unknown client auth mode %q", clientAuth)
+		}
+	}
+
+	return tlsConfig, Fingerprint(certificate.Certificate[0]), nil
+}
+
+// Fingerprint is the SHA-256 of a DER certificate, formatted the way browsers and openssl show it
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+
+	hexString := strings.ToUpper(hex.EncodeToString(sum[:]))
+	pairs := make([]string, 0, len(sum))
+
+	for i := 0; i < len(hexString); i += 2 {
+		pairs = append(pairs, hexString[i:i+2])
+	}
+
+	return strings.Join(pairs, ":")
+}
+
+func loadOrCreateSelfSigned(stateDir string, hosts []string) (tls.Certificate, error) {
+	certPath := filepath.Join(stateDir, selfSignedCertFile)
+	keyPath := filepath.Join(stateDir, selfSignedKeyFile)
+
+	// Reuse the existing cert so pinned fingerprints keep working across restarts
+	if certificate, err := tls.LoadX509KeyPair(certPath, keyPath); err == nil {
+		leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+		if err == nil && time.Until(leaf.NotAfter) > selfSignedRenewBefore && coversHosts(leaf, hosts) {
+			return certificate, nil
+		}
+	}
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate TLS key: %w", err)
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate certificate serial: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: "adb-server"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if host != "" {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to create self-signed certificate: %w", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	if err := os.WriteFile(certPath, certPEM, 0o644); err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to write TLS certificate: %w", err)
+	}
+
+	if err := os.WriteFile(keyPath, keyPEM, 0o600); err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to write TLS key: %w", err)
+	}
+
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+func coversHosts(leaf *x509.Certificate, hosts []string) bool {
+	for _, host := range hosts {
+		if host != "" && leaf.VerifyHostname(host) != nil {
+			return false
+		}
+	}
+
+	return true
+}
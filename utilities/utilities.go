@@ -3,10 +3,12 @@ package utilities
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"math/rand"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
 )
 
 func WriteJSON(responseWriter http.ResponseWriter, status int, jsonContent any) {
@@ -17,33 +19,74 @@ func WriteJSON(responseWriter http.ResponseWriter, status int, jsonContent any)
 	_ = json.NewEncoder(responseWriter).Encode(jsonContent) // Ignoring JSON write errors for now
 }
 
-// pickRandomPort tries to bind to an available random port in [min,max].
-func PickRandomPort(min int, max int) int {
+// ListenRandomPort binds a random port in [min,max] on host and hands back the open listener,
+// so nothing can grab the port between picking it and serving on it.
+func ListenRandomPort(host string, min int, max int) (net.Listener, int, error) {
 	for i := 0; i < 20; i++ {
 		port := rand.Intn(max-min+1) + min
 
-		listener, error := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		listener, error := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
 
 		if error == nil {
-			_ = listener.Close()
-			return port
+			return listener, port, nil
 		}
 	}
 
 	// Fallback to 0 (let OS choose), then extract the port
-	listener, error := net.Listen("tcp", "127.0.0.1:0")
+	listener, error := net.Listen("tcp", net.JoinHostPort(host, "0"))
 
 	if error != nil {
-		log.Fatal("failed to find a free port:", error)
+		return nil, 0, fmt.Errorf("failed to find a free port: %w", error)
 	}
 
-	defer listener.Close()
+	return listener, listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// ConfigDir returns the per-user directory we keep credentials and other state in,
+// creating it with owner-only permissions if it doesn't exist yet.
+func ConfigDir() (string, error) {
+	userConfigDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve user config dir: %w", err)
+	}
+
+	dir := filepath.Join(userConfigDir, "adb-server")
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create config dir: %w", err)
+	}
+
+	return dir, nil
+}
 
-	_, portString, _ := net.SplitHostPort(listener.Addr().String()) // Extracting only the port, discarding others
+// WriteFileAtomic writes an owner-only file through a temp file in the same dir and a rename,
+// so a crash never leaves half a file behind
+func WriteFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	tempFile, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	tempPath := tempFile.Name()
+	defer os.Remove(tempPath) // no-op once renamed
 
-	var port int
+	if err := tempFile.Chmod(0o600); err != nil {
+		tempFile.Close()
+		return err
+	}
+
+	if _, err := tempFile.Write(data); err != nil {
+		tempFile.Close()
+		return err
+	}
 
-	fmt.Sscanf(portString, "%d", &port) // converting port to int
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
 
-	return port
+	return os.Rename(tempPath, path)
 }
@@ -0,0 +1,378 @@
+package webhooks
+
+import (
+	"adb-server/audit"
+	"adb-server/events"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Receivers check the signature, HMAC-SHA256 with the webhook's secret over the timestamp, a dot
+// and the body, and that the timestamp is recent. Verify does both.
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery" // the same on every attempt, for deduplicating
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+	StatusDropped   = "dropped" // the webhook's queue was full
+)
+
+// Deliveries waiting for a receiver that's slow or down, newer ones are dropped past this
+const queueSize = 256
+
+// How many deliveries each webhook remembers
+const deliveryLogSize = 100
+
+// Enough of the receiver's answer to see what it didn't like
+const maxResponseBytes = 1024
+
+// Retries wait 5s, 10s, 20s... but never longer than this
+const (
+	baseBackoff = 5 * time.Second
+	maxBackoff  = 5 * time.Minute
+)
+
+// Payload is the body of every delivery
+type Payload struct {
+	ID      string    `json:"id"`
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`
+	Webhook string    `json:"webhook_id"`
+	Data    any       `json:"data,omitempty"`
+}
+
+// Delivery is one event on its way to one webhook
+type Delivery struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	Attempts  []Attempt `json:"attempts"`
+
+	body []byte
+}
+
+type Attempt struct {
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Response   string    `json:"response,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+
+	refused bool // the receiver's address isn't allowed, trying again won't change that
+}
+
+func (attempt Attempt) succeeded() bool {
+	return attempt.Error == "" && attempt.StatusCode >= 200 && attempt.StatusCode < 300
+}
+
+// Receivers that are down or overloaded may do better later, ones that refuse won't
+func (attempt Attempt) retryable() bool {
+	if attempt.refused {
+		return false
+	}
+
+	switch attempt.StatusCode {
+	case 0, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+
+	return attempt.StatusCode >= 500
+}
+
+// Deliveries lists what was sent to a webhook lately, newest last
+func (manager *Manager) Deliveries(id string) ([]Delivery, error) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	hook, found := manager.hooks[id]
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	deliveries := make([]Delivery, 0, len(hook.deliveries))
+	for _, delivery := range hook.deliveries {
+		deliveries = append(deliveries, delivery.snapshot())
+	}
+
+	return deliveries, nil
+}
+
+// Test sends a webhook.test event right away, once, and returns how it went. It goes around the
+// webhook's queue, so it doesn't wait behind a receiver that's down.
+func (manager *Manager) Test(ctx context.Context, id string) (Delivery, error) {
+	manager.mu.Lock()
+
+	hook, found := manager.hooks[id]
+	if !found {
+		manager.mu.Unlock()
+		return Delivery{}, ErrNotFound
+	}
+
+	delivery, err := newDelivery(id, EventTest, time.Now().UTC(), map[string]string{"message": "Test event from adb-server"})
+	if err != nil {
+		manager.mu.Unlock()
+		return Delivery{}, err
+	}
+
+	hook.rememberLocked(delivery)
+	manager.mu.Unlock()
+
+	manager.deliver(ctx, id, delivery, 1)
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	return delivery.snapshot(), nil
+}
+
+// dispatch queues what a bus event means to the webhooks that want it
+func (manager *Manager) dispatch(event events.Event) {
+	eventType, ok := eventTypeOf(event)
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.lastEventID = event.ID
+
+	if !ok || manager.stopped {
+		return
+	}
+
+	for _, hook := range manager.hooks {
+		if !hook.wants(eventType) {
+			continue
+		}
+
+		delivery, err := newDelivery(hook.ID, eventType, event.Time, event.Data)
+		if err != nil {
+			log.Printf("Error preparing webhook delivery for %s: %v", eventType, err)
+			continue
+		}
+
+		hook.rememberLocked(delivery)
+
+		// Better to lose events for one receiver that's down than to hold up every other one
+		select {
+		case hook.queue <- delivery:
+		default:
+			delivery.Status = StatusDropped
+			log.Printf("Webhook %s is %d deliveries behind, dropped %s", hook.ID, queueSize, eventType)
+		}
+	}
+}
+
+// eventTypeOf is the webhook event for a bus event, if there's one
+func eventTypeOf(event events.Event) (string, bool) {
+	switch event.Type {
+	case events.TypeDeviceConnected, events.TypeDeviceStateChanged:
+		if change, ok := event.Data.(events.DeviceChange); ok && change.State == "unauthorized" {
+			return EventDeviceUnauthorized, true
+		}
+
+		return event.Type, true
+
+	case events.TypeDeviceDisconnected, events.TypeJobFinished:
+		return event.Type, true
+
+	case events.TypeOperation:
+		entry, ok := event.Data.(audit.Entry)
+		if !ok || (entry.Operation != "install" && entry.Operation != "batch-install") {
+			return "", false
+		}
+
+		if entry.Outcome == audit.OutcomeSuccess {
+			return EventInstallSucceeded, true
+		}
+
+		return EventInstallFailed, true
+	}
+
+	return "", false
+}
+
+func newDelivery(webhookID string, eventType string, eventTime time.Time, data any) (*Delivery, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(Payload{ID: id, Type: eventType, Time: eventTime, Webhook: webhookID, Data: data})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Delivery{ID: id, Event: eventType, Status: StatusPending, CreatedAt: time.Now().UTC(), body: body}, nil
+}
+
+func (hook *hook) rememberLocked(delivery *Delivery) {
+	hook.deliveries = append(hook.deliveries, delivery)
+	if len(hook.deliveries) > deliveryLogSize {
+		hook.deliveries = slices.Delete(hook.deliveries, 0, len(hook.deliveries)-deliveryLogSize)
+	}
+}
+
+func (delivery *Delivery) snapshot() Delivery {
+	snapshot := *delivery
+	snapshot.Attempts = slices.Clone(delivery.Attempts)
+	return snapshot
+}
+
+// work delivers a webhook's queue in order until the webhook is deleted or we shut down
+func (manager *Manager) work(ctx context.Context, id string, queue <-chan *Delivery) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case delivery := <-queue:
+			manager.deliver(ctx, id, delivery, manager.options.MaxAttempts)
+		}
+	}
+}
+
+func (manager *Manager) deliver(ctx context.Context, id string, delivery *Delivery, maxAttempts int) {
+	for attemptNumber := 1; ; attemptNumber++ {
+		// Read on every attempt, the URL or secret may have been fixed in the meantime
+		manager.mu.Lock()
+		hook, found := manager.hooks[id]
+		var webhook Webhook
+		if found {
+			webhook = hook.Webhook
+		}
+		manager.mu.Unlock()
+
+		if !found {
+			return
+		}
+
+		attempt, retryAfter := manager.post(ctx, webhook, delivery)
+
+		manager.mu.Lock()
+		delivery.Attempts = append(delivery.Attempts, attempt)
+
+		switch {
+		case attempt.succeeded():
+			delivery.Status = StatusDelivered
+		case !attempt.retryable() || attemptNumber >= maxAttempts || ctx.Err() != nil:
+			delivery.Status = StatusFailed
+		}
+
+		status := delivery.Status
+		manager.mu.Unlock()
+
+		if status == StatusFailed && maxAttempts > 1 {
+			log.Printf("Webhook %s gave up on %s %s after %d attempt(s): %s", id, delivery.Event, delivery.ID, attemptNumber, attempt.problem())
+		}
+
+		if status != StatusPending {
+			return
+		}
+
+		delay := min(maxBackoff, baseBackoff<<(attemptNumber-1))
+		if retryAfter > 0 {
+			delay = min(maxBackoff, retryAfter)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// post makes one attempt, and says how long the receiver asked us to wait if it did
+func (manager *Manager) post(ctx context.Context, webhook Webhook, delivery *Delivery) (Attempt, time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, manager.options.Timeout)
+	defer cancel()
+
+	started := time.Now()
+	attempt := Attempt{Time: started.UTC()}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, 0
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "adb-server-webhooks")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(started.Unix(), 10))
+	req.Header.Set(HeaderSignature, Sign(webhook.Secret, started, delivery.body))
+
+	res, err := manager.client.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		attempt.refused = errors.Is(err, errRefusedTarget)
+		attempt.DurationMS = time.Since(started).Milliseconds()
+		return attempt, 0
+	}
+	defer res.Body.Close()
+
+	response, _ := io.ReadAll(io.LimitReader(res.Body, maxResponseBytes))
+
+	attempt.StatusCode = res.StatusCode
+	attempt.Response = string(response)
+	attempt.DurationMS = time.Since(started).Milliseconds()
+
+	retryAfter, _ := strconv.Atoi(res.Header.Get("Retry-After"))
+
+	return attempt, time.Duration(retryAfter) * time.Second
+}
+
+func (attempt Attempt) problem() string {
+	if attempt.Error != "" {
+		return attempt.Error
+	}
+
+	return fmt.Sprintf("receiver answered %d", attempt.StatusCode)
+}
+
+// Sign is the X-Webhook-Signature of a body sent at timestamp
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp.Unix())
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a delivery's signature, for receivers written in Go. Deliveries signed more than
+// tolerance ago are rejected, so a captured one can't be replayed later.
+func Verify(secret string, header http.Header, body []byte, tolerance time.Duration) error {
+	seconds, err := strconv.ParseInt(header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return fmt.Errorf("missing or invalid %s header", HeaderTimestamp)
+	}
+
+	timestamp := time.Unix(seconds, 0)
+	if age := time.Since(timestamp).Abs(); tolerance > 0 && age > tolerance {
+		return fmt.Errorf("delivery was signed %s ago, more than the %s allowed", age.Round(time.Second), tolerance)
+	}
+
+	if !hmac.Equal([]byte(header.Get(HeaderSignature)), []byte(Sign(secret, timestamp, body))) {
+		return errors.New("signature doesn't match")
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"syscall"
+)
+
+// Deliveries come from the server's own network position, and the delivery log keeps what the
+// receiver answered. A webhook pointing at this machine or at a cloud metadata service would let
+// whoever registers it read what's there, so those addresses are refused unless the operator
+// allows them by name, IP or CIDR in webhooks.allowed-hosts.
+
+var errRefusedTarget = errors.New("refused webhook target")
+
+// Metadata services that aren't on a link-local address
+var metadataPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("100.100.100.200/32"), // Alibaba Cloud
+	netip.MustParsePrefix("fd00:ec2::254/128"),  // AWS over IPv6
+}
+
+type targetPolicy struct {
+	hosts    []string // lower case
+	prefixes []netip.Prefix
+}
+
+func newTargetPolicy(allowedHosts []string) (targetPolicy, error) {
+	var policy targetPolicy
+
+	for _, entry := range allowedHosts {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+
+		switch {
+		case entry == "":
+		case strings.Contains(entry, "/"):
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return targetPolicy{}, fmt.Errorf("invalid allowed webhook host %q: %w", entry, err)
+			}
+
+			policy.prefixes = append(policy.prefixes, prefix.Masked())
+		default:
+			if addr, err := netip.ParseAddr(strings.Trim(entry, "[]")); err == nil {
+				policy.prefixes = append(policy.prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			} else {
+				policy.hosts = append(policy.hosts, entry)
+			}
+		}
+	}
+
+	return policy, nil
+}
+
+func (policy targetPolicy) allowsHost(host string) bool {
+	return slices.Contains(policy.hosts, strings.ToLower(host))
+}
+
+func (policy targetPolicy) checkAddress(addr netip.Addr) error {
+	addr = addr.Unmap()
+
+	for _, prefix := range policy.prefixes {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+
+	refused := addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsUnspecified() || addr.IsMulticast() ||
+		slices.ContainsFunc(metadataPrefixes, func(prefix netip.Prefix) bool { return prefix.Contains(addr) })
+
+	if refused {
+		return fmt.Errorf("%w: %s is a loopback, link-local or metadata address, add it to webhooks.allowed-hosts to deliver there", errRefusedTarget, addr)
+	}
+
+	return nil
+}
+
+// checkURL turns away what's refused no matter what DNS says, when a webhook is registered.
+// Names are only resolved when delivering.
+func (policy targetPolicy) checkURL(target *url.URL) error {
+	host := strings.ToLower(target.Hostname())
+
+	if policy.allowsHost(host) {
+		return nil
+	}
+
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return policy.checkAddress(netip.AddrFrom4([4]byte{127, 0, 0, 1}))
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return nil
+	}
+
+	return policy.checkAddress(addr)
+}
+
+// dialContext checks the address a name resolved to right before connecting, so a name that
+// passed checkURL can't be pointed somewhere refused later
+func (policy targetPolicy) dialContext(dialer *net.Dialer) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		if host, _, err := net.SplitHostPort(address); err == nil && policy.allowsHost(host) {
+			return dialer.DialContext(ctx, network, address)
+		}
+
+		checked := *dialer
+		checked.Control = func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+
+			return policy.checkAddress(addrPort.Addr())
+		}
+
+		return checked.DialContext(ctx, network, address)
+	}
+}
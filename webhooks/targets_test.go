@@ -0,0 +1,133 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		allowed []string
+		refused bool
+	}{
+		{url: "https://hooks.example.com/adb"},
+		{url: "http://10.0.0.5:8080/hook"},
+		{url: "http://192.168.1.20/hook"},
+		{url: "http://127.0.0.1:9000/hook", refused: true},
+		{url: "http://127.9.9.9/hook", refused: true},
+		{url: "http://[::1]:9000/hook", refused: true},
+		{url: "http://localhost:9000/hook", refused: true},
+		{url: "http://ci.localhost/hook", refused: true},
+		{url: "http://169.254.169.254/latest/meta-data/", refused: true},
+		{url: "http://[fe80::1]/hook", refused: true},
+		{url: "http://[fd00:ec2::254]/latest/meta-data/", refused: true},
+		{url: "http://[::ffff:169.254.169.254]/", refused: true},
+		{url: "http://100.100.100.200/latest/meta-data/", refused: true},
+		{url: "http://0.0.0.0:9000/hook", refused: true},
+		{url: "http://127.0.0.1:9000/hook", allowed: []string{"127.0.0.1"}},
+		{url: "http://127.0.0.1:9000/hook", allowed: []string{"127.0.0.0/8"}},
+		{url: "http://[::1]:9000/hook", allowed: []string{"[::1]"}},
+		{url: "http://localhost:9000/hook", allowed: []string{"LocalHost"}},
+		{url: "http://169.254.169.254/", allowed: []string{"127.0.0.1"}, refused: true},
+	}
+
+	for _, test := range tests {
+		policy, err := newTargetPolicy(test.allowed)
+		if err != nil {
+			t.Fatalf("newTargetPolicy(%q): %v", test.allowed, err)
+		}
+
+		target, _ := url.Parse(test.url)
+
+		err = policy.checkURL(target)
+		if refused := errors.Is(err, errRefusedTarget); refused != test.refused {
+			t.Errorf("%s allowing %q: got %v, refused should be %v", test.url, test.allowed, err, test.refused)
+		}
+	}
+}
+
+func TestNewTargetPolicyRejectsBadCIDR(t *testing.T) {
+	if _, err := newTargetPolicy([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("accepted 10.0.0.0/33")
+	}
+}
+
+func TestDeliveryToLoopbackNeedsAllowing(t *testing.T) {
+	var (
+		received int
+		header   http.Header
+		body     []byte
+	)
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		received++
+		header = req.Header
+		body, _ = io.ReadAll(req.Body)
+	}))
+	defer receiver.Close()
+
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+
+	refusing, err := Open(Options{Path: path, Timeout: 5 * time.Second, MaxAttempts: 3})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer refusing.Stop()
+
+	if _, err := refusing.Create(Webhook{URL: receiver.URL}); !errors.Is(err, errRefusedTarget) {
+		t.Fatalf("Create got %v, want the loopback receiver refused", err)
+	}
+
+	allowing, err := Open(Options{Path: path, Timeout: 5 * time.Second, MaxAttempts: 3, AllowedHosts: []string{"127.0.0.1"}})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer allowing.Stop()
+
+	created, err := allowing.Create(Webhook{URL: receiver.URL})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	delivery, err := allowing.Test(context.Background(), created.ID)
+	if err != nil || delivery.Status != StatusDelivered || received != 1 {
+		t.Fatalf("Test got %+v, %v with %d received, want it delivered", delivery, err, received)
+	}
+
+	if err := Verify(created.Secret, header, body, time.Minute); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+
+	// Webhooks already in the file are checked again when delivering, where the name was resolved
+	reopened, err := Open(Options{Path: path, Timeout: 5 * time.Second, MaxAttempts: 3})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer reopened.Stop()
+
+	delivery, err = reopened.Test(context.Background(), created.ID)
+	if err != nil {
+		t.Fatalf("Test: %v", err)
+	}
+
+	if delivery.Status != StatusFailed || len(delivery.Attempts) != 1 || !strings.Contains(delivery.Attempts[0].Error, "webhooks.allowed-hosts") {
+		t.Errorf("delivery to a refused address came back as %+v", delivery)
+	}
+
+	if received != 1 {
+		t.Errorf("receiver got %d deliveries, want only the allowed one", received)
+	}
+
+	if len(delivery.Attempts) == 1 && delivery.Attempts[0].retryable() {
+		t.Error("a refused address would be retried")
+	}
+}
@@ -0,0 +1,453 @@
+// Package webhooks pushes device, install and job events to URLs people register, so chat bots
+// and CI don't have to poll. Every delivery is a signed JSON POST, retried with backoff, and the
+// last few per webhook are kept for GET /v1/webhooks/{id}/deliveries.
+package webhooks
+
+import (
+	"adb-server/events"
+	"adb-server/utilities"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const webhooksFileVersion = 1
+
+// What receivers can subscribe to. A device that connects or changes state and is unauthorized
+// (waiting for the RSA prompt) is sent as device.unauthorized instead.
+const (
+	EventDeviceConnected    = "device.connected"
+	EventDeviceDisconnected = "device.disconnected"
+	EventDeviceStateChanged = "device.state-changed"
+	EventDeviceUnauthorized = "device.unauthorized"
+	EventInstallSucceeded   = "install.succeeded"
+	EventInstallFailed      = "install.failed"
+	EventJobFinished        = "job.finished"
+	EventTest               = "webhook.test" // only sent by Test, to whatever the webhook subscribes to
+)
+
+var EventTypes = []string{
+	EventDeviceConnected, EventDeviceDisconnected, EventDeviceStateChanged, EventDeviceUnauthorized,
+	EventInstallSucceeded, EventInstallFailed, EventJobFinished,
+}
+
+var ErrNotFound = errors.New("webhook not found")
+
+// Webhook is a URL that gets the events it subscribes to
+type Webhook struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	Events      []string  `json:"events,omitempty"` // every event when empty
+	Description string    `json:"description,omitempty"`
+	Secret      string    `json:"secret,omitempty"` // only handed out when the webhook is created
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func (webhook Webhook) wants(eventType string) bool {
+	return eventType == EventTest || len(webhook.Events) == 0 || slices.Contains(webhook.Events, eventType)
+}
+
+func (webhook Webhook) redacted() Webhook {
+	webhook.Secret = ""
+	webhook.Events = slices.Clone(webhook.Events)
+	return webhook
+}
+
+type webhooksFile struct {
+	Version  int       `json:"version"`
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+type Options struct {
+	Path         string
+	Timeout      time.Duration // for one attempt
+	MaxAttempts  int
+	AllowedHosts []string // names, IPs and CIDRs receivers may be on even though they're loopback, link-local or metadata
+}
+
+// hook is a webhook with the deliveries waiting for it and the ones it already got
+type hook struct {
+	Webhook
+	queue      chan *Delivery
+	deliveries []*Delivery
+	stop       context.CancelFunc
+}
+
+// Manager keeps the webhooks in a JSON file, rewritten on every change, and delivers to them.
+// Each webhook has a worker of its own, so a receiver that's down only holds up its own
+// deliveries, which it gets in order.
+type Manager struct {
+	mu      sync.Mutex
+	path    string
+	options Options
+	targets targetPolicy
+	client  *http.Client
+	hooks   map[string]*hook
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stopped bool
+
+	bus          *events.Bus
+	subscription *events.Subscription
+	lastEventID  uint64 // to catch up after falling behind the bus
+}
+
+func Open(options Options) (*Manager, error) {
+	if options.Path == "" {
+		return nil, errors.New("webhooks file path is required")
+	}
+
+	if options.Timeout <= 0 {
+		options.Timeout = 10 * time.Second
+	}
+
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = 5
+	}
+
+	targets, err := newTargetPolicy(options.AllowedHosts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Straight to the receiver, through a proxy the address check would only see the proxy
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = targets.dialContext(&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second})
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	manager := &Manager{
+		path:    options.Path,
+		options: options,
+		targets: targets,
+		hooks:   map[string]*hook{},
+		ctx:     ctx,
+		cancel:  cancel,
+		client: &http.Client{
+			Transport: transport,
+			// A redirected POST turns into a GET, better to tell the user the URL is wrong
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}
+
+	data, err := os.ReadFile(options.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return manager, nil
+	}
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to read webhooks: %w", err)
+	}
+
+	var file webhooksFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		cancel()
+		return nil, fmt.Errorf("webhooks file is corrupt: %w", err)
+	}
+
+	if file.Version > webhooksFileVersion {
+		cancel()
+		return nil, fmt.Errorf("webhooks file version %d is newer than this server supports (%d)", file.Version, webhooksFileVersion)
+	}
+
+	for _, webhook := range file.Webhooks {
+		manager.startLocked(webhook)
+	}
+
+	return manager, nil
+}
+
+// Watch delivers bus's events from now on. The bus is only subscribed to while there are
+// webhooks, device events cost an adb devices every couple of seconds.
+func (manager *Manager) Watch(bus *events.Bus) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.bus = bus
+	manager.subscribeLocked()
+}
+
+// Stop gives up on what hasn't been delivered yet, for shutdown
+func (manager *Manager) Stop() {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if manager.stopped {
+		return
+	}
+
+	manager.stopped = true
+	manager.unsubscribeLocked()
+
+	pending := 0
+	for _, hook := range manager.hooks {
+		pending += len(hook.queue)
+	}
+
+	if pending > 0 {
+		log.Printf("Dropping %d undelivered webhook event(s)", pending)
+	}
+
+	manager.cancel()
+}
+
+func (manager *Manager) Webhooks() []Webhook {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	webhooks := make([]Webhook, 0, len(manager.hooks))
+	for _, hook := range manager.hooks {
+		webhooks = append(webhooks, hook.Webhook.redacted())
+	}
+
+	slices.SortFunc(webhooks, func(a, b Webhook) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	return webhooks
+}
+
+func (manager *Manager) Webhook(id string) (Webhook, bool) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	hook, found := manager.hooks[id]
+	if !found {
+		return Webhook{}, false
+	}
+
+	return hook.Webhook.redacted(), true
+}
+
+// Create registers a webhook and returns it with its secret, which is generated when not given.
+// It's the only time the secret is handed out.
+func (manager *Manager) Create(webhook Webhook) (Webhook, error) {
+	if err := manager.normalize(&webhook); err != nil {
+		return Webhook{}, err
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	if webhook.Secret == "" {
+		if webhook.Secret, err = randomHex(32); err != nil {
+			return Webhook{}, err
+		}
+	}
+
+	webhook.ID = id
+	webhook.CreatedAt = time.Now().UTC()
+	webhook.UpdatedAt = webhook.CreatedAt
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	hook := manager.startLocked(webhook)
+
+	if err := manager.saveLocked(); err != nil {
+		hook.stop()
+		delete(manager.hooks, id)
+		return Webhook{}, err
+	}
+
+	manager.subscribeLocked()
+
+	created := webhook.redacted()
+	created.Secret = webhook.Secret
+
+	return created, nil
+}
+
+// Update replaces a webhook's URL, events and description, and its secret when one is given
+func (manager *Manager) Update(id string, webhook Webhook) (Webhook, error) {
+	if err := manager.normalize(&webhook); err != nil {
+		return Webhook{}, err
+	}
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	hook, found := manager.hooks[id]
+	if !found {
+		return Webhook{}, ErrNotFound
+	}
+
+	previous := hook.Webhook
+
+	webhook.ID = id
+	webhook.CreatedAt = previous.CreatedAt
+	webhook.UpdatedAt = time.Now().UTC()
+
+	if webhook.Secret == "" {
+		webhook.Secret = previous.Secret
+	}
+
+	hook.Webhook = webhook
+
+	if err := manager.saveLocked(); err != nil {
+		hook.Webhook = previous
+		return Webhook{}, err
+	}
+
+	return webhook.redacted(), nil
+}
+
+func (manager *Manager) Delete(id string) error {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	hook, found := manager.hooks[id]
+	if !found {
+		return ErrNotFound
+	}
+
+	delete(manager.hooks, id)
+
+	if err := manager.saveLocked(); err != nil {
+		manager.hooks[id] = hook
+		return err
+	}
+
+	hook.stop()
+
+	if len(manager.hooks) == 0 {
+		manager.unsubscribeLocked()
+	}
+
+	return nil
+}
+
+// startLocked adds a webhook and starts its worker
+func (manager *Manager) startLocked(webhook Webhook) *hook {
+	ctx, stop := context.WithCancel(manager.ctx)
+
+	hook := &hook{Webhook: webhook, queue: make(chan *Delivery, queueSize), stop: stop}
+	manager.hooks[webhook.ID] = hook
+
+	go manager.work(ctx, webhook.ID, hook.queue)
+
+	return hook
+}
+
+func (manager *Manager) subscribeLocked() {
+	if manager.bus == nil || manager.stopped || manager.subscription != nil || len(manager.hooks) == 0 {
+		return
+	}
+
+	subscription := manager.bus.Subscribe([]string{
+		events.TypeDeviceConnected, events.TypeDeviceDisconnected, events.TypeDeviceStateChanged,
+		events.TypeJobFinished, events.TypeOperation,
+	}, manager.lastEventID)
+
+	manager.subscription = subscription
+
+	go manager.consume(subscription)
+}
+
+func (manager *Manager) unsubscribeLocked() {
+	if manager.subscription == nil {
+		return
+	}
+
+	manager.subscription.Close()
+	manager.subscription = nil
+
+	// Whatever the bus still has from back then is old news to the next webhook
+	manager.lastEventID = 0
+}
+
+func (manager *Manager) consume(subscription *events.Subscription) {
+	for event := range subscription.C {
+		manager.dispatch(event)
+	}
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	// Closed on purpose, by unsubscribeLocked or by the bus shutting down
+	if manager.subscription != subscription || manager.bus.Closed() {
+		return
+	}
+
+	// Dropped for falling behind, the bus's replay buffer has what we missed
+	log.Printf("Webhooks fell behind the event bus, catching up from event %d", manager.lastEventID)
+
+	manager.subscription = nil
+	manager.subscribeLocked()
+}
+
+func (manager *Manager) saveLocked() error {
+	file := webhooksFile{Version: webhooksFileVersion, Webhooks: []Webhook{}}
+
+	for _, hook := range manager.hooks {
+		file.Webhooks = append(file.Webhooks, hook.Webhook)
+	}
+
+	// Stable order keeps the file diffable
+	slices.SortFunc(file.Webhooks, func(a, b Webhook) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Owner-only, the secrets are in it
+	if err := utilities.WriteFileAtomic(manager.path, data); err != nil {
+		return fmt.Errorf("failed to save webhooks: %w", err)
+	}
+
+	return nil
+}
+
+func (manager *Manager) normalize(webhook *Webhook) error {
+	webhook.URL = strings.TrimSpace(webhook.URL)
+
+	target, err := url.Parse(webhook.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return fmt.Errorf("url must be an http(s) URL, got %q", webhook.URL)
+	}
+
+	if err := manager.targets.checkURL(target); err != nil {
+		return err
+	}
+
+	for _, eventType := range webhook.Events {
+		if !slices.Contains(EventTypes, eventType) {
+			return fmt.Errorf("unknown event %q, use %s", eventType, strings.Join(EventTypes, ", "))
+		}
+	}
+
+	slices.Sort(webhook.Events)
+	webhook.Events = slices.Compact(webhook.Events)
+	webhook.Description = strings.TrimSpace(webhook.Description)
+
+	return nil
+}
+
+func randomHex(size int) (string, error) {
+	randomBytes := make([]byte, size)
+
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", fmt.Errorf("failed to read random bytes: %w", err)
+	}
+
+	return hex.EncodeToString(randomBytes), nil
+}